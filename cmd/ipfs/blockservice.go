@@ -0,0 +1,259 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/cenkalti/backoff/v4"
+	"github.com/ipfs/boxo/blockservice"
+	cmds "github.com/ipfs/go-ipfs-cmds"
+	oldcmds "github.com/ipfs/kubo/commands"
+	config "github.com/ipfs/kubo/config"
+	"github.com/ipfs/kubo/core/commands"
+	"github.com/ipfs/kubo/repo"
+	fsrepo "github.com/ipfs/kubo/repo/fsrepo"
+)
+
+var (
+	errBlockServiceUnreachable = errors.New("block service unreachable")
+	errBlockServiceRejected    = errors.New("block service rejected the API key")
+)
+
+// blockServiceRetry controls how the block service is contacted before it is
+// initialized.
+type blockServiceRetry struct {
+	// Attempts is the number of times the block service is contacted before
+	// giving up.
+	Attempts int
+	// InitialBackoff is the wait after the first failed attempt, doubled
+	// after each following one up to MaxBackoff.
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	// Timeout bounds each attempt.
+	Timeout time.Duration
+}
+
+var defaultBlockServiceRetry = blockServiceRetry{
+	Attempts:       3,
+	InitialBackoff: time.Second,
+	MaxBackoff:     5 * time.Second,
+	Timeout:        10 * time.Second,
+}
+
+// blockServiceHandshakeHash is the multihash looked up to check the block
+// service answers and accepts the API key: that of the empty identity CID,
+// which needs no content.
+const blockServiceHandshakeHash = "0000"
+
+// initBlockService checks the block service can be contacted with
+// contactBlockService, then initializes it.
+func initBlockService(ctx context.Context, cfg config.ConfigPinningService, retry blockServiceRetry) error {
+	if err := contactBlockService(ctx, cfg, retry); err != nil {
+		return err
+	}
+	key, err := config.DecodeBlockEncryptionKey(cfg.BlockEncryptionKey)
+	if err != nil {
+		return fmt.Errorf("BlockEncryptionKey: %w", err)
+	}
+	return blockservice.InitBlockService(
+		cfg.Uploader,
+		cfg.PinningService,
+		cfg.DedicatedGateway,
+		cfg.RedisConn,
+		cfg.AmqpConnect,
+		string(key),
+		cfg.EncryptedBlockPrefix,
+	)
+}
+
+// contactBlockService checks the pinning service of cfg can be reached with
+// its API key, retrying with capped exponential backoff. The returned error
+// wraps errBlockServiceUnreachable when every attempt failed and
+// errBlockServiceRejected when the API key was refused, which is not
+// retried.
+func contactBlockService(ctx context.Context, cfg config.ConfigPinningService, retry blockServiceRetry) error {
+	bo := backoff.NewExponentialBackOff()
+	bo.InitialInterval = retry.InitialBackoff
+	bo.MaxInterval = retry.MaxBackoff
+	bo.MaxElapsedTime = 0
+	bo.RandomizationFactor = 0
+
+	attempt := 0
+	return backoff.Retry(func() error {
+		attempt++
+		err := blockServiceHandshake(ctx, cfg, retry.Timeout)
+		if errors.Is(err, errBlockServiceRejected) {
+			return backoff.Permanent(err)
+		}
+		if err != nil && attempt < retry.Attempts {
+			fmt.Printf("contacting the block service failed (attempt %d of %d): %s\n", attempt, retry.Attempts, err)
+		}
+		return err
+	}, backoff.WithContext(backoff.WithMaxRetries(bo, uint64(retry.Attempts-1)), ctx))
+}
+
+// blockServiceHandshake makes a DMCA lookup on the pinning service with the
+// block service API key.
+func blockServiceHandshake(ctx context.Context, cfg config.ConfigPinningService, timeout time.Duration) error {
+	if cfg.PinningService == "" {
+		// InitBlockService reports the missing endpoint
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("%s/api/dmca/%s", cfg.PinningService, blockServiceHandshakeHash), nil)
+	if err != nil {
+		return backoff.Permanent(err)
+	}
+	req.Header.Set("blockservice-API-Key", cfg.BlockserviceApiKey)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("%w: %s", errBlockServiceUnreachable, err)
+	}
+	resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden:
+		return fmt.Errorf("%w: %s answered %d", errBlockServiceRejected, cfg.PinningService, resp.StatusCode)
+	case resp.StatusCode >= http.StatusInternalServerError:
+		return fmt.Errorf("%w: %s answered %d", errBlockServiceUnreachable, cfg.PinningService, resp.StatusCode)
+	}
+	return nil
+}
+
+var blockServiceCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Manage the block service of the repo.",
+	},
+	Subcommands: map[string]*cmds.Command{
+		"reconfigure": blockServiceReconfigureCmd,
+	},
+}
+
+var blockServiceReconfigureCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Update the block service settings of an existing repo.",
+		ShortDescription: `
+Sets up the block service again, as 'ipfs init' does, with the settings of
+the config overridden by the options given, then saves them to the config.
+Settings are only saved once the block service was set up with them.
+
+The daemon must be stopped, it sets up the block service when it starts.
+`,
+	},
+	Options: []cmds.Option{
+		cmds.StringOption(psEp, "Pinning service endpoint"),
+		cmds.StringOption(apiKey, "Pinning service api key"),
+		cmds.StringOption(uploaderEndpoint, "Uploader endpoint"),
+		cmds.StringOption(redisConn, "Redis connection"),
+		cmds.StringOption(amqpConnect, "AMQP connection"),
+		cmds.BoolOption(dedicatedGateway, "Dedicated gateway"),
+		cmds.StringOption(encryptBlockKey, "Encryption block key of 16, 24 or 32 bytes, prefixed with hex: or base64: when encoded"),
+		cmds.StringOption(encryptedBlockPrefix, "Encryption block prefix"),
+	},
+	NoRemote: true,
+	PreRun:   commands.DaemonNotRunning,
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		cctx := env.(*oldcmds.Context)
+		r, err := fsrepo.Open(cctx.ConfigRoot)
+		if err != nil {
+			return err
+		}
+		defer r.Close()
+
+		return reconfigureBlockService(req.Context, os.Stdout, r, req.Options, func(ctx context.Context, cfg config.ConfigPinningService) error {
+			return initBlockService(ctx, cfg, defaultBlockServiceRetry)
+		})
+	},
+}
+
+// reconfigureBlockService sets up the block service with setup, using the
+// block service settings of the config of r overridden by opts, and saves
+// them to the config once set up.
+func reconfigureBlockService(ctx context.Context, out io.Writer, r repo.Repo, opts cmds.OptMap, setup func(context.Context, config.ConfigPinningService) error) error {
+	cfg, err := r.Config()
+	if err != nil {
+		return err
+	}
+	pinning, changed, err := blockServiceOptions(cfg.ConfigPinningService, opts)
+	if err != nil {
+		return err
+	}
+	if err := pinning.Validate(); err != nil {
+		return err
+	}
+	if err := setup(ctx, pinning); err != nil {
+		return fmt.Errorf("InitBlockService: %w", err)
+	}
+
+	updated := *cfg
+	updated.ConfigPinningService = pinning
+	if err := r.SetConfig(&updated); err != nil {
+		return fmt.Errorf("saving the block service settings: %w", err)
+	}
+	if len(changed) == 0 {
+		fmt.Fprintln(out, "block service set up again, no setting changed")
+	} else {
+		fmt.Fprintf(out, "block service reconfigured, updated %s\n", strings.Join(changed, ", "))
+	}
+	return nil
+}
+
+// blockServiceOptions returns cfg with the settings given in opts, along
+// with the names of the settings changed. The encryption key and prefix
+// can't be changed while blocks are encrypted at rest, as the stored blocks
+// could no longer be read.
+func blockServiceOptions(cfg config.ConfigPinningService, opts cmds.OptMap) (config.ConfigPinningService, []string, error) {
+	var changed []string
+	set := func(name string, field *string, value string) {
+		if *field != value {
+			*field = value
+			changed = append(changed, name)
+		}
+	}
+
+	for _, o := range []struct {
+		option, name string
+		field        *string
+	}{
+		{psEp, "PinningService", &cfg.PinningService},
+		{apiKey, "BlockserviceApiKey", &cfg.BlockserviceApiKey},
+		{uploaderEndpoint, "Uploader", &cfg.Uploader},
+		{redisConn, "RedisConn", &cfg.RedisConn},
+		{amqpConnect, "AmqpConnect", &cfg.AmqpConnect},
+	} {
+		if value, ok := opts[o.option].(string); ok {
+			set(o.name, o.field, value)
+		}
+	}
+	if dGw, ok := opts[dedicatedGateway].(bool); ok && dGw != cfg.DedicatedGateway {
+		cfg.DedicatedGateway = dGw
+		changed = append(changed, "DedicatedGateway")
+	}
+
+	key, hasKey := opts[encryptBlockKey].(string)
+	prefix, hasPrefix := opts[encryptedBlockPrefix].(string)
+	if hasKey && key != "" {
+		var err error
+		if key, err = canonicalBlockKey(key); err != nil {
+			return cfg, nil, fmt.Errorf("invalid --%s: %w", encryptBlockKey, err)
+		}
+	}
+	if cfg.EncryptBlocksAtRest && ((hasKey && key != cfg.BlockEncryptionKey) || (hasPrefix && prefix != cfg.EncryptedBlockPrefix)) {
+		return cfg, nil, fmt.Errorf("--%s and --%s can't be changed while blocks are encrypted at rest, add a key to BlockEncryptionKeys to rotate it", encryptBlockKey, encryptedBlockPrefix)
+	}
+	if hasKey {
+		set("BlockEncryptionKey", &cfg.BlockEncryptionKey, key)
+	}
+	if hasPrefix {
+		set("EncryptedBlockPrefix", &cfg.EncryptedBlockPrefix, prefix)
+	}
+	return cfg, changed, nil
+}
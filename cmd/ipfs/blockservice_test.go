@@ -0,0 +1,179 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	cmds "github.com/ipfs/go-ipfs-cmds"
+	config "github.com/ipfs/kubo/config"
+	"github.com/ipfs/kubo/repo"
+)
+
+var fastBlockServiceRetry = blockServiceRetry{
+	Attempts:       3,
+	InitialBackoff: time.Millisecond,
+	MaxBackoff:     2 * time.Millisecond,
+	Timeout:        time.Second,
+}
+
+// newFlakyBlockService answers with status after failing the first failures
+// requests, returning the number of requests made.
+func newFlakyBlockService(t *testing.T, failures int32, status int) (string, *atomic.Int32) {
+	t.Helper()
+	var calls atomic.Int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("blockservice-API-Key") != "secret" {
+			t.Errorf("expected the API key to be sent, got %q", r.Header.Get("blockservice-API-Key"))
+		}
+		if calls.Add(1) <= failures {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(ts.Close)
+	return ts.URL, &calls
+}
+
+func TestContactBlockService(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("succeeds after failures", func(t *testing.T) {
+		url, calls := newFlakyBlockService(t, 2, http.StatusOK)
+		cfg := config.ConfigPinningService{PinningService: url, BlockserviceApiKey: "secret"}
+		if err := contactBlockService(ctx, cfg, fastBlockServiceRetry); err != nil {
+			t.Fatal(err)
+		}
+		if n := calls.Load(); n != 3 {
+			t.Fatalf("expected 3 attempts, got %d", n)
+		}
+	})
+
+	t.Run("unreachable", func(t *testing.T) {
+		url, calls := newFlakyBlockService(t, 3, http.StatusOK)
+		cfg := config.ConfigPinningService{PinningService: url, BlockserviceApiKey: "secret"}
+		err := contactBlockService(ctx, cfg, fastBlockServiceRetry)
+		if !errors.Is(err, errBlockServiceUnreachable) {
+			t.Fatalf("expected the block service to be unreachable, got %v", err)
+		}
+		if n := calls.Load(); n != 3 {
+			t.Fatalf("expected 3 attempts, got %d", n)
+		}
+	})
+
+	t.Run("rejected", func(t *testing.T) {
+		url, calls := newFlakyBlockService(t, 0, http.StatusUnauthorized)
+		cfg := config.ConfigPinningService{PinningService: url, BlockserviceApiKey: "secret"}
+		err := contactBlockService(ctx, cfg, fastBlockServiceRetry)
+		if !errors.Is(err, errBlockServiceRejected) {
+			t.Fatalf("expected the API key to be rejected, got %v", err)
+		}
+		if n := calls.Load(); n != 1 {
+			t.Fatalf("expected rejected credentials not to be retried, got %d attempts", n)
+		}
+	})
+
+	t.Run("connection refused", func(t *testing.T) {
+		ts := httptest.NewServer(http.NotFoundHandler())
+		ts.Close()
+		cfg := config.ConfigPinningService{PinningService: ts.URL, BlockserviceApiKey: "secret"}
+		if err := contactBlockService(ctx, cfg, fastBlockServiceRetry); !errors.Is(err, errBlockServiceUnreachable) {
+			t.Fatalf("expected the block service to be unreachable, got %v", err)
+		}
+	})
+}
+
+func TestReconfigureBlockService(t *testing.T) {
+	ctx := context.Background()
+	current := config.ConfigPinningService{
+		Uploader:           "http://uploader:8080",
+		PinningService:     "https://old.example.com",
+		BlockserviceApiKey: "old",
+		RedisConn:          "localhost:6379",
+	}
+
+	t.Run("saves the updated settings", func(t *testing.T) {
+		r := &repo.Mock{C: config.Config{ConfigPinningService: current}}
+		var setUp config.ConfigPinningService
+		err := reconfigureBlockService(ctx, io.Discard, r, cmds.OptMap{
+			psEp:             "https://new.example.com",
+			apiKey:           "new",
+			dedicatedGateway: true,
+		}, func(_ context.Context, cfg config.ConfigPinningService) error {
+			setUp = cfg
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := current
+		want.PinningService = "https://new.example.com"
+		want.BlockserviceApiKey = "new"
+		want.DedicatedGateway = true
+		if setUp.PinningService != want.PinningService || setUp.BlockserviceApiKey != want.BlockserviceApiKey {
+			t.Fatalf("expected the block service to be set up with the new settings, got %+v", setUp)
+		}
+		got := r.C.ConfigPinningService
+		if got.PinningService != want.PinningService || got.BlockserviceApiKey != want.BlockserviceApiKey ||
+			!got.DedicatedGateway || got.Uploader != want.Uploader || got.RedisConn != want.RedisConn {
+			t.Fatalf("expected %+v to be saved, got %+v", want, got)
+		}
+	})
+
+	t.Run("keeps the settings when the setup fails", func(t *testing.T) {
+		r := &repo.Mock{C: config.Config{ConfigPinningService: current}}
+		err := reconfigureBlockService(ctx, io.Discard, r, cmds.OptMap{apiKey: "wrong"}, func(context.Context, config.ConfigPinningService) error {
+			return errBlockServiceRejected
+		})
+		if !errors.Is(err, errBlockServiceRejected) {
+			t.Fatalf("expected the setup error, got %v", err)
+		}
+		if r.C.ConfigPinningService.BlockserviceApiKey != "old" {
+			t.Fatal("expected the settings not to be saved")
+		}
+	})
+
+	t.Run("rejects invalid settings", func(t *testing.T) {
+		r := &repo.Mock{C: config.Config{ConfigPinningService: current}}
+		err := reconfigureBlockService(ctx, io.Discard, r, cmds.OptMap{psEp: "old.example.com"}, func(context.Context, config.ConfigPinningService) error {
+			t.Fatal("expected the block service not to be set up")
+			return nil
+		})
+		if err == nil {
+			t.Fatal("expected an error")
+		}
+	})
+}
+
+func TestBlockServiceOptions(t *testing.T) {
+	const key = "hex:000102030405060708090a0b0c0d0e0f000102030405060708090a0b0c0d0e0f"
+
+	cfg, changed, err := blockServiceOptions(config.ConfigPinningService{PinningService: "https://a.example.com"}, cmds.OptMap{
+		psEp:                 "https://a.example.com",
+		encryptBlockKey:      "base64:AAECAwQFBgcICQoLDA0ODwABAgMEBQYHCAkKCwwNDg8=",
+		encryptedBlockPrefix: "enc:",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.BlockEncryptionKey != key {
+		t.Fatalf("expected the key to be normalized to hex, got %q", cfg.BlockEncryptionKey)
+	}
+	if len(changed) != 2 || changed[0] != "BlockEncryptionKey" || changed[1] != "EncryptedBlockPrefix" {
+		t.Fatalf("expected only the encryption settings to change, got %v", changed)
+	}
+
+	encrypted := config.ConfigPinningService{EncryptBlocksAtRest: true, BlockEncryptionKey: key, EncryptedBlockPrefix: "enc:"}
+	if _, _, err := blockServiceOptions(encrypted, cmds.OptMap{encryptedBlockPrefix: "new:"}); err == nil {
+		t.Fatal("expected the prefix of encrypted blocks not to change")
+	}
+	if _, _, err := blockServiceOptions(encrypted, cmds.OptMap{encryptBlockKey: key, encryptedBlockPrefix: "enc:"}); err != nil {
+		t.Fatalf("expected unchanged encryption settings to be accepted, got %v", err)
+	}
+}
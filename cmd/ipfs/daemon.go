@@ -44,6 +44,7 @@ import (
 	manet "github.com/multiformats/go-multiaddr/net"
 	prometheus "github.com/prometheus/client_golang/prometheus"
 	promauto "github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/redis/go-redis/v9"
 )
 
 const (
@@ -139,6 +140,14 @@ or send a SIGTERM signal to it (e.g. with 'kill'). It may take a while for the
 daemon to shutdown gracefully, but it can be killed forcibly by sending a
 second signal.
 
+Reloading
+
+Sending a SIGHUP signal to the daemon re-reads the config file and applies
+the ConfigPinningService settings read on each gateway request: pinning
+service endpoint and key, referer rules, DMCA cache TTLs, error cooldown,
+rate limits and where client API keys are read from. Changes to other
+ConfigPinningService settings are logged as requiring a restart.
+
 IPFS_PATH environment variable
 
 ipfs uses a repository in the local file system. By default, the repo is
@@ -266,7 +275,7 @@ func daemonFunc(req *cmds.Request, re cmds.ResponseEmitter, env cmds.Environment
 			}
 		}
 
-		if err = doInit(os.Stdout, cctx.ConfigRoot, false, profiles, conf); err != nil {
+		if err = doInit(os.Stdout, cctx.ConfigRoot, false, profiles, "", conf); err != nil {
 			return err
 		}
 	}
@@ -376,13 +385,17 @@ func daemonFunc(req *cmds.Request, re cmds.ResponseEmitter, env cmds.Environment
 		return err
 	}
 
+	blockKey, err := config.DecodeBlockEncryptionKey(cfg.ConfigPinningService.BlockEncryptionKey)
+	if err != nil {
+		return fmt.Errorf("ConfigPinningService.BlockEncryptionKey: %w", err)
+	}
 	if err := blockservice.InitBlockService(
 		cfg.ConfigPinningService.Uploader,
 		cfg.ConfigPinningService.PinningService,
 		cfg.ConfigPinningService.DedicatedGateway,
 		cfg.ConfigPinningService.RedisConn,
 		cfg.ConfigPinningService.AmqpConnect,
-		cfg.ConfigPinningService.BlockEncryptionKey,
+		string(blockKey),
 		cfg.ConfigPinningService.EncryptedBlockPrefix,
 	); err != nil {
 		fmt.Printf("InitBlockService  %s\n", err)
@@ -593,6 +606,27 @@ take effect.
 	// start MFS pinning thread
 	startPinMFS(daemonConfigPollInterval, cctx, &ipfsPinMFSNode{node})
 
+	// start IPNS target pinning thread
+	coreAPI, err := coreapi.NewCoreAPI(node)
+	if err != nil {
+		return err
+	}
+	startPinIPNS(cctx, &ipfsPinIPNSNode{coreAPI, node.Repo.Datastore()})
+
+	// start hot CID pinning thread, reading the request counts from Redis
+	if cfg.ConfigPinningService.RedisConn != "" {
+		hotCIDs := &redisHotCIDSource{redis.NewClient(&redis.Options{Addr: cfg.ConfigPinningService.RedisConn})}
+		defer hotCIDs.client.Close()
+		startPinHot(cctx, hotCIDs, &ipfsPinHotNode{node, coreAPI})
+	}
+
+	// reload the pinning service settings of the gateways on SIGHUP
+	configFileOpt, _ := req.Options[commands.ConfigFileOption].(string)
+	reloadh := utilmain.SetupReloadHandler(func() {
+		reloadPinningService(cctx.ConfigRoot, configFileOpt)
+	})
+	defer reloadh.Close()
+
 	// The daemon is *finally* ready.
 	fmt.Printf("Daemon is ready\n")
 	notifyReady()
@@ -649,6 +683,29 @@ take effect.
 	return errs
 }
 
+// reloadPinningService re-reads the config file and applies its
+// ConfigPinningService section to the running gateways.
+func reloadPinningService(configRoot, configFileOpt string) {
+	filename, err := config.Filename(configRoot, configFileOpt)
+	if err != nil {
+		log.Errorf("reloading config failed: %s", err)
+		return
+	}
+	cfg, err := cserial.Load(filename)
+	if err != nil {
+		log.Errorf("reloading config failed: %s", err)
+		return
+	}
+	restart, err := corehttp.ReloadPinningService(cfg.ConfigPinningService)
+	if err != nil {
+		log.Errorf("reloading config failed: %s", err)
+		return
+	}
+	for _, name := range restart {
+		log.Warnf("ConfigPinningService.%s changed, restart the daemon to apply it", name)
+	}
+}
+
 // serveHTTPApi collects options, creates listener, prints status message and starts serving requests.
 func serveHTTPApi(req *cmds.Request, cctx *oldcmds.Context) (<-chan error, error) {
 	cfg, err := cctx.GetConfig()
@@ -716,6 +773,8 @@ func serveHTTPApi(req *cmds.Request, cctx *oldcmds.Context) (<-chan error, error
 		corehttp.MetricsCollectionOption("api"),
 		corehttp.MetricsOpenCensusCollectionOption(),
 		corehttp.MetricsOpenCensusDefaultPrometheusRegistry(),
+		corehttp.EventsOption("/events"),
+		corehttp.APILimitsOption(),
 		corehttp.CheckVersionOption(),
 		corehttp.CommandsOption(*cctx),
 		corehttp.WebUIOption,
@@ -726,6 +785,11 @@ func serveHTTPApi(req *cmds.Request, cctx *oldcmds.Context) (<-chan error, error
 		defaultMux("/debug/stack"),
 		corehttp.MutexFractionOption("/debug/pprof-mutex/"),
 		corehttp.BlockProfileRateOption("/debug/pprof-block/"),
+		corehttp.RateLimitsOption("/debug/ratelimits"),
+		corehttp.MaintenanceOption("/debug/maintenance"),
+		corehttp.DenylistOption("/debug/denylist"),
+		corehttp.TLSReloadOption("/debug/tls/reload"),
+		corehttp.HealthOption(),
 		corehttp.MetricsScrapingOption("/debug/metrics/prometheus"),
 		corehttp.LogOption(),
 	}
@@ -865,8 +929,16 @@ func serveHTTPGateway(req *cmds.Request, cctx *oldcmds.Context) (<-chan error, e
 	cmdctx.Gateway = true
 
 	opts := []corehttp.ServeOption{
+		corehttp.AccessLogOption(),
 		corehttp.MetricsCollectionOption("gateway"),
+		corehttp.CompressionOption(),
 		corehttp.HostnameOption(),
+		corehttp.CacheControlOption(),
+		corehttp.ETagOption(),
+		corehttp.ContentHeadersOption(),
+		corehttp.MaxObjectSizeOption(),
+		corehttp.ResponseTimeoutOption(),
+		corehttp.PrefetchOption(),
 		corehttp.GatewayOption("/ipfs", "/ipns"),
 		corehttp.VersionOption(),
 		corehttp.CheckVersionOption(),
@@ -950,7 +1022,7 @@ func serveTrustlessGatewayOverLibp2p(cctx *oldcmds.Context) (<-chan error, error
 	if err != nil {
 		return nil, err
 	}
-	middlewareHandler := corehttp.DedicatedGatewayMiddleware(handler, cfg)
+	middlewareHandler := corehttp.DedicatedGatewayMiddleware(handler, node, cfg)
 
 	h := p2phttp.Host{
 		StreamHost: node.PeerHost,
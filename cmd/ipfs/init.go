@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -18,7 +19,6 @@ import (
 	"github.com/ipfs/kubo/core/commands"
 	fsrepo "github.com/ipfs/kubo/repo/fsrepo"
 
-	"github.com/ipfs/boxo/blockservice"
 	options "github.com/ipfs/boxo/coreiface/options"
 	"github.com/ipfs/boxo/files"
 	cmds "github.com/ipfs/go-ipfs-cmds"
@@ -33,6 +33,7 @@ const (
 	emptyRepoOptionName  = "empty-repo"
 	dedicatedGateway     = "dedicated-gateway"
 	profileOptionName    = "profile"
+	datastoreOptionName  = "datastore"
 	psEp                 = "pinning-service"
 	apiKey               = "api-key"
 	uploaderEndpoint     = "uploader-endpoint"
@@ -40,6 +41,7 @@ const (
 	amqpConnect          = "amqp-connect"
 	encryptBlockKey      = "encrypt-block-key"
 	encryptedBlockPrefix = "encrypted-block-prefix"
+	dryRunOptionName     = "dry-run"
 )
 
 // nolint
@@ -63,6 +65,10 @@ located at ~/.ipfs. To change the repo location, set the $IPFS_PATH
 environment variable:
 
     export IPFS_PATH=/path/to/ipfsrepo
+
+With --dry-run, the configuration the repo would be initialized with is
+validated and printed as JSON, its private key aside, without writing
+anything or contacting the pinning service.
 `,
 	},
 	Arguments: []cmds.Argument{
@@ -74,13 +80,15 @@ environment variable:
 		cmds.BoolOption(dedicatedGateway, "Dedicated gateway"),
 		cmds.BoolOption(emptyRepoOptionName, "e", "Don't add and pin help files to the local storage.").WithDefault(emptyRepoDefault),
 		cmds.StringOption(profileOptionName, "p", "Apply profile settings to config. Multiple profiles can be separated by ','"),
+		cmds.StringOption(datastoreOptionName, "Datastore backend to initialize the repo with: aiozfs, flatfs or badgerds. Defaults to the datastore of the config."),
 		cmds.StringOption(psEp, "Configuration pinning service endpoint"),
 		cmds.StringOption(apiKey, "Configuration pinning service api key"),
 		cmds.StringOption(uploaderEndpoint, "Configuration uploader endpoint"),
 		cmds.StringOption(redisConn, "Configuration redis connection"),
 		cmds.StringOption(amqpConnect, "Configuration amqp connection"),
-		cmds.StringOption(encryptBlockKey, "Configuration encryption block key"),
+		cmds.StringOption(encryptBlockKey, "Configuration encryption block key of 16, 24 or 32 bytes, prefixed with hex: or base64: when encoded"),
 		cmds.StringOption(encryptedBlockPrefix, "Configuration encryption block prefix"),
+		cmds.BoolOption(dryRunOptionName, "Print the configuration as JSON instead of initializing the repo."),
 
 		// TODO need to decide whether to expose the override as a file or a
 		// directory. That is: should we allow the user to also specify the
@@ -95,6 +103,13 @@ environment variable:
 		empty, _ := req.Options[emptyRepoOptionName].(bool)
 		algorithm, _ := req.Options[algorithmOptionName].(string)
 		nBitsForKeypair, nBitsGiven := req.Options[bitsOptionName].(int)
+		dryRun, _ := req.Options[dryRunOptionName].(bool)
+
+		// stdout only carries the config in a dry run
+		var progress io.Writer = os.Stdout
+		if dryRun {
+			progress = os.Stderr
+		}
 
 		var conf *config.Config
 
@@ -120,14 +135,24 @@ environment variable:
 
 		if conf == nil {
 			var err error
+			encryptKey, _ := req.Options[encryptBlockKey].(string)
+			blockPrefix, _ := req.Options[encryptedBlockPrefix].(string)
+			if encryptKey != "" {
+				if encryptKey, err = canonicalBlockKey(encryptKey); err != nil {
+					return fmt.Errorf("invalid --%s: %w", encryptBlockKey, err)
+				}
+			} else if blockPrefix != "" {
+				return fmt.Errorf("--%s is required to encrypt blocks with --%s", encryptBlockKey, encryptedBlockPrefix)
+			}
+
 			var identity config.Identity
 			if nBitsGiven {
-				identity, err = config.CreateIdentity(os.Stdout, []options.KeyGenerateOption{
+				identity, err = config.CreateIdentity(progress, []options.KeyGenerateOption{
 					options.Key.Size(nBitsForKeypair),
 					options.Key.Type(algorithm),
 				})
 			} else {
-				identity, err = config.CreateIdentity(os.Stdout, []options.KeyGenerateOption{
+				identity, err = config.CreateIdentity(progress, []options.KeyGenerateOption{
 					options.Key.Type(algorithm),
 				})
 			}
@@ -141,11 +166,9 @@ environment variable:
 			dGw, _ := req.Options[dedicatedGateway].(bool)
 			redisConn, ok := req.Options[redisConn].(string)
 			if !ok {
-				fmt.Println("redisConn is not ok")
+				fmt.Fprintln(progress, "redisConn is not ok")
 			}
 			amqpConnect, _ := req.Options[amqpConnect].(string)
-			encryptKey, _ := req.Options[encryptBlockKey].(string)
-			blockPrefix, _ := req.Options[encryptedBlockPrefix].(string)
 
 			configPinningService := config.ConfigPinningService{
 				Uploader:             uploaderEndpoint,
@@ -158,9 +181,11 @@ environment variable:
 				EncryptedBlockPrefix: blockPrefix,
 			}
 
-			if err := blockservice.InitBlockService(uploaderEndpoint, pinningServiceEndpoint, dGw, redisConn, amqpConnect, encryptKey, blockPrefix); err != nil {
-				fmt.Printf("InitBlockService  %s\n", err)
-				return errors.New("InitBlockService")
+			if !dryRun {
+				if err := initBlockService(req.Context, configPinningService, defaultBlockServiceRetry); err != nil {
+					fmt.Printf("InitBlockService  %s\n", err)
+					return fmt.Errorf("InitBlockService: %w", err)
+				}
 			}
 			conf, err = config.InitWithIdentity(identity, configPinningService)
 			if err != nil {
@@ -169,33 +194,134 @@ environment variable:
 		}
 
 		profiles, _ := req.Options[profileOptionName].(string)
-		return doInit(os.Stdout, cctx.ConfigRoot, empty, profiles, conf)
+		datastore, _ := req.Options[datastoreOptionName].(string)
+		if dryRun {
+			return dryRunInit(os.Stdout, profiles, datastore, conf)
+		}
+		return doInit(os.Stdout, cctx.ConfigRoot, empty, profiles, datastore, conf)
 	},
 }
 
-func applyProfiles(conf *config.Config, profiles string) error {
-	if profiles == "" {
-		return nil
+// canonicalBlockKey checks a block encryption key decodes, as described at
+// config.DecodeBlockEncryptionKey, to 16, 24 or 32 bytes, and returns it as
+// stored in the config: hex encoded when it was given encoded, as is
+// otherwise. A raw key is never rewritten, since uploaded blocks are
+// encrypted with keys derived from its string.
+func canonicalBlockKey(key string) (string, error) {
+	b, err := config.DecodeBlockEncryptionKey(key)
+	if err != nil {
+		return "", err
 	}
+	encoded := strings.HasPrefix(key, config.BlockKeyHexPrefix) || strings.HasPrefix(key, config.BlockKeyBase64Prefix)
+	switch len(b) {
+	case 16, 24, 32:
+	default:
+		if !encoded {
+			return "", fmt.Errorf("key must be 16, 24 or 32 bytes long, got %d, prefix it with %q or %q if it is encoded", len(b), config.BlockKeyHexPrefix, config.BlockKeyBase64Prefix)
+		}
+		return "", fmt.Errorf("key must be 16, 24 or 32 bytes long, got %d", len(b))
+	}
+	if encoded {
+		return config.BlockKeyHexPrefix + hex.EncodeToString(b), nil
+	}
+	return key, nil
+}
 
+// applyProfiles applies the comma separated profiles to conf, in order, and
+// returns the names of those applied. Repeated profiles are applied once.
+// Unknown or conflicting profiles are rejected before any is applied.
+func applyProfiles(conf *config.Config, profiles string) ([]string, error) {
+	// drop repeated profiles so a transform is never applied twice
+	var names []string
+	seen := make(map[string]struct{})
 	for _, profile := range strings.Split(profiles, ",") {
-		transformer, ok := config.Profiles[profile]
-		if !ok {
-			return fmt.Errorf("invalid configuration profile: %s", profile)
+		profile = strings.TrimSpace(profile)
+		if profile == "" {
+			continue
+		}
+		if _, ok := seen[profile]; ok {
+			continue
 		}
+		if _, ok := config.Profiles[profile]; !ok {
+			return nil, fmt.Errorf("invalid configuration profile: %s", profile)
+		}
+		seen[profile] = struct{}{}
+		names = append(names, profile)
+	}
 
-		if err := transformer.Transform(conf); err != nil {
-			return err
+	if err := config.CheckProfileConflicts(names); err != nil {
+		return nil, err
+	}
+
+	for i, profile := range names {
+		if err := config.Profiles[profile].Transform(conf); err != nil {
+			return names[:i], fmt.Errorf("applying profile %s: %w", profile, err)
 		}
 	}
-	return nil
+	return names, nil
+}
+
+// datastoreProfile returns the profile setting the Datastore.Spec of the
+// datastore backend.
+func datastoreProfile(backend string) (string, error) {
+	switch backend {
+	case "aiozfs", "flatfs", "badgerds":
+		return backend, nil
+	case "tikv":
+		return "", errors.New("the tikv datastore is not available in this build")
+	default:
+		return "", fmt.Errorf("invalid datastore %q, expected aiozfs, flatfs or badgerds", backend)
+	}
 }
 
-func doInit(out io.Writer, repoRoot string, empty bool, confProfiles string, conf *config.Config) error {
+// applyInitProfiles applies the profile of the datastore backend, if any,
+// then confProfiles to conf, returning the names of the profiles applied.
+func applyInitProfiles(conf *config.Config, confProfiles string, datastore string) ([]string, error) {
+	if datastore != "" {
+		profile, err := datastoreProfile(datastore)
+		if err != nil {
+			return nil, err
+		}
+		if confProfiles != "" {
+			profile += "," + confProfiles
+		}
+		confProfiles = profile
+	}
+	return applyProfiles(conf, confProfiles)
+}
+
+// dryRunInit prints to out the configuration doInit would initialize the
+// repo with, its private key aside, once validated. Nothing is written.
+func dryRunInit(out io.Writer, confProfiles string, datastore string, conf *config.Config) error {
+	if _, err := applyInitProfiles(conf, confProfiles, datastore); err != nil {
+		return err
+	}
+	if err := conf.ConfigPinningService.Validate(); err != nil {
+		return err
+	}
+
+	printed := *conf
+	printed.Identity.PrivKey = ""
+	b, err := config.HumanOutput(printed)
+	if err != nil {
+		return err
+	}
+	_, err = out.Write(append(b, '\n'))
+	return err
+}
+
+func doInit(out io.Writer, repoRoot string, empty bool, confProfiles string, datastore string, conf *config.Config) error {
 	if _, err := fmt.Fprintf(out, "initializing IPFS node at %s\n", repoRoot); err != nil {
 		return err
 	}
 
+	// apply profiles before touching the repo so invalid ones leave nothing
+	// behind
+	applied, err := applyInitProfiles(conf, confProfiles, datastore)
+	if err != nil {
+		return err
+	}
+
 	if err := checkWritable(repoRoot); err != nil {
 		return err
 	}
@@ -204,13 +330,14 @@ func doInit(out io.Writer, repoRoot string, empty bool, confProfiles string, con
 		return errRepoExists
 	}
 
-	if err := applyProfiles(conf, confProfiles); err != nil {
-		return err
-	}
-
 	if err := fsrepo.Init(repoRoot, conf); err != nil {
 		return err
 	}
+	if len(applied) > 0 {
+		if _, err := fmt.Fprintf(out, "applied profiles: %s\n", strings.Join(applied, ", ")); err != nil {
+			return err
+		}
+	}
 
 	if !empty {
 		if err := addDefaultAssets(out, repoRoot); err != nil {
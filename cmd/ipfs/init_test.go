@@ -0,0 +1,221 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	options "github.com/ipfs/boxo/coreiface/options"
+	config "github.com/ipfs/kubo/config"
+)
+
+func TestApplyProfilesDedupe(t *testing.T) {
+	applied := 0
+	config.Profiles["test-counting"] = config.Profile{
+		Transform: func(c *config.Config) error {
+			applied++
+			return nil
+		},
+	}
+	defer delete(config.Profiles, "test-counting")
+
+	names, err := applyProfiles(&config.Config{}, "test-counting, test-counting,")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if applied != 1 {
+		t.Fatalf("expected profile to be applied once, applied %d times", applied)
+	}
+	if !slices.Equal(names, []string{"test-counting"}) {
+		t.Fatalf("expected test-counting to be reported once, got %v", names)
+	}
+}
+
+func TestApplyProfilesConflict(t *testing.T) {
+	conf := &config.Config{}
+	if _, err := applyProfiles(conf, "server,local-discovery"); err == nil {
+		t.Fatal("expected conflicting profiles to be rejected")
+	}
+	if _, err := applyProfiles(conf, "flatfs,server,badgerds"); err == nil {
+		t.Fatal("expected two datastore profiles to be rejected")
+	}
+	if len(conf.Swarm.AddrFilters) != 0 {
+		t.Fatal("expected no profile to be applied on conflict")
+	}
+}
+
+func TestApplyProfilesUnknown(t *testing.T) {
+	conf := &config.Config{}
+	if _, err := applyProfiles(conf, "server,invalid_profile"); err == nil {
+		t.Fatal("expected an unknown profile to be rejected")
+	}
+	if len(conf.Swarm.AddrFilters) != 0 {
+		t.Fatal("expected no profile to be applied along with an unknown one")
+	}
+}
+
+func TestApplyProfiles(t *testing.T) {
+	conf := &config.Config{}
+	names, err := applyProfiles(conf, "server,flatfs,lowpower")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(names, []string{"server", "flatfs", "lowpower"}) {
+		t.Fatalf("expected the profiles to be reported in order, got %v", names)
+	}
+	if len(conf.Swarm.AddrFilters) == 0 || !slices.Contains(specTypes(conf.Datastore.Spec), "flatfs") || conf.Reprovider.Interval.WithDefault(time.Hour) != 0 {
+		t.Fatal("expected all the profiles to be applied")
+	}
+}
+
+// specTypes returns the types of the datastores of spec and its children.
+func specTypes(spec map[string]interface{}) []string {
+	types := []string{spec["type"].(string)}
+	if child, ok := spec["child"].(map[string]interface{}); ok {
+		types = append(types, specTypes(child)...)
+	}
+	if mounts, ok := spec["mounts"].([]interface{}); ok {
+		for _, m := range mounts {
+			types = append(types, specTypes(m.(map[string]interface{}))...)
+		}
+	}
+	return types
+}
+
+func TestInitDatastore(t *testing.T) {
+	for _, backend := range []string{"aiozfs", "flatfs", "badgerds"} {
+		t.Run(backend, func(t *testing.T) {
+			profile, err := datastoreProfile(backend)
+			if err != nil {
+				t.Fatal(err)
+			}
+			conf := &config.Config{}
+			if _, err := applyProfiles(conf, profile); err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Contains(specTypes(conf.Datastore.Spec), backend) {
+				t.Fatalf("expected a %s datastore spec, got %v", backend, conf.Datastore.Spec)
+			}
+		})
+	}
+
+	for _, backend := range []string{"tikv", "leveldb"} {
+		t.Run(backend, func(t *testing.T) {
+			repoRoot := filepath.Join(t.TempDir(), "repo")
+			if err := doInit(io.Discard, repoRoot, true, "", backend, &config.Config{}); err == nil {
+				t.Fatal("expected an error")
+			}
+			if _, err := os.Stat(repoRoot); !os.IsNotExist(err) {
+				t.Fatalf("expected the repo not to be created, got %v", err)
+			}
+		})
+	}
+
+	t.Run("conflicting profile", func(t *testing.T) {
+		repoRoot := filepath.Join(t.TempDir(), "repo")
+		if err := doInit(io.Discard, repoRoot, true, "badgerds", "flatfs", &config.Config{}); err == nil {
+			t.Fatal("expected an error")
+		}
+	})
+}
+
+func TestInitDryRun(t *testing.T) {
+	repoRoot := filepath.Join(t.TempDir(), "repo")
+	t.Setenv("IPFS_PATH", repoRoot)
+
+	identity, err := config.CreateIdentity(io.Discard, []options.KeyGenerateOption{options.Key.Type(algorithmDefault)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pinning := config.ConfigPinningService{
+		PinningService:     "https://pinning.example.com",
+		BlockserviceApiKey: "secret",
+		DedicatedGateway:   true,
+	}
+	conf, err := config.InitWithIdentity(identity, pinning)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	if err := dryRunInit(&out, "server", "flatfs", conf); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(repoRoot); !os.IsNotExist(err) {
+		t.Fatalf("expected the repo not to be created, got %v", err)
+	}
+
+	var printed config.Config
+	if err := json.Unmarshal(out.Bytes(), &printed); err != nil {
+		t.Fatalf("expected the config as JSON, got %q: %s", out.String(), err)
+	}
+	if printed.Identity.PeerID != identity.PeerID || printed.Identity.PrivKey != "" {
+		t.Fatalf("expected the identity without its private key, got %+v", printed.Identity)
+	}
+	if printed.ConfigPinningService.PinningService != pinning.PinningService || !printed.ConfigPinningService.DedicatedGateway {
+		t.Fatalf("expected the pinning service settings, got %+v", printed.ConfigPinningService)
+	}
+	if !slices.Contains(specTypes(printed.Datastore.Spec), "flatfs") {
+		t.Fatalf("expected a flatfs datastore spec, got %v", printed.Datastore.Spec)
+	}
+	if len(printed.Swarm.AddrFilters) == 0 {
+		t.Fatal("expected the server profile to be applied")
+	}
+
+	t.Run("invalid config", func(t *testing.T) {
+		conf, err := config.InitWithIdentity(identity, config.ConfigPinningService{PinningService: "https://pinning.example.com"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var out bytes.Buffer
+		if err := dryRunInit(&out, "", "", conf); err == nil {
+			t.Fatal("expected a pinning service without API key to be rejected")
+		}
+		if out.Len() != 0 {
+			t.Fatalf("expected nothing to be printed, got %q", out.String())
+		}
+	})
+}
+
+func TestCanonicalBlockKey(t *testing.T) {
+	const canonical = "hex:000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f"
+	for _, tc := range []struct {
+		name string
+		key  string
+		want string
+	}{
+		{"hex", canonical, canonical},
+		{"upper case hex", "hex:000102030405060708090A0B0C0D0E0F101112131415161718191A1B1C1D1E1F", canonical},
+		{"base64", "base64:AAECAwQFBgcICQoLDA0ODxAREhMUFRYXGBkaGxwdHh8=", canonical},
+		{"unpadded base64", "base64:AAECAwQFBgcICQoLDA0ODxAREhMUFRYXGBkaGxwdHh8", canonical},
+		{"16 bytes hex", "hex:000102030405060708090a0b0c0d0e0f", "hex:000102030405060708090a0b0c0d0e0f"},
+		{"24 bytes base64", "base64:AAECAwQFBgcICQoLDA0ODxAREhMUFRYX", "hex:000102030405060708090a0b0c0d0e0f1011121314151617"},
+		// raw keys are stored as given
+		{"raw", "0123456789abcdef0123456789abcdef", "0123456789abcdef0123456789abcdef"},
+		{"unprefixed hex", "000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f", ""},
+		{"wrong length", "hex:0001020304", ""},
+		{"short passphrase", "secret-key", ""},
+		{"malformed", "hex:not a key!", ""},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := canonicalBlockKey(tc.key)
+			if tc.want == "" {
+				if err == nil {
+					t.Fatalf("expected an error, got %q", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tc.want {
+				t.Fatalf("expected %q, got %q", tc.want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	coreiface "github.com/ipfs/boxo/coreiface"
+	merkledag "github.com/ipfs/boxo/ipld/merkledag"
+	"github.com/ipfs/boxo/path"
+	cid "github.com/ipfs/go-cid"
+	ds "github.com/ipfs/go-datastore"
+	logging "github.com/ipfs/go-log"
+	"github.com/ipfs/kubo/config"
+	"github.com/ipfs/kubo/core"
+	"github.com/ipfs/kubo/core/cidstats"
+	mh "github.com/multiformats/go-multihash"
+	"github.com/redis/go-redis/v9"
+)
+
+// hotlog is the logger for the pinning of the most requested CIDs.
+var hotlog = logging.Logger("pinning/hot")
+
+const (
+	defaultHotPinInterval = 10 * time.Minute
+
+	// hotPinSlowDatastore is the datastore read latency past which the
+	// datastore is considered under pressure, and a run is skipped.
+	hotPinSlowDatastore = 500 * time.Millisecond
+
+	// hotPinMaxBackoff bounds the factor the interval is multiplied by
+	// while the datastore stays under pressure.
+	hotPinMaxBackoff = 8
+)
+
+// hotPinProbeKey is the datastore key read to measure its latency.
+var hotPinProbeKey = ds.NewKey("/local/hotpin")
+
+// hotCIDSource lists the CIDs requested the most, by multihash.
+type hotCIDSource interface {
+	Top(ctx context.Context, n int) ([]cidstats.Count, error)
+}
+
+type redisHotCIDSource struct {
+	client *redis.Client
+}
+
+func (x *redisHotCIDSource) Top(ctx context.Context, n int) ([]cidstats.Count, error) {
+	return cidstats.Top(ctx, x.client, time.Now(), n)
+}
+
+type pinHotNode interface {
+	// Resolve returns the CID of the content with the multihash h, along
+	// with the size of its DAG.
+	Resolve(ctx context.Context, h mh.Multihash) (cid.Cid, uint64, error)
+	IsPinned(ctx context.Context, c cid.Cid) (bool, error)
+	Pin(ctx context.Context, c cid.Cid) error
+	// RepoSize returns the space used by the repo in bytes.
+	RepoSize(ctx context.Context) (uint64, error)
+	// UnderPressure reports whether the datastore is too busy to fetch
+	// more content.
+	UnderPressure(ctx context.Context) bool
+}
+
+type ipfsPinHotNode struct {
+	node *core.IpfsNode
+	api  coreiface.CoreAPI
+}
+
+// Resolve reads the block with the multihash h, local or fetched, and tells
+// its codec by decoding it: the gateway counts requests per multihash, and
+// pinning a dag-pb root as raw would only pin the root block.
+func (x *ipfsPinHotNode) Resolve(ctx context.Context, h mh.Multihash) (cid.Cid, uint64, error) {
+	nd, err := x.api.Dag().Get(ctx, cid.NewCidV1(cid.Raw, h))
+	if err != nil {
+		return cid.Undef, 0, err
+	}
+	if pn, err := merkledag.DecodeProtobuf(nd.RawData()); err == nil {
+		size, err := pn.Size()
+		if err != nil {
+			return cid.Undef, 0, err
+		}
+		return cid.NewCidV1(cid.DagProtobuf, h), size, nil
+	}
+	return nd.Cid(), uint64(len(nd.RawData())), nil
+}
+
+func (x *ipfsPinHotNode) IsPinned(ctx context.Context, c cid.Cid) (bool, error) {
+	_, pinned, err := x.api.Pin().IsPinned(ctx, path.FromCid(c))
+	return pinned, err
+}
+
+func (x *ipfsPinHotNode) Pin(ctx context.Context, c cid.Cid) error {
+	return x.api.Pin().Add(ctx, path.FromCid(c))
+}
+
+func (x *ipfsPinHotNode) RepoSize(ctx context.Context) (uint64, error) {
+	return x.node.Repo.GetStorageUsage(ctx)
+}
+
+func (x *ipfsPinHotNode) UnderPressure(ctx context.Context) bool {
+	start := time.Now()
+	if _, err := x.node.Repo.Datastore().Get(ctx, hotPinProbeKey); err != nil && !errors.Is(err, ds.ErrNotFound) {
+		hotlog.Debugf("probing datastore: %s", err)
+		return true
+	}
+	return time.Since(start) > hotPinSlowDatastore
+}
+
+// startPinHot periodically pins the ConfigPinningService.HotPinTopN CIDs
+// requested the most, as read from src.
+func startPinHot(cctx pinMFSContext, src hotCIDSource, node pinHotNode) {
+	errCh := make(chan error)
+	go pinHotOnInterval(cctx, src, node, errCh)
+	go func() {
+		for {
+			select {
+			case err, isOpen := <-errCh:
+				if !isOpen {
+					return
+				}
+				hotlog.Errorf("%v", err)
+			case <-cctx.Context().Done():
+				return
+			}
+		}
+	}()
+}
+
+func pinHotOnInterval(cctx pinMFSContext, src hotCIDSource, node pinHotNode, errCh chan<- error) {
+	defer close(errCh)
+
+	var tmo *time.Timer
+	defer func() {
+		if tmo != nil {
+			tmo.Stop()
+		}
+	}()
+
+	warmed := map[cid.Cid]struct{}{}
+	backoff := time.Duration(1)
+	for {
+		interval := defaultHotPinInterval
+
+		// reread the config, which may have changed in the meantime
+		cfg, err := cctx.GetConfig()
+		if err != nil {
+			select {
+			case errCh <- fmt.Errorf("pinning hot CIDs reading config (%v)", err):
+			case <-cctx.Context().Done():
+				return
+			}
+		} else {
+			interval = cfg.ConfigPinningService.HotPinInterval.WithDefault(defaultHotPinInterval)
+			if cfg.ConfigPinningService.HotPinTopN > 0 {
+				if node.UnderPressure(cctx.Context()) {
+					backoff = min(backoff*2, hotPinMaxBackoff)
+					hotlog.Warnf("datastore under pressure, pinning hot CIDs again in %s", interval*backoff)
+				} else {
+					backoff = 1
+					pinHotCIDs(cctx.Context(), src, node, &cfg.ConfigPinningService, warmed, errCh)
+				}
+			}
+		}
+
+		// polling sleep
+		if tmo == nil {
+			tmo = time.NewTimer(interval * backoff)
+		} else {
+			tmo.Reset(interval * backoff)
+		}
+		select {
+		case <-cctx.Context().Done():
+			return
+		case <-tmo.C:
+		}
+	}
+}
+
+// pinHotCIDs pins the cfg.HotPinTopN CIDs requested the most that aren't
+// pinned yet, skipping those whose DAG would take the repo past
+// cfg.HotPinDiskBudget. warmed holds the CIDs pinned by earlier runs, which
+// are not checked again.
+func pinHotCIDs(ctx context.Context, src hotCIDSource, node pinHotNode, cfg *config.ConfigPinningService, warmed map[cid.Cid]struct{}, errCh chan<- error) {
+	sendErr := func(err error) {
+		select {
+		case errCh <- err:
+		case <-ctx.Done():
+		}
+	}
+
+	top, err := src.Top(ctx, cfg.HotPinTopN)
+	if err != nil {
+		sendErr(fmt.Errorf("reading the most requested CIDs (%v)", err))
+		return
+	}
+	used, err := node.RepoSize(ctx)
+	if err != nil {
+		sendErr(fmt.Errorf("reading repo size (%v)", err))
+		return
+	}
+	budget := uint64(cfg.HotPinDiskBudget)
+
+	for _, count := range top {
+		h, err := mh.FromHexString(count.Key)
+		if err != nil {
+			sendErr(fmt.Errorf("invalid multihash %q counted (%v)", count.Key, err))
+			continue
+		}
+		c, size, err := node.Resolve(ctx, h)
+		if err != nil {
+			sendErr(fmt.Errorf("resolving hot multihash %s (%v)", h.B58String(), err))
+			continue
+		}
+		if _, ok := warmed[c]; ok {
+			continue
+		}
+		pinned, err := node.IsPinned(ctx, c)
+		if err != nil {
+			sendErr(fmt.Errorf("checking pin of hot CID %q (%v)", c, err))
+			continue
+		}
+		if pinned {
+			warmed[c] = struct{}{}
+			continue
+		}
+		if used+size > budget {
+			hotlog.Debugf("not pinning hot CID %q of %d bytes, repo would exceed HotPinDiskBudget", c, size)
+			continue
+		}
+
+		if err := node.Pin(ctx, c); err != nil {
+			sendErr(fmt.Errorf("pinning hot CID %q (%v)", c, err))
+			continue
+		}
+		// the DAG may have been partly local already, so this overestimates
+		// the space used, which keeps the repo within the budget
+		used += size
+		warmed[c] = struct{}{}
+		hotlog.Infof("warmed %q, requested %d times today, %d bytes", c, count.Requests, size)
+	}
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"testing"
+
+	merkledag "github.com/ipfs/boxo/ipld/merkledag"
+	cid "github.com/ipfs/go-cid"
+	"github.com/ipfs/kubo/config"
+	"github.com/ipfs/kubo/core/cidstats"
+	mh "github.com/multiformats/go-multihash"
+)
+
+type testHotCIDSource []cidstats.Count
+
+func (x testHotCIDSource) Top(_ context.Context, n int) ([]cidstats.Count, error) {
+	return x[:min(n, len(x))], nil
+}
+
+type testPinHotNode struct {
+	sizes    map[cid.Cid]uint64
+	pins     map[cid.Cid]bool
+	repoSize uint64
+	pressure bool
+}
+
+func (x *testPinHotNode) Resolve(_ context.Context, h mh.Multihash) (cid.Cid, uint64, error) {
+	for c, size := range x.sizes {
+		if string(c.Hash()) == string(h) {
+			return c, size, nil
+		}
+	}
+	return cid.Undef, 0, fmt.Errorf("block not found")
+}
+
+func (x *testPinHotNode) IsPinned(_ context.Context, c cid.Cid) (bool, error) {
+	return x.pins[c], nil
+}
+
+func (x *testPinHotNode) Pin(_ context.Context, c cid.Cid) error {
+	x.pins[c] = true
+	x.repoSize += x.sizes[c]
+	return nil
+}
+
+func (x *testPinHotNode) RepoSize(context.Context) (uint64, error) {
+	return x.repoSize, nil
+}
+
+func (x *testPinHotNode) UnderPressure(context.Context) bool {
+	return x.pressure
+}
+
+func hotCount(c cid.Cid, requests int64) cidstats.Count {
+	return cidstats.Count{Key: hex.EncodeToString(c.Hash()), Requests: requests}
+}
+
+func TestPinHotCIDs(t *testing.T) {
+	ctx := context.Background()
+	first := merkledag.NewRawNode([]byte{0x01}).Cid()
+	second := merkledag.NodeWithData([]byte{0x02}).Cid()
+	third := merkledag.NewRawNode([]byte{0x03}).Cid()
+	cold := merkledag.NewRawNode([]byte{0x04}).Cid()
+
+	src := testHotCIDSource{hotCount(first, 30), hotCount(second, 20), hotCount(third, 10), hotCount(cold, 1)}
+	node := &testPinHotNode{
+		sizes:    map[cid.Cid]uint64{first: 100, second: 200, third: 300, cold: 10},
+		pins:     map[cid.Cid]bool{},
+		repoSize: 1000,
+	}
+	cfg := &config.ConfigPinningService{HotPinTopN: 3, HotPinDiskBudget: 1400}
+	errCh := make(chan error, 10)
+
+	pinHotCIDs(ctx, src, node, cfg, map[cid.Cid]struct{}{}, errCh)
+	if !node.pins[first] || !node.pins[second] {
+		t.Fatalf("expected the most requested CIDs to be pinned, got %v", node.pins)
+	}
+	if node.pins[third] {
+		t.Fatal("expected the CID past the disk budget not to be pinned")
+	}
+	if node.pins[cold] {
+		t.Fatal("expected the CID past the top N not to be pinned")
+	}
+
+	cfg.HotPinDiskBudget = 2000
+	pinHotCIDs(ctx, src, node, cfg, map[cid.Cid]struct{}{}, errCh)
+	if !node.pins[third] {
+		t.Fatal("expected the CID within the raised disk budget to be pinned")
+	}
+	if node.repoSize != 1600 {
+		t.Fatalf("expected the pinned CIDs to be pinned once, repo size is %d", node.repoSize)
+	}
+
+	select {
+	case err := <-errCh:
+		t.Fatalf("unexpected error: %s", err)
+	default:
+	}
+}
+
+func TestPinHotCIDsResolveError(t *testing.T) {
+	missing := merkledag.NewRawNode([]byte{0x01}).Cid()
+	node := &testPinHotNode{sizes: map[cid.Cid]uint64{}, pins: map[cid.Cid]bool{}}
+	cfg := &config.ConfigPinningService{HotPinTopN: 1, HotPinDiskBudget: 1 << 20}
+	errCh := make(chan error, 1)
+	pinHotCIDs(context.Background(), testHotCIDSource{hotCount(missing, 1)}, node, cfg, map[cid.Cid]struct{}{}, errCh)
+	if err := <-errCh; err == nil {
+		t.Fatal("expected resolution error")
+	}
+	if len(node.pins) != 0 {
+		t.Fatal("expected nothing to be pinned")
+	}
+}
+
+func TestPinHotUnderPressure(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	hot := merkledag.NewRawNode([]byte{0x01}).Cid()
+	node := &testPinHotNode{
+		sizes:    map[cid.Cid]uint64{hot: 100},
+		pins:     map[cid.Cid]bool{},
+		pressure: true,
+	}
+	cctx := &testPinMFSContext{ctx: ctx, cfg: &config.Config{ConfigPinningService: config.ConfigPinningService{
+		HotPinTopN:       1,
+		HotPinDiskBudget: 1 << 20,
+	}}}
+	errCh := make(chan error)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		pinHotOnInterval(cctx, testHotCIDSource{hotCount(hot, 1)}, node, errCh)
+	}()
+	// the first run is skipped, the next one is a backed off interval away
+	cancel()
+	<-done
+	if len(node.pins) != 0 {
+		t.Fatal("expected nothing to be pinned while the datastore is under pressure")
+	}
+}
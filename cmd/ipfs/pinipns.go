@@ -0,0 +1,271 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	coreiface "github.com/ipfs/boxo/coreiface"
+	"github.com/ipfs/boxo/path"
+	cid "github.com/ipfs/go-cid"
+	ds "github.com/ipfs/go-datastore"
+	logging "github.com/ipfs/go-log"
+)
+
+// ipnslog is the logger for IPNS target warming.
+var ipnslog = logging.Logger("pinning/ipns")
+
+const defaultIpnsWarmInterval = 10 * time.Minute
+
+// ipnsTargetsKey is the datastore key of the targets pinned by the job, so
+// they are unpinned when their name moves after a restart.
+var ipnsTargetsKey = ds.NewKey("/local/pinning/ipns-targets")
+
+type pinIPNSNode interface {
+	Resolve(ctx context.Context, name string) (cid.Cid, error)
+	IsPinned(ctx context.Context, c cid.Cid) (bool, error)
+	Pin(ctx context.Context, c cid.Cid) error
+	Unpin(ctx context.Context, c cid.Cid) error
+	// LoadTargets returns the targets saved with SaveTargets.
+	LoadTargets(ctx context.Context) (map[string]cid.Cid, error)
+	SaveTargets(ctx context.Context, targets map[string]cid.Cid) error
+}
+
+type ipfsPinIPNSNode struct {
+	api coreiface.CoreAPI
+	ds  ds.Datastore
+}
+
+func (x *ipfsPinIPNSNode) Resolve(ctx context.Context, name string) (cid.Cid, error) {
+	if !strings.HasPrefix(name, "/ipns/") {
+		name = "/ipns/" + name
+	}
+	p, err := path.NewPath(name)
+	if err != nil {
+		return cid.Undef, err
+	}
+	rp, _, err := x.api.ResolvePath(ctx, p)
+	if err != nil {
+		return cid.Undef, err
+	}
+	return rp.RootCid(), nil
+}
+
+func (x *ipfsPinIPNSNode) IsPinned(ctx context.Context, c cid.Cid) (bool, error) {
+	_, pinned, err := x.api.Pin().IsPinned(ctx, path.FromCid(c))
+	return pinned, err
+}
+
+func (x *ipfsPinIPNSNode) Pin(ctx context.Context, c cid.Cid) error {
+	return x.api.Pin().Add(ctx, path.FromCid(c))
+}
+
+func (x *ipfsPinIPNSNode) Unpin(ctx context.Context, c cid.Cid) error {
+	return x.api.Pin().Rm(ctx, path.FromCid(c))
+}
+
+func (x *ipfsPinIPNSNode) LoadTargets(ctx context.Context) (map[string]cid.Cid, error) {
+	b, err := x.ds.Get(ctx, ipnsTargetsKey)
+	if errors.Is(err, ds.ErrNotFound) {
+		return map[string]cid.Cid{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var saved map[string]string
+	if err := json.Unmarshal(b, &saved); err != nil {
+		return nil, err
+	}
+	targets := make(map[string]cid.Cid, len(saved))
+	for name, s := range saved {
+		c, err := cid.Decode(s)
+		if err != nil {
+			return nil, fmt.Errorf("target of %q: %w", name, err)
+		}
+		targets[name] = c
+	}
+	return targets, nil
+}
+
+func (x *ipfsPinIPNSNode) SaveTargets(ctx context.Context, targets map[string]cid.Cid) error {
+	saved := make(map[string]string, len(targets))
+	for name, c := range targets {
+		saved[name] = c.String()
+	}
+	b, err := json.Marshal(saved)
+	if err != nil {
+		return err
+	}
+	return x.ds.Put(ctx, ipnsTargetsKey, b)
+}
+
+// startPinIPNS periodically resolves the IPNS names listed in
+// ConfigPinningService.IpnsWarmNames and keeps their current targets pinned.
+func startPinIPNS(cctx pinMFSContext, node pinIPNSNode) {
+	errCh := make(chan error)
+	go pinIPNSOnInterval(cctx, node, errCh)
+	go func() {
+		for {
+			select {
+			case err, isOpen := <-errCh:
+				if !isOpen {
+					return
+				}
+				ipnslog.Errorf("%v", err)
+			case <-cctx.Context().Done():
+				return
+			}
+		}
+	}()
+}
+
+func pinIPNSOnInterval(cctx pinMFSContext, node pinIPNSNode, errCh chan<- error) {
+	defer close(errCh)
+
+	var tmo *time.Timer
+	defer func() {
+		if tmo != nil {
+			tmo.Stop()
+		}
+	}()
+
+	targets, err := node.LoadTargets(cctx.Context())
+	if err != nil {
+		// targets pinned before the restart stay pinned
+		select {
+		case errCh <- fmt.Errorf("loading pinned IPNS targets (%v)", err):
+		case <-cctx.Context().Done():
+			return
+		}
+		targets = map[string]cid.Cid{}
+	}
+	for {
+		interval := defaultIpnsWarmInterval
+
+		// reread the config, which may have changed in the meantime
+		cfg, err := cctx.GetConfig()
+		if err != nil {
+			select {
+			case errCh <- fmt.Errorf("pinning IPNS targets reading config (%v)", err):
+			case <-cctx.Context().Done():
+				return
+			}
+		} else {
+			interval = cfg.ConfigPinningService.IpnsWarmInterval.WithDefault(defaultIpnsWarmInterval)
+			if pinIPNSTargets(cctx.Context(), node, cfg.ConfigPinningService.IpnsWarmNames, targets, errCh) {
+				if err := node.SaveTargets(cctx.Context(), targets); err != nil {
+					select {
+					case errCh <- fmt.Errorf("saving pinned IPNS targets (%v)", err):
+					case <-cctx.Context().Done():
+						return
+					}
+				}
+			}
+		}
+
+		// polling sleep
+		if tmo == nil {
+			tmo = time.NewTimer(interval)
+		} else {
+			tmo.Reset(interval)
+		}
+		select {
+		case <-cctx.Context().Done():
+			return
+		case <-tmo.C:
+		}
+	}
+}
+
+// pinIPNSTargets resolves every name, pins its current target and unpins the
+// target it had on the previous run. Targets of names that were removed from
+// the configuration are unpinned as well. Only targets pinned by this job,
+// recorded in targets, are ever unpinned: a target that was already pinned
+// is left to whoever pinned it. A target still referenced by another name
+// is kept. It reports whether targets changed.
+func pinIPNSTargets(ctx context.Context, node pinIPNSNode, names []string, targets map[string]cid.Cid, errCh chan<- error) bool {
+	sendErr := func(err error) {
+		select {
+		case errCh <- err:
+		case <-ctx.Done():
+		}
+	}
+
+	var changed bool
+	stale := map[cid.Cid]struct{}{}
+	wanted := make(map[string]struct{}, len(names))
+	for _, name := range names {
+		wanted[name] = struct{}{}
+
+		c, err := node.Resolve(ctx, name)
+		if err != nil {
+			sendErr(fmt.Errorf("resolving IPNS name %q (%v)", name, err))
+			continue
+		}
+
+		prev, ok := targets[name]
+		if ok && prev == c {
+			ipnslog.Debugf("IPNS name %q still points at %q, skipping", name, c)
+			continue
+		}
+
+		if !isIPNSTarget(targets, c) {
+			pinned, err := node.IsPinned(ctx, c)
+			if err != nil {
+				sendErr(fmt.Errorf("checking pin of target %q of IPNS name %q (%v)", c, name, err))
+				continue
+			}
+			if pinned {
+				ipnslog.Debugf("target %q of IPNS name %q is already pinned, leaving it", c, name)
+				if ok {
+					delete(targets, name)
+					stale[prev] = struct{}{}
+					changed = true
+				}
+				continue
+			}
+
+			ipnslog.Debugf("pinning target %q of IPNS name %q", c, name)
+			if err := node.Pin(ctx, c); err != nil {
+				sendErr(fmt.Errorf("pinning target %q of IPNS name %q (%v)", c, name, err))
+				continue
+			}
+		}
+		targets[name] = c
+		changed = true
+		if ok {
+			stale[prev] = struct{}{}
+		}
+	}
+
+	for name, c := range targets {
+		if _, ok := wanted[name]; !ok {
+			delete(targets, name)
+			stale[c] = struct{}{}
+			changed = true
+		}
+	}
+
+	for c := range stale {
+		if isIPNSTarget(targets, c) {
+			continue
+		}
+		ipnslog.Debugf("unpinning previous IPNS target %q", c)
+		if err := node.Unpin(ctx, c); err != nil {
+			sendErr(fmt.Errorf("unpinning previous IPNS target %q (%v)", c, err))
+		}
+	}
+	return changed
+}
+
+func isIPNSTarget(targets map[string]cid.Cid, c cid.Cid) bool {
+	for _, t := range targets {
+		if t == c {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	merkledag "github.com/ipfs/boxo/ipld/merkledag"
+	cid "github.com/ipfs/go-cid"
+	ds "github.com/ipfs/go-datastore"
+)
+
+type testPinIPNSNode struct {
+	names map[string]cid.Cid
+	pins  map[cid.Cid]bool
+}
+
+func (x *testPinIPNSNode) Resolve(_ context.Context, name string) (cid.Cid, error) {
+	c, ok := x.names[name]
+	if !ok {
+		return cid.Undef, fmt.Errorf("could not resolve name")
+	}
+	return c, nil
+}
+
+func (x *testPinIPNSNode) IsPinned(_ context.Context, c cid.Cid) (bool, error) {
+	return x.pins[c], nil
+}
+
+func (x *testPinIPNSNode) LoadTargets(context.Context) (map[string]cid.Cid, error) {
+	return map[string]cid.Cid{}, nil
+}
+
+func (x *testPinIPNSNode) SaveTargets(context.Context, map[string]cid.Cid) error {
+	return nil
+}
+
+func (x *testPinIPNSNode) Pin(_ context.Context, c cid.Cid) error {
+	x.pins[c] = true
+	return nil
+}
+
+func (x *testPinIPNSNode) Unpin(_ context.Context, c cid.Cid) error {
+	if !x.pins[c] {
+		return fmt.Errorf("not pinned")
+	}
+	delete(x.pins, c)
+	return nil
+}
+
+func TestPinIPNSTargets(t *testing.T) {
+	ctx := context.Background()
+	oldTarget := merkledag.NewRawNode([]byte{0x01}).Cid()
+	newTarget := merkledag.NewRawNode([]byte{0x02}).Cid()
+
+	node := &testPinIPNSNode{
+		names: map[string]cid.Cid{"example.com": oldTarget},
+		pins:  map[cid.Cid]bool{},
+	}
+	targets := map[string]cid.Cid{}
+	errCh := make(chan error, 10)
+
+	pinIPNSTargets(ctx, node, []string{"example.com"}, targets, errCh)
+	if !node.pins[oldTarget] {
+		t.Fatal("expected current target to be pinned")
+	}
+
+	node.names["example.com"] = newTarget
+	pinIPNSTargets(ctx, node, []string{"example.com"}, targets, errCh)
+	if !node.pins[newTarget] {
+		t.Fatal("expected updated target to be pinned")
+	}
+	if node.pins[oldTarget] {
+		t.Fatal("expected previous target to be unpinned")
+	}
+
+	pinIPNSTargets(ctx, node, nil, targets, errCh)
+	if len(node.pins) != 0 {
+		t.Fatal("expected target of removed name to be unpinned")
+	}
+
+	select {
+	case err := <-errCh:
+		t.Fatalf("unexpected error: %s", err)
+	default:
+	}
+}
+
+func TestPinIPNSTargetsResolveError(t *testing.T) {
+	node := &testPinIPNSNode{
+		names: map[string]cid.Cid{},
+		pins:  map[cid.Cid]bool{},
+	}
+	errCh := make(chan error, 1)
+	pinIPNSTargets(context.Background(), node, []string{"missing.example.com"}, map[string]cid.Cid{}, errCh)
+	if err := <-errCh; err == nil {
+		t.Fatal("expected resolution error")
+	}
+}
+
+func TestPinIPNSTargetsLeavesUserPins(t *testing.T) {
+	ctx := context.Background()
+	userPinned := merkledag.NewRawNode([]byte{0x01}).Cid()
+	newTarget := merkledag.NewRawNode([]byte{0x02}).Cid()
+
+	node := &testPinIPNSNode{
+		names: map[string]cid.Cid{"example.com": userPinned},
+		pins:  map[cid.Cid]bool{userPinned: true},
+	}
+	targets := map[string]cid.Cid{}
+	errCh := make(chan error, 10)
+
+	if pinIPNSTargets(ctx, node, []string{"example.com"}, targets, errCh) {
+		t.Fatal("expected a target pinned by the user not to be recorded")
+	}
+	node.names["example.com"] = newTarget
+	pinIPNSTargets(ctx, node, []string{"example.com"}, targets, errCh)
+	if !node.pins[userPinned] {
+		t.Fatal("expected the target pinned by the user to stay pinned when the name moves")
+	}
+	pinIPNSTargets(ctx, node, nil, targets, errCh)
+	if !node.pins[userPinned] || node.pins[newTarget] {
+		t.Fatalf("expected only the target pinned by the job to be unpinned, got %v", node.pins)
+	}
+}
+
+func TestIPNSTargetsSaved(t *testing.T) {
+	ctx := context.Background()
+	node := &ipfsPinIPNSNode{ds: ds.NewMapDatastore()}
+	if targets, err := node.LoadTargets(ctx); err != nil || len(targets) != 0 {
+		t.Fatalf("expected no targets, got %v, %v", targets, err)
+	}
+	want := map[string]cid.Cid{"example.com": merkledag.NewRawNode([]byte{0x01}).Cid()}
+	if err := node.SaveTargets(ctx, want); err != nil {
+		t.Fatal(err)
+	}
+	got, err := node.LoadTargets(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || got["example.com"] != want["example.com"] {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+}
@@ -10,9 +10,14 @@ import (
 	"os"
 	"os/signal"
 	"sync"
+	"sync/atomic"
 	"syscall"
 )
 
+// reloadHandlers counts the handlers set up by SetupReloadHandler that are
+// still open. While there is any, SIGHUP reloads instead of shutting down.
+var reloadHandlers atomic.Int32
+
 // IntrHandler helps set up an interrupt handler that can
 // be cleanly shut down through the io.Closer interface.
 type IntrHandler struct {
@@ -60,8 +65,10 @@ func SetupInterruptHandler(ctx context.Context) (io.Closer, context.Context) {
 	intrh := NewIntrHandler()
 	ctx, cancelFunc := context.WithCancel(ctx)
 
-	handlerFunc := func(count int, ih *IntrHandler) {
-		switch count {
+	// SIGINT, SIGTERM and SIGHUP share the count of interrupts caught
+	var interrupts atomic.Int32
+	handlerFunc := func(_ int, ih *IntrHandler) {
+		switch interrupts.Add(1) {
 		case 1:
 			fmt.Println() // Prevent un-terminated ^C character in terminal
 
@@ -77,7 +84,33 @@ func SetupInterruptHandler(ctx context.Context) (io.Closer, context.Context) {
 		}
 	}
 
-	intrh.Handle(handlerFunc, syscall.SIGHUP, syscall.SIGINT, syscall.SIGTERM)
+	intrh.Handle(handlerFunc, syscall.SIGINT, syscall.SIGTERM)
+	intrh.Handle(func(count int, ih *IntrHandler) {
+		if reloadHandlers.Load() > 0 {
+			return
+		}
+		handlerFunc(count, ih)
+	}, syscall.SIGHUP)
 
 	return intrh, ctx
 }
+
+// SetupReloadHandler calls reload each time a SIGHUP is caught, until the
+// returned io.Closer is closed. Until then, SIGHUP no longer interrupts the
+// handler set up by SetupInterruptHandler.
+func SetupReloadHandler(reload func()) io.Closer {
+	intrh := NewIntrHandler()
+	reloadHandlers.Add(1)
+	intrh.Handle(func(int, *IntrHandler) { reload() }, syscall.SIGHUP)
+	return reloadCloser{intrh}
+}
+
+type reloadCloser struct {
+	*IntrHandler
+}
+
+func (c reloadCloser) Close() error {
+	err := c.IntrHandler.Close()
+	reloadHandlers.Add(-1)
+	return err
+}
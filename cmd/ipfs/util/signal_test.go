@@ -0,0 +1,55 @@
+//go:build !windows && !plan9 && !wasm
+
+package util
+
+import (
+	"context"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestSetupReloadHandler(t *testing.T) {
+	reloaded := make(chan struct{}, 1)
+	closer := SetupReloadHandler(func() { reloaded <- struct{}{} })
+	defer closer.Close()
+
+	if err := syscall.Kill(os.Getpid(), syscall.SIGHUP); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-reloaded:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected SIGHUP to trigger a reload")
+	}
+}
+
+func TestReloadHandlerTakesOverSIGHUP(t *testing.T) {
+	intrh, ctx := SetupInterruptHandler(context.Background())
+	defer intrh.Close()
+
+	reloaded := make(chan struct{}, 1)
+	reloadh := SetupReloadHandler(func() { reloaded <- struct{}{} })
+	if err := syscall.Kill(os.Getpid(), syscall.SIGHUP); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-reloaded:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected SIGHUP to trigger a reload")
+	}
+	if ctx.Err() != nil {
+		t.Fatal("expected SIGHUP not to interrupt while reloading on it")
+	}
+	reloadh.Close()
+
+	if err := syscall.Kill(os.Getpid(), syscall.SIGHUP); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-ctx.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected SIGHUP to interrupt once the reload handler is closed")
+	}
+}
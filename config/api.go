@@ -2,4 +2,12 @@ package config
 
 type API struct {
 	HTTPHeaders map[string][]string // HTTP headers to return with the API.
+
+	// MaxRequestBodySize is the size in bytes past which API request bodies
+	// are rejected with 413. Zero means unlimited.
+	MaxRequestBodySize int64 `json:",omitempty"`
+
+	// RequestTimeout bounds the time taken by each API request, reading its
+	// body included. Zero means no timeout.
+	RequestTimeout *OptionalDuration `json:",omitempty"`
 }
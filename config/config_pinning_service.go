@@ -1,5 +1,21 @@
 package config
 
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/netip"
+	"net/url"
+	"os"
+	"reflect"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/ipfs/go-cid"
+)
+
 type ConfigPinningService struct {
 	Uploader             string
 	PinningService       string
@@ -9,4 +25,886 @@ type ConfigPinningService struct {
 	AmqpConnect          string
 	BlockEncryptionKey   string
 	EncryptedBlockPrefix string
+
+	// RefererAllowlist is the list of domains allowed to embed content served
+	// by the gateway. Subdomains of a listed domain are allowed as well. When
+	// empty, referer checks are disabled.
+	RefererAllowlist []string `json:",omitempty"`
+
+	// RefererDenyStatus is the HTTP status code returned to requests whose
+	// Referer is not in RefererAllowlist. Defaults to 403.
+	RefererDenyStatus int `json:",omitempty"`
+
+	// BlockEmptyReferer rejects requests without a Referer header when
+	// RefererAllowlist is set. By default such requests (direct navigation)
+	// are allowed.
+	BlockEmptyReferer bool `json:",omitempty"`
+
+	// IpnsWarmNames lists IPNS names whose current target is periodically
+	// resolved and pinned, so gateway requests for them hit local content.
+	IpnsWarmNames []string `json:",omitempty"`
+
+	// IpnsWarmInterval is how often IpnsWarmNames are re-resolved.
+	// Defaults to 10 minutes.
+	IpnsWarmInterval *OptionalDuration `json:",omitempty"`
+
+	// MaxObjectSize is the maximum size in bytes of an object served by the
+	// gateway. Larger objects are rejected with 413. Zero means unlimited.
+	MaxObjectSize int64 `json:",omitempty"`
+
+	// MaxConcurrentRequests bounds the number of gateway requests served at
+	// the same time. When saturated, requests that passed the dedicated
+	// gateway subscription check are admitted ahead of anonymous ones.
+	// Zero means unlimited.
+	MaxConcurrentRequests int `json:",omitempty"`
+
+	// MaxConnsPerIP bounds the number of simultaneous connections accepted
+	// from a single client IP, resolved through TrustedProxies. Connections
+	// past the limit are rejected with 429 and closed, those admitted before
+	// keep being served. Zero means unlimited.
+	MaxConnsPerIP int `json:",omitempty"`
+
+	// MaxConcurrentPerCID bounds the number of gateway requests for the same
+	// content served at the same time, so a large object can't tie up the
+	// datastore. Requests past the limit are rejected with 503 and a
+	// Retry-After. Zero means unlimited.
+	MaxConcurrentPerCID int `json:",omitempty"`
+
+	// ResponseTimeouts sets the write timeout of gateway responses according
+	// to their Content-Length, counted from the response header. The tier
+	// with the smallest MaxSize fitting the response applies, responses of
+	// unknown size only fitting a tier without MaxSize. When empty, responses
+	// have no write timeout.
+	ResponseTimeouts []ResponseTimeoutTier `json:",omitempty"`
+
+	// ServerReadHeaderTimeout bounds the time a client takes to send the
+	// headers of a request, so slow clients can't hold connections open.
+	// Defaults to 10 seconds.
+	ServerReadHeaderTimeout *OptionalDuration `json:",omitempty"`
+
+	// ServerReadTimeout bounds the time a client takes to send a whole
+	// request, body included. Defaults to 0, no timeout.
+	ServerReadTimeout *OptionalDuration `json:",omitempty"`
+
+	// ServerWriteTimeout bounds the time taken to write a response, from the
+	// end of the request headers. ResponseTimeouts take precedence for the
+	// gateway responses they apply to. Defaults to 0, no timeout.
+	ServerWriteTimeout *OptionalDuration `json:",omitempty"`
+
+	// ServerIdleTimeout is how long idle keep-alive connections are kept
+	// open. Defaults to 2 minutes.
+	ServerIdleTimeout *OptionalDuration `json:",omitempty"`
+
+	// ShutdownDrainDelay is how long the HTTP servers keep serving once the
+	// node is shutting down, /readyz answering 503 meanwhile, so that load
+	// balancers stop sending requests before connections are refused.
+	// In-flight requests are then given up to 30 seconds to complete.
+	// Defaults to 0, shutting down right away.
+	ShutdownDrainDelay *OptionalDuration `json:",omitempty"`
+
+	// PrefetchDepth enables warming the blocks linked from a directory after
+	// its index.html is served, down to the given depth below the
+	// directory. Zero disables prefetching.
+	PrefetchDepth int `json:",omitempty"`
+
+	// PrefetchMaxBlocks bounds the number of blocks fetched by a single
+	// prefetch. Defaults to 64.
+	PrefetchMaxBlocks int `json:",omitempty"`
+
+	// CanonicalGatewayPaths redirects gateway requests with 301 to their
+	// canonical path before any access check: duplicate slashes are
+	// collapsed, segments are percent-encoded the default way, slashes
+	// encoded within a segment staying encoded, and the trailing slash is
+	// handled as TrailingSlash says. Paths with ".." segments are rejected
+	// with 400.
+	CanonicalGatewayPaths bool `json:",omitempty"`
+
+	// TrailingSlash is how CanonicalGatewayPaths handles the trailing slash
+	// of gateway paths: TrailingSlashAdd or TrailingSlashRemove redirect
+	// "/dir" and "/dir/" to one of them, so relative links resolve the same
+	// way. By default the trailing slash is left as requested. The gateway
+	// redirects directory listings to their path with a slash, which
+	// TrailingSlashRemove would undo, so it only suits gateways serving
+	// files.
+	TrailingSlash string `json:",omitempty"`
+
+	// LimiterIdleTimeout is how long the per-IP and per-CID rate limiters of
+	// the public gateway are kept after their last use. Defaults to 10
+	// minutes.
+	LimiterIdleTimeout *OptionalDuration `json:",omitempty"`
+
+	// DmcaCacheSize is the number of CIDs whose DMCA check result is kept in
+	// memory, the least recently used ones being evicted past it. Defaults
+	// to 10000.
+	DmcaCacheSize int `json:",omitempty"`
+
+	// DmcaAllowedTTL is how long content found not to be blocked is served
+	// without checking again. Defaults to 5 minutes.
+	DmcaAllowedTTL *OptionalDuration `json:",omitempty"`
+
+	// DmcaBlockedTTL is how long blocked content is remembered as blocked.
+	// By default it stays blocked until evicted or the daemon restarts.
+	DmcaBlockedTTL *OptionalDuration `json:",omitempty"`
+
+	// AccessErrorCooldown is how long requests for a CID fail fast after its
+	// DMCA or dedicated gateway check failed upstream, instead of calling
+	// the pinning service again. Defaults to 10 seconds.
+	AccessErrorCooldown *OptionalDuration `json:",omitempty"`
+
+	// AccessErrorCooldownSize is the number of CIDs cooling down after a
+	// failed check kept in memory, the least recently used ones being
+	// evicted past it. Defaults to 10000.
+	AccessErrorCooldownSize int `json:",omitempty"`
+
+	// IPRateLimit is the number of public gateway requests a client IP can
+	// make per RateLimitWindow. Defaults to 100.
+	IPRateLimit int `json:",omitempty"`
+
+	// CIDRateLimit is the number of public gateway requests for a single CID
+	// per RateLimitWindow. Defaults to 15.
+	CIDRateLimit int `json:",omitempty"`
+
+	// RateLimitWindow is the period IPRateLimit and CIDRateLimit apply to.
+	// Defaults to 1 minute.
+	RateLimitWindow *OptionalDuration `json:",omitempty"`
+
+	// ClientKeyHeader is the request header from which dedicated gateway
+	// clients can send their own API key. The key is forwarded to the pinning
+	// service to authorize the request. A "Bearer " scheme, as sent in the
+	// Authorization header, is stripped. When empty, keys are not read from
+	// headers.
+	ClientKeyHeader string `json:",omitempty"`
+
+	// ClientKeyParam is the query parameter from which dedicated gateway
+	// clients can send their own API key, for links where a header can't be
+	// set. When empty, keys are not read from the query.
+	ClientKeyParam string `json:",omitempty"`
+
+	// RequireClientKey answers dedicated gateway requests sent without a
+	// client API key with 401, instead of leaving the pinning service to
+	// decide. AllowedCIDs are still served without a key. Defaults to true
+	// when ClientKeyHeader or ClientKeyParam is set.
+	RequireClientKey Flag `json:",omitempty"`
+
+	// URLSigningKey is the secret signed dedicated gateway URLs and appeal
+	// tokens are checked with. URLs carrying a valid, unexpired signature for
+	// their CID are served without asking the pinning service. Defaults to
+	// BlockserviceApiKey.
+	URLSigningKey string `json:",omitempty"`
+
+	// AccessDecisionOrder is the order of the access checks of dedicated
+	// gateway requests. Each of them allows the request, skipping the checks
+	// left, denies it, or defers to the next one:
+	//   - "denylist" denies the content of the DMCA denylists,
+	//   - "appeal-token" allows URLs carrying a valid appeal token for their
+	//     CID, issued for content whose takedown is being appealed, and
+	//     denies invalid ones,
+	//   - "dmca-upstream" denies the content the pinning service blocks,
+	//   - "signed-url" allows URLs carrying a valid signature for their CID,
+	//     and denies invalid ones,
+	//   - "subscription" allows AllowedCIDs and the content the client has a
+	//     subscription to, and denies the rest.
+	// The order must list all of them. Defaults to DefaultAccessDecisionOrder.
+	AccessDecisionOrder []string `json:",omitempty"`
+
+	// AccessCacheTTL is how long dedicated gateway access decisions are
+	// cached per CID and client key. Defaults to 1 minute, zero disables the
+	// cache.
+	AccessCacheTTL *OptionalDuration `json:",omitempty"`
+
+	// AccessCacheSize is the number of dedicated gateway access decisions
+	// kept in memory, the least recently used ones being evicted past it.
+	// Defaults to 10000.
+	AccessCacheSize int `json:",omitempty"`
+
+	// StaleIfError is how long past their expiry the cached decisions
+	// allowing content, those of the DMCA check and of the dedicated gateway
+	// access check, are still honored while the pinning service fails, so
+	// content known to be servable is served during an outage. Defaults to
+	// 0, disabled.
+	StaleIfError *OptionalDuration `json:",omitempty"`
+
+	// EncryptBlocksAtRest encrypts the blocks stored in the repo with
+	// AES-256-GCM, using BlockEncryptionKey, which must then be 32 bytes
+	// long, see DecodeBlockEncryptionKey. Encrypted blocks are tagged with
+	// EncryptedBlockPrefix, blocks stored before encryption was enabled are
+	// still read.
+	EncryptBlocksAtRest bool `json:",omitempty"`
+
+	// BlockEncryptionKeys replaces BlockEncryptionKey for encryption at rest
+	// to rotate keys. New blocks are encrypted with the active key and
+	// blocks are read with the key they were written with. Blocks written
+	// with BlockEncryptionKey before keys were listed are read with the key
+	// of ID "".
+	BlockEncryptionKeys []BlockEncryptionKeyEntry `json:",omitempty"`
+
+	// AmqpEventRoutingKey enables publishing an event to AmqpConnect for
+	// each block written and each pin added or removed, with this routing
+	// key.
+	AmqpEventRoutingKey string `json:",omitempty"`
+
+	// AmqpEventExchange is the exchange events are published to. Defaults to
+	// the default exchange, which routes them to the queue named after
+	// AmqpEventRoutingKey.
+	AmqpEventExchange string `json:",omitempty"`
+
+	// AmqpEventBufferSize is the number of events kept while the broker is
+	// unreachable. Past it, the oldest events are dropped. Defaults to 1000.
+	AmqpEventBufferSize int `json:",omitempty"`
+
+	// EventStream serves the block and pin events on the /events endpoint
+	// of the API as server-sent events, whether or not they are published
+	// to AmqpConnect.
+	EventStream bool `json:",omitempty"`
+
+	// SslCertPath and SslKeyPath are the PEM encoded certificate and key
+	// files the HTTP servers, API and gateway, are served with over TLS.
+	// They must be set together, to readable files. When empty, plain HTTP
+	// is served. The files are reloaded when they change, or right away on
+	// a POST to /debug/tls/reload.
+	SslCertPath string `json:",omitempty"`
+	SslKeyPath  string `json:",omitempty"`
+
+	// AllowedCIDs lists content that is always served: requests for these
+	// CIDs skip the dedicated gateway access check and the public gateway
+	// rate limits. CIDv0 and CIDv1 of the same content both match. The DMCA
+	// check still applies.
+	AllowedCIDs []string `json:",omitempty"`
+
+	// IPAllowlist lists the client addresses, as IPs or CIDR ranges, that
+	// skip the public gateway IP rate limit, such as the egress IPs of a CDN
+	// in front of the gateway.
+	IPAllowlist []string `json:",omitempty"`
+
+	// IPDenylist lists the client addresses, as IPs or CIDR ranges, whose
+	// requests are rejected with 403 before any other check.
+	IPDenylist []string `json:",omitempty"`
+
+	// TrustedProxies lists the addresses, as IPs or CIDR ranges, of the
+	// proxies in front of the gateway, such as load balancers, whose
+	// X-Forwarded-For header is trusted to carry the client address. It is
+	// the address the IP rate limit is keyed by and IPAllowlist and
+	// IPDenylist are matched against. When empty, X-Forwarded-For is
+	// ignored.
+	TrustedProxies []string `json:",omitempty"`
+
+	// DmcaDenylistPath is a local file of content blocked without asking the
+	// pinning service, one CID or multihash per line. Files in the IPFS
+	// denylist ".deny" format are accepted: their header, comments and
+	// rules other than whole CIDs are skipped. The file is reloaded when it
+	// changes.
+	DmcaDenylistPath string `json:",omitempty"`
+
+	// DmcaDenylistPaths are more denylist files, in the same format as
+	// DmcaDenylistPath. Content blocked by any of them is blocked unless one
+	// of them allows it with a negated "!" rule.
+	DmcaDenylistPaths []string `json:",omitempty"`
+
+	// DmcaFailMode decides whether content is served when the DMCA check
+	// fails upstream, the pinning service being unreachable or answering
+	// neither 200 nor 410: "closed", the default, denies it and "open"
+	// serves it. Timeouts are retried once before the mode applies.
+	DmcaFailMode string `json:",omitempty"`
+
+	// DmcaBlockedPage is the path of an HTML template served as the body
+	// of the 410 responses for content blocked for DMCA reasons, {{.CID}}
+	// standing for the CID requested. Clients accepting JSON get the plain
+	// text message, as do all clients when empty.
+	DmcaBlockedPage string `json:",omitempty"`
+
+	// BlockCache caches the small blocks read from the datastore in
+	// RedisConn, so hot blocks don't hit the datastore. Blocks are removed
+	// from the cache when written or deleted.
+	BlockCache bool `json:",omitempty"`
+
+	// BlockCacheTTL is how long a block stays in the cache after it was
+	// read. Defaults to 10 minutes.
+	BlockCacheTTL *OptionalDuration `json:",omitempty"`
+
+	// BlockCacheMaxSize is the size in bytes of the largest block cached.
+	// Defaults to 64 KiB.
+	BlockCacheMaxSize int `json:",omitempty"`
+
+	// CIDStats counts the /ipfs/ requests served by the gateway per CID in
+	// RedisConn, in a hash per day read by 'ipfs gateway top-cids'. Counts
+	// are sent in batches, in the background.
+	CIDStats bool `json:",omitempty"`
+
+	// CIDStatsMaxCIDs is the number of distinct CIDs counted per day. Past
+	// it, only the CIDs already counted that day are. Defaults to 100000.
+	CIDStatsMaxCIDs int `json:",omitempty"`
+
+	// CIDStatsRetention is how long the counts of a day are kept. Defaults
+	// to 7 days.
+	CIDStatsRetention *OptionalDuration `json:",omitempty"`
+
+	// HotPinTopN pins, every HotPinInterval, the HotPinTopN CIDs requested
+	// the most today as counted by CIDStats, so popular content stays local.
+	// Zero disables it. Pins are added as long as the repo stays within
+	// HotPinDiskBudget, and are never removed by the daemon.
+	HotPinTopN int `json:",omitempty"`
+
+	// HotPinInterval is how often the most requested CIDs are pinned.
+	// Defaults to 10 minutes.
+	HotPinInterval *OptionalDuration `json:",omitempty"`
+
+	// HotPinDiskBudget is the repo size in bytes HotPinTopN pins may grow it
+	// to. A CID whose DAG would take the repo past it is not pinned.
+	HotPinDiskBudget int64 `json:",omitempty"`
+
+	// AdminToken is the bearer token required by the admin endpoints of the
+	// API server, such as /debug/maintenance, /debug/denylist and
+	// /debug/tls/reload. When empty, they are disabled.
+	AdminToken string `json:",omitempty"`
+
+	// PinningServiceEndpoints lists the pinning service URLs the gateway
+	// checks content with, in the order they are tried: the next one is
+	// called when one can't be reached or answers with a server error. When
+	// empty, PinningService is the only endpoint.
+	PinningServiceEndpoints []string `json:",omitempty"`
+
+	// UpstreamTimeout bounds each call of the gateway to a pinning service
+	// endpoint, response included. Defaults to 2 seconds.
+	UpstreamTimeout *OptionalDuration `json:",omitempty"`
+
+	// UpstreamMaxHeaderBytes bounds the size of the response headers
+	// accepted from the pinning service. A response with larger headers is
+	// rejected as a failed call. Defaults to 64 KiB.
+	UpstreamMaxHeaderBytes int64 `json:",omitempty"`
+
+	// CircuitBreakerThreshold is the number of consecutive failed pinning
+	// service calls, unreachable or answering with a server error, after
+	// which the gateway stops calling it for CircuitBreakerCooldown. Checks
+	// then fail right away, DMCA checks following DmcaFailMode. Zero
+	// disables the circuit breaker.
+	CircuitBreakerThreshold int `json:",omitempty"`
+
+	// CircuitBreakerCooldown is how long the pinning service isn't called
+	// once the circuit breaker opens, before a single call probes whether
+	// it recovered. Defaults to 30 seconds.
+	CircuitBreakerCooldown *OptionalDuration `json:",omitempty"`
+
+	// Compression gzips the gateway responses of compressible content types,
+	// such as HTML, JSON or SVG, to clients accepting it. Range requests are
+	// served uncompressed.
+	Compression bool `json:",omitempty"`
+
+	// CompressionMinSize is the size in bytes under which responses are not
+	// compressed. Defaults to 1 KiB.
+	CompressionMinSize int `json:",omitempty"`
+
+	// AccessLog is the file the gateway appends a line to for each request,
+	// in the Apache Combined Log Format followed by the duration of the
+	// request in seconds, or "stdout". When empty, no access log is written.
+	// The client keys passed in ClientKeyParam and the signed URL
+	// credentials are logged redacted.
+	AccessLog string `json:",omitempty"`
+
+	// ImmutableMaxAge is the max-age of the Cache-Control header given to
+	// the successful gateway responses for /ipfs/ paths left without one,
+	// which are marked immutable. Defaults to 1 year.
+	ImmutableMaxAge *OptionalDuration `json:",omitempty"`
+
+	// IPNSMaxAge is the max-age of the Cache-Control header given to the
+	// successful gateway responses for /ipns/ paths left without one, whose
+	// content can change. Defaults to 1 minute.
+	IPNSMaxAge *OptionalDuration `json:",omitempty"`
+}
+
+const (
+	DmcaFailClosed = "closed"
+	DmcaFailOpen   = "open"
+)
+
+// Values of TrailingSlash.
+const (
+	TrailingSlashAdd    = "add"
+	TrailingSlashRemove = "remove"
+)
+
+// Access checks of AccessDecisionOrder.
+const (
+	AccessDenylist     = "denylist"
+	AccessAppealToken  = "appeal-token"
+	AccessDmcaUpstream = "dmca-upstream"
+	AccessSignedURL    = "signed-url"
+	AccessSubscription = "subscription"
+)
+
+// DefaultAccessDecisionOrder is the AccessDecisionOrder used when it is not
+// set: content blocked locally is never served, an appeal token overrides
+// the pinning service blocking the content, and signed URLs skip the
+// subscription check but not the DMCA ones.
+var DefaultAccessDecisionOrder = []string{
+	AccessDenylist,
+	AccessAppealToken,
+	AccessDmcaUpstream,
+	AccessSignedURL,
+	AccessSubscription,
+}
+
+// Prefixes of the block encryption keys given encoded, see
+// DecodeBlockEncryptionKey.
+const (
+	BlockKeyHexPrefix    = "hex:"
+	BlockKeyBase64Prefix = "base64:"
+)
+
+// DecodeBlockEncryptionKey returns the bytes of a BlockEncryptionKey or
+// BlockEncryptionKeys key: decoded when it starts with BlockKeyHexPrefix or
+// BlockKeyBase64Prefix, the bytes of the string otherwise, so keys set
+// before encodings were supported keep their meaning. The same bytes are
+// used to encrypt blocks at rest and uploaded blocks.
+func DecodeBlockEncryptionKey(key string) ([]byte, error) {
+	switch {
+	case strings.HasPrefix(key, BlockKeyHexPrefix):
+		b, err := hex.DecodeString(strings.TrimPrefix(key, BlockKeyHexPrefix))
+		if err != nil {
+			return nil, errors.New("invalid hex encoded key")
+		}
+		return b, nil
+	case strings.HasPrefix(key, BlockKeyBase64Prefix):
+		encoded := strings.TrimPrefix(key, BlockKeyBase64Prefix)
+		for _, enc := range []*base64.Encoding{
+			base64.StdEncoding,
+			base64.RawStdEncoding,
+			base64.URLEncoding,
+			base64.RawURLEncoding,
+		} {
+			if b, err := enc.DecodeString(encoded); err == nil {
+				return b, nil
+			}
+		}
+		return nil, errors.New("invalid base64 encoded key")
+	}
+	return []byte(key), nil
+}
+
+// BlockEncryptionKeyEntry is a 32 bytes key used to encrypt blocks at rest,
+// along with the ID stored in the blocks it encrypted. Key is encoded as
+// BlockEncryptionKey is.
+type BlockEncryptionKeyEntry struct {
+	ID     string
+	Key    string
+	Active bool `json:",omitempty"`
+}
+
+// Validate reports settings that can't be used as configured.
+func (c ConfigPinningService) Validate() error {
+	for _, endpoint := range []struct{ name, url string }{
+		{"Uploader", c.Uploader},
+		{"PinningService", c.PinningService},
+	} {
+		if err := validateEndpoint(endpoint.url); err != nil {
+			return fmt.Errorf("ConfigPinningService.%s: %w", endpoint.name, err)
+		}
+	}
+	for _, endpoint := range c.PinningServiceEndpoints {
+		if endpoint == "" {
+			return fmt.Errorf("ConfigPinningService.PinningServiceEndpoints must not list empty URLs")
+		}
+		if err := validateEndpoint(endpoint); err != nil {
+			return fmt.Errorf("ConfigPinningService.PinningServiceEndpoints: %w", err)
+		}
+	}
+	if len(c.PinningServiceURLs()) > 0 && c.BlockserviceApiKey == "" {
+		return fmt.Errorf("ConfigPinningService.BlockserviceApiKey must be set to call the pinning service")
+	}
+	if strings.ContainsAny(c.EncryptedBlockPrefix, `/\`) {
+		return fmt.Errorf("ConfigPinningService.EncryptedBlockPrefix must not contain path separators, got %q", c.EncryptedBlockPrefix)
+	}
+	if c.IPRateLimit < 0 {
+		return fmt.Errorf("ConfigPinningService.IPRateLimit must not be negative, got %d", c.IPRateLimit)
+	}
+	if c.CIDRateLimit < 0 {
+		return fmt.Errorf("ConfigPinningService.CIDRateLimit must not be negative, got %d", c.CIDRateLimit)
+	}
+	if w := c.RateLimitWindow; w != nil && !w.IsDefault() && w.WithDefault(0) <= 0 {
+		return fmt.Errorf("ConfigPinningService.RateLimitWindow must be positive, got %s", w)
+	}
+	if c.RequireClientKey == True && c.ClientKeyHeader == "" && c.ClientKeyParam == "" {
+		return fmt.Errorf("ConfigPinningService.ClientKeyHeader or ClientKeyParam must be set to require a client API key")
+	}
+	if ut := c.UpstreamTimeout; ut != nil && !ut.IsDefault() && ut.WithDefault(0) <= 0 {
+		return fmt.Errorf("ConfigPinningService.UpstreamTimeout must be positive, got %s", ut)
+	}
+	if c.UpstreamMaxHeaderBytes < 0 {
+		return fmt.Errorf("ConfigPinningService.UpstreamMaxHeaderBytes must not be negative, got %d", c.UpstreamMaxHeaderBytes)
+	}
+	if c.CircuitBreakerThreshold < 0 {
+		return fmt.Errorf("ConfigPinningService.CircuitBreakerThreshold must not be negative, got %d", c.CircuitBreakerThreshold)
+	}
+	if cd := c.CircuitBreakerCooldown; cd != nil && !cd.IsDefault() && cd.WithDefault(0) <= 0 {
+		return fmt.Errorf("ConfigPinningService.CircuitBreakerCooldown must be positive, got %s", cd)
+	}
+	if c.RefererDenyStatus != 0 && (c.RefererDenyStatus < 400 || c.RefererDenyStatus > 599) {
+		return fmt.Errorf("ConfigPinningService.RefererDenyStatus must be an HTTP error status between 400 and 599, got %d", c.RefererDenyStatus)
+	}
+	for _, n := range []struct {
+		name  string
+		value int
+	}{
+		{"AccessCacheSize", c.AccessCacheSize},
+		{"AccessErrorCooldownSize", c.AccessErrorCooldownSize},
+		{"DmcaCacheSize", c.DmcaCacheSize},
+		{"MaxConcurrentPerCID", c.MaxConcurrentPerCID},
+		{"MaxConcurrentRequests", c.MaxConcurrentRequests},
+		{"MaxConnsPerIP", c.MaxConnsPerIP},
+		{"PrefetchDepth", c.PrefetchDepth},
+		{"PrefetchMaxBlocks", c.PrefetchMaxBlocks},
+	} {
+		if n.value < 0 {
+			return fmt.Errorf("ConfigPinningService.%s must not be negative, got %d", n.name, n.value)
+		}
+	}
+	for i, tier := range c.ResponseTimeouts {
+		if tier.MaxSize < 0 {
+			return fmt.Errorf("ConfigPinningService.ResponseTimeouts[%d].MaxSize must not be negative, got %d", i, tier.MaxSize)
+		}
+		if tier.Timeout != nil && tier.Timeout.WithDefault(0) < 0 {
+			return fmt.Errorf("ConfigPinningService.ResponseTimeouts[%d].Timeout must not be negative, got %s", i, tier.Timeout)
+		}
+	}
+	for _, d := range []struct {
+		name  string
+		value *OptionalDuration
+	}{
+		{"StaleIfError", c.StaleIfError},
+		{"ImmutableMaxAge", c.ImmutableMaxAge},
+		{"IPNSMaxAge", c.IPNSMaxAge},
+		{"ServerReadHeaderTimeout", c.ServerReadHeaderTimeout},
+		{"ServerReadTimeout", c.ServerReadTimeout},
+		{"ServerWriteTimeout", c.ServerWriteTimeout},
+		{"ServerIdleTimeout", c.ServerIdleTimeout},
+		{"ShutdownDrainDelay", c.ShutdownDrainDelay},
+	} {
+		if d.value != nil && d.value.WithDefault(0) < 0 {
+			return fmt.Errorf("ConfigPinningService.%s must not be negative, got %s", d.name, d.value)
+		}
+	}
+	if c.CompressionMinSize < 0 {
+		return fmt.Errorf("ConfigPinningService.CompressionMinSize must not be negative, got %d", c.CompressionMinSize)
+	}
+	if (c.SslCertPath == "") != (c.SslKeyPath == "") {
+		return fmt.Errorf("ConfigPinningService.SslCertPath and SslKeyPath must be set together")
+	}
+	for _, path := range []struct {
+		name, value string
+	}{
+		{"SslCertPath", c.SslCertPath},
+		{"SslKeyPath", c.SslKeyPath},
+	} {
+		if path.value == "" {
+			continue
+		}
+		f, err := os.Open(path.value)
+		if err != nil {
+			return fmt.Errorf("ConfigPinningService.%s must be a readable file: %w", path.name, err)
+		}
+		fi, err := f.Stat()
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("ConfigPinningService.%s must be a readable file: %w", path.name, err)
+		}
+		if fi.IsDir() {
+			return fmt.Errorf("ConfigPinningService.%s must be a readable file, %s is a directory", path.name, path.value)
+		}
+	}
+	for _, allowed := range c.AllowedCIDs {
+		if _, err := cid.Decode(allowed); err != nil {
+			return fmt.Errorf("ConfigPinningService.AllowedCIDs: invalid CID %q: %w", allowed, err)
+		}
+	}
+	for _, list := range []struct {
+		name     string
+		prefixes []string
+	}{
+		{"IPAllowlist", c.IPAllowlist},
+		{"IPDenylist", c.IPDenylist},
+		{"TrustedProxies", c.TrustedProxies},
+	} {
+		for _, prefix := range list.prefixes {
+			if _, err := ParseIPPrefix(prefix); err != nil {
+				return fmt.Errorf("ConfigPinningService.%s: %w", list.name, err)
+			}
+		}
+	}
+	switch c.DmcaFailMode {
+	case "", DmcaFailClosed, DmcaFailOpen:
+	default:
+		return fmt.Errorf("ConfigPinningService.DmcaFailMode must be %q or %q, got %q", DmcaFailClosed, DmcaFailOpen, c.DmcaFailMode)
+	}
+	switch c.TrailingSlash {
+	case "", TrailingSlashAdd, TrailingSlashRemove:
+	default:
+		return fmt.Errorf("ConfigPinningService.TrailingSlash must be %q or %q, got %q", TrailingSlashAdd, TrailingSlashRemove, c.TrailingSlash)
+	}
+	if len(c.AccessDecisionOrder) > 0 {
+		listed := make(map[string]bool, len(c.AccessDecisionOrder))
+		for _, check := range c.AccessDecisionOrder {
+			if !slices.Contains(DefaultAccessDecisionOrder, check) {
+				return fmt.Errorf("ConfigPinningService.AccessDecisionOrder: unknown check %q", check)
+			}
+			if listed[check] {
+				return fmt.Errorf("ConfigPinningService.AccessDecisionOrder: duplicate check %q", check)
+			}
+			listed[check] = true
+		}
+		if len(listed) != len(DefaultAccessDecisionOrder) {
+			return fmt.Errorf("ConfigPinningService.AccessDecisionOrder must list all of %s", strings.Join(DefaultAccessDecisionOrder, ", "))
+		}
+	}
+	if c.AmqpEventRoutingKey != "" && c.AmqpConnect == "" {
+		return fmt.Errorf("ConfigPinningService.AmqpConnect must be set to publish events")
+	}
+	if c.AmqpEventBufferSize < 0 {
+		return fmt.Errorf("ConfigPinningService.AmqpEventBufferSize must not be negative, got %d", c.AmqpEventBufferSize)
+	}
+	if c.BlockCache && c.RedisConn == "" {
+		return fmt.Errorf("ConfigPinningService.RedisConn must be set to cache blocks")
+	}
+	if ttl := c.BlockCacheTTL; ttl != nil && !ttl.IsDefault() && ttl.WithDefault(0) <= 0 {
+		return fmt.Errorf("ConfigPinningService.BlockCacheTTL must be positive, got %s", ttl)
+	}
+	if c.BlockCacheMaxSize < 0 {
+		return fmt.Errorf("ConfigPinningService.BlockCacheMaxSize must not be negative, got %d", c.BlockCacheMaxSize)
+	}
+	if c.CIDStats && c.RedisConn == "" {
+		return fmt.Errorf("ConfigPinningService.RedisConn must be set to count requests per CID")
+	}
+	if c.CIDStatsMaxCIDs < 0 {
+		return fmt.Errorf("ConfigPinningService.CIDStatsMaxCIDs must not be negative, got %d", c.CIDStatsMaxCIDs)
+	}
+	if r := c.CIDStatsRetention; r != nil && !r.IsDefault() && r.WithDefault(0) <= 0 {
+		return fmt.Errorf("ConfigPinningService.CIDStatsRetention must be positive, got %s", r)
+	}
+	if c.HotPinTopN < 0 {
+		return fmt.Errorf("ConfigPinningService.HotPinTopN must not be negative, got %d", c.HotPinTopN)
+	}
+	if c.HotPinDiskBudget < 0 {
+		return fmt.Errorf("ConfigPinningService.HotPinDiskBudget must not be negative, got %d", c.HotPinDiskBudget)
+	}
+	if c.HotPinTopN > 0 {
+		if !c.CIDStats {
+			return fmt.Errorf("ConfigPinningService.CIDStats must be enabled to pin the most requested CIDs")
+		}
+		if c.HotPinDiskBudget == 0 {
+			return fmt.Errorf("ConfigPinningService.HotPinDiskBudget must be set to pin the most requested CIDs")
+		}
+	}
+	if i := c.HotPinInterval; i != nil && !i.IsDefault() && i.WithDefault(0) <= 0 {
+		return fmt.Errorf("ConfigPinningService.HotPinInterval must be positive, got %s", i)
+	}
+	key, err := DecodeBlockEncryptionKey(c.BlockEncryptionKey)
+	if err != nil {
+		return fmt.Errorf("ConfigPinningService.BlockEncryptionKey: %w", err)
+	}
+	if c.EncryptBlocksAtRest {
+		if c.EncryptedBlockPrefix == "" {
+			return fmt.Errorf("ConfigPinningService.EncryptedBlockPrefix must be set to encrypt blocks at rest")
+		}
+		if len(c.BlockEncryptionKeys) == 0 && len(key) != 32 {
+			return fmt.Errorf("ConfigPinningService.BlockEncryptionKey must be 32 bytes long to encrypt blocks at rest, got %d", len(key))
+		}
+	}
+	return c.validateBlockEncryptionKeys()
+}
+
+// ParseIPPrefix parses an IP range in CIDR notation, or a single IP as the
+// range holding only that address.
+func ParseIPPrefix(s string) (netip.Prefix, error) {
+	if strings.Contains(s, "/") {
+		prefix, err := netip.ParsePrefix(s)
+		if err != nil {
+			return netip.Prefix{}, fmt.Errorf("invalid CIDR range %q", s)
+		}
+		return prefix.Masked(), nil
+	}
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Prefix{}, fmt.Errorf("invalid IP %q", s)
+	}
+	addr = addr.Unmap()
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
+
+// ClientKeyRequired reports whether dedicated gateway requests sent without
+// a client API key are answered with 401, see RequireClientKey.
+func (c ConfigPinningService) ClientKeyRequired() bool {
+	return c.RequireClientKey.WithDefault(c.ClientKeyHeader != "" || c.ClientKeyParam != "")
+}
+
+// PinningServiceURLs returns the pinning service endpoints in the order the
+// gateway tries them: PinningServiceEndpoints, or PinningService when they
+// are not set.
+func (c ConfigPinningService) PinningServiceURLs() []string {
+	if len(c.PinningServiceEndpoints) > 0 {
+		return c.PinningServiceEndpoints
+	}
+	if c.PinningService != "" {
+		return []string{c.PinningService}
+	}
+	return nil
+}
+
+func (c ConfigPinningService) validateBlockEncryptionKeys() error {
+	if len(c.BlockEncryptionKeys) == 0 {
+		return nil
+	}
+	ids := make(map[string]bool, len(c.BlockEncryptionKeys))
+	var active int
+	for _, k := range c.BlockEncryptionKeys {
+		key, err := DecodeBlockEncryptionKey(k.Key)
+		if err != nil {
+			return fmt.Errorf("ConfigPinningService.BlockEncryptionKeys: key %q: %w", k.ID, err)
+		}
+		if len(key) != 32 {
+			return fmt.Errorf("ConfigPinningService.BlockEncryptionKeys: key %q must be 32 bytes long, got %d", k.ID, len(key))
+		}
+		if len(k.ID) > 255 {
+			return fmt.Errorf("ConfigPinningService.BlockEncryptionKeys: key ID %q is longer than 255 bytes", k.ID)
+		}
+		if ids[k.ID] {
+			return fmt.Errorf("ConfigPinningService.BlockEncryptionKeys: duplicate key ID %q", k.ID)
+		}
+		ids[k.ID] = true
+		if k.Active {
+			active++
+		}
+	}
+	if active != 1 {
+		return fmt.Errorf("ConfigPinningService.BlockEncryptionKeys must have exactly one active key, got %d", active)
+	}
+	return nil
+}
+
+// validateEndpoint checks that u, when set, is an absolute http or https
+// URL.
+func validateEndpoint(u string) error {
+	if u == "" {
+		return nil
+	}
+	parsed, err := url.Parse(u)
+	if err != nil {
+		return err
+	}
+	if parsed.Scheme != "http" && parsed.Scheme != "https" {
+		return fmt.Errorf("%q must use http or https", u)
+	}
+	if parsed.Host == "" {
+		return fmt.Errorf("%q has no host", u)
+	}
+	return nil
+}
+
+// ResponseTimeoutTier is the write timeout applied to gateway responses for
+// objects of up to MaxSize bytes. A zero MaxSize matches objects of any size,
+// including those whose size can't be determined.
+type ResponseTimeoutTier struct {
+	MaxSize int64             `json:",omitempty"`
+	Timeout *OptionalDuration `json:",omitempty"`
+}
+
+// envReference matches the ${NAME} references expanded by ExpandEnv.
+var envReference = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// ExpandEnv returns c with the ${NAME} references in its string settings,
+// and in the keys of BlockEncryptionKeys, replaced with the value of the
+// environment variable NAME, so secrets can be kept out of the config file.
+// When NAME is not set but NAME_FILE is, the content of the file it names is
+// used instead, without its trailing newline. A reference to a variable set
+// neither way is an error.
+func (c ConfigPinningService) ExpandEnv() (ConfigPinningService, error) {
+	v := reflect.ValueOf(&c).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		f := v.Field(i)
+		if f.Kind() != reflect.String {
+			continue
+		}
+		s, err := expandEnv(f.String())
+		if err != nil {
+			return c, fmt.Errorf("ConfigPinningService.%s: %w", v.Type().Field(i).Name, err)
+		}
+		f.SetString(s)
+	}
+
+	if len(c.BlockEncryptionKeys) > 0 {
+		keys := make([]BlockEncryptionKeyEntry, len(c.BlockEncryptionKeys))
+		for i, k := range c.BlockEncryptionKeys {
+			key, err := expandEnv(k.Key)
+			if err != nil {
+				return c, fmt.Errorf("ConfigPinningService.BlockEncryptionKeys: key %q: %w", k.ID, err)
+			}
+			k.Key = key
+			keys[i] = k
+		}
+		c.BlockEncryptionKeys = keys
+	}
+	return c, nil
+}
+
+// WithEnvReferences returns c with the string settings that raw sets with
+// ${NAME} references, and that still hold the values they expand to, set
+// back to the references. It is used to save a config whose references were
+// expanded without writing the secrets they hold.
+func (c ConfigPinningService) WithEnvReferences(raw ConfigPinningService) ConfigPinningService {
+	restore := func(raw, cur string) string {
+		if !envReference.MatchString(raw) {
+			return cur
+		}
+		if expanded, err := expandEnv(raw); err != nil || expanded != cur {
+			return cur
+		}
+		return raw
+	}
+
+	v, rv := reflect.ValueOf(&c).Elem(), reflect.ValueOf(raw)
+	for i := 0; i < v.NumField(); i++ {
+		if f := v.Field(i); f.Kind() == reflect.String {
+			f.SetString(restore(rv.Field(i).String(), f.String()))
+		}
+	}
+
+	if len(c.BlockEncryptionKeys) > 0 {
+		rawKeys := make(map[string]string, len(raw.BlockEncryptionKeys))
+		for _, k := range raw.BlockEncryptionKeys {
+			rawKeys[k.ID] = k.Key
+		}
+		keys := make([]BlockEncryptionKeyEntry, len(c.BlockEncryptionKeys))
+		for i, k := range c.BlockEncryptionKeys {
+			k.Key = restore(rawKeys[k.ID], k.Key)
+			keys[i] = k
+		}
+		c.BlockEncryptionKeys = keys
+	}
+	return c
+}
+
+func expandEnv(s string) (string, error) {
+	var err error
+	expanded := envReference.ReplaceAllStringFunc(s, func(ref string) string {
+		name := envReference.FindStringSubmatch(ref)[1]
+		value, lerr := lookupEnv(name)
+		if lerr != nil && err == nil {
+			err = lerr
+		}
+		return value
+	})
+	return expanded, err
+}
+
+// lookupEnv returns the value of the environment variable name, or the
+// content of the file named by name_FILE.
+func lookupEnv(name string) (string, error) {
+	if value, ok := os.LookupEnv(name); ok {
+		return value, nil
+	}
+	if file, ok := os.LookupEnv(name + "_FILE"); ok {
+		b, err := os.ReadFile(file)
+		if err != nil {
+			return "", fmt.Errorf("reading %s: %w", name+"_FILE", err)
+		}
+		return strings.TrimRight(string(b), "\r\n"), nil
+	}
+	return "", fmt.Errorf("environment variable %s is not set", name)
 }
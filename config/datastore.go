@@ -23,6 +23,10 @@ type Datastore struct {
 
 	HashOnRead      bool
 	BloomFilterSize int
+
+	// ReadOnly rejects the writes to the blocks and pins, whatever the
+	// datastore backend, for nodes only serving the content already stored.
+	ReadOnly bool `json:",omitempty"`
 }
 
 // DataStorePath returns the default data store path given a configuration root
@@ -3,6 +3,7 @@ package config
 import (
 	"fmt"
 	"net"
+	"strings"
 	"time"
 )
 
@@ -168,6 +169,50 @@ This profile may only be applied when first initializing the node.`,
 			return nil
 		},
 	},
+	"aiozfs": {
+		Description: `Configures the node to use the aiozfs datastore.
+
+Like flatfs, this datastore stores each block as a separate file, sharded
+with the aiozfs shard function.
+
+This profile may only be applied when first initializing the node.
+`,
+
+		InitOnly: true,
+		Transform: func(c *Config) error {
+			c.Datastore.Spec = aiozfsSpec()
+			return nil
+		},
+	},
+	"dedicated-gateway": {
+		Description: `Configures the node as a dedicated gateway: gateway requests
+are checked with the pinning service, concurrency, connections per client
+and server timeouts are bounded, in-flight requests are drained on shutdown
+and blocks are stored with the aiozfs datastore.
+
+The gateway is expected to serve TLS: set ConfigPinningService.SslCertPath
+and SslKeyPath, along with PinningService and BlockserviceApiKey.
+
+This profile may only be applied when first initializing the node.
+`,
+
+		InitOnly: true,
+		Transform: func(c *Config) error {
+			p := &c.ConfigPinningService
+			p.DedicatedGateway = true
+			p.CanonicalGatewayPaths = true
+			p.DmcaFailMode = DmcaFailClosed
+			p.MaxConcurrentRequests = 1024
+			p.MaxConcurrentPerCID = 64
+			p.MaxConnsPerIP = 64
+			p.CircuitBreakerThreshold = 5
+			p.ServerReadHeaderTimeout = NewOptionalDuration(10 * time.Second)
+			p.ServerIdleTimeout = NewOptionalDuration(2 * time.Minute)
+			p.ShutdownDrainDelay = NewOptionalDuration(10 * time.Second)
+			c.Datastore.Spec = aiozfsSpec()
+			return nil
+		},
+	},
 	"lowpower": {
 		Description: `Reduces daemon overhead on the system. May affect node
 functionality - performance of content discovery and data
@@ -205,6 +250,38 @@ fetching may be degraded.
 	},
 }
 
+// conflictingProfiles lists groups of profiles which set the same options to
+// different values. At most one profile of each group may be applied at once.
+var conflictingProfiles = [][]string{
+	{"server", "local-discovery"},
+	{"test", "default-networking"},
+	{"default-datastore", "flatfs", "badgerds", "aiozfs"},
+	// dedicated-gateway sets the aiozfs datastore
+	{"default-datastore", "dedicated-gateway"},
+	{"flatfs", "dedicated-gateway"},
+	{"badgerds", "dedicated-gateway"},
+}
+
+// CheckProfileConflicts returns an error if profiles contains more than one
+// profile from a group of conflicting profiles.
+func CheckProfileConflicts(profiles []string) error {
+	for _, group := range conflictingProfiles {
+		var found []string
+		for _, g := range group {
+			for _, p := range profiles {
+				if p == g {
+					found = append(found, p)
+					break
+				}
+			}
+		}
+		if len(found) > 1 {
+			return fmt.Errorf("conflicting configuration profiles: %s", strings.Join(found, ", "))
+		}
+	}
+	return nil
+}
+
 func getAvailablePort() (port int, err error) {
 	ln, err := net.Listen("tcp", "[::]:0")
 	if err != nil {
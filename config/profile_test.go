@@ -0,0 +1,87 @@
+package config
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestCheckProfileConflicts(t *testing.T) {
+	for _, tc := range []struct {
+		profiles []string
+		conflict bool
+	}{
+		{[]string{"server"}, false},
+		{[]string{"server", "lowpower", "flatfs"}, false},
+		{[]string{"server", "local-discovery"}, true},
+		{[]string{"test", "default-networking"}, true},
+		{[]string{"flatfs", "badgerds"}, true},
+		{[]string{"aiozfs", "flatfs"}, true},
+		{[]string{"dedicated-gateway", "server"}, false},
+		{[]string{"aiozfs", "dedicated-gateway"}, false},
+		{[]string{"dedicated-gateway", "flatfs"}, true},
+	} {
+		err := CheckProfileConflicts(tc.profiles)
+		if tc.conflict && err == nil {
+			t.Errorf("%v: expected a conflict error", tc.profiles)
+		}
+		if !tc.conflict && err != nil {
+			t.Errorf("%v: unexpected error: %s", tc.profiles, err)
+		}
+	}
+}
+
+func TestDedicatedGatewayProfile(t *testing.T) {
+	profile := Profiles["dedicated-gateway"]
+	if !profile.InitOnly {
+		t.Fatal("expected the profile to be applied on init only, it sets the datastore")
+	}
+	cfg := &Config{
+		Addresses: Addresses{Gateway: Strings{"/ip4/0.0.0.0/tcp/8080"}},
+		ConfigPinningService: ConfigPinningService{
+			PinningService:     "https://pinning.example.com",
+			BlockserviceApiKey: "secret",
+			MaxConnsPerIP:      8,
+		},
+	}
+	if err := profile.Transform(cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	p := cfg.ConfigPinningService
+	if !p.DedicatedGateway || !p.CanonicalGatewayPaths || p.DmcaFailMode != DmcaFailClosed {
+		t.Fatalf("expected dedicated gateway checks to be enabled, got %+v", p)
+	}
+	if p.MaxConcurrentRequests != 1024 || p.MaxConcurrentPerCID != 64 || p.MaxConnsPerIP != 64 || p.CircuitBreakerThreshold != 5 {
+		t.Fatalf("expected the recommended limits, got %+v", p)
+	}
+	if p.ServerReadHeaderTimeout.WithDefault(0) != 10*time.Second || p.ServerIdleTimeout.WithDefault(0) != 2*time.Minute || p.ShutdownDrainDelay.WithDefault(0) != 10*time.Second {
+		t.Fatalf("expected the recommended server timeouts, got %+v", p)
+	}
+	if !reflect.DeepEqual(cfg.Datastore.Spec, aiozfsSpec()) {
+		t.Fatalf("expected the aiozfs datastore, got %v", cfg.Datastore.Spec)
+	}
+	if p.PinningService != "https://pinning.example.com" || len(cfg.Addresses.Gateway) != 1 {
+		t.Fatal("expected the settings outside the profile to be kept")
+	}
+	if err := p.Validate(); err != nil {
+		t.Fatalf("expected the profile to produce a valid config, got %s", err)
+	}
+
+	// applying the profile again changes nothing
+	before, err := json.Marshal(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := profile.Transform(cfg); err != nil {
+		t.Fatal(err)
+	}
+	after, err := json.Marshal(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(before) != string(after) {
+		t.Fatalf("expected the profile to be idempotent:\n%s\n%s", before, after)
+	}
+}
@@ -60,7 +60,8 @@ func encode(w io.Writer, value interface{}) error {
 	return err
 }
 
-// Load reads given file and returns the read config, or error.
+// Load reads given file and returns the read config, or error. The
+// environment references of ConfigPinningService are expanded.
 func Load(filename string) (*config.Config, error) {
 	var cfg config.Config
 	err := ReadConfigFile(filename, &cfg)
@@ -68,5 +69,12 @@ func Load(filename string) (*config.Config, error) {
 		return nil, err
 	}
 
+	if cfg.ConfigPinningService, err = cfg.ConfigPinningService.ExpandEnv(); err != nil {
+		return nil, err
+	}
+	if err := cfg.ConfigPinningService.Validate(); err != nil {
+		return nil, err
+	}
+
 	return &cfg, err
 }
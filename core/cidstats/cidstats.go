@@ -0,0 +1,213 @@
+// Package cidstats counts the gateway requests per CID in Redis, so the most
+// requested content can be listed across all the gateways sharing a Redis
+// server.
+package cidstats
+
+import (
+	"context"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	logging "github.com/ipfs/go-log"
+	config "github.com/ipfs/kubo/config"
+	"github.com/redis/go-redis/v9"
+)
+
+var log = logging.Logger("cidstats")
+
+const (
+	// DefaultMaxCIDs is the number of distinct CIDs counted per day, unless
+	// configured otherwise.
+	DefaultMaxCIDs = 100000
+
+	// DefaultRetention is how long the counts of a day are kept, unless
+	// configured otherwise.
+	DefaultRetention = 7 * 24 * time.Hour
+)
+
+// keyPrefix is prepended to the day to name the Redis hash of its counts.
+const keyPrefix = "kubo:cidstats:"
+
+// redisTimeout bounds each Redis round trip.
+const redisTimeout = time.Second
+
+// flushBatch is the number of CIDs sent to Redis at once.
+const flushBatch = 500
+
+// flushInterval is how often the counts are sent to Redis.
+var flushInterval = 10 * time.Second
+
+var now = time.Now
+
+// Key returns the Redis key of the hash counting the requests of the UTC day
+// of t, one field per CID.
+func Key(t time.Time) string {
+	return keyPrefix + t.UTC().Format(time.DateOnly)
+}
+
+// incrScript adds to the hash KEYS[1] the counts of ARGV, given as pairs of
+// field and count from ARGV[3], leaving out new fields once the hash holds
+// ARGV[1] of them. The hash expires ARGV[2] milliseconds later.
+var incrScript = redis.NewScript(`
+local max = tonumber(ARGV[1])
+for i = 3, #ARGV, 2 do
+	if redis.call('HEXISTS', KEYS[1], ARGV[i]) == 1 or redis.call('HLEN', KEYS[1]) < max then
+		redis.call('HINCRBY', KEYS[1], ARGV[i], ARGV[i + 1])
+	end
+end
+redis.call('PEXPIRE', KEYS[1], ARGV[2])
+return 0
+`)
+
+// Counter counts requests per CID in memory and adds the counts to Redis in
+// the background, so that counting never waits for Redis. Counts that can't
+// be sent are dropped.
+type Counter struct {
+	client    *redis.Client
+	maxCIDs   int
+	retention time.Duration
+
+	mu      sync.Mutex
+	pending map[string]map[string]int64
+
+	closing chan struct{}
+	done    chan struct{}
+}
+
+// NewCounter starts counting requests in the Redis server of client, up to
+// maxCIDs distinct CIDs per day kept for retention. The client is not closed
+// with the Counter.
+func NewCounter(client *redis.Client, maxCIDs int, retention time.Duration) *Counter {
+	c := &Counter{
+		client:    client,
+		maxCIDs:   maxCIDs,
+		retention: retention,
+		pending:   make(map[string]map[string]int64),
+		closing:   make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+	go c.run()
+	return c
+}
+
+// NewCounterConfig starts counting requests with the settings of cfg.
+func NewCounterConfig(client *redis.Client, cfg config.ConfigPinningService) *Counter {
+	maxCIDs := cfg.CIDStatsMaxCIDs
+	if maxCIDs == 0 {
+		maxCIDs = DefaultMaxCIDs
+	}
+	return NewCounter(client, maxCIDs, cfg.CIDStatsRetention.WithDefault(DefaultRetention))
+}
+
+// Record counts a request for the CID identified by key, its normalized
+// form.
+func (c *Counter) Record(key string) {
+	day := Key(now())
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	counts := c.pending[day]
+	if counts == nil {
+		counts = make(map[string]int64)
+		c.pending[day] = counts
+	}
+	if _, ok := counts[key]; ok || len(counts) < c.maxCIDs {
+		counts[key]++
+	}
+}
+
+// Flush sends the counts recorded so far to Redis.
+func (c *Counter) Flush(ctx context.Context) error {
+	c.mu.Lock()
+	pending := c.pending
+	c.pending = make(map[string]map[string]int64)
+	c.mu.Unlock()
+
+	var firstErr error
+	for day, counts := range pending {
+		args := make([]interface{}, 0, 2+2*flushBatch)
+		send := func() {
+			if len(args) == 2 {
+				return
+			}
+			rctx, cancel := context.WithTimeout(ctx, redisTimeout)
+			defer cancel()
+			if err := incrScript.Run(rctx, c.client, []string{day}, args...).Err(); err != nil && firstErr == nil {
+				firstErr = err
+			}
+			args = args[:2]
+		}
+		args = append(args, c.maxCIDs, c.retention.Milliseconds())
+		for key, n := range counts {
+			args = append(args, key, n)
+			if len(args) == 2+2*flushBatch {
+				send()
+			}
+		}
+		send()
+	}
+	return firstErr
+}
+
+func (c *Counter) run() {
+	defer close(c.done)
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-c.closing:
+			c.flush()
+			return
+		}
+		c.flush()
+	}
+}
+
+func (c *Counter) flush() {
+	if err := c.Flush(context.Background()); err != nil {
+		log.Warnf("dropping request counts, Redis is unavailable: %s", err)
+	}
+}
+
+// Close sends the counts recorded so far and stops the Counter.
+func (c *Counter) Close() error {
+	close(c.closing)
+	<-c.done
+	return nil
+}
+
+// Count is the number of requests for a CID.
+type Count struct {
+	Key      string
+	Requests int64
+}
+
+// Top returns the n CIDs requested the most on the UTC day of t, most
+// requested first.
+func Top(ctx context.Context, client *redis.Client, t time.Time, n int) ([]Count, error) {
+	fields, err := client.HGetAll(ctx, Key(t)).Result()
+	if err != nil {
+		return nil, err
+	}
+	counts := make([]Count, 0, len(fields))
+	for key, v := range fields {
+		requests, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			log.Warnf("ignoring invalid count %q of %s", v, key)
+			continue
+		}
+		counts = append(counts, Count{Key: key, Requests: requests})
+	}
+	sort.Slice(counts, func(i, j int) bool {
+		if counts[i].Requests != counts[j].Requests {
+			return counts[i].Requests > counts[j].Requests
+		}
+		return counts[i].Key < counts[j].Key
+	})
+	if n > 0 && len(counts) > n {
+		counts = counts[:n]
+	}
+	return counts, nil
+}
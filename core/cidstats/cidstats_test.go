@@ -0,0 +1,86 @@
+package cidstats
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+func TestCounter(t *testing.T) {
+	ctx := context.Background()
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	clock := time.Date(2024, 3, 1, 23, 59, 0, 0, time.UTC)
+	now = func() time.Time { return clock }
+	t.Cleanup(func() { now = time.Now })
+
+	c := NewCounter(client, 2, time.Hour)
+	for _, key := range []string{"a", "b", "a", "c"} {
+		c.Record(key)
+	}
+	if err := c.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+	// c was left out, past the CIDs counted per day
+	top, err := Top(ctx, client, clock, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(top) != 2 || top[0] != (Count{"a", 2}) || top[1] != (Count{"b", 1}) {
+		t.Fatalf("expected a counted twice and b once, got %v", top)
+	}
+
+	// counts add up across flushes, and across gateways
+	other := NewCounter(client, 2, time.Hour)
+	c.Record("b")
+	other.Record("b")
+	other.Record("b")
+	other.Record("c")
+	if err := c.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := other.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if top, err = Top(ctx, client, clock, 1); err != nil {
+		t.Fatal(err)
+	}
+	if len(top) != 1 || top[0] != (Count{"b", 4}) {
+		t.Fatalf("expected b on top with 4 requests, got %v", top)
+	}
+	if ttl := mr.TTL(Key(clock)); ttl != time.Hour {
+		t.Fatalf("expected the counts to expire in an hour, got %s", ttl)
+	}
+
+	// the next day is counted apart
+	clock = clock.Add(time.Minute)
+	c = NewCounter(client, 2, time.Hour)
+	c.Record("c")
+	if err := c.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if top, err = Top(ctx, client, clock, 10); err != nil {
+		t.Fatal(err)
+	}
+	if len(top) != 1 || top[0] != (Count{"c", 1}) {
+		t.Fatalf("expected only c to be counted on the next day, got %v", top)
+	}
+}
+
+func TestCounterRedisDown(t *testing.T) {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	c := NewCounter(client, DefaultMaxCIDs, DefaultRetention)
+	mr.Close()
+
+	c.Record("a")
+	if err := c.Flush(context.Background()); err == nil {
+		t.Fatal("expected flushing to fail while Redis is down")
+	}
+	if err := c.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
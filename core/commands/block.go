@@ -5,12 +5,21 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"math/rand"
 	"os"
+	"time"
 
+	blockstore "github.com/ipfs/boxo/blockstore"
+	"github.com/ipfs/boxo/datastore/dshelp"
 	"github.com/ipfs/boxo/files"
+	"github.com/ipfs/go-cid"
+	ds "github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/namespace"
+	dsq "github.com/ipfs/go-datastore/query"
 
 	cmdenv "github.com/ipfs/kubo/core/commands/cmdenv"
 	"github.com/ipfs/kubo/core/commands/cmdutils"
+	"github.com/ipfs/kubo/repo/encryptds"
 
 	options "github.com/ipfs/boxo/coreiface/options"
 
@@ -38,10 +47,12 @@ passed with a valid CID.
 	},
 
 	Subcommands: map[string]*cmds.Command{
-		"stat": blockStatCmd,
-		"get":  blockGetCmd,
-		"put":  blockPutCmd,
-		"rm":   blockRmCmd,
+		"stat":              blockStatCmd,
+		"get":               blockGetCmd,
+		"put":               blockPutCmd,
+		"rm":                blockRmCmd,
+		"rotate-encryption": blockRotateEncryptionCmd,
+		"verify-encryption": blockVerifyEncryptionCmd,
 	},
 }
 
@@ -330,3 +341,324 @@ It takes a list of CIDs to remove from the local datastore..
 	},
 	Type: removedBlock{},
 }
+
+type rotatedBlock struct {
+	Hash     string                    `json:",omitempty"`
+	Error    string                    `json:",omitempty"`
+	Progress *encryptds.RotateProgress `json:",omitempty"`
+}
+
+const blockRestartOptionName = "restart"
+
+// rotateProgressInterval is the number of blocks checked between two
+// progress reports, and checkpoints, of 'ipfs block rotate-encryption'.
+const rotateProgressInterval = 1000
+
+// rotateCheckpointKey is the datastore key of the checkpoint of
+// 'ipfs block rotate-encryption' run over all blocks.
+var rotateCheckpointKey = ds.NewKey("/local/rotate-encryption")
+
+var blockRotateEncryptionCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Re-encrypt stored blocks with the active encryption key.",
+		ShortDescription: `
+'ipfs block rotate-encryption' rewrites blocks encrypted at rest with a key
+other than the active one of ConfigPinningService.BlockEncryptionKeys, as well
+as blocks stored in plain text. Without arguments, every stored block is
+checked. Once done, the keys no longer in use can be removed from the config.
+
+When checking every block, blocks go in the order of their keys and progress
+is reported every 1000 blocks, with the number of blocks left and an estimate
+of the time left. It is also recorded in the datastore, so a rotation that is
+interrupted resumes where it stopped when run again, unless --restart is
+given or the active key changed in the meantime.
+`,
+	},
+	Arguments: []cmds.Argument{
+		cmds.StringArg("cid", false, true, "CIDs of the blocks to re-encrypt.").EnableStdin(),
+	},
+	Options: []cmds.Option{
+		cmds.BoolOption(blockQuietOptionName, "q", "Write minimal output."),
+		cmds.BoolOption(blockRestartOptionName, "Start over instead of resuming an interrupted rotation."),
+	},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		n, err := cmdenv.GetNode(env)
+		if err != nil {
+			return err
+		}
+		cfg, err := n.Repo.Config()
+		if err != nil {
+			return err
+		}
+		if !cfg.ConfigPinningService.EncryptBlocksAtRest {
+			return errors.New("blocks are not encrypted at rest, see ConfigPinningService.EncryptBlocksAtRest")
+		}
+		d, err := encryptds.WrapConfig(namespace.Wrap(n.Repo.Datastore(), blockstore.BlockPrefix), cfg.ConfigPinningService)
+		if err != nil {
+			return err
+		}
+		quiet, _ := req.Options[blockQuietOptionName].(bool)
+
+		// keep the garbage collector from removing blocks being rewritten
+		defer n.Blockstore.PinLock(req.Context).Unlock(req.Context)
+
+		emitError := func(k ds.Key, err error) error {
+			return res.Emit(&rotatedBlock{Hash: blockKeyHash(k), Error: err.Error()})
+		}
+
+		if len(req.Arguments) == 0 {
+			cp := encryptds.Checkpoint{Store: n.Repo.Datastore(), Key: rotateCheckpointKey}
+			if restart, _ := req.Options[blockRestartOptionName].(bool); restart {
+				if err := cp.Store.Delete(req.Context, cp.Key); err != nil {
+					return err
+				}
+			}
+			return d.RotateAll(req.Context, cp, rotateProgressInterval, func(p encryptds.RotateProgress) error {
+				if quiet {
+					return nil
+				}
+				return res.Emit(&rotatedBlock{Progress: &p})
+			}, emitError)
+		}
+
+		ctx, cancel := context.WithCancel(req.Context)
+		defer cancel()
+		keys := make(chan ds.Key)
+		errc := make(chan error, 1)
+		go func() {
+			defer close(keys)
+			errc <- listBlockKeys(ctx, d, req.Arguments, keys)
+		}()
+
+		for k := range keys {
+			rotated, err := d.Rotate(ctx, k)
+			if err != nil {
+				if err := emitError(k, err); err != nil {
+					return err
+				}
+				continue
+			}
+			if rotated && !quiet {
+				if err := res.Emit(&rotatedBlock{Hash: blockKeyHash(k)}); err != nil {
+					return err
+				}
+			}
+		}
+		return <-errc
+	},
+	PostRun: cmds.PostRunMap{
+		cmds.CLI: func(res cmds.Response, re cmds.ResponseEmitter) error {
+			someFailed := false
+			for {
+				res, err := res.Next()
+				if err == io.EOF {
+					break
+				} else if err != nil {
+					return err
+				}
+				r := res.(*rotatedBlock)
+				switch {
+				case r.Progress != nil:
+					p := r.Progress
+					fmt.Fprintf(os.Stderr, "checked %d blocks, %d re-encrypted, %d failed, %d left, about %s to go\n",
+						p.Done, p.Rotated, p.Failed, p.Remaining, p.ETA.Round(time.Second))
+				case r.Error != "":
+					someFailed = true
+					fmt.Fprintf(os.Stderr, "cannot re-encrypt %s: %s\n", r.Hash, r.Error)
+				default:
+					fmt.Fprintf(os.Stdout, "re-encrypted %s\n", r.Hash)
+				}
+			}
+			if someFailed {
+				return fmt.Errorf("some blocks not re-encrypted")
+			}
+			return nil
+		},
+	},
+	Type: rotatedBlock{},
+}
+
+const blockSampleRateOptionName = "sample-rate"
+
+// verifyProgressInterval is the number of blocks checked between two
+// progress reports of 'ipfs block verify-encryption'.
+const verifyProgressInterval = 1000
+
+type verifiedBlock struct {
+	Hash     string                    `json:",omitempty"`
+	Key      string                    `json:",omitempty"`
+	Error    string                    `json:",omitempty"`
+	Progress *encryptionVerifyProgress `json:",omitempty"`
+}
+
+type encryptionVerifyProgress struct {
+	Checked   int
+	Encrypted int
+	Failed    int
+}
+
+var blockVerifyEncryptionCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Check that the blocks encrypted at rest can be decrypted.",
+		ShortDescription: `
+'ipfs block verify-encryption' reads the stored blocks and decrypts those
+encrypted at rest, identified by ConfigPinningService.EncryptedBlockPrefix,
+with the keys of the config. Blocks that are corrupted or were encrypted with
+a key no longer configured are reported with their datastore key. Nothing is
+written. Without arguments, every stored block is checked.
+
+On large repositories, --sample-rate checks a random share of the blocks
+only, such as 0.01 for about one block in a hundred. Progress is reported
+every 1000 blocks checked.
+`,
+	},
+	Arguments: []cmds.Argument{
+		cmds.StringArg("cid", false, true, "CIDs of the blocks to verify.").EnableStdin(),
+	},
+	Options: []cmds.Option{
+		cmds.FloatOption(blockSampleRateOptionName, "Share of the blocks to check, between 0 and 1.").WithDefault(1.0),
+	},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		n, err := cmdenv.GetNode(env)
+		if err != nil {
+			return err
+		}
+		cfg, err := n.Repo.Config()
+		if err != nil {
+			return err
+		}
+		if !cfg.ConfigPinningService.EncryptBlocksAtRest {
+			return errors.New("blocks are not encrypted at rest, see ConfigPinningService.EncryptBlocksAtRest")
+		}
+		rate, _ := req.Options[blockSampleRateOptionName].(float64)
+		if rate <= 0 || rate > 1 {
+			return fmt.Errorf("--%s must be greater than 0 and at most 1, got %g", blockSampleRateOptionName, rate)
+		}
+		d, err := encryptds.WrapConfig(namespace.Wrap(n.Repo.Datastore(), blockstore.BlockPrefix), cfg.ConfigPinningService)
+		if err != nil {
+			return err
+		}
+
+		ctx, cancel := context.WithCancel(req.Context)
+		defer cancel()
+		keys := make(chan ds.Key)
+		errc := make(chan error, 1)
+		go func() {
+			defer close(keys)
+			errc <- listBlockKeys(ctx, d, req.Arguments, keys)
+		}()
+
+		sample := func() bool { return rate >= 1 || rand.Float64() < rate }
+		if err := verifyBlockEncryption(ctx, d, keys, sample, func(v *verifiedBlock) error {
+			return res.Emit(v)
+		}); err != nil {
+			return err
+		}
+		return <-errc
+	},
+	PostRun: cmds.PostRunMap{
+		cmds.CLI: func(res cmds.Response, re cmds.ResponseEmitter) error {
+			var last encryptionVerifyProgress
+			for {
+				res, err := res.Next()
+				if err == io.EOF {
+					break
+				} else if err != nil {
+					return err
+				}
+				v := res.(*verifiedBlock)
+				if v.Progress != nil {
+					last = *v.Progress
+					fmt.Fprintf(os.Stderr, "checked %d blocks, %d encrypted, %d failed\n", last.Checked, last.Encrypted, last.Failed)
+					continue
+				}
+				fmt.Fprintf(os.Stdout, "cannot decrypt %s (%s): %s\n", v.Hash, v.Key, v.Error)
+			}
+			if last.Failed > 0 {
+				return fmt.Errorf("%d blocks failed verification", last.Failed)
+			}
+			return nil
+		},
+	},
+	Type: verifiedBlock{},
+}
+
+// verifyBlockEncryption verifies the blocks of d under the keys read until
+// the channel is closed, those for which sample returns true. Failures are
+// emitted as they are found, along with progress every
+// verifyProgressInterval blocks checked and once done.
+func verifyBlockEncryption(ctx context.Context, d *encryptds.Datastore, keys <-chan ds.Key, sample func() bool, emit func(*verifiedBlock) error) error {
+	var progress encryptionVerifyProgress
+	for k := range keys {
+		if !sample() {
+			continue
+		}
+		encrypted, err := d.Verify(ctx, k)
+		progress.Checked++
+		if encrypted {
+			progress.Encrypted++
+		}
+		if err != nil {
+			progress.Failed++
+			hash := k.String()
+			if c, err := dshelp.DsKeyToCidV1(k, cid.Raw); err == nil {
+				hash = c.String()
+			}
+			if err := emit(&verifiedBlock{Hash: hash, Key: k.String(), Error: err.Error()}); err != nil {
+				return err
+			}
+		}
+		if progress.Checked%verifyProgressInterval == 0 {
+			p := progress
+			if err := emit(&verifiedBlock{Progress: &p}); err != nil {
+				return err
+			}
+		}
+	}
+	return emit(&verifiedBlock{Progress: &progress})
+}
+
+// blockKeyHash returns the CIDv1 of the block stored under the datastore key
+// k, or k itself when it isn't a block key.
+func blockKeyHash(k ds.Key) string {
+	if c, err := dshelp.DsKeyToCidV1(k, cid.Raw); err == nil {
+		return c.String()
+	}
+	return k.String()
+}
+
+// listBlockKeys sends the datastore keys of the blocks with the given CIDs,
+// or of all the blocks in d if there are none.
+func listBlockKeys(ctx context.Context, d ds.Datastore, cids []string, keys chan<- ds.Key) error {
+	if len(cids) > 0 {
+		for _, s := range cids {
+			c, err := cid.Decode(s)
+			if err != nil {
+				return err
+			}
+			select {
+			case keys <- dshelp.MultihashToDsKey(c.Hash()):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+		return nil
+	}
+
+	qr, err := d.Query(ctx, dsq.Query{KeysOnly: true})
+	if err != nil {
+		return err
+	}
+	defer qr.Close()
+	for r := range qr.Next() {
+		if r.Error != nil {
+			return r.Error
+		}
+		select {
+		case keys <- ds.RawKey(r.Key):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+package commands
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ipfs/boxo/datastore/dshelp"
+	blocks "github.com/ipfs/go-block-format"
+	ds "github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	"github.com/ipfs/kubo/repo/encryptds"
+)
+
+func TestVerifyBlockEncryption(t *testing.T) {
+	ctx := context.Background()
+	child := dssync.MutexWrap(ds.NewMapDatastore())
+	d, err := encryptds.Wrap(child, []byte("0123456789abcdef0123456789abcdef"), "enc:")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var keys []ds.Key
+	for _, data := range []string{"good", "corrupted", "plain"} {
+		b := blocks.NewBlock([]byte(data))
+		k := dshelp.MultihashToDsKey(b.Cid().Hash())
+		keys = append(keys, k)
+		if data == "plain" {
+			err = child.Put(ctx, k, b.RawData())
+		} else {
+			err = d.Put(ctx, k, b.RawData())
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	corrupted := keys[1]
+	stored, err := child.Get(ctx, corrupted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored[len(stored)-1] ^= 0x01
+	if err := child.Put(ctx, corrupted, stored); err != nil {
+		t.Fatal(err)
+	}
+
+	verify := func(sample func() bool) []*verifiedBlock {
+		t.Helper()
+		ch := make(chan ds.Key, len(keys))
+		for _, k := range keys {
+			ch <- k
+		}
+		close(ch)
+		var out []*verifiedBlock
+		if err := verifyBlockEncryption(ctx, d, ch, sample, func(v *verifiedBlock) error {
+			out = append(out, v)
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+		return out
+	}
+
+	out := verify(func() bool { return true })
+	if len(out) != 2 {
+		t.Fatalf("expected a failure and the final progress, got %d results", len(out))
+	}
+	if out[0].Key != corrupted.String() || out[0].Error == "" {
+		t.Fatalf("expected the corrupted block to be reported, got %+v", out[0])
+	}
+	if p := out[1].Progress; p == nil || *p != (encryptionVerifyProgress{Checked: 3, Encrypted: 2, Failed: 1}) {
+		t.Fatalf("unexpected progress %+v", out[1].Progress)
+	}
+
+	sampled := 0
+	out = verify(func() bool { sampled++; return sampled != 2 })
+	if len(out) != 1 || *out[0].Progress != (encryptionVerifyProgress{Checked: 2, Encrypted: 1}) {
+		t.Fatalf("expected the corrupted block to be skipped by sampling, got %+v", out)
+	}
+
+	if got, _ := child.Get(ctx, corrupted); string(got) != string(stored) {
+		t.Fatal("expected the corrupted block to be left as is")
+	}
+}
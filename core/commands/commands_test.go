@@ -85,6 +85,8 @@ func TestCommands(t *testing.T) {
 		"/block/get",
 		"/block/put",
 		"/block/rm",
+		"/block/rotate-encryption",
+		"/block/verify-encryption",
 		"/block/stat",
 		"/bootstrap",
 		"/bootstrap/add",
@@ -154,6 +156,8 @@ func TestCommands(t *testing.T) {
 		"/filestore/dups",
 		"/filestore/ls",
 		"/filestore/verify",
+		"/gateway",
+		"/gateway/top-cids",
 		"/get",
 		"/id",
 		"/key",
@@ -206,6 +210,7 @@ func TestCommands(t *testing.T) {
 		"/p2p/stream/ls",
 		"/pin",
 		"/pin/add",
+		"/pin/import",
 		"/pin/ls",
 		"/pin/remote",
 		"/pin/remote/add",
@@ -227,9 +232,11 @@ func TestCommands(t *testing.T) {
 		"/refs",
 		"/refs/local",
 		"/repo",
+		"/repo/check-pins",
 		"/repo/fsck",
 		"/repo/gc",
 		"/repo/migrate",
+		"/repo/rebuild-aiozfs-metadata",
 		"/repo/stat",
 		"/repo/verify",
 		"/repo/version",
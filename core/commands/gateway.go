@@ -0,0 +1,90 @@
+package commands
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	cmds "github.com/ipfs/go-ipfs-cmds"
+	"github.com/ipfs/kubo/core/cidstats"
+	cmdenv "github.com/ipfs/kubo/core/commands/cmdenv"
+	mh "github.com/multiformats/go-multihash"
+	"github.com/redis/go-redis/v9"
+)
+
+const gatewayTopCIDsCountOptionName = "count"
+
+var GatewayCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Inspect the requests served by the gateway.",
+	},
+	Subcommands: map[string]*cmds.Command{
+		"top-cids": gatewayTopCIDsCmd,
+	},
+}
+
+// TopCID is the number of gateway requests served today for a content.
+type TopCID struct {
+	Multihash string
+	Requests  int64
+}
+
+var gatewayTopCIDsCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "List the CIDs requested the most today.",
+		ShortDescription: `
+'ipfs gateway top-cids' lists the content requested the most through the
+/ipfs/ paths of the gateways sharing ConfigPinningService.RedisConn, for the
+current UTC day. Requests are counted when ConfigPinningService.CIDStats is
+enabled. The CIDv0 and CIDv1 of the same content are counted together, so
+content is listed by its multihash.
+
+Counts are sent to Redis every few seconds and may lag behind.
+`,
+	},
+	Options: []cmds.Option{
+		cmds.IntOption(gatewayTopCIDsCountOptionName, "n", "Number of CIDs to list.").WithDefault(10),
+	},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		n, err := cmdenv.GetNode(env)
+		if err != nil {
+			return err
+		}
+		cfg, err := n.Repo.Config()
+		if err != nil {
+			return err
+		}
+		if !cfg.ConfigPinningService.CIDStats || cfg.ConfigPinningService.RedisConn == "" {
+			return errors.New("requests are not counted per CID, see ConfigPinningService.CIDStats")
+		}
+		count, _ := req.Options[gatewayTopCIDsCountOptionName].(int)
+		if count <= 0 {
+			return fmt.Errorf("--%s must be positive, got %d", gatewayTopCIDsCountOptionName, count)
+		}
+
+		client := redis.NewClient(&redis.Options{Addr: cfg.ConfigPinningService.RedisConn})
+		defer client.Close()
+		top, err := cidstats.Top(req.Context, client, time.Now(), count)
+		if err != nil {
+			return fmt.Errorf("reading request counts: %w", err)
+		}
+		for _, c := range top {
+			out := &TopCID{Multihash: c.Key, Requests: c.Requests}
+			if h, err := mh.FromHexString(c.Key); err == nil {
+				out.Multihash = h.B58String()
+			}
+			if err := res.Emit(out); err != nil {
+				return err
+			}
+		}
+		return nil
+	},
+	Encoders: cmds.EncoderMap{
+		cmds.Text: cmds.MakeTypedEncoder(func(req *cmds.Request, w io.Writer, c *TopCID) error {
+			_, err := fmt.Fprintf(w, "%d\t%s\n", c.Requests, c.Multihash)
+			return err
+		}),
+	},
+	Type: TopCID{},
+}
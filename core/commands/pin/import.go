@@ -0,0 +1,272 @@
+package pin
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/ipfs/boxo/coreiface/options"
+	"github.com/ipfs/boxo/path"
+	cid "github.com/ipfs/go-cid"
+	cmds "github.com/ipfs/go-ipfs-cmds"
+
+	cmdenv "github.com/ipfs/kubo/core/commands/cmdenv"
+)
+
+const (
+	pinConcurrencyOptionName = "concurrency"
+	defaultPinConcurrency    = 8
+)
+
+// PinImportOutput is the result of pinning one CID of an import, or the
+// summary of the import once all CIDs were processed.
+type PinImportOutput struct {
+	Cid     string            `json:",omitempty"`
+	Error   string            `json:",omitempty"`
+	Summary *PinImportSummary `json:",omitempty"`
+}
+
+// PinImportSummary counts the CIDs of an import.
+type PinImportSummary struct {
+	Pinned int
+	Failed int
+}
+
+var importPinCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Pin a list of CIDs read from a file or stdin.",
+		ShortDescription: `
+Pins the CIDs read from the given file, or from stdin, several at a time.
+The result of each pin is reported as it completes, failures not stopping
+the import, followed by a summary. The command fails if any pin failed.
+`,
+		LongDescription: `
+Pins the CIDs read from the given file, or from stdin, several at a time.
+The result of each pin is reported as it completes, failures not stopping
+the import, followed by a summary. The command fails if any pin failed.
+
+The input is either one CID per line, blank lines and lines starting with
+'#' being skipped, or a stream of JSON values, each a CID string, an array
+of CID strings or an object with a "Cid" field, as output by
+'ipfs pin ls --enc=json'.
+
+Example:
+
+  $ ipfs pin ls -t recursive -q > pins.txt
+  $ ipfs pin import pins.txt
+`,
+	},
+
+	Arguments: []cmds.Argument{
+		cmds.FileArg("cids", true, false, "File of the CIDs to pin.").EnableStdin(),
+	},
+	Options: []cmds.Option{
+		cmds.BoolOption(pinRecursiveOptionName, "r", "Recursively pin the objects linked to by the CIDs.").WithDefault(true),
+		cmds.IntOption(pinConcurrencyOptionName, "Number of CIDs pinned at the same time.").WithDefault(defaultPinConcurrency),
+	},
+	Type: PinImportOutput{},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		api, err := cmdenv.GetApi(env, req)
+		if err != nil {
+			return err
+		}
+		enc, err := cmdenv.GetCidEncoder(req)
+		if err != nil {
+			return err
+		}
+		file, err := cmdenv.GetFileArg(req.Files.Entries())
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+
+		recursive, _ := req.Options[pinRecursiveOptionName].(bool)
+		concurrency, _ := req.Options[pinConcurrencyOptionName].(int)
+		if concurrency < 1 {
+			return fmt.Errorf("--%s must be at least 1", pinConcurrencyOptionName)
+		}
+
+		pin := func(ctx context.Context, c cid.Cid) error {
+			return api.Pin().Add(ctx, path.FromCid(c), options.Pin.Recursive(recursive))
+		}
+		summary, err := importPins(req.Context, file, concurrency, pin, func(out *PinImportOutput) error {
+			if c, err := cid.Decode(out.Cid); err == nil {
+				out.Cid = enc.Encode(c)
+			}
+			return res.Emit(out)
+		})
+		if err != nil {
+			return err
+		}
+		if err := res.Emit(&PinImportOutput{Summary: &summary}); err != nil {
+			return err
+		}
+		if summary.Failed > 0 {
+			return fmt.Errorf("%d of %d pins failed", summary.Failed, summary.Pinned+summary.Failed)
+		}
+		return nil
+	},
+	Encoders: cmds.EncoderMap{
+		cmds.Text: cmds.MakeTypedEncoder(func(req *cmds.Request, w io.Writer, out *PinImportOutput) error {
+			switch {
+			case out.Summary != nil:
+				fmt.Fprintf(w, "pinned %d, failed %d\n", out.Summary.Pinned, out.Summary.Failed)
+			case out.Error != "":
+				fmt.Fprintf(w, "error %s: %s\n", out.Cid, out.Error)
+			default:
+				fmt.Fprintf(w, "pinned %s\n", out.Cid)
+			}
+			return nil
+		}),
+	},
+}
+
+// importPins pins the CIDs read from r with pin, concurrency at a time,
+// passing the result of each to emit as it completes. CIDs that can't be
+// parsed or pinned are reported without stopping the import. It returns
+// when all CIDs were processed, or with the error of ctx or emit.
+func importPins(ctx context.Context, r io.Reader, concurrency int, pin func(context.Context, cid.Cid) error, emit func(*PinImportOutput) error) (PinImportSummary, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	todo := make(chan string)
+	results := make(chan *PinImportOutput)
+	var workers sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			for s := range todo {
+				out := &PinImportOutput{Cid: s}
+				if c, err := cid.Decode(s); err != nil {
+					out.Error = fmt.Sprintf("invalid CID: %s", err)
+				} else if err := pin(ctx, c); err != nil {
+					out.Error = err.Error()
+				}
+				select {
+				case results <- out:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+	}
+
+	readErr := make(chan error, 1)
+	go func() {
+		defer close(todo)
+		readErr <- readPinImport(r, func(s string) bool {
+			select {
+			case todo <- s:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		})
+	}()
+	go func() {
+		workers.Wait()
+		close(results)
+	}()
+
+	var summary PinImportSummary
+	for out := range results {
+		if out.Error != "" {
+			summary.Failed++
+		} else {
+			summary.Pinned++
+		}
+		if err := emit(out); err != nil {
+			return summary, err
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return summary, err
+	}
+	if err := <-readErr; err != nil {
+		return summary, fmt.Errorf("reading CIDs: %w", err)
+	}
+	return summary, nil
+}
+
+// readPinImport passes the CIDs of r to add until it returns false. r holds
+// a stream of JSON values when its first non blank character starts one,
+// one CID per line otherwise.
+func readPinImport(r io.Reader, add func(string) bool) error {
+	br := bufio.NewReader(r)
+	for {
+		b, err := br.Peek(1)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if b[0] != ' ' && b[0] != '\t' && b[0] != '\r' && b[0] != '\n' {
+			break
+		}
+		br.ReadByte()
+	}
+
+	if b, _ := br.Peek(1); bytes.ContainsAny(b, `"[{`) {
+		return readPinImportJSON(br, add)
+	}
+
+	scanner := bufio.NewScanner(br)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if !add(line) {
+			return nil
+		}
+	}
+	return scanner.Err()
+}
+
+func readPinImportJSON(r io.Reader, add func(string) bool) error {
+	dec := json.NewDecoder(r)
+	for {
+		var v json.RawMessage
+		if err := dec.Decode(&v); err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+
+		var cids []string
+		switch v[0] {
+		case '"':
+			var s string
+			if err := json.Unmarshal(v, &s); err != nil {
+				return err
+			}
+			cids = []string{s}
+		case '[':
+			if err := json.Unmarshal(v, &cids); err != nil {
+				return err
+			}
+		case '{':
+			var o struct{ Cid string }
+			if err := json.Unmarshal(v, &o); err != nil {
+				return err
+			}
+			cids = []string{o.Cid}
+		default:
+			return fmt.Errorf("expected a CID, an array of CIDs or an object with a Cid field, got %s", v)
+		}
+		for _, s := range cids {
+			if !add(s) {
+				return nil
+			}
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package pin
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+
+	cid "github.com/ipfs/go-cid"
+)
+
+const (
+	importCid1 = "bafybeigdyrzt5sfp7udm7hu76uh7y26nf3efuylqabf3oclgtqy55fbzdi"
+	importCid2 = "QmUNLLsPACCz1vLxQVkXqqLX5R1X345qqfHbsf67hvA3Nn"
+	importCid3 = "bafkreifzjut3te2nhyekklss27nh3k72ysco7y32koao5eei66wof36n5e"
+)
+
+func TestImportPins(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		input string
+	}{
+		{"lines", "# pins\n" + importCid1 + "\n\nnot-a-cid\n  " + importCid2 + "  \n" + importCid3 + "\n"},
+		{"json strings", `"` + importCid1 + `" "not-a-cid"` + "\n" + `["` + importCid2 + `", "` + importCid3 + `"]`},
+		{"json objects", `{"Cid":"` + importCid1 + `","Type":"recursive"}{"Cid":"not-a-cid"}` + "\n" + `{"Cid":"` + importCid2 + `"}` + "\n" + `{"Cid":"` + importCid3 + `"}`},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var mu sync.Mutex
+			var pinned []string
+			pin := func(ctx context.Context, c cid.Cid) error {
+				if c.String() == importCid3 {
+					return errors.New("not found")
+				}
+				mu.Lock()
+				pinned = append(pinned, c.String())
+				mu.Unlock()
+				return nil
+			}
+
+			results := make(map[string]string)
+			summary, err := importPins(context.Background(), strings.NewReader(tc.input), 2, pin, func(out *PinImportOutput) error {
+				results[out.Cid] = out.Error
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if summary.Pinned != 2 || summary.Failed != 2 {
+				t.Fatalf("expected 2 pinned and 2 failed, got %+v", summary)
+			}
+			if len(pinned) != 2 {
+				t.Fatalf("expected 2 pins, got %v", pinned)
+			}
+			for s, failed := range map[string]bool{importCid1: false, importCid2: false, importCid3: true, "not-a-cid": true} {
+				errMsg, ok := results[s]
+				if !ok {
+					t.Fatalf("expected a result for %s", s)
+				}
+				if failed != (errMsg != "") {
+					t.Fatalf("unexpected result for %s: %q", s, errMsg)
+				}
+			}
+		})
+	}
+}
+
+func TestImportPinsCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	input := strings.Repeat(importCid1+"\n", 100)
+	var calls int
+	pin := func(ctx context.Context, c cid.Cid) error {
+		return nil
+	}
+	_, err := importPins(ctx, strings.NewReader(input), 1, pin, func(out *PinImportOutput) error {
+		if calls++; calls == 3 {
+			cancel()
+		}
+		return nil
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected the import to be canceled, got %v", err)
+	}
+	if calls >= 100 {
+		t.Fatalf("expected the import to stop early, got %d results", calls)
+	}
+}
+
+func TestImportPinsInvalidJSON(t *testing.T) {
+	pin := func(ctx context.Context, c cid.Cid) error { return nil }
+	summary, err := importPins(context.Background(), strings.NewReader(`"`+importCid1+`" {"Cid": `), 1, pin, func(*PinImportOutput) error { return nil })
+	if err == nil {
+		t.Fatal("expected truncated JSON to fail")
+	}
+	if summary.Pinned != 1 {
+		t.Fatalf("expected the CIDs before the error to be pinned, got %+v", summary)
+	}
+}
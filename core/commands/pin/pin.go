@@ -35,6 +35,7 @@ var PinCmd = &cmds.Command{
 		"ls":     listPinCmd,
 		"verify": verifyPinCmd,
 		"update": updatePinCmd,
+		"import": importPinCmd,
 		"remote": remotePinCmd,
 	},
 }
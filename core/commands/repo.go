@@ -12,8 +12,11 @@ import (
 	"text/tabwriter"
 
 	oldcmds "github.com/ipfs/kubo/commands"
+	"github.com/ipfs/kubo/config"
+	serialize "github.com/ipfs/kubo/config/serialize"
 	cmdenv "github.com/ipfs/kubo/core/commands/cmdenv"
 	corerepo "github.com/ipfs/kubo/core/corerepo"
+	aiozfsplugin "github.com/ipfs/kubo/plugin/plugins/aiozfs"
 	fsrepo "github.com/ipfs/kubo/repo/fsrepo"
 	"github.com/ipfs/kubo/repo/fsrepo/migrations"
 	"github.com/ipfs/kubo/repo/fsrepo/migrations/ipfsfetcher"
@@ -37,13 +40,15 @@ var RepoCmd = &cmds.Command{
 	},
 
 	Subcommands: map[string]*cmds.Command{
-		"stat":    repoStatCmd,
-		"gc":      repoGcCmd,
-		"fsck":    repoFsckCmd,
-		"version": repoVersionCmd,
-		"verify":  repoVerifyCmd,
-		"migrate": repoMigrateCmd,
-		"ls":      RefsLocalCmd,
+		"stat":                    repoStatCmd,
+		"gc":                      repoGcCmd,
+		"fsck":                    repoFsckCmd,
+		"version":                 repoVersionCmd,
+		"verify":                  repoVerifyCmd,
+		"migrate":                 repoMigrateCmd,
+		"ls":                      RefsLocalCmd,
+		"check-pins":              repoCheckPinsCmd,
+		"rebuild-aiozfs-metadata": repoRebuildAiozfsMetadataCmd,
 	},
 }
 
@@ -363,6 +368,137 @@ var repoVerifyCmd = &cmds.Command{
 	},
 }
 
+// PinCheckOutput is a single inconsistency reported by "repo check-pins".
+type PinCheckOutput struct {
+	Cid      string
+	Status   string
+	Repaired bool   `json:",omitempty"`
+	Error    string `json:",omitempty"`
+}
+
+const repoRepairOptionName = "repair"
+
+var repoCheckPinsCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Check that the pin set and the blockstore agree.",
+		ShortDescription: `
+'ipfs repo check-pins' walks every pinned DAG using only local blocks and
+reports pinned blocks that are missing from the blockstore. It then lists
+blocks that are stored locally but not reachable from any pin or from MFS.
+
+With --repair, missing blocks are fetched from the network and orphaned
+blocks are removed with a garbage collection.
+`,
+	},
+	Options: []cmds.Option{
+		cmds.BoolOption(repoRepairOptionName, "Fetch missing blocks and remove orphaned ones."),
+	},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		n, err := cmdenv.GetNode(env)
+		if err != nil {
+			return err
+		}
+
+		repair, _ := req.Options[repoRepairOptionName].(bool)
+
+		var failed bool
+		for r := range corerepo.CheckPins(req.Context, n, repair) {
+			out := &PinCheckOutput{Repaired: r.Repaired}
+			if r.Cid.Defined() {
+				out.Cid = r.Cid.String()
+			}
+			switch {
+			case r.Missing:
+				out.Status = "missing"
+			case r.Orphaned:
+				out.Status = "orphaned"
+			}
+			if r.Err != nil {
+				out.Error = r.Err.Error()
+				failed = true
+			}
+			if err := res.Emit(out); err != nil {
+				return err
+			}
+		}
+
+		if err := req.Context.Err(); err != nil {
+			return err
+		}
+		if failed {
+			return errors.New("pin check did not complete cleanly")
+		}
+		return nil
+	},
+	Type: &PinCheckOutput{},
+	Encoders: cmds.EncoderMap{
+		cmds.Text: cmds.MakeTypedEncoder(func(req *cmds.Request, w io.Writer, out *PinCheckOutput) error {
+			if out.Error != "" {
+				if out.Cid != "" {
+					_, err := fmt.Fprintf(w, "%s error: %s\n", out.Cid, out.Error)
+					return err
+				}
+				_, err := fmt.Fprintf(w, "error: %s\n", out.Error)
+				return err
+			}
+			if out.Repaired {
+				_, err := fmt.Fprintf(w, "%s %s (repaired)\n", out.Cid, out.Status)
+				return err
+			}
+			_, err := fmt.Fprintf(w, "%s %s\n", out.Cid, out.Status)
+			return err
+		}),
+	},
+}
+
+var repoRebuildAiozfsMetadataCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Rebuild the metadata files of the aiozfs datastore.",
+		ShortDescription: `
+'ipfs repo rebuild-aiozfs-metadata' recreates the SHARDING and disk usage
+cache files of the aiozfs datastore when they were lost or corrupted. The
+sharding function is derived from where blocks are stored and the disk
+usage is recomputed by walking the datastore.
+
+The daemon must not be running.
+`,
+	},
+	NoRemote: true,
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		cctx := env.(*oldcmds.Context)
+
+		locked, err := fsrepo.LockedByOtherProcess(cctx.ConfigRoot)
+		if err != nil {
+			return err
+		}
+		if locked {
+			return errors.New("the repo is in use, stop the daemon before rebuilding aiozfs metadata")
+		}
+
+		configFileOpt, _ := req.Options[ConfigFileOption].(string)
+		filename, err := config.Filename(cctx.ConfigRoot, configFileOpt)
+		if err != nil {
+			return err
+		}
+		cfg, err := serialize.Load(filename)
+		if err != nil {
+			return err
+		}
+
+		dir, fallback, err := aiozfsplugin.FindDatastore(cctx.ConfigRoot, cfg.Datastore.Spec)
+		if err != nil {
+			return err
+		}
+		fun, du, err := aiozfsplugin.RebuildMetadata(dir, fallback)
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("Rebuilt aiozfs metadata in %s: sharding %s, disk usage %s.\n", dir, fun, humanize.Bytes(du))
+		return nil
+	},
+}
+
 var repoVersionCmd = &cmds.Command{
 	Helptext: cmds.HelpText{
 		Tagline: "Show the repo version.",
@@ -64,6 +64,7 @@ ADVANCED COMMANDS
   stats         Various operational stats
   p2p           Libp2p stream mounting (experimental)
   filestore     Manage the filestore (experimental)
+  gateway       Inspect the requests served by the gateway
   mount         Mount an IPFS read-only mount point (experimental)
 
 NETWORK COMMANDS
@@ -131,6 +132,7 @@ var rootSubcommands = map[string]*cmds.Command{
 	"commands":  CommandsDaemonCmd,
 	"files":     FilesCmd,
 	"filestore": FileStoreCmd,
+	"gateway":   GatewayCmd,
 	"get":       GetCmd,
 	"pubsub":    PubsubCmd,
 	"repo":      RepoCmd,
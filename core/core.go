@@ -51,6 +51,7 @@ import (
 	ipnsrp "github.com/ipfs/boxo/namesys/republisher"
 	"github.com/ipfs/kubo/config"
 	"github.com/ipfs/kubo/core/bootstrap"
+	"github.com/ipfs/kubo/core/events"
 	"github.com/ipfs/kubo/core/node"
 	"github.com/ipfs/kubo/core/node/libp2p"
 	"github.com/ipfs/kubo/fuse/mount"
@@ -71,6 +72,7 @@ type IpfsNode struct {
 
 	// Local node
 	Pinning         pin.Pinner             // the pinning manager
+	Events          *events.Emitter        `optional:"true"` // block and pin events, if published
 	Mounts          Mounts                 `optional:"true"` // current mount state, if any.
 	PrivateKey      ic.PrivKey             `optional:"true"` // the local node's private Key
 	PNetFingerprint libp2p.PNetFingerprint `optional:"true"` // fingerprint of private network
@@ -0,0 +1,209 @@
+package corehttp
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	config "github.com/ipfs/kubo/config"
+	"golang.org/x/sync/singleflight"
+)
+
+const (
+	defaultAccessCacheTTL = time.Minute
+
+	// defaultAccessCacheSize is the number of access decisions past which
+	// the least recently used ones are evicted, unless
+	// ConfigPinningService.AccessCacheSize is set.
+	defaultAccessCacheSize = 10000
+)
+
+// accessCache remembers the dedicated gateway access decisions of the
+// pinning service per CID and client key, so repeated requests from the same
+// client don't each wait for the pinning service. Keys are only kept hashed.
+// Upstream failures are not cached, errorCooldown takes care of those, nor
+// are keys the pinning service rejects, which the client picks. Allowed
+// access is still granted for staleIfError past its TTL when the pinning
+// service fails. The least recently used entries are evicted past size.
+// Concurrent misses for the same CID and client key share a single call.
+type accessCache struct {
+	mu           sync.Mutex
+	size         int
+	ttl          time.Duration
+	staleIfError time.Duration
+	ll           *list.List
+	items        map[accessCacheKey]*list.Element
+	calls        singleflight.Group
+}
+
+type accessCacheKey struct {
+	cid       string
+	clientKey string // hash of the client's API key
+}
+
+type accessEntry struct {
+	key    accessCacheKey
+	status int
+	err    error
+	until  time.Time
+}
+
+func newAccessCache(size int, ttl, staleIfError time.Duration) *accessCache {
+	if size <= 0 {
+		size = defaultAccessCacheSize
+	}
+	return &accessCache{
+		size:         size,
+		ttl:          ttl,
+		staleIfError: staleIfError,
+		ll:           list.New(),
+		items:        make(map[accessCacheKey]*list.Element),
+	}
+}
+
+// setStaleIfError changes how long allowed access is still granted past its
+// TTL when the pinning service fails.
+func (c *accessCache) setStaleIfError(stale time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.staleIfError = stale
+}
+
+// check returns the cached access decision for the CID and client key,
+// calling getDedicatedGatewayAccess on a miss.
+func (c *accessCache) check(ctx context.Context, cid, clientKey string, cfg *config.Config) (int, error) {
+	key := accessCacheKey{cid: cid, clientKey: clientKeyHash(clientKey)}
+	call := func() (int, error) {
+		return coalesce(ctx, &c.calls, key.cid+"/"+key.clientKey, func(ctx context.Context) (int, error) {
+			return getDedicatedGatewayAccess(ctx, cid, clientKey, cfg)
+		})
+	}
+	if c.ttl <= 0 {
+		return call()
+	}
+
+	e, ok := c.get(key)
+	if ok && now().Before(e.until) {
+		return e.status, e.err
+	}
+
+	status, err := call()
+	if status >= http.StatusInternalServerError && ok && e.err == nil && c.staleAllowed(e) {
+		log.Warnf("granting access to %s allowed by an expired decision, as the check failed: %s", cid, err)
+		return e.status, nil
+	}
+	if status >= http.StatusInternalServerError || status == http.StatusRequestTimeout || errors.Is(err, errClientKeyRejected) {
+		return status, err
+	}
+	c.add(&accessEntry{key: key, status: status, err: err, until: now().Add(c.ttl)})
+	return status, err
+}
+
+// get returns the entry cached for key, expired or not. Entries past their
+// stale-if-error window are removed instead.
+func (c *accessCache) get(key accessCacheKey) (accessEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.items[key]
+	if !ok {
+		return accessEntry{}, false
+	}
+	e := elem.Value.(*accessEntry)
+	if !now().Before(e.until.Add(c.staleIfError)) {
+		c.ll.Remove(elem)
+		delete(c.items, key)
+		return accessEntry{}, false
+	}
+	c.ll.MoveToFront(elem)
+	return *e, true
+}
+
+// staleAllowed reports whether e expired less than staleIfError ago.
+func (c *accessCache) staleAllowed(e accessEntry) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return now().Before(e.until.Add(c.staleIfError))
+}
+
+// add caches e, evicting the least recently used entries past size.
+func (c *accessCache) add(e *accessEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.items[e.key]; ok {
+		elem.Value = e
+		c.ll.MoveToFront(elem)
+		return
+	}
+	c.items[e.key] = c.ll.PushFront(e)
+	for c.ll.Len() > c.size {
+		oldest := c.ll.Back()
+		c.ll.Remove(oldest)
+		delete(c.items, oldest.Value.(*accessEntry).key)
+	}
+}
+
+// errClientKeyRejected is returned when the pinning service refuses the API
+// key sent by the client.
+var errClientKeyRejected = errors.New("The API key was rejected for this content")
+
+// bearerScheme prefixes the client keys sent as bearer tokens.
+const bearerScheme = "Bearer "
+
+// missingClientKey answers a dedicated gateway request sent without a client
+// API key while one is required, see ConfigPinningService.RequireClientKey.
+func missingClientKey(w http.ResponseWriter, cfg config.ConfigPinningService) {
+	if strings.EqualFold(cfg.ClientKeyHeader, "Authorization") {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+	}
+	http.Error(w, "An API key is required to access this gateway", http.StatusUnauthorized)
+}
+
+// clientKeyHash returns the hex encoded SHA-256 of a client API key, or ""
+// if the client sent none.
+func clientKeyHash(clientKey string) string {
+	if clientKey == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(clientKey))
+	return hex.EncodeToString(sum[:])
+}
+
+// takeClientKey returns the API key the client sent in the header or query
+// parameter configured in ConfigPinningService, the header taking
+// precedence. A bearer token is taken without its scheme. The returned
+// request has the query parameter removed so the key isn't passed on with
+// the rest of the URL.
+func takeClientKey(r *http.Request, cfg config.ConfigPinningService) (string, *http.Request) {
+	var key string
+	if cfg.ClientKeyHeader != "" {
+		key = r.Header.Get(cfg.ClientKeyHeader)
+		if len(key) > len(bearerScheme) && strings.EqualFold(key[:len(bearerScheme)], bearerScheme) {
+			key = key[len(bearerScheme):]
+		}
+	}
+	if cfg.ClientKeyParam == "" {
+		return key, r
+	}
+
+	q := r.URL.Query()
+	if !q.Has(cfg.ClientKeyParam) {
+		return key, r
+	}
+	if key == "" {
+		key = q.Get(cfg.ClientKeyParam)
+	}
+	q.Del(cfg.ClientKeyParam)
+	u := *r.URL
+	u.RawQuery = q.Encode()
+	r = r.WithContext(r.Context())
+	r.URL = &u
+	return key, r
+}
@@ -0,0 +1,280 @@
+package corehttp
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ipfs/kubo/config"
+)
+
+func TestClientKeyAccess(t *testing.T) {
+	var (
+		mu    sync.Mutex
+		calls = make(map[string]int) // per forwarded client key
+	)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.URL.Path, "/api/dedicatedGateways/") {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		key := r.Header.Get("client-API-Key")
+		mu.Lock()
+		calls[key]++
+		mu.Unlock()
+		if key != "good" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(ts.Close)
+
+	cfg := &config.Config{
+		ConfigPinningService: config.ConfigPinningService{
+			PinningService:   ts.URL,
+			DedicatedGateway: true,
+			ClientKeyHeader:  "X-Api-Key",
+			ClientKeyParam:   "key",
+		},
+	}
+	var forwardedQuery string
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		forwardedQuery = r.URL.RawQuery
+		w.WriteHeader(http.StatusOK)
+	})
+	handler := DedicatedGatewayMiddleware(next, nil, cfg)
+
+	for _, tc := range []struct {
+		name   string
+		header string
+		query  string
+		status int
+	}{
+		{"header", "good", "", http.StatusOK},
+		{"header again", "good", "", http.StatusOK},
+		{"query", "", "?key=good&format=raw", http.StatusOK},
+		{"other client", "bad", "", http.StatusForbidden},
+		{"other client again", "bad", "", http.StatusForbidden},
+		{"no key", "", "", http.StatusUnauthorized},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/ipfs/"+testCid+tc.query, nil)
+			if tc.header != "" {
+				r.Header.Set("X-Api-Key", tc.header)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+			if w.Code != tc.status {
+				t.Fatalf("expected status %d, got %d", tc.status, w.Code)
+			}
+		})
+	}
+
+	if forwardedQuery != "format=raw" {
+		t.Errorf("expected the key to be stripped from the query, got %q", forwardedQuery)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	// requests without a key are answered without asking, rejected keys
+	// aren't cached
+	for key, want := range map[string]int{"good": 1, "bad": 2, "": 0} {
+		if calls[key] != want {
+			t.Errorf("expected %d access check(s) with client key %q, got %d", want, key, calls[key])
+		}
+	}
+}
+
+func TestAccessCacheSize(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.Header.Get("client-API-Key"), "stranger") {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(ts.Close)
+	cfg := &config.Config{ConfigPinningService: config.ConfigPinningService{PinningService: ts.URL}}
+
+	c := newAccessCache(10, time.Minute, 0)
+	entries := func() int {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		if len(c.items) != c.ll.Len() {
+			t.Fatalf("expected the index and the list to agree, got %d and %d", len(c.items), c.ll.Len())
+		}
+		return len(c.items)
+	}
+
+	for i := 0; i < 100; i++ {
+		if status, _ := c.check(context.Background(), testCid, fmt.Sprintf("stranger-%d", i), cfg); status != http.StatusForbidden {
+			t.Fatalf("expected the key to be rejected, got %d", status)
+		}
+	}
+	if n := entries(); n != 0 {
+		t.Fatalf("expected rejected keys not to be cached, got %d entries", n)
+	}
+
+	for i := 0; i < 100; i++ {
+		if status, err := c.check(context.Background(), testCid, fmt.Sprintf("subscriber-%d", i), cfg); status != http.StatusOK {
+			t.Fatalf("expected the key to be allowed, got %d: %v", status, err)
+		}
+		if n := entries(); n > c.size {
+			t.Fatalf("expected at most %d entries, got %d", c.size, n)
+		}
+	}
+	if n := entries(); n != c.size {
+		t.Fatalf("expected the cache to be full at %d entries, got %d", c.size, n)
+	}
+	if _, ok := c.get(accessCacheKey{cid: testCid, clientKey: clientKeyHash("subscriber-0")}); ok {
+		t.Fatal("expected the least recently used entry to be evicted")
+	}
+}
+
+func TestRequireClientKey(t *testing.T) {
+	var (
+		mu       sync.Mutex
+		received []string // client keys forwarded to the access check
+	)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.URL.Path, "/api/dedicatedGateways/") {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		key := r.Header.Get("client-API-Key")
+		mu.Lock()
+		received = append(received, key)
+		mu.Unlock()
+		if key != "subscriber" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(ts.Close)
+
+	cfg := &config.Config{
+		ConfigPinningService: config.ConfigPinningService{
+			PinningService:   ts.URL,
+			DedicatedGateway: true,
+			ClientKeyHeader:  "Authorization",
+			AllowedCIDs:      []string{testBlockedCid},
+		},
+	}
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	handler := DedicatedGatewayMiddleware(next, nil, cfg)
+
+	for _, tc := range []struct {
+		name          string
+		cid           string
+		authorization string
+		status        int
+		body          string
+	}{
+		{"token", testCid, "Bearer subscriber", http.StatusOK, ""},
+		{"no token", testCid, "", http.StatusUnauthorized, "An API key is required"},
+		{"rejected token", testCid, "bearer stranger", http.StatusUnauthorized, errClientKeyRejected.Error()},
+		{"allowlisted without token", testBlockedCid, "", http.StatusOK, ""},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/ipfs/"+tc.cid, nil)
+			if tc.authorization != "" {
+				r.Header.Set("Authorization", tc.authorization)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+			if w.Code != tc.status {
+				t.Fatalf("expected status %d, got %d", tc.status, w.Code)
+			}
+			if !strings.Contains(w.Body.String(), tc.body) {
+				t.Fatalf("expected the body to contain %q, got %q", tc.body, w.Body)
+			}
+			if tc.authorization == "" && tc.status == http.StatusUnauthorized && w.Header().Get("WWW-Authenticate") != "Bearer" {
+				t.Fatalf("expected a bearer challenge, got %q", w.Header().Get("WWW-Authenticate"))
+			}
+		})
+	}
+
+	mu.Lock()
+	if len(received) != 2 || received[0] != "subscriber" || received[1] != "stranger" {
+		t.Fatalf("expected only the tokens to be forwarded, without their scheme, got %q", received)
+	}
+	received = nil
+	mu.Unlock()
+
+	// opted out, the pinning service decides
+	cfg.ConfigPinningService.RequireClientKey = config.False
+	handler = DedicatedGatewayMiddleware(next, nil, cfg)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/ipfs/"+testCid, nil))
+	if w.Code != http.StatusUnauthorized || strings.Contains(w.Body.String(), "An API key is required") {
+		t.Fatalf("expected the pinning service to answer, got %d: %s", w.Code, w.Body)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(received) != 1 || received[0] != "" {
+		t.Fatalf("expected the request to be forwarded without a key, got %q", received)
+	}
+}
+
+func TestStaleIfError(t *testing.T) {
+	clock := time.Unix(0, 0)
+	now = func() time.Time { return clock }
+	t.Cleanup(func() { now = time.Now })
+
+	var down atomic.Bool
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if down.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(ts.Close)
+
+	cfg := &config.Config{
+		ConfigPinningService: config.ConfigPinningService{
+			PinningService:   ts.URL,
+			DedicatedGateway: true,
+			DmcaAllowedTTL:   config.NewOptionalDuration(time.Minute),
+			AccessCacheTTL:   config.NewOptionalDuration(time.Minute),
+			StaleIfError:     config.NewOptionalDuration(10 * time.Minute),
+		},
+	}
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	handler := DedicatedGatewayMiddleware(next, nil, cfg)
+	get := func(c string) int {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/ipfs/"+c, nil))
+		return w.Code
+	}
+
+	if status := get(testCid); status != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, status)
+	}
+
+	down.Store(true)
+	clock = clock.Add(2 * time.Minute)
+	if status := get(testCid); status != http.StatusOK {
+		t.Fatalf("expected the expired allow decisions to be honored during the outage, got %d", status)
+	}
+	if status := get(testBlockedCid); status == http.StatusOK {
+		t.Fatal("expected content never checked not to be served during the outage")
+	}
+
+	clock = clock.Add(10 * time.Minute)
+	if status := get(testCid); status == http.StatusOK {
+		t.Fatal("expected the allow decisions not to be honored past the stale-if-error window")
+	}
+}
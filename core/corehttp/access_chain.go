@@ -0,0 +1,189 @@
+package corehttp
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	cid "github.com/ipfs/go-cid"
+	config "github.com/ipfs/kubo/config"
+)
+
+// accessVerdict is the outcome of an access check of the decision chain.
+type accessVerdict int
+
+const (
+	// accessDefer leaves the decision to the next check.
+	accessDefer accessVerdict = iota
+	// accessAllow serves the request, skipping the checks left.
+	accessAllow
+	// accessDeny means the check answered the request itself, with an
+	// error or a redirect.
+	accessDeny
+)
+
+// accessRequest is a dedicated gateway request going through the access
+// decision chain, along with the credentials taken from it.
+type accessRequest struct {
+	w        http.ResponseWriter
+	r        *http.Request
+	root     cid.Cid
+	key      string
+	cfg      *config.Config
+	settings *gatewaySettings
+	rl       *requestLog
+
+	appeal       bool
+	appealErr    error
+	signed       bool
+	signatureErr error
+	clientKey    string
+
+	// subscribed is set by the checks allowing the request on behalf of a
+	// subscriber, whose requests are admitted first.
+	subscribed bool
+}
+
+// accessCheck is a check of the access decision chain, which answers the
+// request itself when it denies it.
+type accessCheck func(m *gatewayMiddleware, req *accessRequest) accessVerdict
+
+// accessChecks are the checks of ConfigPinningService.AccessDecisionOrder,
+// by name.
+var accessChecks = map[string]accessCheck{
+	config.AccessDenylist:     checkDenylist,
+	config.AccessAppealToken:  checkAppealToken,
+	config.AccessDmcaUpstream: checkDmcaUpstream,
+	config.AccessSignedURL:    checkSignedURL,
+	config.AccessSubscription: checkSubscription,
+}
+
+// decideAccess runs the access checks in the order of
+// ConfigPinningService.AccessDecisionOrder until one of them allows or denies
+// the request, and reports whether it is allowed. The order is checked to
+// list every check by ConfigPinningService.Validate.
+func (m *gatewayMiddleware) decideAccess(req *accessRequest) bool {
+	order := req.cfg.ConfigPinningService.AccessDecisionOrder
+	if len(order) == 0 {
+		order = config.DefaultAccessDecisionOrder
+	}
+	for _, name := range order {
+		switch accessChecks[name](m, req) {
+		case accessAllow:
+			return true
+		case accessDeny:
+			return false
+		}
+	}
+	return true
+}
+
+// checkDenylist denies the content of the DMCA denylists.
+func checkDenylist(m *gatewayMiddleware, req *accessRequest) accessVerdict {
+	if !m.dmca.deny.contains(req.key) {
+		return accessDefer
+	}
+	gatewayAccessRequests.WithLabelValues(accessDmcaBlocked).Inc()
+	req.rl.decision(accessDmcaBlocked, http.StatusGone)
+	dmcaBlocked(req.w, req.r, req.rl.cid, req.settings.dmcaPage)
+	return accessDeny
+}
+
+// checkAppealToken allows the requests carrying a valid appeal token.
+func checkAppealToken(_ *gatewayMiddleware, req *accessRequest) accessVerdict {
+	if !req.appeal {
+		return accessDefer
+	}
+	if req.appealErr != nil {
+		gatewayAccessRequests.WithLabelValues(accessDenied).Inc()
+		req.rl.decision(decisionBadSignature, http.StatusForbidden)
+		http.Error(req.w, req.appealErr.Error(), http.StatusForbidden)
+		return accessDeny
+	}
+	return accessAllow
+}
+
+// checkDmcaUpstream denies the content the pinning service blocks, or whose
+// check failed.
+func checkDmcaUpstream(m *gatewayMiddleware, req *accessRequest) accessVerdict {
+	start := time.Now()
+	status, err := m.dmca.checkUpstream(req.r.Context(), req.root, req.cfg)
+	req.rl.timeUpstream(start)
+	if err == nil {
+		return accessDefer
+	}
+
+	outcome := decisionCheckFailed
+	if status == http.StatusGone {
+		outcome = accessDmcaBlocked
+		gatewayAccessRequests.WithLabelValues(accessDmcaBlocked).Inc()
+	}
+	req.rl.decision(outcome, status)
+	m.cooldown.record(req.key, status, err)
+	if status == http.StatusGone {
+		dmcaBlocked(req.w, req.r, req.rl.cid, req.settings.dmcaPage)
+		return accessDeny
+	}
+	http.Error(req.w, err.Error(), status)
+	return accessDeny
+}
+
+// checkSignedURL allows the requests carrying a valid URL signature.
+func checkSignedURL(_ *gatewayMiddleware, req *accessRequest) accessVerdict {
+	if !req.signed {
+		return accessDefer
+	}
+	if req.signatureErr != nil {
+		gatewayAccessRequests.WithLabelValues(accessDenied).Inc()
+		req.rl.decision(decisionBadSignature, http.StatusForbidden)
+		http.Error(req.w, req.signatureErr.Error(), http.StatusForbidden)
+		return accessDeny
+	}
+	req.subscribed = true
+	return accessAllow
+}
+
+// checkSubscription allows the AllowedCIDs and the content the client has a
+// subscription to, as the pinning service tells, and denies the rest.
+func checkSubscription(m *gatewayMiddleware, req *accessRequest) accessVerdict {
+	if req.settings.allowlisted(req.key) {
+		return accessAllow
+	}
+	pinning := req.cfg.ConfigPinningService
+	if req.clientKey == "" && pinning.ClientKeyRequired() {
+		gatewayAccessRequests.WithLabelValues(accessDenied).Inc()
+		req.rl.decision(decisionMissingClientKey, http.StatusUnauthorized)
+		missingClientKey(req.w, pinning)
+		return accessDeny
+	}
+
+	start := time.Now()
+	status, err := m.access.check(req.r.Context(), req.key, req.clientKey, req.cfg)
+	req.rl.timeUpstream(start)
+	var redirect *accessRedirect
+	if errors.As(err, &redirect) {
+		target, err := redirectTarget(redirect.location, req.root)
+		if err != nil {
+			log.Warnf("ignoring redirect for %s from pinning service: %s", req.root, err)
+			req.rl.decision(decisionCheckFailed, http.StatusBadGateway)
+			http.Error(req.w, "Invalid redirect from dedicated gateway API", http.StatusBadGateway)
+			return accessDeny
+		}
+		req.rl.decision(decisionRedirected, http.StatusFound)
+		http.Redirect(req.w, req.r, target, http.StatusFound)
+		return accessDeny
+	}
+	if err != nil {
+		outcome := decisionCheckFailed
+		if status < http.StatusInternalServerError && status != http.StatusRequestTimeout {
+			outcome = accessDenied
+			gatewayAccessRequests.WithLabelValues(accessDenied).Inc()
+		}
+		req.rl.decision(outcome, status)
+		m.cooldown.record(req.key, status, err)
+		http.Error(req.w, err.Error(), status)
+		return accessDeny
+	}
+	req.subscribed = true
+	return accessAllow
+}
@@ -0,0 +1,132 @@
+package corehttp
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	cid "github.com/ipfs/go-cid"
+	"github.com/ipfs/kubo/config"
+)
+
+func TestAccessDecisionOrder(t *testing.T) {
+	const denylisted = "bafkreifjjcie6lypi6ny7amxnfftagclbuxndqonfipmb64f2km2devei4"
+	clock := time.Unix(1700000000, 0)
+	now = func() time.Time { return clock }
+	t.Cleanup(func() { now = time.Now })
+
+	// the pinning service blocks testBlockedCid and knows no subscription
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/api/dmca/"+testBlockedCid:
+			w.WriteHeader(http.StatusGone)
+		case strings.HasPrefix(r.URL.Path, "/api/dedicatedGateways/"):
+			w.WriteHeader(http.StatusForbidden)
+		default:
+			w.WriteHeader(http.StatusOK)
+		}
+	}))
+	t.Cleanup(ts.Close)
+
+	denylist := filepath.Join(t.TempDir(), "dmca.deny")
+	if err := os.WriteFile(denylist, []byte(denylisted+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	const secret = "signing-key"
+	exp := clock.Add(time.Minute)
+	plain := func(c string) string { return "/ipfs/" + c }
+	appeal := func(c string) string {
+		u := &url.URL{Path: plain(c)}
+		SignAppealURL(u, cid.MustParse(c), exp, secret)
+		return u.RequestURI()
+	}
+	signed := func(c string) string {
+		u := &url.URL{Path: plain(c)}
+		SignGatewayURL(u, cid.MustParse(c), exp, "", secret)
+		return u.RequestURI()
+	}
+	badAppeal := func(c string) string {
+		return strings.Replace(appeal(c), "appeal_sig=", "appeal_sig=0", 1)
+	}
+
+	type request struct {
+		name   string
+		target string
+		status int
+	}
+	for _, tc := range []struct {
+		name     string
+		order    []string
+		requests []request
+	}{
+		{
+			name: "default",
+			requests: []request{
+				{"denylisted with an appeal token", appeal(denylisted), http.StatusGone},
+				{"denylisted with a signed URL", signed(denylisted), http.StatusGone},
+				{"blocked upstream with an appeal token", appeal(testBlockedCid), http.StatusOK},
+				{"blocked upstream with a signed URL", signed(testBlockedCid), http.StatusGone},
+				{"invalid appeal token", badAppeal(testCid), http.StatusForbidden},
+				{"signed URL without a subscription", signed(testCid), http.StatusOK},
+				{"without a subscription", plain(testCid), http.StatusForbidden},
+			},
+		},
+		{
+			name: "custom",
+			order: []string{
+				config.AccessSignedURL,
+				config.AccessDenylist,
+				config.AccessDmcaUpstream,
+				config.AccessAppealToken,
+				config.AccessSubscription,
+			},
+			requests: []request{
+				{"denylisted with a signed URL", signed(denylisted), http.StatusOK},
+				{"denylisted with an appeal token", appeal(denylisted), http.StatusGone},
+				{"blocked upstream with an appeal token", appeal(testBlockedCid), http.StatusGone},
+				{"blocked upstream with a signed URL", signed(testBlockedCid), http.StatusOK},
+				{"invalid appeal token", badAppeal(testCid), http.StatusForbidden},
+				{"appeal token without a subscription", appeal(testCid), http.StatusOK},
+				{"without a subscription", plain(testCid), http.StatusForbidden},
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := &config.Config{ConfigPinningService: config.ConfigPinningService{
+				PinningService:      ts.URL,
+				DedicatedGateway:    true,
+				BlockserviceApiKey:  "api-key",
+				URLSigningKey:       secret,
+				DmcaDenylistPath:    denylist,
+				AccessDecisionOrder: tc.order,
+			}}
+			if err := cfg.ConfigPinningService.Validate(); err != nil {
+				t.Fatal(err)
+			}
+			var forwardedQuery string
+			next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				forwardedQuery = r.URL.RawQuery
+				w.WriteHeader(http.StatusOK)
+			})
+			handler := DedicatedGatewayMiddleware(next, nil, cfg)
+
+			for _, req := range tc.requests {
+				forwardedQuery = ""
+				w := httptest.NewRecorder()
+				handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, req.target, nil))
+				if w.Code != req.status {
+					t.Fatalf("%s: expected status %d, got %d: %s", req.name, req.status, w.Code, w.Body)
+				}
+				if req.status == http.StatusOK && forwardedQuery != "" {
+					t.Fatalf("%s: expected the credentials to be removed from the query, got %q", req.name, forwardedQuery)
+				}
+			}
+		})
+	}
+}
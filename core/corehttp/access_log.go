@@ -0,0 +1,203 @@
+package corehttp
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	core "github.com/ipfs/kubo/core"
+)
+
+// accessLogStdout is the ConfigPinningService.AccessLog value writing the
+// access log to stdout.
+const accessLogStdout = "stdout"
+
+// accessLogTimeFormat is the time format of the Common Log Format.
+const accessLogTimeFormat = "02/Jan/2006:15:04:05 -0700"
+
+// AccessLogOption writes a line in the Apache Combined Log Format for each
+// request to ConfigPinningService.AccessLog, a file appended to or "stdout".
+// The duration of the request, in seconds, is appended to the line. The
+// client address is taken from X-Forwarded-For behind TrustedProxies. The
+// values of the ClientKeyParam and signed URL query parameters are redacted.
+func AccessLogOption() ServeOption {
+	return func(n *core.IpfsNode, _ net.Listener, parent *http.ServeMux) (*http.ServeMux, error) {
+		cfg, err := n.Repo.Config()
+		if err != nil {
+			return nil, err
+		}
+
+		dest := cfg.ConfigPinningService.AccessLog
+		if dest == "" {
+			return parent, nil
+		}
+		var out io.Writer = os.Stdout
+		if dest != accessLogStdout {
+			f, err := os.OpenFile(dest, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o640)
+			if err != nil {
+				return nil, fmt.Errorf("opening access log: %w", err)
+			}
+			out = f
+		}
+		al := &accessLog{
+			out:            out,
+			trustedProxies: parseIPPrefixes("TrustedProxies", cfg.ConfigPinningService.TrustedProxies),
+			secretParams:   []string{signedURLToken, signedURLSig, appealSig},
+		}
+		if p := cfg.ConfigPinningService.ClientKeyParam; p != "" {
+			al.secretParams = append(al.secretParams, p)
+		}
+
+		mux := http.NewServeMux()
+		parent.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+			start := now()
+			sw := &statusWriter{ResponseWriter: w}
+			defer func() {
+				al.write(r, sw, start, now())
+			}()
+			mux.ServeHTTP(sw, r)
+		})
+		return mux, nil
+	}
+}
+
+// accessLog writes the access log lines to out.
+type accessLog struct {
+	mu             sync.Mutex
+	out            io.Writer
+	trustedProxies ipPrefixes
+	// secretParams are the query parameters whose values are redacted
+	secretParams []string
+}
+
+// write logs the response written to sw for r, served from start to end.
+func (l *accessLog) write(r *http.Request, sw *statusWriter, start, end time.Time) {
+	line := formatAccessLog(r, sw.status, sw.bytes, l.trustedProxies, l.secretParams, start, end)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, err := io.WriteString(l.out, line); err != nil {
+		log.Debugf("writing access log: %s", err)
+	}
+}
+
+// formatAccessLog returns the access log line of r, answered with status and
+// size bytes, with the values of the secretParams query parameters redacted.
+func formatAccessLog(r *http.Request, status int, size int64, trustedProxies ipPrefixes, secretParams []string, start, end time.Time) string {
+	host := remoteIP(r.RemoteAddr)
+	if ip, ok := clientIP(r, trustedProxies); ok {
+		host = ip.String()
+	}
+	user := "-"
+	if u, _, ok := r.BasicAuth(); ok && u != "" {
+		user = escapeLogField(u)
+	}
+	uri := r.RequestURI
+	if uri == "" {
+		uri = r.URL.RequestURI()
+	}
+	uri = redactQuery(uri, secretParams)
+	if status == 0 {
+		status = http.StatusOK
+	}
+	bytes := "-"
+	if size > 0 {
+		bytes = strconv.FormatInt(size, 10)
+	}
+
+	return fmt.Sprintf("%s - %s [%s] \"%s %s %s\" %d %s \"%s\" \"%s\" %.3f\n",
+		host, user, start.Format(accessLogTimeFormat),
+		escapeLogField(r.Method), escapeLogField(uri), escapeLogField(r.Proto),
+		status, bytes,
+		escapeLogField(logHeader(r, "Referer")), escapeLogField(logHeader(r, "User-Agent")),
+		end.Sub(start).Seconds())
+}
+
+// redactedValue replaces the values of the secret query parameters logged.
+const redactedValue = "REDACTED"
+
+// redactQuery returns uri with the values of the query parameters named in
+// secretParams replaced by redactedValue, the rest left as sent.
+func redactQuery(uri string, secretParams []string) string {
+	path, query, ok := strings.Cut(uri, "?")
+	if !ok || len(secretParams) == 0 {
+		return uri
+	}
+	pairs := strings.Split(query, "&")
+	for i, pair := range pairs {
+		name, _, _ := strings.Cut(pair, "=")
+		if unescaped, err := url.QueryUnescape(name); err == nil {
+			name = unescaped
+		}
+		if slices.Contains(secretParams, name) {
+			pairs[i] = url.QueryEscape(name) + "=" + redactedValue
+		}
+	}
+	return path + "?" + strings.Join(pairs, "&")
+}
+
+func logHeader(r *http.Request, name string) string {
+	if v := r.Header.Get(name); v != "" {
+		return v
+	}
+	return "-"
+}
+
+// escapeLogField escapes the quotes, backslashes and non printable bytes of
+// s the way Apache does, so a field can't break out of its quotes or line.
+func escapeLogField(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '"' || c == '\\':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case c < 0x20 || c > 0x7e:
+			fmt.Fprintf(&b, "\\x%02x", c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// statusWriter records the status and the number of bytes of a response.
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	if w.status == 0 && status >= http.StatusOK {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusWriter) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(p)
+	w.bytes += int64(n)
+	return n, err
+}
+
+func (w *statusWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *statusWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
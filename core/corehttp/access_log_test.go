@@ -0,0 +1,121 @@
+package corehttp
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ipfs/kubo/config"
+	core "github.com/ipfs/kubo/core"
+	"github.com/ipfs/kubo/repo"
+)
+
+// combinedLogLine matches a line of the Combined Log Format followed by a
+// duration.
+var combinedLogLine = regexp.MustCompile(`^(\S+) - (\S+) \[([^\]]+)\] "((?:[^"\\]|\\.)*)" (\d{3}) (\d+|-) "((?:[^"\\]|\\.)*)" "((?:[^"\\]|\\.)*)" (\d+\.\d{3})\n$`)
+
+func TestAccessLog(t *testing.T) {
+	start := time.Date(2024, time.March, 5, 14, 7, 9, 0, time.FixedZone("", -7*3600))
+	clock := []time.Time{start, start.Add(1234 * time.Millisecond)}
+	now = func() time.Time {
+		t := clock[0]
+		clock = clock[1:]
+		return t
+	}
+	t.Cleanup(func() { now = time.Now })
+
+	logPath := filepath.Join(t.TempDir(), "access.log")
+	n := &core.IpfsNode{Repo: &repo.Mock{
+		C: config.Config{ConfigPinningService: config.ConfigPinningService{
+			AccessLog:      logPath,
+			TrustedProxies: []string{"10.0.0.0/8"},
+		}},
+	}}
+	serve := func(_ *core.IpfsNode, _ net.Listener, mux *http.ServeMux) (*http.ServeMux, error) {
+		mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte("no link named \"a\""))
+		})
+		return mux, nil
+	}
+	handler, err := MakeHandler(n, nil, AccessLogOption(), serve)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/ipfs/"+testCid+"/a?format=raw", nil)
+	r.RemoteAddr = "10.0.0.1:4321"
+	r.Header.Set("X-Forwarded-For", "198.51.100.7")
+	r.Header.Set("Referer", "https://example.com/")
+	r.Header.Set("User-Agent", `curl/8.0 "quoted"`)
+	handler.ServeHTTP(httptest.NewRecorder(), r)
+
+	b, err := os.ReadFile(logPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	line := string(b)
+	want := `198.51.100.7 - - [05/Mar/2024:14:07:09 -0700] "GET /ipfs/` + testCid + `/a?format=raw HTTP/1.1" 404 17 "https://example.com/" "curl/8.0 \"quoted\"" 1.234` + "\n"
+	if line != want {
+		t.Fatalf("unexpected log line\nwant %q\ngot  %q", want, line)
+	}
+	if !combinedLogLine.MatchString(line) {
+		t.Fatalf("expected a Combined Log Format line, got %q", line)
+	}
+}
+
+func TestAccessLogRedactsSecrets(t *testing.T) {
+	logPath := filepath.Join(t.TempDir(), "access.log")
+	n := &core.IpfsNode{Repo: &repo.Mock{
+		C: config.Config{ConfigPinningService: config.ConfigPinningService{
+			AccessLog:      logPath,
+			ClientKeyParam: "api_key",
+		}},
+	}}
+	serve := func(_ *core.IpfsNode, _ net.Listener, mux *http.ServeMux) (*http.ServeMux, error) {
+		mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {})
+		return mux, nil
+	}
+	handler, err := MakeHandler(n, nil, AccessLogOption(), serve)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/ipfs/"+testCid+"?api_key=client-secret&format=raw&token=tok&exp=1700000000&sig=url-signature&appeal_%73ig=appeal-signature", nil)
+	handler.ServeHTTP(httptest.NewRecorder(), r)
+
+	b, err := os.ReadFile(logPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	line := string(b)
+	for _, secret := range []string{"client-secret", "tok&", "url-signature", "appeal-signature"} {
+		if strings.Contains(line, secret) {
+			t.Fatalf("expected %q not to be logged, got %q", secret, line)
+		}
+	}
+	want := "/ipfs/" + testCid + "?api_key=REDACTED&format=raw&token=REDACTED&exp=1700000000&sig=REDACTED&appeal_sig=REDACTED "
+	if !strings.Contains(line, want) {
+		t.Fatalf("expected the request URI to be logged as %q, got %q", want, line)
+	}
+}
+
+func TestEscapeLogField(t *testing.T) {
+	for in, want := range map[string]string{
+		"/ipfs/a b":   "/ipfs/a b",
+		`say "hi"`:    `say \"hi\"`,
+		`back\slash`:  `back\\slash`,
+		"line\nbreak": `line\x0abreak`,
+		"caf\xc3\xa9": `caf\xc3\xa9`,
+	} {
+		if got := escapeLogField(in); got != want {
+			t.Errorf("escapeLogField(%q): expected %q, got %q", in, want, got)
+		}
+	}
+}
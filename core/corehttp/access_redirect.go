@@ -0,0 +1,45 @@
+package corehttp
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/ipfs/go-cid"
+)
+
+// accessRedirect is returned by getDedicatedGatewayAccess when the pinning
+// service asks for the content to be served from another gateway.
+type accessRedirect struct {
+	location string
+}
+
+func (e *accessRedirect) Error() string {
+	return "content is served from " + e.location
+}
+
+// redirectTarget validates a redirect directive returned by the pinning
+// service. Only absolute http(s) URLs referencing the requested CID, either in
+// the path or as a subdomain, are accepted.
+func redirectTarget(location string, c cid.Cid) (string, error) {
+	if location == "" {
+		return "", fmt.Errorf("empty redirect location")
+	}
+	u, err := url.Parse(location)
+	if err != nil {
+		return "", fmt.Errorf("malformed redirect location: %w", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return "", fmt.Errorf("unsupported redirect scheme %q", u.Scheme)
+	}
+	if u.Host == "" || u.User != nil {
+		return "", fmt.Errorf("redirect location must be an absolute URL without credentials")
+	}
+
+	for _, s := range []string{c.String(), cid.NewCidV1(c.Type(), c.Hash()).String()} {
+		if strings.Contains(u.Path, "/"+s) || strings.HasPrefix(u.Host, s+".") {
+			return u.String(), nil
+		}
+	}
+	return "", fmt.Errorf("redirect location does not reference %s", c)
+}
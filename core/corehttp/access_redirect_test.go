@@ -0,0 +1,73 @@
+package corehttp
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/kubo/config"
+)
+
+func TestDedicatedGatewayRedirect(t *testing.T) {
+	c := cid.MustParse(testCid)
+	location := "https://other-gateway.example.com/ipfs/" + testCid
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/api/dedicatedGateways/") {
+			http.Redirect(w, r, location, http.StatusFound)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	cfg := &config.Config{
+		ConfigPinningService: config.ConfigPinningService{
+			PinningService:   ts.URL,
+			DedicatedGateway: true,
+		},
+	}
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("request should have been redirected")
+	})
+	handler := DedicatedGatewayMiddleware(next, nil, cfg)
+
+	r := httptest.NewRequest(http.MethodGet, "/ipfs/"+c.String(), nil)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+
+	if w.Code != http.StatusFound {
+		t.Fatalf("expected status %d, got %d", http.StatusFound, w.Code)
+	}
+	if got := w.Header().Get("Location"); got != location {
+		t.Fatalf("expected redirect to %q, got %q", location, got)
+	}
+}
+
+func TestRedirectTarget(t *testing.T) {
+	c := cid.MustParse("QmUNLLsPACCz1vLxQVkXqqLX5R1X345qqfHbsf67hvA3Nn")
+	v1 := cid.NewCidV1(c.Type(), c.Hash()).String()
+
+	for _, tc := range []struct {
+		location string
+		valid    bool
+	}{
+		{"https://gw.example.com/ipfs/" + c.String(), true},
+		{"https://gw.example.com/ipfs/" + v1 + "/index.html", true},
+		{"https://" + v1 + ".ipfs.gw.example.com/", true},
+		{"https://gw.example.com/ipfs/bafkqaaa", false},
+		{"javascript:alert(1)//" + c.String(), false},
+		{"/ipfs/" + c.String(), false},
+		{"", false},
+	} {
+		_, err := redirectTarget(tc.location, c)
+		if tc.valid && err != nil {
+			t.Errorf("%q: unexpected error: %s", tc.location, err)
+		}
+		if !tc.valid && err == nil {
+			t.Errorf("%q: expected redirect to be rejected", tc.location)
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package corehttp
+
+import (
+	"container/list"
+	"context"
+	"sync"
+)
+
+const (
+	priorityAnonymous = iota
+	prioritySubscribed
+)
+
+// admissionQueue bounds the number of requests served concurrently. When all
+// slots are taken, waiting subscribed requests are admitted ahead of
+// anonymous ones. Requests of the same priority are admitted in FIFO order.
+type admissionQueue struct {
+	mu        sync.Mutex
+	available int
+	waiting   [prioritySubscribed + 1]*list.List
+}
+
+func newAdmissionQueue(limit int) *admissionQueue {
+	q := &admissionQueue{available: limit}
+	for i := range q.waiting {
+		q.waiting[i] = list.New()
+	}
+	return q
+}
+
+// acquire blocks until a slot is available or ctx is done. Callers must call
+// release once done if and only if acquire returned nil.
+func (q *admissionQueue) acquire(ctx context.Context, priority int) error {
+	q.mu.Lock()
+	if q.available > 0 && q.queued() == 0 {
+		q.available--
+		q.mu.Unlock()
+		return nil
+	}
+	ready := make(chan struct{})
+	elem := q.waiting[priority].PushBack(ready)
+	q.mu.Unlock()
+
+	select {
+	case <-ready:
+		return nil
+	case <-ctx.Done():
+		q.mu.Lock()
+		select {
+		case <-ready:
+			// admitted while giving up, hand the slot to the next waiter
+			q.mu.Unlock()
+			q.release()
+		default:
+			q.waiting[priority].Remove(elem)
+			q.mu.Unlock()
+		}
+		return ctx.Err()
+	}
+}
+
+// tryAcquire takes a slot only if one is free and nobody is waiting for it.
+// It returns whether a slot was taken.
+func (q *admissionQueue) tryAcquire() bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.available > 0 && q.queued() == 0 {
+		q.available--
+		return true
+	}
+	return false
+}
+
+func (q *admissionQueue) release() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for p := len(q.waiting) - 1; p >= 0; p-- {
+		if front := q.waiting[p].Front(); front != nil {
+			q.waiting[p].Remove(front)
+			close(front.Value.(chan struct{}))
+			return
+		}
+	}
+	q.available++
+}
+
+type admissionQueueKey struct{}
+
+func withAdmissionQueue(ctx context.Context, q *admissionQueue) context.Context {
+	return context.WithValue(ctx, admissionQueueKey{}, q)
+}
+
+// admissionQueueFrom returns the admission queue the request went through, if
+// any.
+func admissionQueueFrom(ctx context.Context) *admissionQueue {
+	q, _ := ctx.Value(admissionQueueKey{}).(*admissionQueue)
+	return q
+}
+
+// queued returns the number of waiting requests. q.mu must be held.
+func (q *admissionQueue) queued() int {
+	n := 0
+	for _, l := range q.waiting {
+		n += l.Len()
+	}
+	return n
+}
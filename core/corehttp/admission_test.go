@@ -0,0 +1,88 @@
+package corehttp
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func waitQueued(t *testing.T, q *admissionQueue, n int) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		q.mu.Lock()
+		queued := q.queued()
+		q.mu.Unlock()
+		if queued == n {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("expected %d queued requests", n)
+}
+
+func TestAdmissionQueuePriority(t *testing.T) {
+	ctx := context.Background()
+	q := newAdmissionQueue(1)
+	if err := q.acquire(ctx, priorityAnonymous); err != nil {
+		t.Fatal(err)
+	}
+
+	admitted := make(chan int, 2)
+	enqueue := func(priority int) {
+		go func() {
+			if err := q.acquire(ctx, priority); err != nil {
+				t.Error(err)
+				return
+			}
+			admitted <- priority
+			q.release()
+		}()
+	}
+
+	// the anonymous request queues up first but must be admitted last
+	enqueue(priorityAnonymous)
+	waitQueued(t, q, 1)
+	enqueue(prioritySubscribed)
+	waitQueued(t, q, 2)
+
+	q.release()
+	if p := <-admitted; p != prioritySubscribed {
+		t.Fatal("expected subscribed request to be admitted first")
+	}
+	if p := <-admitted; p != priorityAnonymous {
+		t.Fatal("expected anonymous request to be admitted second")
+	}
+}
+
+func TestAdmissionQueueCancel(t *testing.T) {
+	q := newAdmissionQueue(1)
+	if err := q.acquire(context.Background(), priorityAnonymous); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := q.acquire(ctx, prioritySubscribed); err == nil {
+		t.Fatal("expected acquire to fail once the context is done")
+	}
+
+	q.release()
+	if err := q.acquire(context.Background(), priorityAnonymous); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAdmissionQueueTryAcquire(t *testing.T) {
+	q := newAdmissionQueue(1)
+	if !q.tryAcquire() {
+		t.Fatal("expected a free slot")
+	}
+	if q.tryAcquire() {
+		t.Fatal("expected no free slot")
+	}
+	q.release()
+	if !q.tryAcquire() {
+		t.Fatal("expected the released slot to be free")
+	}
+}
@@ -0,0 +1,97 @@
+package corehttp
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/kubo/config"
+)
+
+func TestAllowedCIDsSkipAccessCheck(t *testing.T) {
+	const listed = "QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n"
+	var accessCalls atomic.Int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/api/dedicatedGateways/") {
+			accessCalls.Add(1)
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(ts.Close)
+
+	// the CIDv1 of the listed content is allowlisted as well
+	v1 := cid.NewCidV1(cid.DagProtobuf, cid.MustParse(listed).Hash()).String()
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	handler := DedicatedGatewayMiddleware(next, nil, &config.Config{
+		ConfigPinningService: config.ConfigPinningService{
+			PinningService:   ts.URL,
+			DedicatedGateway: true,
+			AllowedCIDs:      []string{listed},
+		},
+	})
+
+	for _, c := range []string{listed, v1} {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/ipfs/"+c, nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected 200 for %s, got %d", c, w.Code)
+		}
+	}
+	if n := accessCalls.Load(); n != 0 {
+		t.Fatalf("expected no access check for allowlisted CIDs, got %d", n)
+	}
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/ipfs/"+testCid, nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", w.Code)
+	}
+	if n := accessCalls.Load(); n != 1 {
+		t.Fatalf("expected the access check for a CID not listed, got %d calls", n)
+	}
+}
+
+func TestAllowedCIDsSkipRateLimits(t *testing.T) {
+	const other = "QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n"
+	ts := newTestPinningService(t)
+	t.Cleanup(func() {
+		mtx.Lock()
+		clear(ipLimiters)
+		clear(cidLimiters)
+		mtx.Unlock()
+	})
+
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	handler := DedicatedGatewayMiddleware(next, nil, &config.Config{
+		ConfigPinningService: config.ConfigPinningService{
+			PinningService: ts.URL,
+			IPRateLimit:    1,
+			CIDRateLimit:   1,
+			AllowedCIDs:    []string{testCid},
+		},
+	})
+	get := func(c string) int {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/ipfs/"+c, nil))
+		return w.Code
+	}
+
+	for i := 0; i < 3; i++ {
+		if code := get(testCid); code != http.StatusOK {
+			t.Fatalf("expected allowlisted requests not to be rate limited, got %d", code)
+		}
+	}
+	if code := get(other); code != http.StatusOK {
+		t.Fatalf("expected the first request for another CID to be allowed, got %d", code)
+	}
+	if code := get(other); code != http.StatusTooManyRequests {
+		t.Fatalf("expected the second request for another CID to be rate limited, got %d", code)
+	}
+}
@@ -0,0 +1,50 @@
+package corehttp
+
+import (
+	"context"
+	"net"
+	"net/http"
+
+	core "github.com/ipfs/kubo/core"
+)
+
+// APILimitsOption bounds the API requests: bodies larger than
+// API.MaxRequestBodySize are rejected with 413, and requests taking longer
+// than API.RequestTimeout, reading their body included, are aborted. Bodies
+// whose size isn't announced are cut at the limit, the command reading them
+// failing.
+func APILimitsOption() ServeOption {
+	return func(n *core.IpfsNode, _ net.Listener, parent *http.ServeMux) (*http.ServeMux, error) {
+		cfg, err := n.Repo.Config()
+		if err != nil {
+			return nil, err
+		}
+		maxBody := cfg.API.MaxRequestBodySize
+		timeout := cfg.API.RequestTimeout.WithDefault(0)
+		if maxBody <= 0 && timeout <= 0 {
+			return parent, nil
+		}
+
+		mux := http.NewServeMux()
+		parent.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+			if maxBody > 0 {
+				if r.ContentLength > maxBody {
+					http.Error(w, "request body too large", http.StatusRequestEntityTooLarge)
+					return
+				}
+				r.Body = http.MaxBytesReader(w, r.Body, maxBody)
+			}
+			if timeout > 0 {
+				deadline := now().Add(timeout)
+				if err := http.NewResponseController(w).SetReadDeadline(deadline); err != nil {
+					log.Debugf("cannot set read deadline for %s: %s", r.URL.Path, err)
+				}
+				ctx, cancel := context.WithDeadline(r.Context(), deadline)
+				defer cancel()
+				r = r.WithContext(ctx)
+			}
+			mux.ServeHTTP(w, r)
+		})
+		return mux, nil
+	}
+}
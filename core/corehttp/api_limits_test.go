@@ -0,0 +1,79 @@
+package corehttp
+
+import (
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ipfs/kubo/config"
+	core "github.com/ipfs/kubo/core"
+	"github.com/ipfs/kubo/repo"
+)
+
+func TestAPILimits(t *testing.T) {
+	var readErr error
+	var deadline time.Time
+	serve := func(_ *core.IpfsNode, _ net.Listener, mux *http.ServeMux) (*http.ServeMux, error) {
+		mux.HandleFunc("/api/v0/add", func(w http.ResponseWriter, r *http.Request) {
+			deadline, _ = r.Context().Deadline()
+			if _, readErr = io.ReadAll(r.Body); readErr != nil {
+				http.Error(w, readErr.Error(), http.StatusBadRequest)
+				return
+			}
+			w.WriteHeader(http.StatusOK)
+		})
+		return mux, nil
+	}
+	n := &core.IpfsNode{Repo: &repo.Mock{C: config.Config{API: config.API{
+		MaxRequestBodySize: 10,
+		RequestTimeout:     config.NewOptionalDuration(time.Minute),
+	}}}}
+	handler, err := MakeHandler(n, nil, APILimitsOption(), serve)
+	if err != nil {
+		t.Fatal(err)
+	}
+	post := func(body io.Reader) int {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/api/v0/add", body))
+		return w.Code
+	}
+
+	if code := post(strings.NewReader("0123456789")); code != http.StatusOK {
+		t.Fatalf("expected a body at the limit to be accepted, got %d", code)
+	}
+	if time.Until(deadline) <= 0 || time.Until(deadline) > time.Minute {
+		t.Fatalf("expected the request to be given a deadline within a minute, got %s", deadline)
+	}
+
+	readErr = nil
+	if code := post(strings.NewReader("0123456789a")); code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("expected 413 for an announced body over the limit, got %d", code)
+	}
+	if readErr != nil {
+		t.Fatal("expected the command not to be called")
+	}
+
+	// without Content-Length the body is cut at the limit
+	code := post(io.MultiReader(strings.NewReader("0123456789"), strings.NewReader("a")))
+	var tooLarge *http.MaxBytesError
+	if code == http.StatusOK || !errors.As(readErr, &tooLarge) {
+		t.Fatalf("expected reading past the limit to fail, got %d, %v", code, readErr)
+	}
+}
+
+func TestAPILimitsDisabled(t *testing.T) {
+	n := &core.IpfsNode{Repo: &repo.Mock{C: config.Config{}}}
+	parent := http.NewServeMux()
+	mux, err := APILimitsOption()(n, nil, parent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mux != parent {
+		t.Fatal("expected no middleware without limits")
+	}
+}
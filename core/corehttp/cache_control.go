@@ -0,0 +1,61 @@
+package corehttp
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	core "github.com/ipfs/kubo/core"
+)
+
+const (
+	// defaultImmutableMaxAge is the max-age of the /ipfs/ responses unless
+	// ConfigPinningService.ImmutableMaxAge is set.
+	defaultImmutableMaxAge = 365 * 24 * time.Hour
+	// defaultIPNSMaxAge is the max-age of the /ipns/ responses unless
+	// ConfigPinningService.IPNSMaxAge is set.
+	defaultIPNSMaxAge = time.Minute
+)
+
+// CacheControlOption sets the Cache-Control header of the successful gateway
+// responses the gateway left without one, by path: /ipfs/ content is
+// addressed by its hash and cached for ConfigPinningService.ImmutableMaxAge
+// as immutable, /ipns/ content can change and is cached for IPNSMaxAge. The
+// header set by the gateway, such as the TTL of an IPNS record, is kept, and
+// other responses are left as is.
+func CacheControlOption() ServeOption {
+	return func(n *core.IpfsNode, _ net.Listener, parent *http.ServeMux) (*http.ServeMux, error) {
+		cfg, err := n.Repo.Config()
+		if err != nil {
+			return nil, err
+		}
+		immutable := fmt.Sprintf("public, max-age=%d, immutable",
+			int64(cfg.ConfigPinningService.ImmutableMaxAge.WithDefault(defaultImmutableMaxAge).Seconds()))
+		mutable := fmt.Sprintf("public, max-age=%d",
+			int64(cfg.ConfigPinningService.IPNSMaxAge.WithDefault(defaultIPNSMaxAge).Seconds()))
+
+		mux := http.NewServeMux()
+		parent.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+			switch {
+			case strings.HasPrefix(r.URL.Path, "/ipfs/"):
+				w = &headerWriter{ResponseWriter: w, setHeader: cacheControl(immutable)}
+			case strings.HasPrefix(r.URL.Path, "/ipns/"):
+				w = &headerWriter{ResponseWriter: w, setHeader: cacheControl(mutable)}
+			}
+			mux.ServeHTTP(w, r)
+		})
+		return mux, nil
+	}
+}
+
+// cacheControl sets the Cache-Control header of the successful responses
+// that have none to value.
+func cacheControl(value string) func(http.Header, int) {
+	return func(h http.Header, status int) {
+		if status < http.StatusMultipleChoices && h.Get("Cache-Control") == "" {
+			h.Set("Cache-Control", value)
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package corehttp
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/ipfs/kubo/config"
+	core "github.com/ipfs/kubo/core"
+	"github.com/ipfs/kubo/repo"
+)
+
+func TestCacheControl(t *testing.T) {
+	serve := func(_ *core.IpfsNode, _ net.Listener, mux *http.ServeMux) (*http.ServeMux, error) {
+		mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Query().Get("set") != "" {
+				w.Header().Set("Cache-Control", "public, max-age=29030400, immutable")
+			}
+			switch r.URL.Query().Get("status") {
+			case "404":
+				http.Error(w, "not found", http.StatusNotFound)
+			case "304":
+				w.WriteHeader(http.StatusNotModified)
+			default:
+				w.Write([]byte("hello"))
+			}
+		})
+		return mux, nil
+	}
+	handler := func(pinning config.ConfigPinningService) http.Handler {
+		n := &core.IpfsNode{Repo: &repo.Mock{C: config.Config{ConfigPinningService: pinning}}}
+		h, err := MakeHandler(n, nil, CacheControlOption(), serve)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return h
+	}
+	defaults := handler(config.ConfigPinningService{})
+	configured := handler(config.ConfigPinningService{
+		ImmutableMaxAge: config.NewOptionalDuration(24 * time.Hour),
+		IPNSMaxAge:      config.NewOptionalDuration(5 * time.Minute),
+	})
+
+	for _, tc := range []struct {
+		name    string
+		handler http.Handler
+		target  string
+		want    string
+	}{
+		{"ipfs", defaults, "/ipfs/" + testCid + "/a", "public, max-age=31536000, immutable"},
+		{"ipns", defaults, "/ipns/example.com/a", "public, max-age=60"},
+		{"set by the gateway", defaults, "/ipns/example.com/a?set=1", "public, max-age=29030400, immutable"},
+		{"not modified", defaults, "/ipfs/" + testCid + "?status=304", ""},
+		{"error", defaults, "/ipns/example.com?status=404", ""},
+		{"other path", defaults, "/api/v0/version", ""},
+		{"configured ipfs", configured, "/ipfs/" + testCid, "public, max-age=86400, immutable"},
+		{"configured ipns", configured, "/ipns/example.com", "public, max-age=300"},
+		{"configured set by the gateway", configured, "/ipfs/" + testCid + "?set=1", "public, max-age=29030400, immutable"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			tc.handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tc.target, nil))
+			if got := w.Header().Get("Cache-Control"); got != tc.want {
+				t.Fatalf("expected Cache-Control %q, got %q", tc.want, got)
+			}
+		})
+	}
+}
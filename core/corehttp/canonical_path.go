@@ -0,0 +1,46 @@
+package corehttp
+
+import (
+	"net/url"
+	"strings"
+
+	config "github.com/ipfs/kubo/config"
+)
+
+// canonicalGatewayPath returns the canonical form of the percent-encoded
+// gateway path p: duplicate slashes are collapsed, each segment is
+// percent-encoded the default way, keeping the slashes encoded within a
+// segment, and the trailing slash is added or removed as trailingSlash says.
+// Dot segments are left to the gateway. ok is false when p has a ".."
+// segment, which the gateway must not be asked to resolve.
+func canonicalGatewayPath(p, trailingSlash string) (canonical string, ok bool) {
+	segments := strings.Split(p, "/")
+	trailing := len(segments) > 1 && segments[len(segments)-1] == ""
+
+	var b strings.Builder
+	for _, segment := range segments {
+		if segment == "" {
+			continue
+		}
+		decoded, err := url.PathUnescape(segment)
+		if err != nil {
+			decoded = segment
+		}
+		if decoded == ".." {
+			return "", false
+		}
+		b.WriteByte('/')
+		b.WriteString(strings.ReplaceAll((&url.URL{Path: decoded}).EscapedPath(), "/", "%2F"))
+	}
+
+	switch trailingSlash {
+	case config.TrailingSlashAdd:
+		trailing = true
+	case config.TrailingSlashRemove:
+		trailing = false
+	}
+	if trailing || b.Len() == 0 {
+		b.WriteByte('/')
+	}
+	return b.String(), true
+}
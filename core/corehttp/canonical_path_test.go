@@ -0,0 +1,266 @@
+package corehttp
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sync"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/kubo/config"
+)
+
+func TestCanonicalGatewayPath(t *testing.T) {
+	for _, tc := range []struct {
+		path, trailingSlash, canonical string
+	}{
+		{"/ipfs/" + testCid, "", "/ipfs/" + testCid},
+		{"/ipfs/" + testCid + "/", "", "/ipfs/" + testCid + "/"},
+		{"/ipfs/" + testCid + "/dir", "", "/ipfs/" + testCid + "/dir"},
+		{"/ipfs//" + testCid + "//dir///", "", "/ipfs/" + testCid + "/dir/"},
+		{"/ipfs/" + testCid + "/./style.css", "", "/ipfs/" + testCid + "/./style.css"},
+		{"/ipfs/" + testCid + "/%64ir", "", "/ipfs/" + testCid + "/dir"},
+		{"/ipfs/" + testCid + "/a%2Fb", "", "/ipfs/" + testCid + "/a%2Fb"},
+		{"/ipfs/" + testCid + "/a%20b", "", "/ipfs/" + testCid + "/a%20b"},
+		{"/ipns/example.com", "", "/ipns/example.com"},
+		{"/ipfs/" + testCid + "/dir", config.TrailingSlashAdd, "/ipfs/" + testCid + "/dir/"},
+		{"/ipfs/" + testCid + "/dir/", config.TrailingSlashAdd, "/ipfs/" + testCid + "/dir/"},
+		{"/ipfs/" + testCid + "/dir/", config.TrailingSlashRemove, "/ipfs/" + testCid + "/dir"},
+		{"/ipfs/" + testCid + "//", config.TrailingSlashRemove, "/ipfs/" + testCid},
+	} {
+		got, ok := canonicalGatewayPath(tc.path, tc.trailingSlash)
+		if !ok || got != tc.canonical {
+			t.Errorf("%s (%q): expected %s, got %s, %v", tc.path, tc.trailingSlash, tc.canonical, got, ok)
+		}
+	}
+
+	for _, p := range []string{
+		"/ipfs/" + testCid + "/..",
+		"/ipfs/" + testCid + "/dir/../../other",
+		"/ipfs/" + testCid + "/%2E%2E",
+	} {
+		if _, ok := canonicalGatewayPath(p, ""); ok {
+			t.Errorf("%s: expected the path to be rejected", p)
+		}
+	}
+}
+
+func TestCanonicalGatewayPathRedirect(t *testing.T) {
+	var (
+		mu      sync.Mutex
+		checked []string
+	)
+	ps := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		checked = append(checked, r.URL.Path)
+		mu.Unlock()
+		w.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(ps.Close)
+
+	cfg := &config.Config{
+		ConfigPinningService: config.ConfigPinningService{
+			PinningService:        ps.URL,
+			DedicatedGateway:      true,
+			CanonicalGatewayPaths: true,
+		},
+	}
+	var served string
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		served = r.URL.Path
+		w.WriteHeader(http.StatusOK)
+	})
+	handler := DedicatedGatewayMiddleware(next, nil, cfg)
+
+	r := httptest.NewRequest(http.MethodGet, "/ipfs//"+testCid+"/dir?format=raw", nil)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	if w.Code != http.StatusMovedPermanently {
+		t.Fatalf("expected status %d, got %d", http.StatusMovedPermanently, w.Code)
+	}
+	canonical := "/ipfs/" + testCid + "/dir"
+	if loc := w.Header().Get("Location"); loc != canonical+"?format=raw" {
+		t.Fatalf("expected redirect to %s?format=raw, got %s", canonical, loc)
+	}
+	if len(checked) != 0 {
+		t.Fatalf("expected no access check before the redirect, got %v", checked)
+	}
+
+	r = httptest.NewRequest(http.MethodGet, canonical, nil)
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, w.Code)
+	}
+	if served != canonical {
+		t.Fatalf("expected %s to be served, got %s", canonical, served)
+	}
+
+	c := cid.MustParse(testCid)
+	want := []string{"/api/dmca/" + c.String(), "/api/dedicatedGateways/" + c.Hash().HexString()}
+	if len(checked) != len(want) {
+		t.Fatalf("expected access checks %v, got %v", want, checked)
+	}
+	for i := range want {
+		if checked[i] != want[i] {
+			t.Fatalf("expected access checks %v, got %v", want, checked)
+		}
+	}
+}
+
+func TestCanonicalGatewayPathEncodingAndDotDot(t *testing.T) {
+	ps := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(ps.Close)
+
+	cfg := &config.Config{
+		ConfigPinningService: config.ConfigPinningService{
+			PinningService:        ps.URL,
+			CanonicalGatewayPaths: true,
+		},
+	}
+	var served string
+	handler := DedicatedGatewayMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		served = r.URL.EscapedPath()
+		w.WriteHeader(http.StatusOK)
+	}), nil, cfg)
+
+	r := httptest.NewRequest(http.MethodGet, "/ipfs/"+testCid+"/%64ir", nil)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	if w.Code != http.StatusMovedPermanently {
+		t.Fatalf("expected status %d, got %d", http.StatusMovedPermanently, w.Code)
+	}
+	if loc := w.Header().Get("Location"); loc != "/ipfs/"+testCid+"/dir" {
+		t.Fatalf("expected redirect to the default percent-encoding, got %s", loc)
+	}
+
+	// a slash encoded within a segment names another resource
+	encoded := "/ipfs/" + testCid + "/a%2Fb"
+	r = httptest.NewRequest(http.MethodGet, encoded, nil)
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	if w.Code != http.StatusOK || served != encoded {
+		t.Fatalf("expected %s to be served as is, got %d for %q", encoded, w.Code, served)
+	}
+	r = httptest.NewRequest(http.MethodGet, "/ipfs//"+testCid+"/a%2Fb", nil)
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	if loc := w.Header().Get("Location"); w.Code != http.StatusMovedPermanently || loc != encoded {
+		t.Fatalf("expected redirect to %s, got %d to %s", encoded, w.Code, loc)
+	}
+
+	served = ""
+	r = httptest.NewRequest(http.MethodGet, "/ipfs/"+testCid+"/dir/..", nil)
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("expected status %d, got %d", http.StatusBadRequest, w.Code)
+	}
+	if served != "" {
+		t.Fatal("expected the path with a .. segment not to be served")
+	}
+}
+
+func TestCanonicalGatewayPathTrailingSlash(t *testing.T) {
+	var (
+		mu      sync.Mutex
+		checked []string
+	)
+	ps := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		checked = append(checked, r.URL.Path)
+		mu.Unlock()
+		w.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(ps.Close)
+
+	c := cid.MustParse(testCid)
+	dir := "/ipfs/" + testCid + "/dir"
+	for _, tc := range []struct {
+		trailingSlash string
+		other         string
+		canonical     string
+	}{
+		{config.TrailingSlashAdd, dir, dir + "/"},
+		{config.TrailingSlashRemove, dir + "/", dir},
+	} {
+		t.Run(tc.trailingSlash, func(t *testing.T) {
+			mu.Lock()
+			checked = nil
+			mu.Unlock()
+			cfg := &config.Config{
+				ConfigPinningService: config.ConfigPinningService{
+					PinningService:        ps.URL,
+					DedicatedGateway:      true,
+					CanonicalGatewayPaths: true,
+					TrailingSlash:         tc.trailingSlash,
+				},
+			}
+			var served string
+			handler := DedicatedGatewayMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				served = r.URL.Path
+				w.WriteHeader(http.StatusOK)
+			}), nil, cfg)
+
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tc.other, nil))
+			if w.Code != http.StatusMovedPermanently {
+				t.Fatalf("expected status %d, got %d", http.StatusMovedPermanently, w.Code)
+			}
+			if loc := w.Header().Get("Location"); loc != tc.canonical {
+				t.Fatalf("expected redirect to %s, got %s", tc.canonical, loc)
+			}
+			if len(checked) != 0 {
+				t.Fatalf("expected no access check before the redirect, got %v", checked)
+			}
+
+			w = httptest.NewRecorder()
+			handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tc.canonical, nil))
+			if w.Code != http.StatusOK || served != tc.canonical {
+				t.Fatalf("expected %s to be served, got %d for %s", tc.canonical, w.Code, served)
+			}
+			want := []string{"/api/dmca/" + c.String(), "/api/dedicatedGateways/" + c.Hash().HexString()}
+			mu.Lock()
+			defer mu.Unlock()
+			if !slices.Equal(checked, want) {
+				t.Fatalf("expected access checks %v, got %v", want, checked)
+			}
+		})
+	}
+}
+
+func TestCanonicalGatewayPathETag(t *testing.T) {
+	ps := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(ps.Close)
+
+	cfg := &config.Config{
+		ConfigPinningService: config.ConfigPinningService{
+			PinningService:        ps.URL,
+			DedicatedGateway:      true,
+			CanonicalGatewayPaths: true,
+		},
+	}
+	parent := http.NewServeMux()
+	mux, err := ETagOption()(nil, nil, parent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		t.Fatal("expected the request to be answered before the gateway")
+	})
+	handler := DedicatedGatewayMiddleware(parent, nil, cfg)
+
+	etag := `"` + cid.MustParse(testCid).String() + `"`
+	r := httptest.NewRequest(http.MethodGet, "/ipfs/"+testCid, nil)
+	r.Header.Set("If-None-Match", etag)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	if w.Code != http.StatusNotModified {
+		t.Fatalf("expected status %d, got %d", http.StatusNotModified, w.Code)
+	}
+}
@@ -0,0 +1,64 @@
+package corehttp
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"sync"
+)
+
+// cidBusyRetryAfter is the Retry-After, in seconds, of the requests rejected
+// because too many requests for their content are in flight.
+const cidBusyRetryAfter = 1
+
+// cidInflight counts the gateway requests being served per normalized CID
+// key. Keys are removed as soon as their last request completes, so only the
+// content being served is tracked.
+type cidInflight struct {
+	mu     sync.Mutex
+	counts map[string]int
+}
+
+func newCidInflight() *cidInflight {
+	return &cidInflight{counts: make(map[string]int)}
+}
+
+// acquire counts a request for key unless limit requests for it are already
+// in flight, reporting whether it did. Callers must call release once the
+// request is served if and only if acquire returned true.
+func (c *cidInflight) acquire(key string, limit int) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.counts[key] >= limit {
+		return false
+	}
+	c.counts[key]++
+	return true
+}
+
+// release ends a request for key counted by acquire.
+func (c *cidInflight) release(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.counts[key] <= 1 {
+		delete(c.counts, key)
+		return
+	}
+	c.counts[key]--
+}
+
+// cidBusy answers with 503, telling the client to retry shortly.
+func cidBusy(w http.ResponseWriter) {
+	w.Header().Set("Retry-After", strconv.Itoa(cidBusyRetryAfter))
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(http.StatusServiceUnavailable)
+	body := rateLimitError{
+		Code:       "cid_concurrency_limited",
+		Message:    "Too many concurrent requests for this CID",
+		RetryAfter: cidBusyRetryAfter,
+	}
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		log.Debugf("writing concurrency limit response: %s", err)
+	}
+}
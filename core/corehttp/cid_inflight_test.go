@@ -0,0 +1,89 @@
+package corehttp
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/ipfs/kubo/config"
+)
+
+func TestMaxConcurrentPerCID(t *testing.T) {
+	ts := newTestPinningService(t)
+	t.Cleanup(func() {
+		mtx.Lock()
+		clear(ipLimiters)
+		clear(cidLimiters)
+		mtx.Unlock()
+	})
+
+	started := make(chan struct{})
+	unblock := make(chan struct{})
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/ipfs/"+testCid {
+			started <- struct{}{}
+			<-unblock
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+	handler := DedicatedGatewayMiddleware(next, nil, &config.Config{
+		ConfigPinningService: config.ConfigPinningService{
+			PinningService:      ts.URL,
+			IPRateLimit:         100,
+			CIDRateLimit:        100,
+			MaxConcurrentPerCID: 2,
+		},
+	})
+	runningMiddlewares.Lock()
+	m := runningMiddlewares.list[len(runningMiddlewares.list)-1]
+	runningMiddlewares.Unlock()
+	get := func(c string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/ipfs/"+c, nil)
+		r.RemoteAddr = "192.0.2.1:1234"
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+
+	var wg sync.WaitGroup
+	codes := make([]int, 2)
+	for i := range codes {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			codes[i] = get(testCid).Code
+		}()
+		<-started
+	}
+
+	w := get(testCid)
+	if w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected 503 past the limit, got %d", w.Code)
+	}
+	if got := w.Header().Get("Retry-After"); got != "1" {
+		t.Fatalf("expected Retry-After 1, got %q", got)
+	}
+	if code := get(testBlockedCid).Code; code != http.StatusOK {
+		t.Fatalf("expected other CIDs to be served, got %d", code)
+	}
+
+	close(unblock)
+	wg.Wait()
+	for i, code := range codes {
+		if code != http.StatusOK {
+			t.Fatalf("request %d: expected 200, got %d", i, code)
+		}
+	}
+	m.inflight.mu.Lock()
+	n := len(m.inflight.counts)
+	m.inflight.mu.Unlock()
+	if n != 0 {
+		t.Fatalf("expected completed requests to be released, %d CIDs tracked", n)
+	}
+
+	go func() { <-started }()
+	if code := get(testCid).Code; code != http.StatusOK {
+		t.Fatalf("expected 200 once requests completed, got %d", code)
+	}
+}
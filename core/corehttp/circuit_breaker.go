@@ -0,0 +1,124 @@
+package corehttp
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	config "github.com/ipfs/kubo/config"
+)
+
+// defaultCircuitBreakerCooldown is how long the circuit breaker stays open
+// unless ConfigPinningService.CircuitBreakerCooldown is set.
+const defaultCircuitBreakerCooldown = 30 * time.Second
+
+// States of the circuit breaker, as reported by the
+// ipfs_pinning_service_circuit_state metric.
+const (
+	circuitClosed   = 0
+	circuitOpen     = 1
+	circuitHalfOpen = 2
+)
+
+// errCircuitOpen is returned instead of calling the pinning service while
+// the circuit breaker is open.
+var errCircuitOpen = errors.New("pinning service circuit breaker open")
+
+// circuitBreaker stops calling the pinning service after
+// ConfigPinningService.CircuitBreakerThreshold consecutive failures. Calls
+// fail right away for CircuitBreakerCooldown, then a single call is let
+// through to probe the service: the breaker closes if it succeeds and opens
+// again otherwise.
+type circuitBreaker struct {
+	mu       sync.Mutex
+	state    int
+	failures int
+	openedAt time.Time
+	probing  bool
+}
+
+// pinningServiceBreaker guards the calls of callPinningService.
+var pinningServiceBreaker = &circuitBreaker{}
+
+// breakerCall is a call allowed by circuitBreaker.allow.
+type breakerCall struct {
+	// probe is set for the probe of the half-open circuit
+	probe   bool
+	started time.Time
+}
+
+// allow reports whether a call can be made. Calls allowed must be followed
+// by done or abandon, passed the returned call.
+func (b *circuitBreaker) allow(cfg config.ConfigPinningService) (bool, breakerCall) {
+	call := breakerCall{started: now()}
+	if cfg.CircuitBreakerThreshold <= 0 {
+		return true, call
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case circuitOpen:
+		if call.started.Sub(b.openedAt) < cfg.CircuitBreakerCooldown.WithDefault(defaultCircuitBreakerCooldown) {
+			return false, call
+		}
+		b.setState(circuitHalfOpen)
+		fallthrough
+	case circuitHalfOpen:
+		if b.probing {
+			return false, call
+		}
+		b.probing = true
+		call.probe = true
+	}
+	return true, call
+}
+
+// done records the outcome of call. Once the circuit opened, only the
+// outcome of the probe changes it: the calls started before it opened are
+// ignored.
+func (b *circuitBreaker) done(cfg config.ConfigPinningService, call breakerCall, failed bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if call.probe {
+		b.probing = false
+	} else if b.state != circuitClosed || call.started.Before(b.openedAt) {
+		return
+	}
+	if cfg.CircuitBreakerThreshold <= 0 {
+		return
+	}
+	if !failed {
+		b.failures = 0
+		if b.state != circuitClosed {
+			log.Infof("pinning service recovered, closing the circuit breaker")
+			b.setState(circuitClosed)
+		}
+		return
+	}
+	b.failures++
+	if b.state == circuitHalfOpen || b.failures >= cfg.CircuitBreakerThreshold {
+		if b.state != circuitOpen {
+			log.Warnf("pinning service failed %d times in a row, opening the circuit breaker", b.failures)
+		}
+		b.openedAt = now()
+		b.setState(circuitOpen)
+	}
+}
+
+// abandon ends call, canceled before telling whether the pinning service is
+// healthy, letting another probe through if it was the probe.
+func (b *circuitBreaker) abandon(call breakerCall) {
+	if !call.probe {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.probing = false
+}
+
+func (b *circuitBreaker) setState(state int) {
+	b.state = state
+	pinningServiceCircuitState.Set(float64(state))
+}
@@ -0,0 +1,117 @@
+package corehttp
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	cid "github.com/ipfs/go-cid"
+	"github.com/ipfs/kubo/config"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	ctx := context.Background()
+	clock := time.Now()
+	now = func() time.Time { return clock }
+	t.Cleanup(func() { now = time.Now })
+	pinningServiceBreaker = &circuitBreaker{}
+	t.Cleanup(func() { pinningServiceBreaker = &circuitBreaker{} })
+
+	var status, calls atomic.Int32
+	status.Store(http.StatusInternalServerError)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.WriteHeader(int(status.Load()))
+	}))
+	t.Cleanup(ts.Close)
+	cfg := &config.Config{ConfigPinningService: config.ConfigPinningService{
+		PinningService:          ts.URL,
+		BlockserviceApiKey:      "secret",
+		CircuitBreakerThreshold: 2,
+		CircuitBreakerCooldown:  config.NewOptionalDuration(time.Minute),
+		DmcaFailMode:            config.DmcaFailOpen,
+	}}
+	expectState := func(want int) {
+		t.Helper()
+		if pinningServiceBreaker.state != want {
+			t.Fatalf("expected circuit state %d, got %d", want, pinningServiceBreaker.state)
+		}
+		if got := testutil.ToFloat64(pinningServiceCircuitState); got != float64(want) {
+			t.Fatalf("expected the circuit state metric to be %d, got %v", want, got)
+		}
+	}
+
+	// calls still running when the circuit opens
+	pinning := cfg.ConfigPinningService
+	_, lateSuccess := pinningServiceBreaker.allow(pinning)
+	_, lateFailure := pinningServiceBreaker.allow(pinning)
+	_, lateProbeRival := pinningServiceBreaker.allow(pinning)
+	clock = clock.Add(time.Second)
+
+	// closed: failures are counted until the threshold
+	for i := 0; i < 2; i++ {
+		if status, _ := getDedicatedGatewayAccess(ctx, testCid, "", cfg); status != http.StatusInternalServerError {
+			t.Fatalf("call %d: expected the failure to be passed on, got %d", i, status)
+		}
+	}
+	expectState(circuitOpen)
+
+	// open: the outcome of the calls started before it opened is ignored
+	openedAt := pinningServiceBreaker.openedAt
+	clock = clock.Add(time.Second)
+	pinningServiceBreaker.done(pinning, lateSuccess, false)
+	expectState(circuitOpen)
+	pinningServiceBreaker.done(pinning, lateFailure, true)
+	expectState(circuitOpen)
+	if !pinningServiceBreaker.openedAt.Equal(openedAt) {
+		t.Fatalf("expected a late failure not to push back the cooldown, opened at %s then %s", openedAt, pinningServiceBreaker.openedAt)
+	}
+
+	// open: calls fail without reaching the pinning service, DMCA checks
+	// following the fail-open policy
+	before := calls.Load()
+	if _, err := getDedicatedGatewayAccess(ctx, testCid, "", cfg); err == nil {
+		t.Fatal("expected the access check to fail while the circuit is open")
+	}
+	if status, err := newDmcaCache(cfg.ConfigPinningService).check(ctx, cid.MustParse(testCid), cfg); status != http.StatusOK || err != nil {
+		t.Fatalf("expected the DMCA check to fail open, got %d, %v", status, err)
+	}
+	if n := calls.Load() - before; n != 0 {
+		t.Fatalf("expected no call while the circuit is open, got %d", n)
+	}
+
+	// half-open: a single probe is let through, its failure reopens
+	clock = openedAt.Add(time.Minute)
+	ok, probe := pinningServiceBreaker.allow(pinning)
+	if !ok || !probe.probe {
+		t.Fatal("expected a probe to be allowed after the cooldown")
+	}
+	expectState(circuitHalfOpen)
+	if ok, _ := pinningServiceBreaker.allow(pinning); ok {
+		t.Fatal("expected a single probe at a time")
+	}
+	// a call started before the circuit opened doesn't end the probe
+	pinningServiceBreaker.done(pinning, lateProbeRival, false)
+	expectState(circuitHalfOpen)
+	pinningServiceBreaker.abandon(lateProbeRival)
+	if ok, _ := pinningServiceBreaker.allow(pinning); ok {
+		t.Fatal("expected the probe to still be running")
+	}
+	pinningServiceBreaker.done(pinning, probe, true)
+	expectState(circuitOpen)
+
+	// a successful probe closes the circuit
+	clock = clock.Add(time.Minute)
+	status.Store(http.StatusOK)
+	if status, err := getDedicatedGatewayAccess(ctx, testCid, "", cfg); status != http.StatusOK || err != nil {
+		t.Fatalf("expected the probe to succeed, got %d, %v", status, err)
+	}
+	expectState(circuitClosed)
+	if status, err := getDedicatedGatewayAccess(ctx, testCid, "", cfg); status != http.StatusOK || err != nil {
+		t.Fatalf("expected calls to go through once closed, got %d, %v", status, err)
+	}
+}
@@ -0,0 +1,26 @@
+package corehttp
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"golang.org/x/sync/singleflight"
+)
+
+// coalesce calls check once for the concurrent callers passing the same key
+// to group, so a burst of requests for the same content makes a single
+// pinning service call and shares its result. The shared call is not
+// canceled with the context of the request that started it: each caller
+// stops waiting for it when its own context is done.
+func coalesce(ctx context.Context, group *singleflight.Group, key string, check func(context.Context) (int, error)) (int, error) {
+	ch := group.DoChan(key, func() (interface{}, error) {
+		return check(context.WithoutCancel(ctx))
+	})
+	select {
+	case res := <-ch:
+		return res.Val.(int), res.Err
+	case <-ctx.Done():
+		return http.StatusRequestTimeout, fmt.Errorf("check aborted: %w", ctx.Err())
+	}
+}
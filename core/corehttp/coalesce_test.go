@@ -0,0 +1,116 @@
+package corehttp
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/kubo/config"
+)
+
+// newBlockingPinningService answers 200 once release is closed, returning
+// the number of requests made and a channel receiving a value for each of
+// them.
+func newBlockingPinningService(t *testing.T, release <-chan struct{}) (*httptest.Server, *atomic.Int32, <-chan struct{}) {
+	t.Helper()
+	var calls atomic.Int32
+	entered := make(chan struct{}, 100)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		entered <- struct{}{}
+		<-release
+		w.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(ts.Close)
+	return ts, &calls, entered
+}
+
+func TestCoalesceChecks(t *testing.T) {
+	root := cid.MustParse(testCid)
+	key := normalizeCIDKey(root)
+
+	for _, tc := range []struct {
+		name  string
+		check func(*config.Config) func(context.Context) (int, error)
+	}{
+		{"dmca", func(cfg *config.Config) func(context.Context) (int, error) {
+			c := newDmcaCache(cfg.ConfigPinningService)
+			return func(ctx context.Context) (int, error) { return c.check(ctx, root, cfg) }
+		}},
+		{"access", func(cfg *config.Config) func(context.Context) (int, error) {
+			// without caching, only coalescing saves calls
+			c := newAccessCache(0, 0, 0)
+			return func(ctx context.Context) (int, error) { return c.check(ctx, key, "client", cfg) }
+		}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			release := make(chan struct{})
+			ts, calls, entered := newBlockingPinningService(t, release)
+			check := tc.check(&config.Config{ConfigPinningService: config.ConfigPinningService{PinningService: ts.URL}})
+
+			var wg sync.WaitGroup
+			var failed atomic.Int32
+			for i := 0; i < 50; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					if status, err := check(context.Background()); status != http.StatusOK || err != nil {
+						failed.Add(1)
+					}
+				}()
+			}
+			<-entered
+			// let the other requests join the call in flight
+			time.Sleep(50 * time.Millisecond)
+			close(release)
+			wg.Wait()
+
+			if n := calls.Load(); n != 1 {
+				t.Fatalf("expected a single upstream call, got %d", n)
+			}
+			if n := failed.Load(); n != 0 {
+				t.Fatalf("expected every request to share the result, %d did not", n)
+			}
+		})
+	}
+}
+
+func TestCoalesceCanceled(t *testing.T) {
+	release := make(chan struct{})
+	ts, calls, entered := newBlockingPinningService(t, release)
+	cfg := &config.Config{ConfigPinningService: config.ConfigPinningService{PinningService: ts.URL}}
+	c := newDmcaCache(cfg.ConfigPinningService)
+	root := cid.MustParse(testCid)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	first := make(chan int)
+	go func() {
+		status, _ := c.check(ctx, root, cfg)
+		first <- status
+	}()
+	<-entered
+	cancel()
+	if status := <-first; status != http.StatusRequestTimeout {
+		t.Fatalf("expected the canceled request to stop waiting, got %d", status)
+	}
+
+	// the call in flight outlives the request that started it
+	second := make(chan int)
+	go func() {
+		status, _ := c.check(context.Background(), root, cfg)
+		second <- status
+	}()
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	if status := <-second; status != http.StatusOK {
+		t.Fatalf("expected the call in flight to answer, got %d", status)
+	}
+	if n := calls.Load(); n != 1 {
+		t.Fatalf("expected a single upstream call, got %d", n)
+	}
+}
@@ -0,0 +1,247 @@
+package corehttp
+
+import (
+	"compress/gzip"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	core "github.com/ipfs/kubo/core"
+)
+
+// defaultCompressionMinSize is the size under which responses are not
+// compressed unless ConfigPinningService.CompressionMinSize is set.
+const defaultCompressionMinSize = 1024
+
+// compressibleTypes lists the media types compressed on top of text/* and
+// the +json and +xml structured syntaxes. Anything else, images, video and
+// archives in particular, is usually compressed already.
+var compressibleTypes = map[string]bool{
+	"application/javascript":        true,
+	"application/json":              true,
+	"application/vnd.ipld.dag-json": true,
+	"application/wasm":              true,
+	"application/x-ndjson":          true,
+	"application/xml":               true,
+}
+
+var gzipWriters = sync.Pool{
+	New: func() any { return gzip.NewWriter(io.Discard) },
+}
+
+// CompressionOption gzips the responses of compressible content types to
+// the GET requests accepting it. It is enabled by
+// ConfigPinningService.Compression.
+//
+// Responses are buffered up to the minimum size before deciding whether to
+// compress them, unless they are flushed or their Content-Length is set.
+// Range requests and responses with a status other than 200 are passed
+// through as is.
+func CompressionOption() ServeOption {
+	return func(n *core.IpfsNode, _ net.Listener, parent *http.ServeMux) (*http.ServeMux, error) {
+		cfg, err := n.Repo.Config()
+		if err != nil {
+			return nil, err
+		}
+		if !cfg.ConfigPinningService.Compression {
+			return parent, nil
+		}
+		minSize := cfg.ConfigPinningService.CompressionMinSize
+		if minSize <= 0 {
+			minSize = defaultCompressionMinSize
+		}
+
+		mux := http.NewServeMux()
+		parent.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodGet || r.Header.Get("Range") != "" || !acceptsGzip(r.Header.Get("Accept-Encoding")) {
+				mux.ServeHTTP(w, r)
+				return
+			}
+			cw := &compressWriter{ResponseWriter: w, minSize: minSize}
+			defer cw.close()
+			mux.ServeHTTP(cw, r)
+		})
+		return mux, nil
+	}
+}
+
+// acceptsGzip reports whether the Accept-Encoding header accepts gzip.
+func acceptsGzip(header string) bool {
+	gzipQ, anyQ := -1.0, -1.0
+	for _, part := range strings.Split(header, ",") {
+		coding, params, _ := strings.Cut(part, ";")
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			var err error
+			if q, err = strconv.ParseFloat(v, 64); err != nil {
+				continue
+			}
+		}
+		switch strings.ToLower(strings.TrimSpace(coding)) {
+		case "gzip", "x-gzip":
+			gzipQ = q
+		case "*":
+			anyQ = q
+		}
+	}
+	if gzipQ < 0 {
+		gzipQ = anyQ
+	}
+	return gzipQ > 0
+}
+
+// isCompressible reports whether responses of the content type ct are worth
+// compressing.
+func isCompressible(ct string) bool {
+	mediaType, _, err := mime.ParseMediaType(ct)
+	if err != nil {
+		return false
+	}
+	return strings.HasPrefix(mediaType, "text/") ||
+		strings.HasSuffix(mediaType, "+json") ||
+		strings.HasSuffix(mediaType, "+xml") ||
+		compressibleTypes[mediaType]
+}
+
+// compressWriter holds back the status and first bytes of a response until
+// it knows whether to compress it.
+type compressWriter struct {
+	http.ResponseWriter
+	minSize int
+
+	status  int
+	buf     []byte
+	decided bool
+	gz      *gzip.Writer
+}
+
+func (w *compressWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func (w *compressWriter) WriteHeader(status int) {
+	if status < http.StatusOK {
+		w.ResponseWriter.WriteHeader(status)
+		return
+	}
+	if w.status != 0 {
+		return
+	}
+	w.status = status
+	if status != http.StatusOK {
+		w.start(false)
+	}
+}
+
+func (w *compressWriter) Write(p []byte) (int, error) {
+	if w.decided {
+		if w.gz != nil {
+			return w.gz.Write(p)
+		}
+		return w.ResponseWriter.Write(p)
+	}
+
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	w.buf = append(w.buf, p...)
+	var err error
+	if size, ok := w.contentLength(); ok {
+		err = w.start(size >= int64(w.minSize))
+	} else if len(w.buf) >= w.minSize {
+		err = w.start(true)
+	}
+	if err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Flush decides whether to compress a streamed response without waiting for
+// the minimum size.
+func (w *compressWriter) Flush() {
+	if !w.decided {
+		if w.status == 0 {
+			w.status = http.StatusOK
+		}
+		size, ok := w.contentLength()
+		if err := w.start(!ok || size >= int64(w.minSize)); err != nil {
+			return
+		}
+	}
+	if w.gz != nil {
+		if err := w.gz.Flush(); err != nil {
+			return
+		}
+	}
+	if err := http.NewResponseController(w.ResponseWriter).Flush(); err != nil {
+		log.Debugf("cannot flush compressed response: %s", err)
+	}
+}
+
+func (w *compressWriter) contentLength() (int64, bool) {
+	size, err := strconv.ParseInt(w.Header().Get("Content-Length"), 10, 64)
+	return size, err == nil
+}
+
+// start writes the status and the buffered bytes of the response, compressed
+// when compress is set and the response can be.
+func (w *compressWriter) start(compress bool) error {
+	w.decided = true
+	h := w.Header()
+
+	compressible := h.Get("Content-Encoding") == "" && h.Get("Content-Range") == ""
+	if compressible {
+		if _, ok := h["Content-Type"]; !ok && len(w.buf) > 0 {
+			h.Set("Content-Type", http.DetectContentType(w.buf))
+		}
+		compressible = isCompressible(h.Get("Content-Type"))
+	}
+	if compressible {
+		h.Add("Vary", "Accept-Encoding")
+	}
+	if compressible && compress {
+		h.Del("Content-Length")
+		h.Del("Accept-Ranges")
+		h.Set("Content-Encoding", "gzip")
+		if etag := h.Get("Etag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+			h.Set("Etag", "W/"+etag)
+		}
+		w.gz = gzipWriters.Get().(*gzip.Writer)
+		w.gz.Reset(w.ResponseWriter)
+	}
+	w.ResponseWriter.WriteHeader(w.status)
+
+	buf := w.buf
+	w.buf = nil
+	if len(buf) == 0 {
+		return nil
+	}
+	var err error
+	if w.gz != nil {
+		_, err = w.gz.Write(buf)
+	} else {
+		_, err = w.ResponseWriter.Write(buf)
+	}
+	return err
+}
+
+// close writes what is left of the response once it was served.
+func (w *compressWriter) close() {
+	if !w.decided && w.status != 0 {
+		if err := w.start(false); err != nil {
+			log.Debugf("writing response: %s", err)
+		}
+	}
+	if w.gz != nil {
+		if err := w.gz.Close(); err != nil {
+			log.Debugf("writing compressed response: %s", err)
+		}
+		gzipWriters.Put(w.gz)
+		w.gz = nil
+	}
+}
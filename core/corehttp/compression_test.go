@@ -0,0 +1,164 @@
+package corehttp
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ipfs/kubo/config"
+	core "github.com/ipfs/kubo/core"
+	"github.com/ipfs/kubo/repo"
+)
+
+// compressionHandler serves the requests with h behind CompressionOption.
+func compressionHandler(t *testing.T, h http.HandlerFunc) http.Handler {
+	t.Helper()
+	n := &core.IpfsNode{Repo: &repo.Mock{
+		C: config.Config{ConfigPinningService: config.ConfigPinningService{Compression: true}},
+	}}
+	serve := func(_ *core.IpfsNode, _ net.Listener, mux *http.ServeMux) (*http.ServeMux, error) {
+		mux.Handle("/", h)
+		return mux, nil
+	}
+	handler, err := MakeHandler(n, nil, CompressionOption(), serve)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return handler
+}
+
+func TestCompression(t *testing.T) {
+	html := "<!DOCTYPE html><html><body>" + strings.Repeat("<p>hello</p>", 200) + "</body></html>"
+	jpeg := append([]byte("\xff\xd8\xff\xe0\x00\x10JFIF\x00"), bytes.Repeat([]byte{0x42}, 4096)...)
+
+	for _, tc := range []struct {
+		name        string
+		contentType string
+		body        []byte
+		header      http.Header
+		compressed  bool
+	}{
+		{"large html", "text/html; charset=utf-8", []byte(html), nil, true},
+		{"sniffed html", "", []byte(html), nil, true},
+		{"jpeg", "image/jpeg", jpeg, nil, false},
+		{"small html", "text/html", []byte("<p>hello</p>"), nil, false},
+		{"gzip refused", "text/html", []byte(html), http.Header{"Accept-Encoding": {"gzip;q=0, *"}}, false},
+		{"no accept encoding", "text/html", []byte(html), http.Header{"Accept-Encoding": nil}, false},
+		{"range", "text/html", []byte(html), http.Header{"Range": {"bytes=0-99"}}, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			handler := compressionHandler(t, func(w http.ResponseWriter, r *http.Request) {
+				if tc.contentType != "" {
+					w.Header().Set("Content-Type", tc.contentType)
+				}
+				w.Header().Set("Etag", `"etag"`)
+				http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(tc.body))
+			})
+
+			r := httptest.NewRequest(http.MethodGet, "/ipfs/"+testCid, nil)
+			r.Header.Set("Accept-Encoding", "gzip, deflate, br")
+			for k, v := range tc.header {
+				r.Header[k] = v
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+
+			body := w.Body.Bytes()
+			if !tc.compressed {
+				if enc := w.Header().Get("Content-Encoding"); enc != "" {
+					t.Fatalf("expected an uncompressed response, got Content-Encoding %q", enc)
+				}
+				if tc.header.Get("Range") != "" {
+					if w.Code != http.StatusPartialContent || len(body) != 100 {
+						t.Fatalf("expected the range to be served, got %d with %d bytes", w.Code, len(body))
+					}
+					return
+				}
+				if !bytes.Equal(body, tc.body) {
+					t.Fatal("expected the body to be served as is")
+				}
+				return
+			}
+
+			if enc := w.Header().Get("Content-Encoding"); enc != "gzip" {
+				t.Fatalf("expected a gzipped response, got Content-Encoding %q", enc)
+			}
+			if w.Header().Get("Content-Length") != "" || w.Header().Get("Accept-Ranges") != "" {
+				t.Fatalf("expected the identity Content-Length and Accept-Ranges to be removed, got %v", w.Header())
+			}
+			if vary := w.Header().Get("Vary"); vary != "Accept-Encoding" {
+				t.Fatalf("expected Vary: Accept-Encoding, got %q", vary)
+			}
+			if etag := w.Header().Get("Etag"); etag != `W/"etag"` {
+				t.Fatalf("expected a weak Etag, got %q", etag)
+			}
+			if len(body) >= len(tc.body) {
+				t.Fatalf("expected the body to shrink, got %d bytes for %d", len(body), len(tc.body))
+			}
+			gz, err := gzip.NewReader(bytes.NewReader(body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			decoded, err := io.ReadAll(gz)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(decoded, tc.body) {
+				t.Fatal("expected the decompressed body to match")
+			}
+		})
+	}
+}
+
+func TestCompressionStreaming(t *testing.T) {
+	release := make(chan struct{})
+	ts := httptest.NewServer(compressionHandler(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		io.WriteString(w, "{\"first\":true}\n")
+		http.NewResponseController(w).Flush()
+		<-release
+		io.WriteString(w, "{\"last\":true}\n")
+	}))
+	defer ts.Close()
+	defer close(release)
+
+	req, err := http.NewRequest(http.MethodGet, ts.URL+"/ipfs/"+testCid, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Accept-Encoding", "gzip")
+	resp, err := (&http.Client{Transport: &http.Transport{DisableCompression: true}}).Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if enc := resp.Header.Get("Content-Encoding"); enc != "gzip" {
+		t.Fatalf("expected a gzipped stream, got Content-Encoding %q", enc)
+	}
+
+	first := make(chan string, 1)
+	go func() {
+		gz, err := gzip.NewReader(resp.Body)
+		if err != nil {
+			first <- err.Error()
+			return
+		}
+		buf := make([]byte, 64)
+		n, _ := gz.Read(buf)
+		first <- string(buf[:n])
+	}()
+	select {
+	case line := <-first:
+		if line != "{\"first\":true}\n" {
+			t.Fatalf("expected the flushed line, got %q", line)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the flushed line before the end of the response")
+	}
+}
@@ -0,0 +1,122 @@
+package corehttp
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"sync"
+)
+
+// connLimiter bounds the connections of each client IP. A connection is
+// admitted on its first request, counting against the IP of that request's
+// client, resolved as clientIP does through the trusted proxies. Connections
+// admitted keep being served until they close, while those past the per-IP
+// limit get 429 and are closed. Behind a proxy, a connection counts for the
+// client of its first request.
+type connLimiter struct {
+	maxConns int
+	proxies  func() ipPrefixes
+
+	mu     sync.Mutex
+	active map[string]int
+	conns  map[net.Conn]*limitedConn
+}
+
+// limitedConn is the admission of a connection, decided on its first
+// request.
+type limitedConn struct {
+	decided  bool
+	admitted bool
+	ip       string
+}
+
+type limitedConnKey struct{}
+
+// newConnLimiter returns a limiter of maxConns connections per client IP,
+// proxies returning the current ConfigPinningService.TrustedProxies.
+func newConnLimiter(maxConns int, proxies func() ipPrefixes) *connLimiter {
+	return &connLimiter{
+		maxConns: maxConns,
+		proxies:  proxies,
+		active:   make(map[string]int),
+		conns:    make(map[net.Conn]*limitedConn),
+	}
+}
+
+// connContext is meant to be used as http.Server.ConnContext, for wrap to
+// tell which connection requests arrive on.
+func (l *connLimiter) connContext(ctx context.Context, c net.Conn) context.Context {
+	return context.WithValue(ctx, limitedConnKey{}, c)
+}
+
+// connState is meant to be used as http.Server.ConnState.
+func (l *connLimiter) connState(c net.Conn, state http.ConnState) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	switch state {
+	case http.StateNew:
+		l.conns[c] = &limitedConn{}
+	case http.StateHijacked, http.StateClosed:
+		lc, ok := l.conns[c]
+		if !ok {
+			return
+		}
+		delete(l.conns, c)
+		if !lc.admitted {
+			return
+		}
+		if l.active[lc.ip] <= 1 {
+			delete(l.active, lc.ip)
+		} else {
+			l.active[lc.ip]--
+		}
+	}
+}
+
+// admitted reports whether the connection r arrived on is within the limit,
+// deciding it on the connection's first request.
+func (l *connLimiter) admitted(r *http.Request) bool {
+	c, ok := r.Context().Value(limitedConnKey{}).(net.Conn)
+	if !ok {
+		return true
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	lc, ok := l.conns[c]
+	if !ok {
+		return true
+	}
+	if !lc.decided {
+		lc.decided = true
+		ip := remoteIP(r.RemoteAddr)
+		if addr, ok := clientIP(r, l.proxies()); ok {
+			ip = addr.String()
+		}
+		if l.active[ip] < l.maxConns {
+			lc.admitted, lc.ip = true, ip
+			l.active[ip]++
+		}
+	}
+	return lc.admitted
+}
+
+func (l *connLimiter) wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !l.admitted(r) {
+			w.Header().Set("Connection", "close")
+			http.Error(w, "Too many connections from this IP", http.StatusTooManyRequests)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// remoteIP strips the port from a remote address.
+func remoteIP(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	return host
+}
@@ -0,0 +1,108 @@
+package corehttp
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func startConnLimited(t *testing.T, maxConns int, proxies ipPrefixes) *httptest.Server {
+	t.Helper()
+	limiter := newConnLimiter(maxConns, func() ipPrefixes { return proxies })
+	ts := httptest.NewUnstartedServer(limiter.wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})))
+	ts.Config.ConnContext = limiter.connContext
+	ts.Config.ConnState = limiter.connState
+	ts.Start()
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+func TestConnLimiter(t *testing.T) {
+	const maxConns = 2
+	ts := startConnLimited(t, maxConns, nil)
+
+	// every client has its own transport, and thus its own connection, kept
+	// alive between requests
+	get := func(c *http.Client) int {
+		t.Helper()
+		res, err := c.Get(ts.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.Copy(io.Discard, res.Body)
+		res.Body.Close()
+		return res.StatusCode
+	}
+	var clients []*http.Client
+	for i := 0; i < maxConns; i++ {
+		c := &http.Client{Transport: &http.Transport{MaxConnsPerHost: 1}}
+		t.Cleanup(c.CloseIdleConnections)
+		clients = append(clients, c)
+		if status := get(c); status != http.StatusOK {
+			t.Fatalf("client %d: expected status %d, got %d", i, http.StatusOK, status)
+		}
+	}
+
+	// a connection past the limit doesn't affect those admitted
+	extra, err := net.Dial("tcp", ts.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer extra.Close()
+	for i, c := range clients {
+		if status := get(c); status != http.StatusOK {
+			t.Fatalf("client %d: expected the admitted connection to keep working, got %d", i, status)
+		}
+	}
+
+	fmt.Fprintf(extra, "GET / HTTP/1.1\r\nHost: %s\r\n\r\n", ts.Listener.Addr())
+	res, err := http.ReadResponse(bufio.NewReader(extra), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusTooManyRequests {
+		t.Fatalf("expected status %d, got %d", http.StatusTooManyRequests, res.StatusCode)
+	}
+	if !res.Close {
+		t.Fatal("expected the rejected connection to be closed")
+	}
+}
+
+func TestConnLimiterTrustedProxies(t *testing.T) {
+	ts := startConnLimited(t, 1, parseIPPrefixes("TrustedProxies", []string{"127.0.0.1"}))
+
+	get := func(forwardedFor string) int {
+		t.Helper()
+		c := &http.Client{Transport: &http.Transport{}}
+		t.Cleanup(c.CloseIdleConnections)
+		req, err := http.NewRequest(http.MethodGet, ts.URL, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("X-Forwarded-For", forwardedFor)
+		res, err := c.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		return res.StatusCode
+	}
+
+	// the proxy's connections count for the clients it forwards
+	if status := get("203.0.113.1"); status != http.StatusOK {
+		t.Fatalf("expected the first client to be admitted, got %d", status)
+	}
+	if status := get("203.0.113.2"); status != http.StatusOK {
+		t.Fatalf("expected another client behind the proxy to be admitted, got %d", status)
+	}
+	if status := get("203.0.113.1"); status != http.StatusTooManyRequests {
+		t.Fatalf("expected a second connection of the first client to be rejected, got %d", status)
+	}
+}
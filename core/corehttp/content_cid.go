@@ -0,0 +1,61 @@
+package corehttp
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/ipfs/boxo/namesys"
+	"github.com/ipfs/boxo/path"
+	"github.com/ipfs/go-cid"
+)
+
+// ipnsResolveTimeout bounds the time spent resolving an IPNS name before its
+// target can be access checked.
+const ipnsResolveTimeout = 10 * time.Second
+
+var ipfsPathPattern = regexp.MustCompile(`/ipfs/([^/]+)`)
+
+var errIPNSUnresolved = errors.New("Could not resolve IPNS name")
+
+// contentCid returns the root CID of the content requested by the gateway
+// path p. IPNS names are resolved through ns. On failure, the HTTP status to
+// answer with is returned along with the error.
+func contentCid(ctx context.Context, ns namesys.NameSystem, p string) (cid.Cid, int, error) {
+	if strings.HasPrefix(p, "/ipfs/") {
+		matches := ipfsPathPattern.FindStringSubmatch(p)
+		if matches == nil || len(matches) < 2 {
+			return cid.Undef, http.StatusBadRequest, errors.New("Invalid path")
+		}
+		c, err := cid.Parse(matches[1])
+		if err != nil {
+			return cid.Undef, http.StatusBadRequest, errors.New("Invalid hash")
+		}
+		return c, http.StatusOK, nil
+	}
+
+	name, _, _ := strings.Cut(strings.TrimPrefix(p, "/ipns/"), "/")
+	if name == "" {
+		return cid.Undef, http.StatusBadRequest, errors.New("Invalid path")
+	}
+	if ns == nil {
+		return cid.Undef, http.StatusBadGateway, errIPNSUnresolved
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, ipnsResolveTimeout)
+	defer cancel()
+
+	resolved, err := ns.Resolve(ctx, "/ipns/"+name)
+	if err != nil {
+		log.Debugf("resolving /ipns/%s for access checks: %s", name, err)
+		return cid.Undef, http.StatusBadGateway, errIPNSUnresolved
+	}
+	ip, err := path.NewImmutablePath(resolved)
+	if err != nil {
+		return cid.Undef, http.StatusBadGateway, errIPNSUnresolved
+	}
+	return ip.RootCid(), http.StatusOK, nil
+}
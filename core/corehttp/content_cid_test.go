@@ -0,0 +1,83 @@
+package corehttp
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/ipfs/boxo/path"
+	"github.com/ipfs/kubo/config"
+	"github.com/ipfs/kubo/core"
+)
+
+func TestIPNSAccessChecks(t *testing.T) {
+	const v1 = "bafybeihdwdcefgh4dqkjv67uzcmw7ojee6xedzdetojuzjevtenxquvyku"
+	const blocked = v1
+
+	var (
+		mu      sync.Mutex
+		checked []string
+	)
+	ps := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		checked = append(checked, r.URL.Path)
+		mu.Unlock()
+		if strings.HasSuffix(r.URL.Path, "/"+blocked) {
+			w.WriteHeader(http.StatusGone)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(ps.Close)
+
+	target, err := path.NewPath("/ipfs/" + v1 + "/site")
+	if err != nil {
+		t.Fatal(err)
+	}
+	node := &core.IpfsNode{
+		Namesys: mockNamesys{"/ipns/example.com": target},
+	}
+	cfg := &config.Config{
+		ConfigPinningService: config.ConfigPinningService{
+			PinningService:   ps.URL,
+			DedicatedGateway: true,
+		},
+	}
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	handler := DedicatedGatewayMiddleware(next, node, cfg)
+
+	for _, tc := range []struct {
+		name   string
+		path   string
+		status int
+		checks int
+	}{
+		{"resolvable name is checked against its target", "/ipns/example.com/index.html", http.StatusGone, 1},
+		{"unresolvable name", "/ipns/unknown.example.com/", http.StatusBadGateway, 0},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			mu.Lock()
+			checked = nil
+			mu.Unlock()
+
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tc.path, nil))
+			if w.Code != tc.status {
+				t.Fatalf("expected status %d, got %d", tc.status, w.Code)
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			if len(checked) != tc.checks {
+				t.Fatalf("expected %d upstream checks, got %v", tc.checks, checked)
+			}
+			if tc.checks > 0 && checked[0] != "/api/dmca/"+blocked {
+				t.Fatalf("expected the resolved CID to be checked, got %s", checked[0])
+			}
+		})
+	}
+}
@@ -0,0 +1,85 @@
+package corehttp
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+
+	core "github.com/ipfs/kubo/core"
+)
+
+// ContentHeadersOption sets the X-Ipfs-Path and Content-Disposition headers
+// of the successful gateway responses the gateway handler left without
+// them. X-Ipfs-Path is the content path requested. The filename suggested by
+// Content-Disposition is the filename query parameter, or the name of the
+// file in its parent directory for paths below a root CID or IPNS name. It is
+// an attachment with download=true, inline otherwise.
+func ContentHeadersOption() ServeOption {
+	return func(_ *core.IpfsNode, _ net.Listener, parent *http.ServeMux) (*http.ServeMux, error) {
+		mux := http.NewServeMux()
+		parent.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+			if isGatewayPath(r.URL.Path) {
+				w = &headerWriter{
+					ResponseWriter: w,
+					setHeader:      contentHeaders(r.URL.EscapedPath(), contentDisposition(r)),
+				}
+			}
+			mux.ServeHTTP(w, r)
+		})
+		return mux, nil
+	}
+}
+
+// contentDisposition returns the Content-Disposition header value of the
+// gateway request r, or "" if no filename is known.
+func contentDisposition(r *http.Request) string {
+	query := r.URL.Query()
+	name := query.Get("filename")
+	if name == "" {
+		// /ipfs/<cid>/<name>: the name is only known below the root
+		segments := strings.Split(strings.TrimPrefix(r.URL.Path, "/"), "/")
+		if len(segments) > 2 {
+			name = segments[len(segments)-1]
+		}
+	}
+	if name == "" {
+		return ""
+	}
+	disposition := "inline"
+	if query.Get("download") == "true" {
+		disposition = "attachment"
+	}
+	return dispositionHeader(disposition, name)
+}
+
+// dispositionHeader formats a Content-Disposition header suggesting name as
+// in RFC 6266: name percent-encoded in filename*, along with an ASCII
+// fallback for older clients. Characters that could end the quoted string or
+// the header are replaced in the fallback.
+func dispositionHeader(disposition, name string) string {
+	ascii := strings.Map(func(r rune) rune {
+		if r < 0x20 || r >= 0x7f || r == '"' || r == '\\' || r == '/' {
+			return '_'
+		}
+		return r
+	}, name)
+	return fmt.Sprintf(`%s; filename="%s"; filename*=UTF-8''%s`, disposition, ascii, url.PathEscape(name))
+}
+
+// contentHeaders sets the X-Ipfs-Path and Content-Disposition headers of the
+// successful and not modified responses, unless already set.
+func contentHeaders(ipfsPath, disposition string) func(http.Header, int) {
+	return func(h http.Header, status int) {
+		if status >= http.StatusMultipleChoices && status != http.StatusNotModified {
+			return
+		}
+		if h.Get("X-Ipfs-Path") == "" {
+			h.Set("X-Ipfs-Path", ipfsPath)
+		}
+		if disposition != "" && h.Get("Content-Disposition") == "" {
+			h.Set("Content-Disposition", disposition)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package corehttp
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	core "github.com/ipfs/kubo/core"
+)
+
+func TestContentHeaders(t *testing.T) {
+	serve := func(_ *core.IpfsNode, _ net.Listener, mux *http.ServeMux) (*http.ServeMux, error) {
+		mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Query().Has("own") {
+				w.Header().Set("X-Ipfs-Path", "/ipfs/own")
+				w.Header().Set("Content-Disposition", "attachment")
+			}
+			if r.URL.Query().Get("status") == "404" {
+				http.Error(w, "not found", http.StatusNotFound)
+				return
+			}
+			w.Write([]byte("hello"))
+		})
+		return mux, nil
+	}
+	handler, err := MakeHandler(&core.IpfsNode{}, nil, ContentHeadersOption(), serve)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name        string
+		target      string
+		path        string
+		disposition string
+	}{
+		{"root", "/ipfs/" + testCid, "/ipfs/" + testCid, ""},
+		{"directory listing", "/ipfs/" + testCid + "/dir/", "/ipfs/" + testCid + "/dir/", ""},
+		{"unixfs name", "/ipfs/" + testCid + "/dir/photo.jpg", "/ipfs/" + testCid + "/dir/photo.jpg",
+			`inline; filename="photo.jpg"; filename*=UTF-8''photo.jpg`},
+		{"ipns", "/ipns/example.com/a.txt", "/ipns/example.com/a.txt",
+			`inline; filename="a.txt"; filename*=UTF-8''a.txt`},
+		{"filename", "/ipfs/" + testCid + "?filename=report.pdf", "/ipfs/" + testCid,
+			`inline; filename="report.pdf"; filename*=UTF-8''report.pdf`},
+		{"download", "/ipfs/" + testCid + "/a.txt?filename=report.pdf&download=true", "/ipfs/" + testCid + "/a.txt",
+			`attachment; filename="report.pdf"; filename*=UTF-8''report.pdf`},
+		{"non ascii", "/ipfs/" + testCid + "?filename=r%C3%A9sum%C3%A9.txt", "/ipfs/" + testCid,
+			`inline; filename="r_sum_.txt"; filename*=UTF-8''r%C3%A9sum%C3%A9.txt`},
+		{"header injection", "/ipfs/" + testCid + "?filename=a%22%3B%0D%0ASet-Cookie:%20x=1", "/ipfs/" + testCid,
+			`inline; filename="a_;__Set-Cookie: x=1"; filename*=UTF-8''a%22%3B%0D%0ASet-Cookie:%20x=1`},
+		{"escaped path", "/ipfs/" + testCid + "/a%0D%0Ab", "/ipfs/" + testCid + "/a%0D%0Ab",
+			`inline; filename="a__b"; filename*=UTF-8''a%0D%0Ab`},
+		{"handler headers", "/ipfs/" + testCid + "/a.txt?own", "/ipfs/own", "attachment"},
+		{"error", "/ipfs/" + testCid + "/a.txt?status=404", "", ""},
+		{"other path", "/api/v0/version?filename=a.txt", "", ""},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tc.target, nil))
+			if got := w.Header().Get("X-Ipfs-Path"); got != tc.path {
+				t.Fatalf("expected X-Ipfs-Path %q, got %q", tc.path, got)
+			}
+			got := w.Header().Get("Content-Disposition")
+			if got != tc.disposition {
+				t.Fatalf("expected Content-Disposition %q, got %q", tc.disposition, got)
+			}
+			if strings.ContainsAny(got, "\r\n") {
+				t.Fatalf("expected Content-Disposition on a single line, got %q", got)
+			}
+		})
+	}
+}
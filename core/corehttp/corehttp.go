@@ -5,19 +5,24 @@ high-level HTTP interfaces to IPFS.
 package corehttp
 
 import (
-	"bytes"
 	"context"
+	"crypto/tls"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"math"
 	"net"
 	"net/http"
-	"regexp"
+	"net/netip"
+	"net/url"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
 
 	"golang.org/x/time/rate"
 
+	"github.com/ipfs/boxo/namesys"
 	"github.com/ipfs/go-cid"
 	logging "github.com/ipfs/go-log"
 	config "github.com/ipfs/kubo/config"
@@ -66,13 +71,10 @@ func MakeHandler(n *core.IpfsNode, l net.Listener, options ...ServeOption) (http
 }
 
 // ListenAndServe runs an HTTP server listening at |listeningMultiAddr| with
-// the given serve options. The address must be provided in multiaddr format.
-//
-// TODO intelligently parse address strings in other formats so long as they
-// unambiguously map to a valid multiaddr. e.g. for convenience, ":8080" should
-// map to "/ip4/0.0.0.0/tcp/8080".
+// the given serve options. The address is a multiaddr, or a TCP host:port
+// address as accepted by parseListenAddr.
 func ListenAndServe(n *core.IpfsNode, listeningMultiAddr string, options ...ServeOption) error {
-	addr, err := ma.NewMultiaddr(listeningMultiAddr)
+	addr, err := parseListenAddr(listeningMultiAddr)
 	if err != nil {
 		return err
 	}
@@ -89,8 +91,45 @@ func ListenAndServe(n *core.IpfsNode, listeningMultiAddr string, options ...Serv
 	return Serve(n, manet.NetListener(list), options...)
 }
 
+// parseListenAddr parses a multiaddr, or a host:port address translated to
+// the TCP multiaddr it stands for: ":8080" listens on all the IPv4
+// interfaces, "127.0.0.1:8080" and "[::1]:8080" on the IP given. Host names
+// are rejected, as they may resolve to several addresses.
+func parseListenAddr(s string) (ma.Multiaddr, error) {
+	if strings.HasPrefix(s, "/") {
+		return ma.NewMultiaddr(s)
+	}
+	host, port, err := net.SplitHostPort(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid listen address %q: expected a multiaddr or host:port", s)
+	}
+	if _, err := strconv.ParseUint(port, 10, 16); err != nil {
+		return nil, fmt.Errorf("invalid listen address %q: invalid port %q", s, port)
+	}
+	if host == "" {
+		return ma.NewMultiaddr("/ip4/0.0.0.0/tcp/" + port)
+	}
+	ip, err := netip.ParseAddr(host)
+	if err != nil {
+		return nil, fmt.Errorf("invalid listen address %q: %q is not an IP, use a /dns multiaddr for host names", s, host)
+	}
+	if ip.Zone() != "" {
+		return nil, fmt.Errorf("invalid listen address %q: IPv6 zones are not supported", s)
+	}
+	ip = ip.Unmap()
+	proto := "ip4"
+	if ip.Is6() {
+		proto = "ip6"
+	}
+	return ma.NewMultiaddr("/" + proto + "/" + ip.String() + "/tcp/" + port)
+}
+
 // Serve accepts incoming HTTP connections on the listener and pass them
-// to ServeOption handlers.
+// to ServeOption handlers. Connections are served over TLS when
+// ConfigPinningService.SslCertPath and SslKeyPath are set. The files are
+// reloaded when they change. When the node closes, requests keep being
+// served for ConfigPinningService.ShutdownDrainDelay, /readyz reporting the
+// node as unready, before the server shuts down.
 func Serve(node *core.IpfsNode, lis net.Listener, options ...ServeOption) error {
 	// make sure we close this no matter what.
 	defer lis.Close()
@@ -104,7 +143,13 @@ func Serve(node *core.IpfsNode, lis net.Listener, options ...ServeOption) error
 		return err
 	}
 
-	middlewareHandler := DedicatedGatewayMiddleware(handler, cfg)
+	certFile, keyFile := cfg.ConfigPinningService.SslCertPath, cfg.ConfigPinningService.SslKeyPath
+	if (certFile == "") != (keyFile == "") {
+		return errors.New("ConfigPinningService.SslCertPath and SslKeyPath must be set together to serve over TLS")
+	}
+
+	middlewareHandler, m, deregister := dedicatedGatewayMiddleware(handler, node, cfg)
+	defer deregister()
 
 	addr, err := manet.FromNetAddr(lis.Addr())
 	if err != nil {
@@ -120,10 +165,34 @@ func Serve(node *core.IpfsNode, lis net.Listener, options ...ServeOption) error
 	server := &http.Server{
 		Handler: middlewareHandler,
 	}
+	setServerTimeouts(server, cfg.ConfigPinningService)
+	if certFile != "" {
+		certs, err := newCertLoader(certFile, keyFile)
+		if err != nil {
+			return fmt.Errorf("loading TLS certificate: %w", err)
+		}
+		defer registerCertLoader(certs)()
+		server.TLSConfig = &tls.Config{
+			MinVersion:     tls.VersionTLS12,
+			GetCertificate: certs.GetCertificate,
+			NextProtos:     []string{"h2", "http/1.1"},
+		}
+	}
+	if maxConns := cfg.ConfigPinningService.MaxConnsPerIP; maxConns > 0 {
+		limiter := newConnLimiter(maxConns, func() ipPrefixes { return m.settings.Load().trustedProxies })
+		server.Handler = limiter.wrap(middlewareHandler)
+		server.ConnContext = limiter.connContext
+		server.ConnState = limiter.connState
+	}
 
 	var serverError error
 	serverProc := node.Process.Go(func(p goprocess.Process) {
-		serverError = server.Serve(lis)
+		if certFile != "" {
+			// certificates are loaded by TLSConfig.GetCertificate
+			serverError = server.ServeTLS(lis, "", "")
+		} else {
+			serverError = server.Serve(lis)
+		}
 	})
 
 	// wait for server to exit.
@@ -131,6 +200,13 @@ func Serve(node *core.IpfsNode, lis net.Listener, options ...ServeOption) error
 	case <-serverProc.Closed():
 	// if node being closed before server exits, close server
 	case <-node.Process.Closing():
+		if delay := cfg.ConfigPinningService.ShutdownDrainDelay.WithDefault(0); delay > 0 {
+			log.Infof("server at %s draining for %s...", addr, delay)
+			select {
+			case <-time.After(delay):
+			case <-serverProc.Closed():
+			}
+		}
 		log.Infof("server at %s terminating...", addr)
 
 		warnProc := periodicproc.Tick(5*time.Second, func(_ goprocess.Process) {
@@ -155,140 +231,495 @@ func Serve(node *core.IpfsNode, lis net.Listener, options ...ServeOption) error
 	return serverError
 }
 
-var ipLimiters = make(map[string]*rate.Limiter)
-var cidLimiters = make(map[string]*rate.Limiter)
+// limiterEntry is a rate limiter along with the last time it was used, so
+// idle limiters can be evicted.
+type limiterEntry struct {
+	limiter    *rate.Limiter
+	lastAccess time.Time
+}
+
+var ipLimiters = make(map[string]*limiterEntry)
+var cidLimiters = make(map[string]*limiterEntry)
 var mtx sync.Mutex
 
-func getLimiter(limit string, limitMap map[string]*rate.Limiter, rps float64) *rate.Limiter {
+// now is the clock used to track limiter usage, swapped in tests.
+var now = time.Now
+
+const defaultLimiterIdleTimeout = 10 * time.Minute
+
+var sweepLimitersOnce sync.Once
+
+const (
+	defaultIPRateLimit     = 100
+	defaultCIDRateLimit    = 15
+	defaultRateLimitWindow = time.Minute
+)
+
+// getLimiter returns the limiter stored under key in limitMap, allowing
+// requests per window with bursts of the same size. A limiter created earlier
+// with a different rate is updated in place so rate changes apply to keys
+// already seen.
+func getLimiter(key string, limitMap map[string]*limiterEntry, requests int, window time.Duration) *rate.Limiter {
 	mtx.Lock()
 	defer mtx.Unlock()
 
-	limiter, exists := limitMap[limit]
+	limit := rate.Limit(requests) * rate.Every(window)
+	burst := requests
+
+	entry, exists := limitMap[key]
 	if !exists {
-		limiter = rate.NewLimiter(rate.Every(time.Minute), int(rps))
-		limitMap[limit] = limiter
+		entry = &limiterEntry{limiter: rate.NewLimiter(limit, burst)}
+		limitMap[key] = entry
 	}
+	entry.lastAccess = now()
 
+	limiter := entry.limiter
+	if limiter.Limit() != limit {
+		limiter.SetLimit(limit)
+	}
+	if limiter.Burst() != burst {
+		limiter.SetBurst(burst)
+	}
 	return limiter
 }
 
-func DedicatedGatewayMiddleware(next http.Handler, cfg *config.Config) http.Handler {
+// rateLimitError is the JSON body of 429 responses.
+type rateLimitError struct {
+	Code       string `json:"code"`
+	Message    string `json:"message"`
+	RetryAfter int    `json:"retry_after"`
+}
+
+// tooManyRequests answers with 429, telling the client through Retry-After
+// when l will let a request through again.
+func tooManyRequests(w http.ResponseWriter, l limiter, code, msg string) {
+	retryAfter := int(math.Ceil(l.Delay().Seconds()))
+	if retryAfter < 1 {
+		retryAfter = 1
+	}
+
+	w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(http.StatusTooManyRequests)
+	if err := json.NewEncoder(w).Encode(rateLimitError{Code: code, Message: msg, RetryAfter: retryAfter}); err != nil {
+		log.Debugf("writing rate limit response: %s", err)
+	}
+}
+
+// startLimiterSweeper evicts limiters idle for longer than idle in the
+// background. Only the first call has an effect.
+func startLimiterSweeper(idle time.Duration) {
+	sweepLimitersOnce.Do(func() {
+		go func() {
+			ticker := time.NewTicker(idle / 2)
+			defer ticker.Stop()
+			for range ticker.C {
+				sweepLimiters(idle)
+			}
+		}()
+	})
+}
+
+// sweepLimiters removes the limiters not used for longer than idle.
+func sweepLimiters(idle time.Duration) {
+	mtx.Lock()
+	defer mtx.Unlock()
+
+	cutoff := now().Add(-idle)
+	for _, limitMap := range []map[string]*limiterEntry{ipLimiters, cidLimiters} {
+		for key, entry := range limitMap {
+			if entry.lastAccess.Before(cutoff) {
+				delete(limitMap, key)
+			}
+		}
+	}
+}
+
+// isGatewayPath reports whether p is an /ipfs/ or /ipns/ content path.
+func isGatewayPath(p string) bool {
+	return strings.HasPrefix(p, "/ipfs/") || strings.HasPrefix(p, "/ipns/")
+}
+
+// normalizeCIDKey returns the key identifying the content of c in access
+// checks, caches and rate limiters: the hex encoded multihash, shared by the
+// CIDv0 and CIDv1 forms of the same content.
+func normalizeCIDKey(c cid.Cid) string {
+	return c.Hash().HexString()
+}
+
+// DedicatedGatewayMiddleware guards gateway requests with the DMCA and
+// dedicated gateway access checks of the pinning service, along with rate
+// limits on the public gateway. IPNS names are resolved through the node's
+// name system so the checks apply to the content they point to. Each request
+// gets an ID, taken from its X-Request-ID header or generated, which is
+// echoed back and logged along with the access decision. Clients are
+// identified by their IP, taken from X-Forwarded-For behind TrustedProxies.
+// Clients in IPDenylist are rejected first, those in IPAllowlist skip the IP
+// rate limit. Past MaxConcurrentPerCID requests for the same content being
+// served, requests for it are rejected with 503. With CIDStats, the /ipfs/
+// requests let through are counted per CID in Redis. Part of cfg can be
+// changed while running with ReloadPinningService. Dedicated gateway
+// requests go through the access checks in the order of
+// ConfigPinningService.AccessDecisionOrder, see decideAccess. The middleware
+// stops being reloaded once node closes.
+func DedicatedGatewayMiddleware(next http.Handler, node *core.IpfsNode, cfg *config.Config) http.Handler {
+	handler, _, deregister := dedicatedGatewayMiddleware(next, node, cfg)
+	if node != nil && node.Process != nil {
+		go func() {
+			<-node.Process.Closing()
+			deregister()
+		}()
+	}
+	return handler
+}
+
+// dedicatedGatewayMiddleware returns the handler of DedicatedGatewayMiddleware
+// and its reloadable state, along with a function removing it from the
+// middlewares reloaded by ReloadPinningService, to be called once it no
+// longer serves requests.
+func dedicatedGatewayMiddleware(next http.Handler, node *core.IpfsNode, cfg *config.Config) (http.Handler, *gatewayMiddleware, func()) {
+	var ns namesys.NameSystem
+	if node != nil {
+		ns = node.Namesys
+	}
+
+	var queue *admissionQueue
+	if cfg.ConfigPinningService.MaxConcurrentRequests > 0 {
+		queue = newAdmissionQueue(cfg.ConfigPinningService.MaxConcurrentRequests)
+	}
+
+	m := newGatewayMiddleware(cfg)
+	deregister := registerMiddleware(m)
+	dmca, cooldown := m.dmca, m.cooldown
+
+	if !cfg.ConfigPinningService.DedicatedGateway {
+		startLimiterSweeper(cfg.ConfigPinningService.LimiterIdleTimeout.WithDefault(defaultLimiterIdleTimeout))
+	}
 
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// Check if the path is follow the pattern /ipfs/<hash>
-		if cfg.ConfigPinningService.DedicatedGateway && strings.HasPrefix(r.URL.Path, "/ipfs/") {
-			// Get the hash from the request URL
-			pathPattern := regexp.MustCompile(`/ipfs/([^/]+)`)
-
-			matches := pathPattern.FindStringSubmatch(r.URL.Path)
-			if matches == nil || len(matches) < 2 {
-				http.Error(w, "Invalid path", http.StatusBadRequest)
+		priority := priorityAnonymous
+
+		rl := newRequestLog(r)
+		w.Header().Set(requestIDHeader, rl.id)
+
+		// settings may be swapped by ReloadPinningService
+		settings := m.settings.Load()
+		cfg := settings.cfg
+
+		ip, hasIP := clientIP(r, settings.trustedProxies)
+		if hasIP && settings.ipDenylist.contains(ip) {
+			gatewayAccessRequests.WithLabelValues(accessIPDenied).Inc()
+			rl.decision(accessIPDenied, http.StatusForbidden)
+			http.Error(w, "Requests from this IP are not allowed", http.StatusForbidden)
+			return
+		}
+		ipAllowlisted := hasIP && settings.ipAllowlist.contains(ip)
+		// contentKey is the normalized CID of the content requested, once
+		// checked
+		var contentKey string
+		ipKey := r.RemoteAddr
+		if hasIP {
+			ipKey = ip.String()
+			rl.remoteIP = ipKey
+		}
+
+		if isGatewayPath(r.URL.Path) && inMaintenance(w) {
+			rl.decision(decisionMaintenance, http.StatusServiceUnavailable)
+			return
+		}
+
+		if cfg.ConfigPinningService.CanonicalGatewayPaths && isGatewayPath(r.URL.Path) {
+			p, ok := canonicalGatewayPath(r.URL.EscapedPath(), cfg.ConfigPinningService.TrailingSlash)
+			if !ok {
+				rl.decision(decisionInvalidPath, http.StatusBadRequest)
+				http.Error(w, "Path must not have .. segments", http.StatusBadRequest)
 				return
 			}
-			cid, err := cid.Parse(matches[1])
-			if err != nil {
-				http.Error(w, "Invalid hash", http.StatusBadRequest)
+			if p != r.URL.EscapedPath() {
+				u := *r.URL
+				u.RawPath = p
+				u.Path, _ = url.PathUnescape(p)
+				http.Redirect(w, r, u.RequestURI(), http.StatusMovedPermanently)
 				return
 			}
+		}
 
-			status, err := checkDmca(cid.String(), cfg)
+		if isGatewayPath(r.URL.Path) && !refererAllowed(r, cfg.ConfigPinningService) {
+			status := refererDenyStatus(cfg.ConfigPinningService)
+			rl.decision(decisionRefererDenied, status)
+			http.Error(w, "Hotlinking is not allowed", status)
+			return
+		}
+
+		// Check if the path is follow the pattern /ipfs/<hash> or /ipns/<name>
+		if cfg.ConfigPinningService.DedicatedGateway && isGatewayPath(r.URL.Path) {
+			cid, status, err := contentCid(r.Context(), ns, r.URL.Path)
 			if err != nil {
+				rl.decision(decisionInvalidPath, status)
 				http.Error(w, err.Error(), status)
 				return
 			}
-			// Call the getDedicatedGatewayAccess function
-			status, err = getDedicatedGatewayAccess(cid.Hash().HexString(), cfg)
-			if err != nil {
+			key := normalizeCIDKey(cid)
+			rl.cid = cid.String()
+
+			if status, err, ok := cooldown.failed(key); ok {
+				rl.decision(decisionCoolingDown, status)
 				http.Error(w, err.Error(), status)
 				return
 			}
-		} else if !cfg.ConfigPinningService.DedicatedGateway && strings.HasPrefix(r.URL.Path, "/ipfs/") {
-			ipLimiter := getLimiter(r.RemoteAddr, ipLimiters, 100)
-			if !ipLimiter.Allow() {
-				http.Error(w, "Too many requests from this IP", http.StatusTooManyRequests)
+
+			req := &accessRequest{w: w, root: cid, key: key, cfg: cfg, settings: settings, rl: rl}
+			req.appeal, r, req.appealErr = takeAppealToken(r, cid, cfg.ConfigPinningService)
+			req.signed, r, req.signatureErr = takeSignature(r, cid, cfg.ConfigPinningService)
+			req.clientKey, r = takeClientKey(r, cfg.ConfigPinningService)
+			req.r = r
+			if !m.decideAccess(req) {
 				return
 			}
-			pathPattern := regexp.MustCompile(`/ipfs/([^/]+)`)
+			if req.subscribed {
+				priority = prioritySubscribed
+			}
+			contentKey = key
+		} else if !cfg.ConfigPinningService.DedicatedGateway && isGatewayPath(r.URL.Path) {
+			// /ipfs/ paths are parsed first so allowlisted CIDs skip the IP
+			// rate limit, IPNS names are only resolved past it
+			var (
+				root cid.Cid
+				key  string
+			)
+			ipfsPath := strings.HasPrefix(r.URL.Path, "/ipfs/")
+			if ipfsPath {
+				var status int
+				var err error
+				root, status, err = contentCid(r.Context(), ns, r.URL.Path)
+				if err != nil {
+					rl.decision(decisionInvalidPath, status)
+					http.Error(w, err.Error(), status)
+					return
+				}
+				key = normalizeCIDKey(root)
+				rl.cid = root.String()
+			}
 
-			matches := pathPattern.FindStringSubmatch(r.URL.Path)
-			if matches == nil || len(matches) < 2 {
-				http.Error(w, "Invalid path", http.StatusBadRequest)
-				return
+			allowlisted := ipfsPath && settings.allowlisted(key)
+			if !allowlisted && !ipAllowlisted {
+				ipLimiter := m.limiterFor(r.Context(), "ip", ipKey, ipLimiters, settings.ipRateLimit, settings.rateLimitWindow)
+				if !ipLimiter.Allow() {
+					gatewayAccessRequests.WithLabelValues(accessIPThrottled).Inc()
+					rl.decision(accessIPThrottled, http.StatusTooManyRequests)
+					tooManyRequests(w, ipLimiter, "ip_rate_limited", "Too many requests from this IP")
+					return
+				}
 			}
-			cid, err := cid.Parse(matches[1])
-			if err != nil {
-				http.Error(w, "Invalid hash", http.StatusBadRequest)
-				return
+
+			if !ipfsPath {
+				var status int
+				var err error
+				root, status, err = contentCid(r.Context(), ns, r.URL.Path)
+				if err != nil {
+					rl.decision(decisionInvalidPath, status)
+					http.Error(w, err.Error(), status)
+					return
+				}
+				key = normalizeCIDKey(root)
+				rl.cid = root.String()
+				allowlisted = settings.allowlisted(key)
 			}
 
-			cidLimiter := getLimiter(cid.String(), cidLimiters, 15)
-			if !cidLimiter.Allow() {
-				http.Error(w, "Too many requests for this CID", http.StatusTooManyRequests)
+			if !allowlisted {
+				cidLimiter := m.limiterFor(r.Context(), "cid", key, cidLimiters, settings.cidRateLimit, settings.rateLimitWindow)
+				if !cidLimiter.Allow() {
+					gatewayAccessRequests.WithLabelValues(accessCIDThrottled).Inc()
+					rl.decision(accessCIDThrottled, http.StatusTooManyRequests)
+					tooManyRequests(w, cidLimiter, "cid_rate_limited", "Too many requests for this CID")
+					return
+				}
+			}
+
+			if status, err, ok := cooldown.failed(key); ok {
+				rl.decision(decisionCoolingDown, status)
+				http.Error(w, err.Error(), status)
 				return
 			}
 
-			status, err := checkDmca(cid.String(), cfg)
+			start := time.Now()
+			status, err := dmca.check(r.Context(), root, cfg)
+			rl.timeUpstream(start)
 			if err != nil {
+				outcome := decisionCheckFailed
+				if status == http.StatusGone {
+					outcome = accessDmcaBlocked
+					gatewayAccessRequests.WithLabelValues(accessDmcaBlocked).Inc()
+				}
+				rl.decision(outcome, status)
+				cooldown.record(key, status, err)
+				if status == http.StatusGone {
+					dmcaBlocked(w, r, rl.cid, settings.dmcaPage)
+					return
+				}
 				http.Error(w, err.Error(), status)
 				return
 			}
+			contentKey = key
+		}
+		if limit := cfg.ConfigPinningService.MaxConcurrentPerCID; limit > 0 && contentKey != "" {
+			if !m.inflight.acquire(contentKey, limit) {
+				gatewayAccessRequests.WithLabelValues(accessCIDBusy).Inc()
+				rl.decision(accessCIDBusy, http.StatusServiceUnavailable)
+				cidBusy(w)
+				return
+			}
+			defer m.inflight.release(contentKey)
+		}
+		if isGatewayPath(r.URL.Path) {
+			gatewayAccessRequests.WithLabelValues(accessAllowed).Inc()
+			rl.decision(accessAllowed, http.StatusOK)
+		}
+		if m.stats != nil && contentKey != "" && strings.HasPrefix(r.URL.Path, "/ipfs/") {
+			m.stats.Record(contentKey)
+		}
+
+		if queue != nil && isGatewayPath(r.URL.Path) {
+			if err := queue.acquire(r.Context(), priority); err != nil {
+				http.Error(w, "Request cancelled while waiting to be served", http.StatusServiceUnavailable)
+				return
+			}
+			defer queue.release()
+			r = r.WithContext(withAdmissionQueue(r.Context(), queue))
 		}
 
 		next.ServeHTTP(w, r)
-	})
+	}), m, deregister
 }
 
-func getDedicatedGatewayAccess(hash string, cfg *config.Config) (int, error) {
-	apiUrl := fmt.Sprintf("%s/api/dedicatedGateways/%s", cfg.ConfigPinningService.PinningService, hash)
-	req, err := http.NewRequest("GET", apiUrl, bytes.NewBuffer(nil))
-	if err != nil {
-		return http.StatusInternalServerError, fmt.Errorf("failed to create request: %w", err)
+// upstreamDialTimeout bounds connecting to the pinning service, shorter than
+// ConfigPinningService.UpstreamTimeout so an unreachable endpoint leaves
+// time to fail over to the next one.
+const upstreamDialTimeout = 500 * time.Millisecond
+
+// defaultUpstreamMaxHeaderBytes bounds the headers of the pinning service
+// responses unless ConfigPinningService.UpstreamMaxHeaderBytes is set.
+const defaultUpstreamMaxHeaderBytes = 64 << 10
+
+// pinningServiceClients are shared by the calls to the pinning service API
+// so connections are kept alive across gateway requests, one per
+// ConfigPinningService.UpstreamMaxHeaderBytes configured as the limit is a
+// setting of the transport.
+var pinningServiceClients = struct {
+	sync.Mutex
+	byLimit map[int64]*http.Client
+}{byLimit: make(map[int64]*http.Client)}
+
+// pinningServiceClient returns the client calling the pinning service of
+// cfg. Redirects are not followed: they are directives for the gateway
+// client. Calls are bounded by ConfigPinningService.UpstreamTimeout through
+// their context, and fail when the response headers are larger than
+// UpstreamMaxHeaderBytes.
+func pinningServiceClient(cfg config.ConfigPinningService) *http.Client {
+	limit := cfg.UpstreamMaxHeaderBytes
+	if limit <= 0 {
+		limit = defaultUpstreamMaxHeaderBytes
 	}
-	req.Header.Set("blockservice-API-Key", cfg.ConfigPinningService.BlockserviceApiKey)
-	req.Header.Set("Content-Type", "application/json")
 
-	client := &http.Client{
-		Timeout: 15 * time.Second,
+	pinningServiceClients.Lock()
+	defer pinningServiceClients.Unlock()
+	if c, ok := pinningServiceClients.byLimit[limit]; ok {
+		return c
 	}
+	c := &http.Client{
+		Transport: &http.Transport{
+			Proxy:                  http.ProxyFromEnvironment,
+			DialContext:            (&net.Dialer{Timeout: upstreamDialTimeout}).DialContext,
+			MaxIdleConns:           100,
+			MaxIdleConnsPerHost:    32,
+			IdleConnTimeout:        90 * time.Second,
+			TLSHandshakeTimeout:    10 * time.Second,
+			MaxResponseHeaderBytes: limit,
+		},
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	pinningServiceClients.byLimit[limit] = c
+	return c
+}
 
-	resp, err := client.Do(req)
+// getDedicatedGatewayAccess asks the pinning service whether the content
+// can be served by the dedicated gateway, forwarding the API key the client
+// sent, if any, for the pinning service to authorize it.
+func getDedicatedGatewayAccess(ctx context.Context, hash, clientKey string, cfg *config.Config) (int, error) {
+	header := http.Header{"Content-Type": {"application/json"}}
+	if clientKey != "" {
+		header.Set("client-API-Key", clientKey)
+	}
+	resp, err := callPinningService(ctx, cfg.ConfigPinningService, "dedicated_gateway", "/api/dedicatedGateways/"+hash, header)
 	if err != nil {
+		if ctx.Err() != nil {
+			return http.StatusRequestTimeout, fmt.Errorf("dedicated gateway check aborted: %w", ctx.Err())
+		}
 		return http.StatusInternalServerError, errors.New("Error while calling dedicated gateway API")
 	}
 	defer resp.Body.Close()
 
+	switch resp.StatusCode {
+	case http.StatusMovedPermanently, http.StatusFound, http.StatusSeeOther, http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+		return resp.StatusCode, &accessRedirect{location: resp.Header.Get("Location")}
+	}
+
+	if clientKey != "" && (resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden) {
+		return resp.StatusCode, errClientKeyRejected
+	}
 	if resp.StatusCode != 200 {
 		return resp.StatusCode, errors.New("No users have subscribed to this hash yet.")
 	}
 	return http.StatusOK, nil
 }
 
-func checkDmca(hash string, cfg *config.Config) (int, error) {
-	apiUrl := fmt.Sprintf("%s/api/dmca/%s", cfg.ConfigPinningService.PinningService, hash)
-	req, err := http.NewRequest("GET", apiUrl, bytes.NewBuffer(nil))
-	if err != nil {
-		return http.StatusInternalServerError, fmt.Errorf("failed to create request: %w", err)
-	}
-	req.Header.Set("blockservice-API-Key", cfg.ConfigPinningService.BlockserviceApiKey)
-	req.Header.Set("Content-Type", "application/json")
+// dmcaRetryBackoff is how long checkDmca waits before retrying a timed out
+// call, swapped in tests.
+var dmcaRetryBackoff = 250 * time.Millisecond
 
-	client := &http.Client{
-		Timeout: 15 * time.Second,
+// checkDmca asks the pinning service whether the CID c is blocked, retrying
+// once if the call times out.
+func checkDmca(ctx context.Context, c string, cfg *config.Config) (int, error) {
+	status, err, timeout := callDmca(ctx, c, cfg)
+	if !timeout {
+		return status, err
+	}
+	log.Debugf("DMCA check of %s timed out, retrying: %s", c, err)
+	t := time.NewTimer(dmcaRetryBackoff)
+	defer t.Stop()
+	select {
+	case <-t.C:
+	case <-ctx.Done():
+		return http.StatusRequestTimeout, fmt.Errorf("DMCA check aborted: %w", ctx.Err())
 	}
+	status, err, _ = callDmca(ctx, c, cfg)
+	return status, err
+}
 
-	resp, err := client.Do(req)
+// callDmca makes a single DMCA check call, reporting whether it failed
+// because the pinning service did not answer in time.
+func callDmca(ctx context.Context, c string, cfg *config.Config) (int, error, bool) {
+	resp, err := callPinningService(ctx, cfg.ConfigPinningService, "dmca", "/api/dmca/"+c, http.Header{"Content-Type": {"application/json"}})
 	if err != nil {
-		return http.StatusInternalServerError, errors.New("Error while calling DMCA API")
+		if ctx.Err() != nil {
+			return http.StatusRequestTimeout, fmt.Errorf("DMCA check aborted: %w", ctx.Err()), false
+		}
+		var netErr net.Error
+		timeout := errors.As(err, &netErr) && netErr.Timeout()
+		return http.StatusInternalServerError, errors.New("Error while calling DMCA API"), timeout
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode == http.StatusGone {
-		return http.StatusGone, errors.New("The content that you requested has been blocked because of legal, abuse, malware or security reasons. Please contact support@aiozpin.network for more information")
+		return http.StatusGone, errContentBlocked, false
 	}
 
 	if resp.StatusCode != 200 {
-		return resp.StatusCode, errors.New("Something went wrong")
+		return resp.StatusCode, errors.New("Something went wrong"), false
 	}
 
-	return http.StatusOK, nil
+	return http.StatusOK, nil, false
 }
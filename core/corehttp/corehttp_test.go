@@ -0,0 +1,328 @@
+package corehttp
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/kubo/config"
+	"github.com/multiformats/go-multihash"
+	"golang.org/x/time/rate"
+)
+
+func TestGetLimiterUpdatesRate(t *testing.T) {
+	limiters := make(map[string]*limiterEntry)
+
+	limiter := getLimiter("key", limiters, 100, time.Minute)
+	if want := rate.Limit(100) * rate.Every(time.Minute); limiter.Limit() != want {
+		t.Fatalf("expected limit %v, got %v", want, limiter.Limit())
+	}
+
+	updated := getLimiter("key", limiters, 15, time.Minute)
+	if updated != limiter {
+		t.Fatal("expected the existing limiter to be reused")
+	}
+	if want := rate.Limit(15) * rate.Every(time.Minute); limiter.Limit() != want {
+		t.Fatalf("expected limit %v after rate change, got %v", want, limiter.Limit())
+	}
+	if limiter.Burst() != 15 {
+		t.Fatalf("expected burst 15 after rate change, got %d", limiter.Burst())
+	}
+}
+
+func TestSweepLimiters(t *testing.T) {
+	clock := time.Unix(0, 0)
+	now = func() time.Time { return clock }
+	t.Cleanup(func() {
+		now = time.Now
+		mtx.Lock()
+		clear(ipLimiters)
+		clear(cidLimiters)
+		mtx.Unlock()
+	})
+
+	getLimiter("stale-ip", ipLimiters, 100, time.Minute)
+	getLimiter("stale-cid", cidLimiters, 15, time.Minute)
+	getLimiter("active-cid", cidLimiters, 15, time.Minute)
+
+	clock = clock.Add(8 * time.Minute)
+	getLimiter("active-cid", cidLimiters, 15, time.Minute)
+	getLimiter("fresh-ip", ipLimiters, 100, time.Minute)
+
+	clock = clock.Add(3 * time.Minute)
+	sweepLimiters(10 * time.Minute)
+
+	mtx.Lock()
+	defer mtx.Unlock()
+	for _, key := range []string{"stale-ip", "stale-cid"} {
+		if _, ok := ipLimiters[key]; ok {
+			t.Errorf("expected %s to be evicted", key)
+		}
+		if _, ok := cidLimiters[key]; ok {
+			t.Errorf("expected %s to be evicted", key)
+		}
+	}
+	if _, ok := cidLimiters["active-cid"]; !ok {
+		t.Error("expected active-cid to be kept")
+	}
+	if _, ok := ipLimiters["fresh-ip"]; !ok {
+		t.Error("expected fresh-ip to be kept")
+	}
+}
+
+func TestNormalizeCIDKey(t *testing.T) {
+	v0 := cid.MustParse("QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n")
+	v1 := cid.MustParse("bafybeihdwdcefgh4dqkjv67uzcmw7ojee6xedzdetojuzjevtenxquvyku")
+	if normalizeCIDKey(v0) != normalizeCIDKey(v1) {
+		t.Fatalf("expected %s and %s to share a key", v0, v1)
+	}
+}
+
+func TestDmcaBlockAppliesToBothCIDVersions(t *testing.T) {
+	const (
+		v0 = "QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n"
+		v1 = "bafybeihdwdcefgh4dqkjv67uzcmw7ojee6xedzdetojuzjevtenxquvyku"
+	)
+	// the pinning service knows the takedown by the CID it was filed for,
+	// the CIDv1 is blocked through the cache entry shared by both
+	blocked := "/api/dmca/" + v0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == blocked {
+			w.WriteHeader(http.StatusGone)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(ts.Close)
+
+	cfg := &config.Config{
+		ConfigPinningService: config.ConfigPinningService{
+			PinningService:   ts.URL,
+			DedicatedGateway: true,
+		},
+	}
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	handler := DedicatedGatewayMiddleware(next, nil, cfg)
+
+	for _, c := range []string{v0, v1} {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/ipfs/"+c, nil))
+		if w.Code != http.StatusGone {
+			t.Errorf("%s: expected status %d, got %d", c, http.StatusGone, w.Code)
+		}
+	}
+}
+
+func TestConfiguredRateLimits(t *testing.T) {
+	const remoteAddr = "203.0.113.7:1234"
+	ts := newTestPinningService(t)
+	cfg := &config.Config{
+		ConfigPinningService: config.ConfigPinningService{
+			PinningService:  ts.URL,
+			IPRateLimit:     40,
+			CIDRateLimit:    4,
+			RateLimitWindow: config.NewOptionalDuration(10 * time.Second),
+		},
+	}
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	handler := DedicatedGatewayMiddleware(next, nil, cfg)
+	t.Cleanup(func() {
+		mtx.Lock()
+		clear(ipLimiters)
+		clear(cidLimiters)
+		mtx.Unlock()
+	})
+
+	r := httptest.NewRequest(http.MethodGet, "/ipfs/"+testCid, nil)
+	r.RemoteAddr = remoteAddr
+	handler.ServeHTTP(httptest.NewRecorder(), r)
+
+	mtx.Lock()
+	defer mtx.Unlock()
+	for _, tc := range []struct {
+		name  string
+		entry *limiterEntry
+		limit rate.Limit
+		burst int
+	}{
+		{"ip", ipLimiters["203.0.113.7"], 4, 40},
+		{"cid", cidLimiters[normalizeCIDKey(cid.MustParse(testCid))], 0.4, 4},
+	} {
+		if tc.entry == nil {
+			t.Fatalf("%s: expected a limiter to be created", tc.name)
+		}
+		if got := tc.entry.limiter.Limit(); math.Abs(float64(got-tc.limit)) > 1e-9 {
+			t.Errorf("%s: expected limit %v, got %v", tc.name, tc.limit, got)
+		}
+		if got := tc.entry.limiter.Burst(); got != tc.burst {
+			t.Errorf("%s: expected burst %d, got %d", tc.name, tc.burst, got)
+		}
+	}
+}
+
+func TestRateLimitResponse(t *testing.T) {
+	const throttled = "QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n"
+	ts := newTestPinningService(t)
+	cfg := &config.Config{
+		ConfigPinningService: config.ConfigPinningService{
+			PinningService: ts.URL,
+		},
+	}
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	handler := DedicatedGatewayMiddleware(next, nil, cfg)
+	t.Cleanup(func() {
+		mtx.Lock()
+		clear(ipLimiters)
+		clear(cidLimiters)
+		mtx.Unlock()
+	})
+
+	hammer := func(remoteAddr, p func(i int) string) *httptest.ResponseRecorder {
+		for i := 0; i < 1000; i++ {
+			r := httptest.NewRequest(http.MethodGet, p(i), nil)
+			r.RemoteAddr = remoteAddr(i)
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+			if w.Code == http.StatusTooManyRequests {
+				return w
+			}
+		}
+		t.Fatal("expected requests to be throttled")
+		return nil
+	}
+
+	for _, tc := range []struct {
+		name       string
+		remoteAddr func(i int) string
+		path       func(i int) string
+		code       string
+	}{
+		{
+			name:       "ip",
+			remoteAddr: func(int) string { return "192.0.2.1:1234" },
+			path: func(i int) string {
+				// a distinct CID per request to stay below the CID limit
+				mh, err := multihash.Sum([]byte(strconv.Itoa(i)), multihash.IDENTITY, -1)
+				if err != nil {
+					t.Fatal(err)
+				}
+				return "/ipfs/" + cid.NewCidV1(cid.Raw, mh).String()
+			},
+			code: "ip_rate_limited",
+		},
+		{
+			name:       "cid",
+			remoteAddr: func(i int) string { return fmt.Sprintf("198.51.100.%d:1234", i) },
+			path:       func(int) string { return "/ipfs/" + throttled },
+			code:       "cid_rate_limited",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			w := hammer(tc.remoteAddr, tc.path)
+
+			retryAfter, err := strconv.Atoi(w.Header().Get("Retry-After"))
+			if err != nil || retryAfter < 1 {
+				t.Fatalf("expected a positive Retry-After, got %q", w.Header().Get("Retry-After"))
+			}
+			if ct := w.Header().Get("Content-Type"); ct != "application/json" {
+				t.Fatalf("expected a JSON body, got %s", ct)
+			}
+			var body rateLimitError
+			if err := json.NewDecoder(w.Body).Decode(&body); err != nil {
+				t.Fatal(err)
+			}
+			if body.Code != tc.code {
+				t.Fatalf("expected code %s, got %s", tc.code, body.Code)
+			}
+			if body.RetryAfter != retryAfter {
+				t.Fatalf("expected retry_after %d to match Retry-After, got %d", retryAfter, body.RetryAfter)
+			}
+		})
+	}
+}
+
+func BenchmarkCheckDmca(b *testing.B) {
+	var conns atomic.Int64
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	ts.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			conns.Add(1)
+		}
+	}
+	ts.Start()
+	b.Cleanup(ts.Close)
+
+	cfg := &config.Config{
+		ConfigPinningService: config.ConfigPinningService{
+			PinningService: ts.URL,
+		},
+	}
+	ctx := context.Background()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := checkDmca(ctx, testCid, cfg); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.ReportMetric(float64(conns.Load())/float64(b.N), "conns/op")
+}
+
+func TestParseListenAddr(t *testing.T) {
+	for _, tc := range []struct {
+		addr string
+		want string
+	}{
+		{"/ip4/127.0.0.1/tcp/5001", "/ip4/127.0.0.1/tcp/5001"},
+		{":8080", "/ip4/0.0.0.0/tcp/8080"},
+		{"127.0.0.1:8080", "/ip4/127.0.0.1/tcp/8080"},
+		{"0.0.0.0:0", "/ip4/0.0.0.0/tcp/0"},
+		{"[::]:8080", "/ip6/::/tcp/8080"},
+		{"[::1]:8080", "/ip6/::1/tcp/8080"},
+		{"[::ffff:127.0.0.1]:8080", "/ip4/127.0.0.1/tcp/8080"},
+	} {
+		t.Run(tc.addr, func(t *testing.T) {
+			addr, err := parseListenAddr(tc.addr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if addr.String() != tc.want {
+				t.Fatalf("expected %s, got %s", tc.want, addr)
+			}
+		})
+	}
+
+	for _, addr := range []string{
+		"8080",
+		"localhost:8080",
+		"127.0.0.1",
+		"127.0.0.1:http",
+		"127.0.0.1:65536",
+		"[fe80::1%eth0]:8080",
+		"/ip4/127.0.0.1/tcp",
+	} {
+		t.Run(addr, func(t *testing.T) {
+			if _, err := parseListenAddr(addr); err == nil {
+				t.Fatalf("expected %q to be rejected", addr)
+			}
+		})
+	}
+}
@@ -0,0 +1,179 @@
+package corehttp
+
+import (
+	"container/list"
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	config "github.com/ipfs/kubo/config"
+	"golang.org/x/sync/singleflight"
+)
+
+const (
+	defaultDmcaCacheSize  = 10000
+	defaultDmcaAllowedTTL = 5 * time.Minute
+)
+
+// dmcaCache remembers DMCA check outcomes per CID so repeated requests don't
+// each wait for the pinning service. Only definitive answers are cached:
+// allowed content for ConfigPinningService.DmcaAllowedTTL and blocked content
+// for DmcaBlockedTTL, forever by default. The least recently used entries
+// are evicted past DmcaCacheSize. Concurrent misses for the same CID share
+// a single call. Allowed content is still served for StaleIfError past its
+// TTL when the pinning service fails.
+type dmcaCache struct {
+	mu           sync.Mutex
+	size         int
+	allowedTTL   time.Duration
+	blockedTTL   time.Duration
+	staleIfError time.Duration
+	ll           *list.List
+	items        map[string]*list.Element
+	deny         dmcaDenylists
+	calls        singleflight.Group
+}
+
+type dmcaEntry struct {
+	cid     string
+	status  int
+	err     error
+	expires time.Time // zero for entries that never expire
+}
+
+func newDmcaCache(cfg config.ConfigPinningService) *dmcaCache {
+	size := cfg.DmcaCacheSize
+	if size <= 0 {
+		size = defaultDmcaCacheSize
+	}
+	return &dmcaCache{
+		size:         size,
+		allowedTTL:   cfg.DmcaAllowedTTL.WithDefault(defaultDmcaAllowedTTL),
+		blockedTTL:   cfg.DmcaBlockedTTL.WithDefault(0),
+		staleIfError: cfg.StaleIfError.WithDefault(0),
+		ll:           list.New(),
+		items:        make(map[string]*list.Element),
+		deny:         newDmcaDenylists(cfg),
+	}
+}
+
+// check returns the DMCA status of root: blocked without any call when it
+// is in the local denylists, as checkUpstream returns it otherwise.
+func (c *dmcaCache) check(ctx context.Context, root cid.Cid, cfg *config.Config) (int, error) {
+	if c.deny.contains(normalizeCIDKey(root)) {
+		return http.StatusGone, errContentBlocked
+	}
+	return c.checkUpstream(ctx, root, cfg)
+}
+
+// checkUpstream returns the cached DMCA status of root, calling checkDmca on
+// a miss. Entries are keyed by multihash so the CIDv0 and CIDv1 of the same
+// content share them, while the pinning service is asked about root as
+// requested. When the call fails upstream, the content is denied or served
+// according to ConfigPinningService.DmcaFailMode.
+func (c *dmcaCache) checkUpstream(ctx context.Context, root cid.Cid, cfg *config.Config) (int, error) {
+	key := normalizeCIDKey(root)
+	if e, ok := c.get(key); ok {
+		return e.status, e.err
+	}
+
+	status, err := coalesce(ctx, &c.calls, key, func(ctx context.Context) (int, error) {
+		return checkDmca(ctx, root.String(), cfg)
+	})
+	switch {
+	case err == nil:
+		c.add(&dmcaEntry{cid: key, status: status})
+	case status == http.StatusGone:
+		c.add(&dmcaEntry{cid: key, status: status, err: err})
+	case ctx.Err() != nil:
+		// the client went away, there is nothing to serve
+	case status >= http.StatusInternalServerError && c.staleAllowed(key):
+		log.Warnf("serving %s allowed by an expired DMCA check, as the check failed: %s", root, err)
+		return http.StatusOK, nil
+	case cfg.ConfigPinningService.DmcaFailMode == config.DmcaFailOpen:
+		// serve without caching so the next request checks again
+		log.Warnf("serving %s although its DMCA check failed: %s", root, err)
+		return http.StatusOK, nil
+	}
+	return status, err
+}
+
+// setTTLs changes the lifetime of the entries cached from now on.
+func (c *dmcaCache) setTTLs(allowed, blocked time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.allowedTTL, c.blockedTTL = allowed, blocked
+}
+
+// setStaleIfError changes how long allowed content is still served past its
+// TTL when the pinning service fails.
+func (c *dmcaCache) setStaleIfError(stale time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.staleIfError = stale
+}
+
+// get returns the entry cached for cid unless it expired. Expired entries
+// allowing content are kept for staleIfError, see staleAllowed.
+func (c *dmcaCache) get(cid string) (*dmcaEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.items[cid]
+	if !ok {
+		return nil, false
+	}
+	e := elem.Value.(*dmcaEntry)
+	if !e.expires.IsZero() && !now().Before(e.expires) {
+		if e.err != nil || !now().Before(e.expires.Add(c.staleIfError)) {
+			c.ll.Remove(elem)
+			delete(c.items, cid)
+		}
+		return nil, false
+	}
+	c.ll.MoveToFront(elem)
+	return e, true
+}
+
+// staleAllowed reports whether cid was allowed by an entry that expired less
+// than staleIfError ago.
+func (c *dmcaCache) staleAllowed(cid string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.items[cid]
+	if !ok {
+		return false
+	}
+	e := elem.Value.(*dmcaEntry)
+	return e.err == nil && !e.expires.IsZero() && now().Before(e.expires.Add(c.staleIfError))
+}
+
+// add caches e, for DmcaBlockedTTL if it is an error and DmcaAllowedTTL
+// otherwise.
+func (c *dmcaCache) add(e *dmcaEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	ttl := c.allowedTTL
+	if e.err != nil {
+		ttl = c.blockedTTL
+	}
+	if ttl > 0 {
+		e.expires = now().Add(ttl)
+	}
+
+	if elem, ok := c.items[e.cid]; ok {
+		elem.Value = e
+		c.ll.MoveToFront(elem)
+		return
+	}
+	c.items[e.cid] = c.ll.PushFront(e)
+	for c.ll.Len() > c.size {
+		oldest := c.ll.Back()
+		c.ll.Remove(oldest)
+		delete(c.items, oldest.Value.(*dmcaEntry).cid)
+	}
+}
@@ -0,0 +1,192 @@
+package corehttp
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/kubo/config"
+)
+
+const testBlockedCid = "QmTFauExutTsy4XP6JbMFcw2Wa9645HJt2bTqL6qYDCKfe"
+
+// newCountingDmcaService returns a DMCA endpoint blocking testBlockedCid, and
+// a function returning the number of times a CID was looked up.
+func newCountingDmcaService(t *testing.T) (*httptest.Server, func(c string) int) {
+	t.Helper()
+	var (
+		mu    sync.Mutex
+		calls = make(map[string]int)
+	)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := strings.TrimPrefix(r.URL.Path, "/api/dmca/")
+		mu.Lock()
+		calls[key]++
+		mu.Unlock()
+		if key == testBlockedCid {
+			w.WriteHeader(http.StatusGone)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(ts.Close)
+	return ts, func(c string) int {
+		mu.Lock()
+		defer mu.Unlock()
+		return calls[c]
+	}
+}
+
+func TestDmcaCache(t *testing.T) {
+	clock := time.Unix(0, 0)
+	now = func() time.Time { return clock }
+	t.Cleanup(func() { now = time.Now })
+
+	ts, calls := newCountingDmcaService(t)
+	cfg := &config.Config{
+		ConfigPinningService: config.ConfigPinningService{
+			PinningService: ts.URL,
+			DmcaAllowedTTL: config.NewOptionalDuration(time.Minute),
+		},
+	}
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	handler := DedicatedGatewayMiddleware(next, nil, cfg)
+
+	get := func(cid string) int {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/ipfs/"+cid, nil))
+		return w.Code
+	}
+
+	for i := 0; i < 3; i++ {
+		if status := get(testCid); status != http.StatusOK {
+			t.Fatalf("expected status %d, got %d", http.StatusOK, status)
+		}
+		if status := get(testBlockedCid); status != http.StatusGone {
+			t.Fatalf("expected status %d, got %d", http.StatusGone, status)
+		}
+	}
+	if n := calls(testCid); n != 1 {
+		t.Fatalf("expected 1 DMCA call for allowed content, got %d", n)
+	}
+	if n := calls(testBlockedCid); n != 1 {
+		t.Fatalf("expected 1 DMCA call for blocked content, got %d", n)
+	}
+
+	clock = clock.Add(2 * time.Minute)
+	get(testCid)
+	get(testBlockedCid)
+	if n := calls(testCid); n != 2 {
+		t.Fatalf("expected allowed content to be checked again after the TTL, got %d calls", n)
+	}
+	if n := calls(testBlockedCid); n != 1 {
+		t.Fatalf("expected blocked content to stay cached, got %d calls", n)
+	}
+}
+
+func TestDmcaCacheEviction(t *testing.T) {
+	ts, calls := newCountingDmcaService(t)
+	cfg := &config.Config{
+		ConfigPinningService: config.ConfigPinningService{
+			PinningService: ts.URL,
+			DmcaCacheSize:  1,
+		},
+	}
+	ctx := context.Background()
+	cache := newDmcaCache(cfg.ConfigPinningService)
+	allowed := cid.MustParse(testCid)
+	blocked := cid.MustParse(testBlockedCid)
+
+	cache.check(ctx, allowed, cfg)
+	cache.check(ctx, blocked, cfg)
+	cache.check(ctx, allowed, cfg)
+	if n := calls(testCid); n != 2 {
+		t.Fatalf("expected the least recently used entry to be evicted, got %d calls", n)
+	}
+	if _, ok := cache.get(normalizeCIDKey(blocked)); ok {
+		t.Fatal("expected the cache to hold a single entry")
+	}
+}
+
+func TestDmcaFailMode(t *testing.T) {
+	origBackoff := dmcaRetryBackoff
+	dmcaRetryBackoff = time.Millisecond
+	t.Cleanup(func() { dmcaRetryBackoff = origBackoff })
+
+	for _, tc := range []struct {
+		name     string
+		upstream func(w http.ResponseWriter, r *http.Request)
+		calls    int
+		closed   int
+		open     int
+	}{
+		{
+			name: "timeout",
+			upstream: func(w http.ResponseWriter, r *http.Request) {
+				select {
+				case <-r.Context().Done():
+				case <-time.After(5 * time.Second):
+				}
+			},
+			calls:  2,
+			closed: http.StatusInternalServerError,
+			open:   http.StatusOK,
+		},
+		{
+			name:     "server error",
+			upstream: func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusInternalServerError) },
+			calls:    1,
+			closed:   http.StatusInternalServerError,
+			open:     http.StatusOK,
+		},
+		{
+			name:     "blocked",
+			upstream: func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusGone) },
+			calls:    1,
+			closed:   http.StatusGone,
+			open:     http.StatusGone,
+		},
+	} {
+		for _, mode := range []string{config.DmcaFailClosed, config.DmcaFailOpen} {
+			t.Run(tc.name+"/"+mode, func(t *testing.T) {
+				var mu sync.Mutex
+				var calls int
+				ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					mu.Lock()
+					calls++
+					mu.Unlock()
+					tc.upstream(w, r)
+				}))
+				t.Cleanup(ts.Close)
+
+				cfg := &config.Config{
+					ConfigPinningService: config.ConfigPinningService{
+						PinningService:  ts.URL,
+						DmcaFailMode:    mode,
+						UpstreamTimeout: config.NewOptionalDuration(50 * time.Millisecond),
+					},
+				}
+				status, _ := newDmcaCache(cfg.ConfigPinningService).check(context.Background(), cid.MustParse(testCid), cfg)
+				want := tc.closed
+				if mode == config.DmcaFailOpen {
+					want = tc.open
+				}
+				if status != want {
+					t.Fatalf("expected status %d, got %d", want, status)
+				}
+				mu.Lock()
+				defer mu.Unlock()
+				if calls != tc.calls {
+					t.Fatalf("expected %d DMCA calls, got %d", tc.calls, calls)
+				}
+			})
+		}
+	}
+}
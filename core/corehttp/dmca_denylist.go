@@ -0,0 +1,352 @@
+package corehttp
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	config "github.com/ipfs/kubo/config"
+	core "github.com/ipfs/kubo/core"
+	"github.com/multiformats/go-multihash"
+)
+
+// denylistCheckInterval is how often the denylist file is checked for
+// changes.
+const denylistCheckInterval = 10 * time.Second
+
+// errContentBlocked is returned for content blocked by the DMCA check or the
+// local denylist.
+var errContentBlocked = errors.New("The content that you requested has been blocked because of legal, abuse, malware or security reasons. Please contact support@aiozpin.network for more information")
+
+// dmcaDenylist is the content listed in one of the denylist files of
+// ConfigPinningService, blocked without calling the pinning service. The file
+// is reloaded when it changes, and picked up if it only appears after
+// startup.
+type dmcaDenylist struct {
+	path string
+
+	mu      sync.Mutex
+	blocked map[string]struct{}
+	allowed map[string]struct{}
+	mod     time.Time
+	checked time.Time
+}
+
+// newDmcaDenylist loads the denylist at path, logging the error if it can't.
+func newDmcaDenylist(path string) *dmcaDenylist {
+	d := &dmcaDenylist{path: path}
+	if err := d.load(); err != nil {
+		log.Errorf("loading DMCA denylist: %s", err)
+	}
+	d.checked = now()
+	return d
+}
+
+// load reads the file, remembering the modification time it was read at.
+func (d *dmcaDenylist) load() error {
+	fi, err := os.Stat(d.path)
+	if err != nil {
+		return err
+	}
+	f, err := os.Open(d.path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	blocked, allowed, err := parseDenylist(f.Name(), bufio.NewScanner(f))
+	if err != nil {
+		return err
+	}
+	d.blocked, d.allowed, d.mod = blocked, allowed, fi.ModTime()
+	return nil
+}
+
+// entries returns the keys blocked and allowed by the file, reloading it
+// first if it changed since it was last checked, at most every
+// denylistCheckInterval. A file that fails to load is retried on the next
+// check while the previous list stays in use. The maps returned are never
+// modified.
+func (d *dmcaDenylist) entries() (blocked, allowed map[string]struct{}) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if t := now(); t.Sub(d.checked) >= denylistCheckInterval {
+		d.checked = t
+		fi, err := os.Stat(d.path)
+		if err != nil {
+			log.Errorf("checking DMCA denylist: %s", err)
+		} else if !fi.ModTime().Equal(d.mod) {
+			if err := d.load(); err != nil {
+				log.Errorf("reloading DMCA denylist, keeping the previous one: %s", err)
+			} else {
+				log.Infof("reloaded DMCA denylist from %s", d.path)
+			}
+		}
+	}
+	return d.blocked, d.allowed
+}
+
+// denylistPushedSource names the pushed entries in the sources of an export.
+const denylistPushedSource = "pushed"
+
+// pushedDenylist holds the entries pushed to DenylistOption, until the
+// daemon restarts. It is shared by all the gateways.
+var pushedDenylist denylistEntries
+
+// denylistEntries are the keys blocked and allowed by pushed entries.
+type denylistEntries struct {
+	mu      sync.Mutex
+	blocked map[string]struct{}
+	allowed map[string]struct{}
+}
+
+// push adds the blocked and allowed keys, each overriding the opposite
+// entry pushed earlier for the same key.
+func (p *denylistEntries) push(blocked, allowed map[string]struct{}) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	// the maps are replaced rather than modified, see entries
+	nb, na := maps.Clone(p.blocked), maps.Clone(p.allowed)
+	if nb == nil {
+		nb = make(map[string]struct{})
+	}
+	if na == nil {
+		na = make(map[string]struct{})
+	}
+	for key := range blocked {
+		nb[key] = struct{}{}
+		delete(na, key)
+	}
+	for key := range allowed {
+		na[key] = struct{}{}
+		delete(nb, key)
+	}
+	p.blocked, p.allowed = nb, na
+}
+
+// entries returns the keys pushed so far. The maps returned are never
+// modified.
+func (p *denylistEntries) entries() (blocked, allowed map[string]struct{}) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.blocked, p.allowed
+}
+
+// dmcaDenylists merges the denylist files of ConfigPinningService with the
+// pushed entries. Content is blocked when any of them blocks it, unless any
+// of them allows it: allow rules, the negated "!" rules of the IPFS denylist
+// format, override block rules from every source.
+type dmcaDenylists []*dmcaDenylist
+
+// denylistPaths returns the denylist files configured in cfg.
+func denylistPaths(cfg config.ConfigPinningService) []string {
+	var paths []string
+	if cfg.DmcaDenylistPath != "" {
+		paths = append(paths, cfg.DmcaDenylistPath)
+	}
+	return append(paths, cfg.DmcaDenylistPaths...)
+}
+
+// newDmcaDenylists loads the denylist files of cfg.
+func newDmcaDenylists(cfg config.ConfigPinningService) dmcaDenylists {
+	var lists dmcaDenylists
+	for _, path := range denylistPaths(cfg) {
+		lists = append(lists, newDmcaDenylist(path))
+	}
+	return lists
+}
+
+// each calls f with the entries of every denylist file, then with the pushed
+// entries.
+func (lists dmcaDenylists) each(f func(blocked, allowed map[string]struct{})) {
+	for _, d := range lists {
+		f(d.entries())
+	}
+	f(pushedDenylist.entries())
+}
+
+// contains reports whether the normalized CID key is blocked.
+func (lists dmcaDenylists) contains(key string) bool {
+	var blocked, allowed bool
+	lists.each(func(b, a map[string]struct{}) {
+		_, inBlocked := b[key]
+		_, inAllowed := a[key]
+		blocked = blocked || inBlocked
+		allowed = allowed || inAllowed
+	})
+	return blocked && !allowed
+}
+
+// denylistExport is the effective denylist at a point in time, as exported
+// for audit: the normalized CID keys blocked, sorted, along with the SHA-256
+// of those keys, each followed by a newline.
+type denylistExport struct {
+	Time    time.Time `json:"time"`
+	Sources []string  `json:"sources"`
+	Entries []string  `json:"entries"`
+	SHA256  string    `json:"sha256"`
+}
+
+// export returns the effective denylist.
+func (lists dmcaDenylists) export() denylistExport {
+	blocked := make(map[string]struct{})
+	allowed := make(map[string]struct{})
+	lists.each(func(b, a map[string]struct{}) {
+		maps.Copy(blocked, b)
+		maps.Copy(allowed, a)
+	})
+	exp := denylistExport{Time: now().UTC(), Sources: []string{}, Entries: []string{}}
+	for _, d := range lists {
+		exp.Sources = append(exp.Sources, d.path)
+	}
+	if b, a := pushedDenylist.entries(); len(b) > 0 || len(a) > 0 {
+		exp.Sources = append(exp.Sources, denylistPushedSource)
+	}
+
+	h := sha256.New()
+	for key := range blocked {
+		if _, ok := allowed[key]; !ok {
+			exp.Entries = append(exp.Entries, key)
+		}
+	}
+	slices.Sort(exp.Entries)
+	for _, key := range exp.Entries {
+		io.WriteString(h, key+"\n")
+	}
+	exp.SHA256 = hex.EncodeToString(h.Sum(nil))
+	return exp
+}
+
+// parseDenylist returns the normalized CID keys of the entries read from
+// s, those blocked and those allowed by a negated "!" rule. Entries are CIDs
+// or multihashes, base58 or hex encoded, one per line. The IPFS denylist
+// format is accepted loosely: the header ending with "---", comments, and
+// the rules that can't be matched against a whole CID, such as sub-path,
+// IPNS and double-hashed rules, are skipped.
+func parseDenylist(name string, s *bufio.Scanner) (blocked, allowed map[string]struct{}, err error) {
+	var lines []string
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if line == "---" {
+			// everything so far was the header
+			lines = lines[:0]
+			continue
+		}
+		lines = append(lines, line)
+	}
+	if err := s.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	blocked = make(map[string]struct{})
+	allowed = make(map[string]struct{})
+	for _, line := range lines {
+		entry, negated := strings.CutPrefix(line, "!")
+		if entry == "" || strings.HasPrefix(entry, "#") ||
+			strings.HasPrefix(entry, "//") || strings.HasPrefix(entry, "/ipns/") {
+			continue
+		}
+		if rest, ok := strings.CutPrefix(entry, "/ipfs/"); ok {
+			root, sub, _ := strings.Cut(rest, "/")
+			if sub != "" && sub != "*" {
+				continue
+			}
+			entry = root
+		}
+		key, ok := denylistKey(entry)
+		if !ok {
+			log.Warnf("%s: skipping unrecognized denylist entry %q", name, line)
+			continue
+		}
+		if negated {
+			allowed[key] = struct{}{}
+		} else {
+			blocked[key] = struct{}{}
+		}
+	}
+	return blocked, allowed, nil
+}
+
+// denylistKey returns the normalized CID key of a CID or a multihash.
+func denylistKey(entry string) (string, bool) {
+	if c, err := cid.Decode(entry); err == nil {
+		return normalizeCIDKey(c), true
+	}
+	if mh, err := multihash.FromB58String(entry); err == nil {
+		return mh.HexString(), true
+	}
+	if mh, err := multihash.FromHexString(entry); err == nil {
+		return mh.HexString(), true
+	}
+	return "", false
+}
+
+// DenylistOption exports the effective denylist of the gateways on GET
+// requests to path, as JSON: the denylist files of ConfigPinningService
+// merged with the pushed entries, less the content they allow. A POST pushes
+// the denylist entries of its body, in the same format as the files, which
+// stay in use until the daemon restarts, and returns the export updated.
+// Requests must carry ConfigPinningService.AdminToken as a bearer token, the
+// endpoint is disabled when it is not set. It is meant for the API server.
+func DenylistOption(path string) ServeOption {
+	return func(n *core.IpfsNode, _ net.Listener, mux *http.ServeMux) (*http.ServeMux, error) {
+		mux.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
+			cfg, err := n.Repo.Config()
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			if status, err := checkAdminToken(r, cfg.ConfigPinningService.AdminToken); err != nil {
+				http.Error(w, err.Error(), status)
+				return
+			}
+
+			switch r.Method {
+			case http.MethodGet:
+			case http.MethodPost:
+				blocked, allowed, err := parseDenylist("pushed denylist", bufio.NewScanner(r.Body))
+				if err != nil {
+					http.Error(w, err.Error(), http.StatusBadRequest)
+					return
+				}
+				pushedDenylist.push(blocked, allowed)
+				log.Infof("pushed %d blocked and %d allowed denylist entries", len(blocked), len(allowed))
+			default:
+				http.Error(w, "only GET and POST allowed", http.StatusMethodNotAllowed)
+				return
+			}
+
+			// the files are read as they are now, rather than as last
+			// checked by the gateways
+			var lists dmcaDenylists
+			for _, path := range denylistPaths(cfg.ConfigPinningService) {
+				d := &dmcaDenylist{path: path, checked: now()}
+				if err := d.load(); err != nil {
+					http.Error(w, fmt.Sprintf("loading denylist: %s", err), http.StatusInternalServerError)
+					return
+				}
+				lists = append(lists, d)
+			}
+			w.Header().Set("Content-Type", "application/json")
+			if err := json.NewEncoder(w).Encode(lists.export()); err != nil {
+				log.Debugf("writing denylist export: %s", err)
+			}
+		})
+		return mux, nil
+	}
+}
@@ -0,0 +1,254 @@
+package corehttp
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/kubo/config"
+	core "github.com/ipfs/kubo/core"
+	"github.com/ipfs/kubo/repo"
+)
+
+func TestParseDenylist(t *testing.T) {
+	const (
+		v0    = "QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n"
+		other = "bafkreifjjcie6lypi6ny7amxnfftagclbuxndqonfipmb64f2km2devei4"
+	)
+	c := cid.MustParse(v0)
+	v1 := cid.NewCidV1(cid.DagProtobuf, c.Hash())
+	deny := `version: 1
+name: test
+---
+# blocked content
+/ipfs/` + v0 + `
+/ipfs/` + testBlockedCid + `/*
+/ipfs/` + other + `/only/this/path
+!/ipfs/` + testCid + `
+//d9d295bde21f422d471a90f2a37ec53049fdf3e5fa3ee2e8f20e10003da429e7
+/ipns/example.com
+
+` + c.Hash().HexString() + `
+not-a-cid
+`
+	blocked, allowed, err := parseDenylist("test.deny", bufio.NewScanner(strings.NewReader(deny)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(blocked) != 2 {
+		t.Fatalf("expected 2 entries, got %d", len(blocked))
+	}
+	if _, ok := allowed[normalizeCIDKey(cid.MustParse(testCid))]; !ok || len(allowed) != 1 {
+		t.Fatalf("expected %s to be allowed, got %v", testCid, allowed)
+	}
+	for _, c := range []string{v0, v1.String(), testBlockedCid} {
+		if _, ok := blocked[normalizeCIDKey(cid.MustParse(c))]; !ok {
+			t.Fatalf("expected %s to be denylisted", c)
+		}
+	}
+	for _, c := range []string{other, testCid} {
+		if _, ok := blocked[normalizeCIDKey(cid.MustParse(c))]; ok {
+			t.Fatalf("expected %s not to be denylisted", c)
+		}
+	}
+}
+
+func TestDmcaDenylist(t *testing.T) {
+	clock := time.Now()
+	now = func() time.Time { return clock }
+	t.Cleanup(func() { now = time.Now })
+
+	path := filepath.Join(t.TempDir(), "dmca.deny")
+	if err := os.WriteFile(path, []byte(testCid+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	ts, calls := newCountingDmcaService(t)
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	handler := DedicatedGatewayMiddleware(next, nil, &config.Config{
+		ConfigPinningService: config.ConfigPinningService{
+			PinningService:   ts.URL,
+			DmcaDenylistPath: path,
+		},
+	})
+	t.Cleanup(func() {
+		mtx.Lock()
+		clear(ipLimiters)
+		clear(cidLimiters)
+		mtx.Unlock()
+	})
+	get := func(c string) int {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/ipfs/"+c, nil))
+		return w.Code
+	}
+
+	if code := get(testCid); code != http.StatusGone {
+		t.Fatalf("expected denylisted content to be blocked, got %d", code)
+	}
+	if n := calls(testCid); n != 0 {
+		t.Fatalf("expected no DMCA call for denylisted content, got %d", n)
+	}
+
+	// replace the list, with a modification time distinct from the first
+	// one even on filesystems with a coarse resolution
+	if err := os.WriteFile(path, []byte("/ipfs/"+testBlockedCid+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(path, later, later); err != nil {
+		t.Fatal(err)
+	}
+	if code := get(testCid); code != http.StatusGone {
+		t.Fatalf("expected the file to be checked at most every %s, got %d", denylistCheckInterval, code)
+	}
+
+	clock = clock.Add(denylistCheckInterval)
+	if code := get(testCid); code != http.StatusOK {
+		t.Fatalf("expected content removed from the list to be served, got %d", code)
+	}
+	if n := calls(testCid); n != 1 {
+		t.Fatalf("expected the DMCA check once off the list, got %d calls", n)
+	}
+	if code := get(testBlockedCid); code != http.StatusGone {
+		t.Fatalf("expected newly denylisted content to be blocked, got %d", code)
+	}
+	if n := calls(testBlockedCid); n != 0 {
+		t.Fatalf("expected no DMCA call for denylisted content, got %d", n)
+	}
+
+	// a list that fails to load keeps the previous one in use
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	clock = clock.Add(denylistCheckInterval)
+	if code := get(testBlockedCid); code != http.StatusGone {
+		t.Fatalf("expected the previous list to stay in use, got %d", code)
+	}
+}
+
+func TestDenylistExport(t *testing.T) {
+	const (
+		v0     = "QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n"
+		pushed = "bafkreifjjcie6lypi6ny7amxnfftagclbuxndqonfipmb64f2km2devei4"
+	)
+	clock := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	now = func() time.Time { return clock }
+	t.Cleanup(func() {
+		now = time.Now
+		pushedDenylist = denylistEntries{}
+		mtx.Lock()
+		clear(ipLimiters)
+		clear(cidLimiters)
+		mtx.Unlock()
+	})
+
+	dir := t.TempDir()
+	first := filepath.Join(dir, "first.deny")
+	second := filepath.Join(dir, "second.deny")
+	if err := os.WriteFile(first, []byte(v0+"\n"+testCid+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	// the second list allows content the first one blocks
+	if err := os.WriteFile(second, []byte(testBlockedCid+"\n!"+testCid+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	ts, calls := newCountingDmcaService(t)
+	cfg := config.Config{ConfigPinningService: config.ConfigPinningService{
+		PinningService:    ts.URL,
+		AdminToken:        "secret",
+		DmcaDenylistPath:  first,
+		DmcaDenylistPaths: []string{second},
+	}}
+	mux, err := DenylistOption("/debug/denylist")(&core.IpfsNode{Repo: &repo.Mock{C: cfg}}, nil, http.NewServeMux())
+	if err != nil {
+		t.Fatal(err)
+	}
+	export := func(method, body string) denylistExport {
+		t.Helper()
+		r := httptest.NewRequest(method, "/debug/denylist", strings.NewReader(body))
+		r.Header.Set("Authorization", "Bearer secret")
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, r)
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected 200, got %d: %s", w.Code, w.Body)
+		}
+		var exp denylistExport
+		if err := json.NewDecoder(w.Body).Decode(&exp); err != nil {
+			t.Fatal(err)
+		}
+		if !exp.Time.Equal(clock) {
+			t.Fatalf("expected the export to be timestamped %s, got %s", clock, exp.Time)
+		}
+		h := sha256.New()
+		for _, key := range exp.Entries {
+			h.Write([]byte(key + "\n"))
+		}
+		if sum := hex.EncodeToString(h.Sum(nil)); exp.SHA256 != sum {
+			t.Fatalf("expected the hash of the entries %s, got %s", sum, exp.SHA256)
+		}
+		return exp
+	}
+	keys := func(cids ...string) []string {
+		var keys []string
+		for _, c := range cids {
+			keys = append(keys, normalizeCIDKey(cid.MustParse(c)))
+		}
+		slices.Sort(keys)
+		return keys
+	}
+
+	exp := export(http.MethodGet, "")
+	if want := keys(v0, testBlockedCid); !slices.Equal(exp.Entries, want) {
+		t.Fatalf("expected the entries of both files less the allowed ones %v, got %v", want, exp.Entries)
+	}
+	if want := []string{first, second}; !slices.Equal(exp.Sources, want) {
+		t.Fatalf("expected sources %v, got %v", want, exp.Sources)
+	}
+
+	exp = export(http.MethodPost, "/ipfs/"+pushed+"\n!"+v0+"\n")
+	if want := keys(pushed, testBlockedCid); !slices.Equal(exp.Entries, want) {
+		t.Fatalf("expected the pushed entries to be merged %v, got %v", want, exp.Entries)
+	}
+	if want := []string{first, second, denylistPushedSource}; !slices.Equal(exp.Sources, want) {
+		t.Fatalf("expected sources %v, got %v", want, exp.Sources)
+	}
+	if again := export(http.MethodGet, ""); again.SHA256 != exp.SHA256 {
+		t.Fatalf("expected the same entries to hash the same, got %s and %s", exp.SHA256, again.SHA256)
+	}
+
+	// the gateways enforce the export
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	gw := DedicatedGatewayMiddleware(next, nil, &cfg)
+	get := func(c string) int {
+		w := httptest.NewRecorder()
+		gw.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/ipfs/"+c, nil))
+		return w.Code
+	}
+	if code := get(pushed); code != http.StatusGone {
+		t.Fatalf("expected pushed content to be blocked, got %d", code)
+	}
+	if n := calls(pushed); n != 0 {
+		t.Fatalf("expected no DMCA call for pushed content, got %d", n)
+	}
+	for _, c := range []string{v0, testCid} {
+		if code := get(c); code != http.StatusOK {
+			t.Fatalf("expected allowed content %s to be served, got %d", c, code)
+		}
+	}
+}
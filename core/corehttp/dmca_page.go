@@ -0,0 +1,57 @@
+package corehttp
+
+import (
+	"bytes"
+	"html/template"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// dmcaPageData is passed to the ConfigPinningService.DmcaBlockedPage
+// template.
+type dmcaPageData struct {
+	CID string
+}
+
+// loadDmcaPage parses the HTML template at path, or returns nil if path is
+// empty or the template can't be read.
+func loadDmcaPage(path string) *template.Template {
+	if path == "" {
+		return nil
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		log.Warnf("serving DMCA blocks as text, cannot read DmcaBlockedPage: %s", err)
+		return nil
+	}
+	page, err := template.New("dmca").Parse(string(b))
+	if err != nil {
+		log.Warnf("serving DMCA blocks as text, invalid DmcaBlockedPage: %s", err)
+		return nil
+	}
+	return page
+}
+
+// dmcaBlocked answers a request for content blocked for DMCA reasons with
+// 410, rendering page for the CID c. Without page, or when the client
+// accepts JSON, the plain text message is sent instead.
+func dmcaBlocked(w http.ResponseWriter, r *http.Request, c string, page *template.Template) {
+	if page == nil || strings.Contains(r.Header.Get("Accept"), "application/json") {
+		http.Error(w, errContentBlocked.Error(), http.StatusGone)
+		return
+	}
+	// rendered first so that a failing template still gets the text
+	var buf bytes.Buffer
+	if err := page.Execute(&buf, dmcaPageData{CID: c}); err != nil {
+		log.Warnf("rendering DmcaBlockedPage for %s: %s", c, err)
+		http.Error(w, errContentBlocked.Error(), http.StatusGone)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(http.StatusGone)
+	if _, err := buf.WriteTo(w); err != nil {
+		log.Debugf("writing DMCA page: %s", err)
+	}
+}
@@ -0,0 +1,69 @@
+package corehttp
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ipfs/kubo/config"
+)
+
+func TestDmcaBlockedPage(t *testing.T) {
+	ts, _ := newCountingDmcaService(t)
+	t.Cleanup(func() {
+		mtx.Lock()
+		clear(ipLimiters)
+		clear(cidLimiters)
+		mtx.Unlock()
+	})
+	page := filepath.Join(t.TempDir(), "blocked.html")
+	if err := os.WriteFile(page, []byte("<h1>Blocked</h1><p>{{.CID}} is not available.</p>"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	get := func(blockedPage, accept string) *httptest.ResponseRecorder {
+		handler := DedicatedGatewayMiddleware(next, nil, &config.Config{
+			ConfigPinningService: config.ConfigPinningService{
+				PinningService:  ts.URL,
+				DmcaBlockedPage: blockedPage,
+			},
+		})
+		r := httptest.NewRequest(http.MethodGet, "/ipfs/"+testBlockedCid, nil)
+		if accept != "" {
+			r.Header.Set("Accept", accept)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		if w.Code != http.StatusGone {
+			t.Fatalf("expected 410, got %d", w.Code)
+		}
+		return w
+	}
+	expectText := func(w *httptest.ResponseRecorder) {
+		t.Helper()
+		if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain") {
+			t.Fatalf("expected the text message, got %q", ct)
+		}
+		if body := w.Body.String(); body != errContentBlocked.Error()+"\n" {
+			t.Fatalf("expected the text message, got %q", body)
+		}
+	}
+
+	w := get(page, "text/html,application/xhtml+xml,*/*;q=0.8")
+	if ct := w.Header().Get("Content-Type"); ct != "text/html; charset=utf-8" {
+		t.Fatalf("expected an HTML page, got %q", ct)
+	}
+	if body := w.Body.String(); body != "<h1>Blocked</h1><p>"+testBlockedCid+" is not available.</p>" {
+		t.Fatalf("expected the page to name the CID, got %q", body)
+	}
+
+	expectText(get(page, "application/json"))
+	expectText(get("", "text/html"))
+	// a page that can't be read falls back to the text as well
+	expectText(get(filepath.Join(t.TempDir(), "missing.html"), "text/html"))
+}
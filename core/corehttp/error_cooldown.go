@@ -0,0 +1,97 @@
+package corehttp
+
+import (
+	"container/list"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	defaultAccessErrorCooldown = 10 * time.Second
+
+	// defaultErrorCooldownSize is the number of CIDs cooling down past which
+	// the least recently used ones are evicted, unless
+	// ConfigPinningService.AccessErrorCooldownSize is set.
+	defaultErrorCooldownSize = 10000
+)
+
+// errorCooldown remembers CIDs whose upstream access check failed, so
+// requests for them fail fast for a while instead of calling the pinning
+// service again. The least recently used entries are evicted past size.
+type errorCooldown struct {
+	mu     sync.Mutex
+	size   int
+	window time.Duration
+	ll     *list.List
+	items  map[string]*list.Element
+}
+
+type cooldownEntry struct {
+	key    string
+	status int
+	err    error
+	until  time.Time
+}
+
+func newErrorCooldown(size int, window time.Duration) *errorCooldown {
+	if size <= 0 {
+		size = defaultErrorCooldownSize
+	}
+	return &errorCooldown{
+		size:   size,
+		window: window,
+		ll:     list.New(),
+		items:  make(map[string]*list.Element),
+	}
+}
+
+// failed returns the recorded failure for key if it is still cooling down.
+func (c *errorCooldown) failed(key string) (int, error, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.items[key]
+	if !ok {
+		return 0, nil, false
+	}
+	e := elem.Value.(*cooldownEntry)
+	if !now().Before(e.until) {
+		c.ll.Remove(elem)
+		delete(c.items, key)
+		return 0, nil, false
+	}
+	c.ll.MoveToFront(elem)
+	return e.status, e.err, true
+}
+
+// record starts a cooldown for key if status reports an upstream failure.
+// Definitive answers, such as blocked content, are left alone.
+func (c *errorCooldown) record(key string, status int, err error) {
+	if status < http.StatusInternalServerError {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e := &cooldownEntry{key: key, status: status, err: err, until: now().Add(c.window)}
+	if elem, ok := c.items[key]; ok {
+		elem.Value = e
+		c.ll.MoveToFront(elem)
+		return
+	}
+	c.items[key] = c.ll.PushFront(e)
+	for c.ll.Len() > c.size {
+		oldest := c.ll.Back()
+		c.ll.Remove(oldest)
+		delete(c.items, oldest.Value.(*cooldownEntry).key)
+	}
+}
+
+// setWindow changes the cooldown of failures recorded from now on.
+func (c *errorCooldown) setWindow(window time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.window = window
+}
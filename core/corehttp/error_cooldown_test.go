@@ -0,0 +1,91 @@
+package corehttp
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ipfs/kubo/config"
+)
+
+func TestAccessErrorCooldown(t *testing.T) {
+	clock := time.Unix(0, 0)
+	now = func() time.Time { return clock }
+	t.Cleanup(func() { now = time.Now })
+
+	var calls atomic.Int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	t.Cleanup(ts.Close)
+
+	cfg := &config.Config{
+		ConfigPinningService: config.ConfigPinningService{
+			PinningService:      ts.URL,
+			DedicatedGateway:    true,
+			AccessErrorCooldown: config.NewOptionalDuration(30 * time.Second),
+		},
+	}
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	handler := DedicatedGatewayMiddleware(next, nil, cfg)
+
+	get := func() int {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/ipfs/"+testCid, nil))
+		return w.Code
+	}
+
+	if status := get(); status != http.StatusServiceUnavailable {
+		t.Fatalf("expected status %d, got %d", http.StatusServiceUnavailable, status)
+	}
+	if status := get(); status != http.StatusServiceUnavailable {
+		t.Fatalf("expected status %d within the cooldown, got %d", http.StatusServiceUnavailable, status)
+	}
+	if n := calls.Load(); n != 1 {
+		t.Fatalf("expected a single upstream call within the cooldown, got %d", n)
+	}
+
+	clock = clock.Add(time.Minute)
+	get()
+	if n := calls.Load(); n != 2 {
+		t.Fatalf("expected upstream to be called again after the cooldown, got %d calls", n)
+	}
+}
+
+func TestErrorCooldownIgnoresDefinitiveAnswers(t *testing.T) {
+	c := newErrorCooldown(0, time.Minute)
+	c.record(testCid, http.StatusGone, http.ErrAbortHandler)
+	if _, _, ok := c.failed(testCid); ok {
+		t.Fatal("expected no cooldown for a definitive answer")
+	}
+}
+
+func TestErrorCooldownSize(t *testing.T) {
+	const size = 10
+	c := newErrorCooldown(size, time.Minute)
+	key := func(i int) string { return fmt.Sprintf("cid-%d", i) }
+	for i := 0; i < 3*size; i++ {
+		c.record(key(i), http.StatusBadGateway, http.ErrAbortHandler)
+		// the first CID is kept by being looked up
+		if _, _, ok := c.failed(key(0)); !ok {
+			t.Fatalf("expected the recently used %s to be kept", key(0))
+		}
+	}
+	if n := c.ll.Len(); n != size || len(c.items) != size {
+		t.Fatalf("expected %d entries, got %d listed and %d indexed", size, n, len(c.items))
+	}
+	for i := 1; i < 2*size+1; i++ {
+		if _, _, ok := c.failed(key(i)); ok {
+			t.Fatalf("expected the oldest entry %s to be evicted", key(i))
+		}
+	}
+	if _, _, ok := c.failed(key(3*size - 1)); !ok {
+		t.Fatal("expected the newest entry to be kept")
+	}
+}
@@ -0,0 +1,98 @@
+package corehttp
+
+import (
+	"net"
+	"net/http"
+	"strings"
+
+	cid "github.com/ipfs/go-cid"
+	core "github.com/ipfs/kubo/core"
+	mc "github.com/multiformats/go-multicodec"
+)
+
+// gatewayFormatTypes are the prefixes of the Accept media types for which the
+// gateway answers with another representation than the content itself, such
+// as a raw block or a CAR, each with its own ETag.
+var gatewayFormatTypes = []string{
+	"application/vnd.ipld.",
+	"application/vnd.ipfs.",
+	"application/x-tar",
+	"application/json",
+	"application/cbor",
+}
+
+// ETagOption answers the GET and HEAD requests for /ipfs/<cid> with 304 Not
+// Modified when If-None-Match lists the ETag of the content, "<cid>", before
+// anything is fetched. Content being immutable, a client holding that ETag
+// has the response already. Other responses for /ipfs/<cid> are given that
+// strong ETag when the gateway didn't set one.
+//
+// Requests for another representation of the content, through the format
+// query parameter or the Accept header, and for paths within it are passed
+// through, as their ETag differs. Range requests are passed through as well
+// unless If-None-Match matches, If-None-Match taking precedence over Range.
+func ETagOption() ServeOption {
+	return func(_ *core.IpfsNode, _ net.Listener, parent *http.ServeMux) (*http.ServeMux, error) {
+		mux := http.NewServeMux()
+		parent.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+			etag, ok := contentETag(r)
+			if !ok {
+				mux.ServeHTTP(w, r)
+				return
+			}
+			if etagListed(r.Header.Get("If-None-Match"), etag) {
+				w.Header().Set("Etag", etag)
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+			mux.ServeHTTP(&headerWriter{ResponseWriter: w, setHeader: func(h http.Header, status int) {
+				// successful responses that have no ETag are given etag
+				if (status == http.StatusOK || status == http.StatusPartialContent) && h.Get("Etag") == "" {
+					h.Set("Etag", etag)
+				}
+			}}, r)
+		})
+		return mux, nil
+	}
+}
+
+// contentETag returns the ETag the gateway gives to the response to r, when
+// r asks for the content of a CID as is.
+func contentETag(r *http.Request) (string, bool) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return "", false
+	}
+	s, ok := strings.CutPrefix(r.URL.Path, "/ipfs/")
+	if !ok || s == "" || strings.Contains(s, "/") {
+		return "", false
+	}
+	if r.URL.Query().Has("format") {
+		return "", false
+	}
+	accept := r.Header.Get("Accept")
+	for _, t := range gatewayFormatTypes {
+		if strings.Contains(accept, t) {
+			return "", false
+		}
+	}
+	c, err := cid.Decode(s)
+	if err != nil {
+		return "", false
+	}
+	// other codecs are served as JSON or CBOR, their ETag naming the format
+	if codec := mc.Code(c.Prefix().Codec); codec != mc.DagPb && codec != mc.Raw {
+		return "", false
+	}
+	return `"` + c.String() + `"`, true
+}
+
+// etagListed reports whether the If-None-Match header value lists etag,
+// compared weakly as required for If-None-Match.
+func etagListed(ifNoneMatch, etag string) bool {
+	for _, v := range strings.Split(ifNoneMatch, ",") {
+		if strings.TrimPrefix(strings.TrimSpace(v), "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,77 @@
+package corehttp
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ipfs/kubo/config"
+	core "github.com/ipfs/kubo/core"
+	"github.com/ipfs/kubo/repo"
+)
+
+func TestETag(t *testing.T) {
+	served := 0
+	serve := func(_ *core.IpfsNode, _ net.Listener, mux *http.ServeMux) (*http.ServeMux, error) {
+		mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+			served++
+			if r.Header.Get("Range") != "" {
+				w.Header().Set("Content-Range", "bytes 0-1/5")
+				w.WriteHeader(http.StatusPartialContent)
+				w.Write([]byte("he"))
+				return
+			}
+			w.Write([]byte("hello"))
+		})
+		return mux, nil
+	}
+	n := &core.IpfsNode{Repo: &repo.Mock{C: config.Config{}}}
+	handler, err := MakeHandler(n, nil, ETagOption(), serve)
+	if err != nil {
+		t.Fatal(err)
+	}
+	etag := `"` + testCid + `"`
+
+	for _, tc := range []struct {
+		name        string
+		target      string
+		header      map[string]string
+		status      int
+		etag        string
+		passThrough bool
+	}{
+		{"no etag", "/ipfs/" + testCid, nil, http.StatusOK, etag, true},
+		{"matching etag", "/ipfs/" + testCid, map[string]string{"If-None-Match": etag}, http.StatusNotModified, etag, false},
+		{"weak matching etag", "/ipfs/" + testCid, map[string]string{"If-None-Match": `"other", W/` + etag}, http.StatusNotModified, etag, false},
+		{"other etag", "/ipfs/" + testCid, map[string]string{"If-None-Match": `"other"`}, http.StatusOK, etag, true},
+		{"range", "/ipfs/" + testCid, map[string]string{"Range": "bytes=0-1"}, http.StatusPartialContent, etag, true},
+		{"range with matching etag", "/ipfs/" + testCid, map[string]string{"Range": "bytes=0-1", "If-None-Match": etag}, http.StatusNotModified, etag, false},
+		{"sub path", "/ipfs/" + testCid + "/a", map[string]string{"If-None-Match": etag}, http.StatusOK, "", true},
+		{"format", "/ipfs/" + testCid + "?format=raw", map[string]string{"If-None-Match": etag}, http.StatusOK, "", true},
+		{"raw block", "/ipfs/" + testCid, map[string]string{"If-None-Match": etag, "Accept": "application/vnd.ipld.raw"}, http.StatusOK, "", true},
+		{"ipns", "/ipns/example.com", map[string]string{"If-None-Match": etag}, http.StatusOK, "", true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			served = 0
+			r := httptest.NewRequest(http.MethodGet, tc.target, nil)
+			for k, v := range tc.header {
+				r.Header.Set(k, v)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+			if w.Code != tc.status {
+				t.Fatalf("expected status %d, got %d", tc.status, w.Code)
+			}
+			if got := w.Header().Get("Etag"); got != tc.etag {
+				t.Fatalf("expected Etag %q, got %q", tc.etag, got)
+			}
+			if (served == 1) != tc.passThrough {
+				t.Fatalf("expected the request to be passed through: %v, served %d times", tc.passThrough, served)
+			}
+			if tc.status == http.StatusNotModified && w.Body.Len() != 0 {
+				t.Fatalf("expected no body, got %q", w.Body)
+			}
+		})
+	}
+}
@@ -0,0 +1,108 @@
+package corehttp
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	core "github.com/ipfs/kubo/core"
+	"github.com/ipfs/kubo/core/events"
+)
+
+// eventStreamBuffer is the number of events buffered for each client of the
+// event stream. Past it, events are dropped for the client until it catches
+// up, the node never waiting for it.
+const eventStreamBuffer = 64
+
+// eventStreamKeepAlive is how often a comment is sent to idle clients so
+// that proxies don't close the stream.
+var eventStreamKeepAlive = 15 * time.Second
+
+// EventsOption serves the block and pin events of the node on path as
+// server-sent events, each named after its operation and carrying the event
+// as JSON. The type query parameter restricts the stream to a comma
+// separated list of operations. Nothing is served unless the node publishes
+// events.
+func EventsOption(path string) ServeOption {
+	return func(n *core.IpfsNode, _ net.Listener, mux *http.ServeMux) (*http.ServeMux, error) {
+		if n.Events == nil {
+			return mux, nil
+		}
+		bus := n.Events.Bus()
+		mux.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
+			types, err := parseEventTypes(r.URL.Query().Get("type"))
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			sub := bus.Subscribe(eventStreamBuffer)
+			defer sub.Close()
+
+			rc := http.NewResponseController(w)
+			// the stream lasts longer than any write timeout of the server
+			if err := rc.SetWriteDeadline(time.Time{}); err != nil {
+				log.Debugf("cannot clear write deadline for %s: %s", r.URL.Path, err)
+			}
+			w.Header().Set("Content-Type", "text/event-stream")
+			w.Header().Set("Cache-Control", "no-cache")
+			w.WriteHeader(http.StatusOK)
+			if err := rc.Flush(); err != nil {
+				log.Debugf("cannot stream events to %s: %s", r.RemoteAddr, err)
+				return
+			}
+
+			keepAlive := time.NewTicker(eventStreamKeepAlive)
+			defer keepAlive.Stop()
+			for {
+				select {
+				case <-r.Context().Done():
+					return
+				case <-keepAlive.C:
+					if _, err := fmt.Fprint(w, ": keepalive\n\n"); err != nil {
+						return
+					}
+				case ev, ok := <-sub.Events():
+					if !ok {
+						return
+					}
+					if types != nil && !types[ev.Operation] {
+						continue
+					}
+					data, err := json.Marshal(ev)
+					if err != nil {
+						log.Errorf("cannot encode %s event of %s: %s", ev.Operation, ev.Cid, err)
+						continue
+					}
+					if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", ev.Operation, data); err != nil {
+						return
+					}
+				}
+				if err := rc.Flush(); err != nil {
+					return
+				}
+			}
+		})
+		return mux, nil
+	}
+}
+
+// parseEventTypes parses the type query parameter of the event stream. It
+// returns nil, selecting all events, when s is empty.
+func parseEventTypes(s string) (map[events.Operation]bool, error) {
+	if s == "" {
+		return nil, nil
+	}
+	types := make(map[events.Operation]bool)
+	for _, t := range strings.Split(s, ",") {
+		switch op := events.Operation(strings.TrimSpace(t)); op {
+		case events.OpBlockPut, events.OpPin, events.OpUnpin:
+			types[op] = true
+		default:
+			return nil, fmt.Errorf("unknown event type %q", t)
+		}
+	}
+	return types, nil
+}
@@ -0,0 +1,99 @@
+package corehttp
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	blocks "github.com/ipfs/go-block-format"
+	core "github.com/ipfs/kubo/core"
+	"github.com/ipfs/kubo/core/events"
+)
+
+func TestEventsStream(t *testing.T) {
+	e := events.NewEmitter(nil, "", "", 0)
+	t.Cleanup(func() { e.Close() })
+	handler, err := MakeHandler(&core.IpfsNode{Events: e}, nil, EventsOption("/events"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(handler)
+	t.Cleanup(ts.Close)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	connect := func(query string) *bufio.Reader {
+		t.Helper()
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, ts.URL+"/events"+query, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { res.Body.Close() })
+		if res.StatusCode != http.StatusOK || res.Header.Get("Content-Type") != "text/event-stream" {
+			t.Fatalf("expected an event stream, got %d %q", res.StatusCode, res.Header.Get("Content-Type"))
+		}
+		return bufio.NewReader(res.Body)
+	}
+	expect := func(r *bufio.Reader, op events.Operation, c string) {
+		t.Helper()
+		var name, data string
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				t.Fatal(err)
+			}
+			line = strings.TrimSuffix(line, "\n")
+			if line == "" && name != "" {
+				break
+			}
+			if v, ok := strings.CutPrefix(line, "event: "); ok {
+				name = v
+			} else if v, ok := strings.CutPrefix(line, "data: "); ok {
+				data = v
+			}
+		}
+		var ev events.Event
+		if err := json.Unmarshal([]byte(data), &ev); err != nil {
+			t.Fatal(err)
+		}
+		if name != string(op) || ev.Operation != op || ev.Cid != c {
+			t.Fatalf("expected %s event of %s, got %s event %s", op, c, name, data)
+		}
+	}
+
+	all := connect("")
+	pins := connect("?type=pin,unpin")
+	a, b := blocks.NewBlock([]byte("a")).Cid(), blocks.NewBlock([]byte("b")).Cid()
+	e.Emit(events.OpBlockPut, a, 1)
+	e.Emit(events.OpPin, a, 0)
+	e.Emit(events.OpUnpin, b, 0)
+
+	expect(all, events.OpBlockPut, a.String())
+	expect(all, events.OpPin, a.String())
+	expect(all, events.OpUnpin, b.String())
+	expect(pins, events.OpPin, a.String())
+	expect(pins, events.OpUnpin, b.String())
+}
+
+func TestEventsStreamUnknownType(t *testing.T) {
+	e := events.NewEmitter(nil, "", "", 0)
+	t.Cleanup(func() { e.Close() })
+	handler, err := MakeHandler(&core.IpfsNode{Events: e}, nil, EventsOption("/events"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/events?type=block_get", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for an unknown event type, got %d", w.Code)
+	}
+}
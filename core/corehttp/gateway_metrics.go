@@ -0,0 +1,49 @@
+package corehttp
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Outcomes of the gateway access checks recorded by gatewayAccessRequests.
+const (
+	accessAllowed      = "allowed"
+	accessIPThrottled  = "ip_throttled"
+	accessIPDenied     = "ip_denied"
+	accessCIDThrottled = "cid_throttled"
+	accessCIDBusy      = "cid_busy"
+	accessDmcaBlocked  = "dmca_blocked"
+	accessDenied       = "access_denied"
+)
+
+var (
+	gatewayAccessRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "ipfs",
+		Subsystem: "http_gateway_access",
+		Name:      "requests_total",
+		Help:      "Gateway requests by outcome of the rate limits, DMCA and dedicated gateway checks.",
+	}, []string{"outcome"})
+
+	pinningServiceDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "ipfs",
+		Subsystem: "pinning_service",
+		Name:      "request_duration_seconds",
+		Help:      "Latency of the calls to the pinning service API made by the gateway.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"endpoint"})
+
+	pinningServiceCircuitState = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: "ipfs",
+		Subsystem: "pinning_service",
+		Name:      "circuit_state",
+		Help:      "State of the circuit breaker of the pinning service calls: 0 closed, 1 open, 2 half-open.",
+	})
+)
+
+// observePinningService records the latency of a pinning service call
+// started at start.
+func observePinningService(endpoint string, start time.Time) {
+	pinningServiceDuration.WithLabelValues(endpoint).Observe(time.Since(start).Seconds())
+}
@@ -0,0 +1,109 @@
+package corehttp
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/ipfs/kubo/config"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// scrapeMetric returns the metric of the default registry with the given
+// name and label, or nil if it wasn't recorded yet.
+func scrapeMetric(t *testing.T, name, label, value string) *dto.Metric {
+	t.Helper()
+	mfs, err := prometheus.DefaultGatherer.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, mf := range mfs {
+		if mf.GetName() != name {
+			continue
+		}
+		for _, m := range mf.GetMetric() {
+			for _, l := range m.GetLabel() {
+				if l.GetName() == label && l.GetValue() == value {
+					return m
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func TestGatewayAccessMetrics(t *testing.T) {
+	const blocked = testBlockedCid
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/api/dmca/"+blocked:
+			w.WriteHeader(http.StatusGone)
+		case strings.HasPrefix(r.URL.Path, "/api/dedicatedGateways/"):
+			w.WriteHeader(http.StatusForbidden)
+		default:
+			w.WriteHeader(http.StatusOK)
+		}
+	}))
+	t.Cleanup(ts.Close)
+	t.Cleanup(func() {
+		mtx.Lock()
+		clear(ipLimiters)
+		clear(cidLimiters)
+		mtx.Unlock()
+	})
+
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	public := DedicatedGatewayMiddleware(next, nil, &config.Config{
+		ConfigPinningService: config.ConfigPinningService{
+			PinningService: ts.URL,
+			IPRateLimit:    3,
+			CIDRateLimit:   1,
+		},
+	})
+	dedicated := DedicatedGatewayMiddleware(next, nil, &config.Config{
+		ConfigPinningService: config.ConfigPinningService{
+			PinningService:   ts.URL,
+			DedicatedGateway: true,
+		},
+	})
+
+	counter := func(outcome string) float64 {
+		m := scrapeMetric(t, "ipfs_http_gateway_access_requests_total", "outcome", outcome)
+		return m.GetCounter().GetValue()
+	}
+	latencies := func() uint64 {
+		m := scrapeMetric(t, "ipfs_pinning_service_request_duration_seconds", "endpoint", "dmca")
+		return m.GetHistogram().GetSampleCount()
+	}
+	calls := latencies()
+
+	for _, tc := range []struct {
+		handler http.Handler
+		cid     string
+		outcome string
+	}{
+		{public, testCid, accessAllowed},
+		{public, testCid, accessCIDThrottled},
+		{public, testBlockedCid, accessDmcaBlocked},
+		{public, "QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n", accessIPThrottled},
+		{dedicated, testCid, accessDenied},
+	} {
+		before := counter(tc.outcome)
+		r := httptest.NewRequest(http.MethodGet, "/ipfs/"+tc.cid, nil)
+		r.RemoteAddr = "192.0.2.10:1234"
+		tc.handler.ServeHTTP(httptest.NewRecorder(), r)
+		if got := counter(tc.outcome); got != before+1 {
+			t.Errorf("expected %s to be counted once, went from %v to %v", tc.outcome, before, got)
+		}
+	}
+
+	// the public gateway checked testCid and testBlockedCid, the dedicated
+	// one testCid again
+	if got := latencies() - calls; got != 3 {
+		t.Errorf("expected 3 DMCA call latencies to be observed, got %d", got)
+	}
+}
@@ -0,0 +1,37 @@
+package corehttp
+
+import "net/http"
+
+// headerWriter lets setHeader rewrite the header of a response, given its
+// status, right before the header is written. Informational responses are
+// written as is.
+type headerWriter struct {
+	http.ResponseWriter
+	setHeader   func(h http.Header, status int)
+	wroteHeader bool
+}
+
+func (w *headerWriter) WriteHeader(status int) {
+	if !w.wroteHeader && status >= http.StatusOK {
+		w.wroteHeader = true
+		w.setHeader(w.Header(), status)
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *headerWriter) Write(p []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+func (w *headerWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *headerWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
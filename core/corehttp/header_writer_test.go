@@ -0,0 +1,34 @@
+package corehttp
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHeaderWriter(t *testing.T) {
+	var statuses []int
+	setHeader := func(h http.Header, status int) {
+		statuses = append(statuses, status)
+		h.Set("X-Test", "set")
+	}
+
+	w := &headerWriter{ResponseWriter: httptest.NewRecorder(), setHeader: setHeader}
+	w.WriteHeader(http.StatusEarlyHints)
+	if len(statuses) != 0 {
+		t.Fatalf("expected informational responses to be written as is, got %v", statuses)
+	}
+
+	rec := httptest.NewRecorder()
+	w = &headerWriter{ResponseWriter: rec, setHeader: setHeader}
+	if _, err := w.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	w.WriteHeader(http.StatusNotFound)
+	if len(statuses) != 1 || statuses[0] != http.StatusOK {
+		t.Fatalf("expected the header to be rewritten once for the implicit 200, got %v", statuses)
+	}
+	if rec.Header().Get("X-Test") != "set" {
+		t.Fatal("expected the rewritten header to be written")
+	}
+}
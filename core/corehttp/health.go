@@ -0,0 +1,130 @@
+package corehttp
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	ds "github.com/ipfs/go-datastore"
+	core "github.com/ipfs/kubo/core"
+)
+
+// readinessTimeout bounds each dependency check of /readyz.
+const readinessTimeout = 5 * time.Second
+
+// readinessKey is the datastore key read to check the datastore responds.
+var readinessKey = ds.NewKey("/local/readyz")
+
+// healthStatus is the JSON body of /healthz and /readyz.
+type healthStatus struct {
+	Status string            `json:"status"`
+	Reason string            `json:"reason,omitempty"`
+	Checks map[string]string `json:"checks,omitempty"`
+}
+
+// HealthOption registers /healthz, answering 200 as long as the server
+// serves, and /readyz, answering 200 only if the datastore and the pinning
+// service respond, and 503 with the reason otherwise. Datastores can report
+// their own health by implementing datastore.CheckedDatastore. Once the node
+// is closing, /readyz answers 503 while the server drains.
+func HealthOption() ServeOption {
+	return func(n *core.IpfsNode, _ net.Listener, mux *http.ServeMux) (*http.ServeMux, error) {
+		mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+			writeHealth(w, http.StatusOK, healthStatus{Status: "ok"})
+		})
+		mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+			status, res := readiness(r.Context(), n)
+			writeHealth(w, status, res)
+		})
+		return mux, nil
+	}
+}
+
+// readiness runs the dependency checks of /readyz.
+func readiness(ctx context.Context, n *core.IpfsNode) (int, healthStatus) {
+	if n.Process != nil {
+		select {
+		case <-n.Process.Closing():
+			return http.StatusServiceUnavailable, healthStatus{Status: "draining", Reason: "shutting down"}
+		default:
+		}
+	}
+	res := healthStatus{Status: "ok", Checks: make(map[string]string)}
+	for _, dep := range []struct {
+		name  string
+		check func(context.Context, *core.IpfsNode) error
+	}{
+		{"datastore", checkDatastore},
+		{"pinning_service", checkPinningService},
+	} {
+		ctx, cancel := context.WithTimeout(ctx, readinessTimeout)
+		err := dep.check(ctx, n)
+		cancel()
+		if err != nil {
+			res.Checks[dep.name] = err.Error()
+			if res.Reason == "" {
+				res.Status = "unavailable"
+				res.Reason = fmt.Sprintf("%s: %s", dep.name, err)
+			}
+			continue
+		}
+		res.Checks[dep.name] = "ok"
+	}
+	if res.Reason != "" {
+		log.Warnf("not ready: %s", res.Reason)
+		return http.StatusServiceUnavailable, res
+	}
+	return http.StatusOK, res
+}
+
+// checkDatastore asks the datastore to check itself, if it can, and reads a
+// key from it.
+func checkDatastore(ctx context.Context, n *core.IpfsNode) error {
+	d := n.Repo.Datastore()
+	if c, ok := d.(ds.CheckedDatastore); ok {
+		if err := c.Check(ctx); err != nil {
+			return err
+		}
+	}
+	if _, err := d.Get(ctx, readinessKey); err != nil && !errors.Is(err, ds.ErrNotFound) {
+		return err
+	}
+	return ctx.Err()
+}
+
+// checkPinningService checks one of the pinning service endpoints answers,
+// when some are configured. Any response short of a server error will do.
+func checkPinningService(ctx context.Context, n *core.IpfsNode) error {
+	cfg, err := n.Repo.Config()
+	if err != nil {
+		return err
+	}
+	if len(cfg.ConfigPinningService.PinningServiceURLs()) == 0 {
+		return nil
+	}
+	resp, err := callPinningService(ctx, cfg.ConfigPinningService, "readiness", "", nil)
+	if err != nil {
+		if ctx.Err() != nil {
+			return fmt.Errorf("no answer within %s", readinessTimeout)
+		}
+		return errors.New("unreachable")
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= http.StatusInternalServerError {
+		return fmt.Errorf("answered %d", resp.StatusCode)
+	}
+	return nil
+}
+
+func writeHealth(w http.ResponseWriter, status int, res healthStatus) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(res); err != nil {
+		log.Debugf("writing health status: %s", err)
+	}
+}
@@ -0,0 +1,146 @@
+package corehttp
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	ds "github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	"github.com/ipfs/kubo/config"
+	core "github.com/ipfs/kubo/core"
+	"github.com/ipfs/kubo/repo"
+	"github.com/jbenet/goprocess"
+)
+
+// failingDatastore reports itself unhealthy through Check.
+type failingDatastore struct {
+	ds.Batching
+}
+
+func (failingDatastore) Check(context.Context) error {
+	return errors.New("disk unmounted")
+}
+
+func healthHandler(t *testing.T, d repo.Datastore, pinningService string) http.Handler {
+	t.Helper()
+	n := &core.IpfsNode{Repo: &repo.Mock{
+		C: config.Config{ConfigPinningService: config.ConfigPinningService{PinningService: pinningService}},
+		D: d,
+	}}
+	mux, err := HealthOption()(n, nil, http.NewServeMux())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return mux
+}
+
+func getHealth(t *testing.T, h http.Handler, path string) (int, healthStatus) {
+	t.Helper()
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+	var res healthStatus
+	if err := json.NewDecoder(w.Body).Decode(&res); err != nil {
+		t.Fatal(err)
+	}
+	return w.Code, res
+}
+
+func TestReadyz(t *testing.T) {
+	healthy := dssync.MutexWrap(ds.NewMapDatastore())
+	up := newTestPinningService(t)
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	t.Cleanup(down.Close)
+	gone := httptest.NewServer(http.NotFoundHandler())
+	gone.Close()
+
+	for _, tc := range []struct {
+		name           string
+		datastore      repo.Datastore
+		pinningService string
+		unhealthy      string
+	}{
+		{"healthy", healthy, up.URL, ""},
+		{"no pinning service", healthy, "", ""},
+		{"datastore down", failingDatastore{healthy}, up.URL, "datastore"},
+		{"pinning service error", healthy, down.URL, "pinning_service"},
+		{"pinning service unreachable", healthy, gone.URL, "pinning_service"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			h := healthHandler(t, tc.datastore, tc.pinningService)
+			if status, _ := getHealth(t, h, "/healthz"); status != http.StatusOK {
+				t.Fatalf("expected liveness to be 200, got %d", status)
+			}
+
+			status, res := getHealth(t, h, "/readyz")
+			if tc.unhealthy == "" {
+				if status != http.StatusOK || res.Status != "ok" {
+					t.Fatalf("expected ready, got %d %+v", status, res)
+				}
+				return
+			}
+			if status != http.StatusServiceUnavailable {
+				t.Fatalf("expected 503, got %d", status)
+			}
+			if res.Reason == "" || res.Checks[tc.unhealthy] == "ok" {
+				t.Fatalf("expected %s to be reported down, got %+v", tc.unhealthy, res)
+			}
+		})
+	}
+}
+
+func TestReadyzDrainsBeforeShutdown(t *testing.T) {
+	const delay = 300 * time.Millisecond
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	node := &core.IpfsNode{
+		Repo: &repo.Mock{
+			C: config.Config{ConfigPinningService: config.ConfigPinningService{ShutdownDrainDelay: config.NewOptionalDuration(delay)}},
+			D: dssync.MutexWrap(ds.NewMapDatastore()),
+		},
+		Process: goprocess.WithParent(goprocess.Background()),
+	}
+	errc := make(chan error, 1)
+	go func() {
+		errc <- Serve(node, lis, HealthOption())
+	}()
+	readyz := func() int {
+		res, err := http.Get("http://" + lis.Addr().String() + "/readyz")
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		return res.StatusCode
+	}
+	if status := readyz(); status != http.StatusOK {
+		t.Fatalf("expected the node to be ready, got %d", status)
+	}
+
+	go node.Process.Close()
+	<-node.Process.Closing()
+	closing := time.Now()
+	// still served, but reported unready so the load balancer moves away
+	if status := readyz(); status != http.StatusServiceUnavailable {
+		t.Fatalf("expected the node to be unready while draining, got %d", status)
+	}
+	select {
+	case err := <-errc:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the server to shut down")
+	}
+	if elapsed := time.Since(closing); elapsed < delay {
+		t.Fatalf("expected the server to drain for %s before shutting down, took %s", delay, elapsed)
+	}
+}
@@ -0,0 +1,70 @@
+package corehttp
+
+import (
+	"net/http"
+	"net/netip"
+	"strings"
+
+	config "github.com/ipfs/kubo/config"
+)
+
+// ipPrefixes is a list of IP ranges.
+type ipPrefixes []netip.Prefix
+
+// parseIPPrefixes parses the IP ranges of a ConfigPinningService list,
+// skipping the invalid ones.
+func parseIPPrefixes(name string, list []string) ipPrefixes {
+	var prefixes ipPrefixes
+	for _, s := range list {
+		prefix, err := config.ParseIPPrefix(s)
+		if err != nil {
+			log.Warnf("ignoring ConfigPinningService.%s entry: %s", name, err)
+			continue
+		}
+		prefixes = append(prefixes, prefix)
+	}
+	return prefixes
+}
+
+// contains reports whether addr is in one of the ranges.
+func (p ipPrefixes) contains(addr netip.Addr) bool {
+	for _, prefix := range p {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientIP returns the address of the client of r: the address it connected
+// from or, when that is one of trustedProxies, the last address of
+// X-Forwarded-For not added by a trusted proxy. It returns false when
+// RemoteAddr is not an IP, such as for requests that did not come over the
+// network.
+func clientIP(r *http.Request, trustedProxies ipPrefixes) (netip.Addr, bool) {
+	addr, err := netip.ParseAddr(remoteIP(r.RemoteAddr))
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	addr = addr.Unmap()
+	if !trustedProxies.contains(addr) {
+		return addr, true
+	}
+
+	var hops []string
+	for _, v := range r.Header.Values("X-Forwarded-For") {
+		hops = append(hops, strings.Split(v, ",")...)
+	}
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+		if err != nil {
+			// the hops before a malformed one can't be trusted
+			break
+		}
+		addr = hop.Unmap()
+		if !trustedProxies.contains(addr) {
+			break
+		}
+	}
+	return addr, true
+}
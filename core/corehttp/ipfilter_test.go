@@ -0,0 +1,122 @@
+package corehttp
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ipfs/kubo/config"
+)
+
+func TestIPLists(t *testing.T) {
+	ts := newTestPinningService(t)
+	t.Cleanup(func() {
+		mtx.Lock()
+		clear(ipLimiters)
+		clear(cidLimiters)
+		mtx.Unlock()
+	})
+
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	handler := DedicatedGatewayMiddleware(next, nil, &config.Config{
+		ConfigPinningService: config.ConfigPinningService{
+			PinningService: ts.URL,
+			IPRateLimit:    1,
+			CIDRateLimit:   100,
+			IPAllowlist:    []string{"203.0.113.0/24", "2001:db8::/32"},
+			IPDenylist:     []string{"198.51.100.0/24", "203.0.113.66"},
+			TrustedProxies: []string{"10.0.0.0/8"},
+		},
+	})
+	get := func(remoteAddr, forwardedFor string) int {
+		r := httptest.NewRequest(http.MethodGet, "/ipfs/"+testCid, nil)
+		r.RemoteAddr = remoteAddr
+		if forwardedFor != "" {
+			r.Header.Set("X-Forwarded-For", forwardedFor)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	for _, tc := range []struct {
+		name         string
+		remoteAddr   string
+		forwardedFor string
+		codes        []int
+	}{
+		{"allowlisted", "203.0.113.7:1234", "", []int{200, 200, 200}},
+		{"allowlisted ipv6", "[2001:db8::5]:1234", "", []int{200, 200, 200}},
+		{"denylisted", "198.51.100.23:1234", "", []int{403}},
+		{"denylisted in allowlisted range", "203.0.113.66:1234", "", []int{403}},
+		{"not listed", "192.0.2.1:1234", "", []int{200, 429}},
+		{"allowlisted behind trusted proxy", "10.1.2.3:1234", "192.0.2.9, 203.0.113.8, 10.0.0.1", []int{200, 200, 200}},
+		{"denylisted behind trusted proxy", "10.1.2.3:1234", "198.51.100.1", []int{403}},
+		{"forwarded by untrusted proxy", "192.0.2.2:1234", "203.0.113.8", []int{200, 429}},
+		{"spoofed before the client", "10.1.2.3:1234", "203.0.113.8, 192.0.2.3", []int{200, 429}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			for i, want := range tc.codes {
+				if code := get(tc.remoteAddr, tc.forwardedFor); code != want {
+					t.Fatalf("request %d: expected %d, got %d", i, want, code)
+				}
+			}
+		})
+	}
+}
+
+func TestRateLimitBehindTrustedProxy(t *testing.T) {
+	ts := newTestPinningService(t)
+	t.Cleanup(func() {
+		mtx.Lock()
+		clear(ipLimiters)
+		clear(cidLimiters)
+		mtx.Unlock()
+	})
+
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	handler := DedicatedGatewayMiddleware(next, nil, &config.Config{
+		ConfigPinningService: config.ConfigPinningService{
+			PinningService: ts.URL,
+			IPRateLimit:    1,
+			CIDRateLimit:   100,
+			TrustedProxies: []string{"10.0.0.0/8"},
+		},
+	})
+	get := func(remoteAddr, forwardedFor string) int {
+		r := httptest.NewRequest(http.MethodGet, "/ipfs/"+testCid, nil)
+		r.RemoteAddr = remoteAddr
+		r.Header.Set("X-Forwarded-For", forwardedFor)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	// clients behind the load balancer are limited separately
+	for _, client := range []string{"198.51.100.1", "198.51.100.2", "198.51.100.3"} {
+		if code := get("10.0.0.1:1234", client); code != http.StatusOK {
+			t.Fatalf("expected the first request of %s to pass, got %d", client, code)
+		}
+	}
+	if code := get("10.0.0.2:4321", "198.51.100.1"); code != http.StatusTooManyRequests {
+		t.Fatalf("expected a client to be limited across load balancers, got %d", code)
+	}
+	mtx.Lock()
+	_, ok := ipLimiters["198.51.100.1"]
+	mtx.Unlock()
+	if !ok {
+		t.Fatal("expected the limiter to be keyed by the forwarded client IP")
+	}
+
+	// headers sent by clients connecting directly are ignored
+	if code := get("192.0.2.5:1234", "198.51.100.4"); code != http.StatusOK {
+		t.Fatalf("expected the first request to pass, got %d", code)
+	}
+	if code := get("192.0.2.5:1235", "198.51.100.5"); code != http.StatusTooManyRequests {
+		t.Fatalf("expected a spoofed X-Forwarded-For not to escape the limit, got %d", code)
+	}
+}
@@ -0,0 +1,125 @@
+package corehttp
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	core "github.com/ipfs/kubo/core"
+)
+
+// defaultMaintenanceRetryAfter is the Retry-After sent during maintenance
+// when none was given when enabling it.
+const defaultMaintenanceRetryAfter = time.Minute
+
+// maintenanceRetryAfter is the Retry-After, in seconds, of the 503 answered
+// to gateway requests while in maintenance, and zero when not in
+// maintenance. It is read on each gateway request.
+var maintenanceRetryAfter atomic.Int64
+
+// maintenanceStatus is the JSON body describing the maintenance mode.
+type maintenanceStatus struct {
+	Enabled    bool `json:"enabled"`
+	RetryAfter int  `json:"retry_after,omitempty"`
+}
+
+// inMaintenance answers gateway requests with 503 while in maintenance,
+// reporting whether it did.
+func inMaintenance(w http.ResponseWriter) bool {
+	retryAfter := maintenanceRetryAfter.Load()
+	if retryAfter == 0 {
+		return false
+	}
+	w.Header().Set("Retry-After", strconv.FormatInt(retryAfter, 10))
+	http.Error(w, "The gateway is under maintenance", http.StatusServiceUnavailable)
+	return true
+}
+
+// MaintenanceOption toggles the maintenance mode of the gateways on requests
+// to path: while enabled, their /ipfs/ and /ipns/ requests are answered with
+// 503 and a Retry-After, other paths being served as usual. A POST with the
+// 'enabled' parameter set to true or false toggles it; 'retry_after' sets
+// the Retry-After as a duration, one minute by default. A GET returns the
+// current mode. Requests must carry ConfigPinningService.AdminToken as a
+// bearer token, the endpoint is disabled when it is not set. It is meant
+// for the API server.
+func MaintenanceOption(path string) ServeOption {
+	return func(n *core.IpfsNode, _ net.Listener, mux *http.ServeMux) (*http.ServeMux, error) {
+		mux.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
+			cfg, err := n.Repo.Config()
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			if status, err := checkAdminToken(r, cfg.ConfigPinningService.AdminToken); err != nil {
+				http.Error(w, err.Error(), status)
+				return
+			}
+
+			switch r.Method {
+			case http.MethodGet:
+			case http.MethodPost:
+				if err := setMaintenance(r); err != nil {
+					http.Error(w, err.Error(), http.StatusBadRequest)
+					return
+				}
+			default:
+				http.Error(w, "only GET and POST allowed", http.StatusMethodNotAllowed)
+				return
+			}
+
+			retryAfter := maintenanceRetryAfter.Load()
+			w.Header().Set("Content-Type", "application/json")
+			if err := json.NewEncoder(w).Encode(maintenanceStatus{Enabled: retryAfter != 0, RetryAfter: int(retryAfter)}); err != nil {
+				log.Debugf("writing maintenance status: %s", err)
+			}
+		})
+		return mux, nil
+	}
+}
+
+// setMaintenance toggles the maintenance mode from the parameters of r.
+func setMaintenance(r *http.Request) error {
+	if err := r.ParseForm(); err != nil {
+		return err
+	}
+	enabled, err := strconv.ParseBool(r.Form.Get("enabled"))
+	if err != nil {
+		return fmt.Errorf("invalid 'enabled' parameter %q, expected true or false", r.Form.Get("enabled"))
+	}
+	if !enabled {
+		maintenanceRetryAfter.Store(0)
+		log.Infof("gateway maintenance mode disabled")
+		return nil
+	}
+
+	retryAfter := defaultMaintenanceRetryAfter
+	if v := r.Form.Get("retry_after"); v != "" {
+		if retryAfter, err = time.ParseDuration(v); err != nil || retryAfter < time.Second {
+			return fmt.Errorf("invalid 'retry_after' parameter %q, expected a duration of at least 1s", v)
+		}
+	}
+	seconds := int64(retryAfter / time.Second)
+	maintenanceRetryAfter.Store(seconds)
+	log.Warnf("gateway maintenance mode enabled, answering gateway requests with 503 and Retry-After %d", seconds)
+	return nil
+}
+
+// checkAdminToken checks r carries token as a bearer token, returning the
+// status to answer with otherwise.
+func checkAdminToken(r *http.Request, token string) (int, error) {
+	if token == "" {
+		return http.StatusForbidden, fmt.Errorf("ConfigPinningService.AdminToken is not set")
+	}
+	given, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+		return http.StatusUnauthorized, fmt.Errorf("invalid admin token")
+	}
+	return 0, nil
+}
@@ -0,0 +1,113 @@
+package corehttp
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/ipfs/kubo/config"
+	core "github.com/ipfs/kubo/core"
+	"github.com/ipfs/kubo/repo"
+)
+
+func maintenanceHandler(t *testing.T, token string) http.Handler {
+	t.Helper()
+	n := &core.IpfsNode{Repo: &repo.Mock{
+		C: config.Config{ConfigPinningService: config.ConfigPinningService{AdminToken: token}},
+	}}
+	mux, err := MaintenanceOption("/debug/maintenance")(n, nil, http.NewServeMux())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return mux
+}
+
+func toggleMaintenance(h http.Handler, token string, params url.Values) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodPost, "/debug/maintenance", strings.NewReader(params.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+func TestMaintenance(t *testing.T) {
+	ts := newTestPinningService(t)
+	t.Cleanup(func() {
+		maintenanceRetryAfter.Store(0)
+		mtx.Lock()
+		clear(ipLimiters)
+		clear(cidLimiters)
+		mtx.Unlock()
+	})
+	admin := maintenanceHandler(t, "secret")
+
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	gw := DedicatedGatewayMiddleware(next, nil, &config.Config{
+		ConfigPinningService: config.ConfigPinningService{PinningService: ts.URL},
+	})
+	get := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		gw.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		return w
+	}
+
+	if w := get("/ipfs/" + testCid); w.Code != http.StatusOK {
+		t.Fatalf("expected 200 before maintenance, got %d", w.Code)
+	}
+
+	if w := toggleMaintenance(admin, "secret", url.Values{"enabled": {"true"}, "retry_after": {"2m"}}); w.Code != http.StatusOK {
+		t.Fatalf("expected maintenance to be enabled, got %d: %s", w.Code, w.Body)
+	}
+	for _, path := range []string{"/ipfs/" + testCid, "/ipns/example.com"} {
+		w := get(path)
+		if w.Code != http.StatusServiceUnavailable || w.Header().Get("Retry-After") != "120" {
+			t.Fatalf("expected 503 with Retry-After 120 for %s, got %d with %q", path, w.Code, w.Header().Get("Retry-After"))
+		}
+	}
+	if w := get("/api/v0/version"); w.Code != http.StatusOK {
+		t.Fatalf("expected other paths to be served during maintenance, got %d", w.Code)
+	}
+
+	if w := toggleMaintenance(admin, "secret", url.Values{"enabled": {"false"}}); w.Code != http.StatusOK {
+		t.Fatalf("expected maintenance to be disabled, got %d: %s", w.Code, w.Body)
+	}
+	if w := get("/ipfs/" + testCid); w.Code != http.StatusOK {
+		t.Fatalf("expected 200 after maintenance, got %d", w.Code)
+	}
+}
+
+func TestMaintenanceAuth(t *testing.T) {
+	t.Cleanup(func() { maintenanceRetryAfter.Store(0) })
+	enable := url.Values{"enabled": {"true"}}
+
+	for _, tc := range []struct {
+		name          string
+		token, bearer string
+		status        int
+	}{
+		{"no admin token", "", "", http.StatusForbidden},
+		{"missing token", "secret", "", http.StatusUnauthorized},
+		{"wrong token", "secret", "guess", http.StatusUnauthorized},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			w := toggleMaintenance(maintenanceHandler(t, tc.token), tc.bearer, enable)
+			if w.Code != tc.status {
+				t.Fatalf("expected %d, got %d", tc.status, w.Code)
+			}
+			if maintenanceRetryAfter.Load() != 0 {
+				t.Fatal("expected maintenance to stay disabled")
+			}
+		})
+	}
+
+	if w := toggleMaintenance(maintenanceHandler(t, "secret"), "secret", url.Values{"enabled": {"true"}, "retry_after": {"10ms"}}); w.Code != http.StatusBadRequest {
+		t.Fatalf("expected a Retry-After under a second to be rejected, got %d", w.Code)
+	}
+}
@@ -0,0 +1,123 @@
+package corehttp
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	core "github.com/ipfs/kubo/core"
+)
+
+// errObjectTooLarge is returned by writes going past the configured
+// ConfigPinningService.MaxObjectSize.
+var errObjectTooLarge = errors.New("object exceeds the maximum size served by this gateway")
+
+// MaxObjectSizeOption rejects gateway requests for objects larger than
+// ConfigPinningService.MaxObjectSize with 413 Request Entity Too Large.
+//
+// The size is taken from the response headers set by the gateway, before
+// anything is written: the Content-Length of a full response, or the complete
+// length in the Content-Range of a partial one, so ranges of a large object
+// are rejected too. When the size isn't known up front, the response is cut
+// off once the limit is reached.
+func MaxObjectSizeOption() ServeOption {
+	return func(n *core.IpfsNode, _ net.Listener, parent *http.ServeMux) (*http.ServeMux, error) {
+		cfg, err := n.Repo.Config()
+		if err != nil {
+			return nil, err
+		}
+
+		limit := cfg.ConfigPinningService.MaxObjectSize
+		if limit <= 0 {
+			return parent, nil
+		}
+
+		mux := http.NewServeMux()
+		parent.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+			if !isGatewayPath(r.URL.Path) {
+				mux.ServeHTTP(w, r)
+				return
+			}
+			mux.ServeHTTP(&limitedResponseWriter{ResponseWriter: w, limit: limit, remaining: limit}, r)
+		})
+
+		return mux, nil
+	}
+}
+
+// responseObjectSize returns the size of the object in the response with
+// status and header. The boolean is false when the size can't be told from
+// the header.
+func responseObjectSize(status int, header http.Header) (int64, bool) {
+	if status == http.StatusPartialContent {
+		// Content-Range: bytes <first>-<last>/<complete length>
+		_, total, ok := strings.Cut(header.Get("Content-Range"), "/")
+		if !ok {
+			return 0, false
+		}
+		size, err := strconv.ParseInt(total, 10, 64)
+		return size, err == nil
+	}
+	size, err := strconv.ParseInt(header.Get("Content-Length"), 10, 64)
+	return size, err == nil
+}
+
+// limitedResponseWriter replaces successful responses for objects larger
+// than limit with 413, and refuses to write more than remaining bytes.
+type limitedResponseWriter struct {
+	http.ResponseWriter
+	limit, remaining int64
+	wroteHeader      bool
+	rejected         bool
+}
+
+func (w *limitedResponseWriter) WriteHeader(status int) {
+	if w.wroteHeader {
+		w.ResponseWriter.WriteHeader(status)
+		return
+	}
+	if status >= http.StatusOK && status < http.StatusMultipleChoices {
+		if size, ok := responseObjectSize(status, w.Header()); ok && size > w.limit {
+			w.wroteHeader, w.rejected = true, true
+			h := w.Header()
+			for _, name := range []string{"Cache-Control", "Content-Encoding", "Content-Length", "Content-Range", "Etag", "Last-Modified"} {
+				h.Del(name)
+			}
+			http.Error(w.ResponseWriter, errObjectTooLarge.Error(), http.StatusRequestEntityTooLarge)
+			return
+		}
+	}
+	if status >= http.StatusOK {
+		w.wroteHeader = true
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *limitedResponseWriter) Write(p []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.rejected {
+		return 0, errObjectTooLarge
+	}
+	if int64(len(p)) > w.remaining {
+		n, _ := w.ResponseWriter.Write(p[:w.remaining])
+		w.remaining -= int64(n)
+		return n, errObjectTooLarge
+	}
+	n, err := w.ResponseWriter.Write(p)
+	w.remaining -= int64(n)
+	return n, err
+}
+
+func (w *limitedResponseWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *limitedResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
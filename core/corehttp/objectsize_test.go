@@ -0,0 +1,144 @@
+package corehttp
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/ipfs/boxo/files"
+	"github.com/ipfs/go-datastore"
+	syncds "github.com/ipfs/go-datastore/sync"
+	"github.com/ipfs/kubo/config"
+	"github.com/ipfs/kubo/core"
+	"github.com/ipfs/kubo/core/coreapi"
+	"github.com/ipfs/kubo/repo"
+)
+
+func TestMaxObjectSize(t *testing.T) {
+	c := config.Config{
+		Identity: config.Identity{
+			PeerID: "QmTFauExutTsy4XP6JbMFcw2Wa9645HJt2bTqL6qYDCKfe", // required by offline node
+		},
+		ConfigPinningService: config.ConfigPinningService{
+			MaxObjectSize: 1024,
+		},
+	}
+	r := &repo.Mock{
+		C: c,
+		D: syncds.MutexWrap(datastore.NewMapDatastore()),
+	}
+	n, err := core.NewNode(context.Background(), &core.BuildCfg{Repo: r})
+	if err != nil {
+		t.Fatal(err)
+	}
+	api, err := coreapi.NewCoreAPI(n)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	small, err := api.Unixfs().Add(n.Context(), files.NewBytesFile([]byte("small file")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	large, err := api.Unixfs().Add(n.Context(), files.NewBytesFile(bytes.Repeat([]byte("a"), 4096)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dh := &delegatedHandler{}
+	ts := httptest.NewServer(dh)
+	t.Cleanup(func() { ts.Close() })
+
+	dh.Handler, err = MakeHandler(n,
+		ts.Listener,
+		MaxObjectSizeOption(),
+		GatewayOption("/ipfs", "/ipns"),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		path, rng string
+		status    int
+	}{
+		{small.String(), "", http.StatusOK},
+		{large.String(), "", http.StatusRequestEntityTooLarge},
+		{large.String(), "bytes=0-9", http.StatusRequestEntityTooLarge},
+	} {
+		req, err := http.NewRequest(http.MethodGet, ts.URL+tc.path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tc.rng != "" {
+			req.Header.Set("Range", tc.rng)
+		}
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		if res.StatusCode != tc.status {
+			t.Errorf("%s: expected status %d, got %d", tc.path, tc.status, res.StatusCode)
+		}
+	}
+}
+
+func TestLimitedResponseWriter(t *testing.T) {
+	rec := httptest.NewRecorder()
+	w := &limitedResponseWriter{ResponseWriter: rec, limit: 4, remaining: 4}
+
+	if _, err := w.Write([]byte("abc")); err != nil {
+		t.Fatal(err)
+	}
+	n, err := w.Write([]byte("def"))
+	if err != errObjectTooLarge {
+		t.Fatalf("expected errObjectTooLarge, got %v", err)
+	}
+	if n != 1 || rec.Body.String() != "abcd" {
+		t.Fatalf("unexpected body %q after cut-off", rec.Body.String())
+	}
+}
+
+func TestLimitedResponseWriterContentLength(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		status int
+		header map[string]string
+		want   int
+	}{
+		{"small", http.StatusOK, map[string]string{"Content-Length": "4"}, http.StatusOK},
+		{"large", http.StatusOK, map[string]string{"Content-Length": "5", "Etag": `"x"`}, http.StatusRequestEntityTooLarge},
+		{"small range of a large object", http.StatusPartialContent, map[string]string{"Content-Length": "2", "Content-Range": "bytes 0-1/5"}, http.StatusRequestEntityTooLarge},
+		{"range of a small object", http.StatusPartialContent, map[string]string{"Content-Length": "2", "Content-Range": "bytes 0-1/4"}, http.StatusPartialContent},
+		{"error", http.StatusNotFound, map[string]string{"Content-Length": "5"}, http.StatusNotFound},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			w := &limitedResponseWriter{ResponseWriter: rec, limit: 4, remaining: 4}
+			for k, v := range tc.header {
+				w.Header().Set(k, v)
+			}
+			w.WriteHeader(tc.status)
+			_, err := w.Write([]byte("ab"))
+			if rec.Code != tc.want {
+				t.Fatalf("expected status %d, got %d", tc.want, rec.Code)
+			}
+			if tc.want != http.StatusRequestEntityTooLarge {
+				return
+			}
+			if err != errObjectTooLarge {
+				t.Fatalf("expected errObjectTooLarge, got %v", err)
+			}
+			if strings.Contains(rec.Body.String(), "ab") {
+				t.Fatalf("expected the content not to be written, got %q", rec.Body.String())
+			}
+			if rec.Header().Get("Etag") != "" {
+				t.Fatal("expected the headers of the content to be dropped")
+			}
+		})
+	}
+}
@@ -0,0 +1,107 @@
+package corehttp
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	config "github.com/ipfs/kubo/config"
+)
+
+// defaultUpstreamTimeout bounds each pinning service call unless
+// ConfigPinningService.UpstreamTimeout is set.
+const defaultUpstreamTimeout = 2 * time.Second
+
+// errNoPinningService is returned when no pinning service endpoint is
+// configured.
+var errNoPinningService = errors.New("no pinning service configured")
+
+// callPinningService sends a GET request for path to the pinning service
+// endpoints of cfg in turn, with the API key of the gateway and header,
+// until one answers with something other than a server error. Each call is
+// abandoned past ConfigPinningService.UpstreamTimeout. The response of the
+// last endpoint tried is returned, or the error calling it when it could not
+// be reached. Each call is recorded in the pinning service latency metric
+// under name. Calls fail with errCircuitOpen while pinningServiceBreaker is
+// open.
+func callPinningService(ctx context.Context, cfg config.ConfigPinningService, name, path string, header http.Header) (*http.Response, error) {
+	endpoints := cfg.PinningServiceURLs()
+	if len(endpoints) == 0 {
+		return nil, errNoPinningService
+	}
+	ok, call := pinningServiceBreaker.allow(cfg)
+	if !ok {
+		return nil, errCircuitOpen
+	}
+	resp, err := callPinningServiceEndpoints(ctx, cfg, endpoints, name, path, header)
+	if ctx.Err() != nil {
+		pinningServiceBreaker.abandon(call)
+	} else {
+		pinningServiceBreaker.done(cfg, call, err != nil || resp.StatusCode >= http.StatusInternalServerError)
+	}
+	return resp, err
+}
+
+// callPinningServiceEndpoints calls the endpoints in turn as described by
+// callPinningService.
+func callPinningServiceEndpoints(ctx context.Context, cfg config.ConfigPinningService, endpoints []string, name, path string, header http.Header) (*http.Response, error) {
+
+	timeout := cfg.UpstreamTimeout.WithDefault(defaultUpstreamTimeout)
+	call := func(endpoint string) (*http.Response, error) {
+		callCtx, cancel := context.WithTimeout(ctx, timeout)
+		req, err := http.NewRequestWithContext(callCtx, http.MethodGet, endpoint+path, nil)
+		if err != nil {
+			cancel()
+			return nil, err
+		}
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		req.Header.Set("blockservice-API-Key", cfg.BlockserviceApiKey)
+
+		start := time.Now()
+		defer observePinningService(name, start)
+		resp, err := pinningServiceClient(cfg).Do(req)
+		if err != nil {
+			cancel()
+			// the transport has no error value to match for this
+			if strings.Contains(err.Error(), "response headers exceeded") {
+				log.Warnf("rejected the response of pinning service %s, its headers exceed ConfigPinningService.UpstreamMaxHeaderBytes", endpoint)
+			}
+			return nil, err
+		}
+		resp.Body = &cancelBody{ReadCloser: resp.Body, cancel: cancel}
+		return resp, nil
+	}
+
+	for _, endpoint := range endpoints[:len(endpoints)-1] {
+		resp, err := call(endpoint)
+		switch {
+		case ctx.Err() != nil:
+			return resp, err
+		case err != nil:
+			log.Warnf("pinning service %s unreachable, trying the next endpoint: %s", endpoint, err)
+		case resp.StatusCode >= http.StatusInternalServerError:
+			resp.Body.Close()
+			log.Warnf("pinning service %s answered %d, trying the next endpoint", endpoint, resp.StatusCode)
+		default:
+			return resp, nil
+		}
+	}
+	return call(endpoints[len(endpoints)-1])
+}
+
+// cancelBody releases the context of a call once its response is closed.
+type cancelBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
@@ -0,0 +1,147 @@
+package corehttp
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ipfs/kubo/config"
+)
+
+// newStatusPinningService answers every request with status, returning the
+// number of requests made.
+func newStatusPinningService(t *testing.T, status int) (*httptest.Server, *atomic.Int32) {
+	t.Helper()
+	var calls atomic.Int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(ts.Close)
+	return ts, &calls
+}
+
+func TestPinningServiceFailover(t *testing.T) {
+	ctx := context.Background()
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+	failing, failingCalls := newStatusPinningService(t, http.StatusBadGateway)
+
+	for _, tc := range []struct {
+		name    string
+		primary string
+	}{
+		{"primary unreachable", down.URL},
+		{"primary server error", failing.URL},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			secondary, secondaryCalls := newStatusPinningService(t, http.StatusOK)
+			cfg := &config.Config{ConfigPinningService: config.ConfigPinningService{
+				PinningServiceEndpoints: []string{tc.primary, secondary.URL},
+				BlockserviceApiKey:      "secret",
+			}}
+
+			if status, err := checkDmca(ctx, testCid, cfg); status != http.StatusOK || err != nil {
+				t.Fatalf("expected the DMCA check to pass through the secondary, got %d, %v", status, err)
+			}
+			if status, err := getDedicatedGatewayAccess(ctx, testCid, "", cfg); status != http.StatusOK || err != nil {
+				t.Fatalf("expected access to be granted by the secondary, got %d, %v", status, err)
+			}
+			if n := secondaryCalls.Load(); n != 2 {
+				t.Fatalf("expected both checks to reach the secondary, got %d calls", n)
+			}
+		})
+	}
+	if n := failingCalls.Load(); n != 2 {
+		t.Fatalf("expected both checks to try the primary first, got %d calls", n)
+	}
+
+	t.Run("client errors are final", func(t *testing.T) {
+		primary, _ := newStatusPinningService(t, http.StatusGone)
+		secondary, secondaryCalls := newStatusPinningService(t, http.StatusOK)
+		cfg := &config.Config{ConfigPinningService: config.ConfigPinningService{
+			PinningServiceEndpoints: []string{primary.URL, secondary.URL},
+			BlockserviceApiKey:      "secret",
+		}}
+		if status, err := checkDmca(ctx, testBlockedCid, cfg); status != http.StatusGone || err == nil {
+			t.Fatalf("expected the content to be blocked by the primary, got %d, %v", status, err)
+		}
+		if n := secondaryCalls.Load(); n != 0 {
+			t.Fatalf("expected the secondary not to be called, got %d calls", n)
+		}
+	})
+
+	t.Run("all endpoints failing", func(t *testing.T) {
+		cfg := &config.Config{ConfigPinningService: config.ConfigPinningService{
+			PinningServiceEndpoints: []string{down.URL, failing.URL},
+			BlockserviceApiKey:      "secret",
+		}}
+		if status, err := checkDmca(ctx, testCid, cfg); status != http.StatusBadGateway || err == nil {
+			t.Fatalf("expected the error of the last endpoint, got %d, %v", status, err)
+		}
+	})
+}
+
+func TestUpstreamTimeout(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(5 * time.Second):
+		}
+	}))
+	t.Cleanup(ts.Close)
+	cfg := &config.Config{ConfigPinningService: config.ConfigPinningService{
+		PinningService:  ts.URL,
+		UpstreamTimeout: config.NewOptionalDuration(100 * time.Millisecond),
+	}}
+
+	start := time.Now()
+	status, err := getDedicatedGatewayAccess(context.Background(), testCid, "", cfg)
+	elapsed := time.Since(start)
+	if status != http.StatusInternalServerError || err == nil {
+		t.Fatalf("expected the call to fail, got %d, %v", status, err)
+	}
+	if elapsed < 100*time.Millisecond || elapsed > time.Second {
+		t.Fatalf("expected the call to be abandoned after 100ms, took %s", elapsed)
+	}
+}
+
+func TestPinningServiceMaxHeaderBytes(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		size, _ := strconv.Atoi(r.URL.Query().Get("size"))
+		w.Header().Set("X-Custom", strings.Repeat("a", size))
+		w.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(ts.Close)
+	cfg := config.ConfigPinningService{
+		PinningService:         ts.URL,
+		BlockserviceApiKey:     "secret",
+		UpstreamMaxHeaderBytes: 4 << 10,
+	}
+
+	resp, err := callPinningService(context.Background(), cfg, "test", "/?size=1024", nil)
+	if err != nil {
+		t.Fatalf("expected headers within the limit to be accepted, got %s", err)
+	}
+	resp.Body.Close()
+	if got := len(resp.Header.Get("X-Custom")); got != 1024 {
+		t.Fatalf("expected the header to be returned, got %d bytes", got)
+	}
+
+	if _, err := callPinningService(context.Background(), cfg, "test", "/?size=8192", nil); err == nil {
+		t.Fatal("expected headers past the limit to be rejected")
+	}
+
+	// the default limit allows them
+	cfg.UpstreamMaxHeaderBytes = 0
+	resp, err = callPinningService(context.Background(), cfg, "test", "/?size=8192", nil)
+	if err != nil {
+		t.Fatalf("expected headers within the default limit to be accepted, got %s", err)
+	}
+	resp.Body.Close()
+}
@@ -0,0 +1,160 @@
+package corehttp
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	uio "github.com/ipfs/boxo/ipld/unixfs/io"
+	"github.com/ipfs/go-cid"
+	ipld "github.com/ipfs/go-ipld-format"
+	core "github.com/ipfs/kubo/core"
+)
+
+const (
+	defaultPrefetchMaxBlocks = 64
+
+	// prefetchTimeout bounds the time spent warming a single directory.
+	prefetchTimeout = time.Minute
+
+	// maxConcurrentPrefetches bounds the directories warmed at once. A
+	// directory served while all the slots are taken isn't warmed.
+	maxConcurrentPrefetches = 4
+)
+
+// PrefetchOption warms the blocks linked from a directory once its index.html
+// has been served, so the sub-resources a browser requests next are served
+// from cache. It is enabled by ConfigPinningService.PrefetchDepth.
+//
+// Prefetching only follows successful responses for directories, which went
+// through the access checks, and starts from the CID the gateway resolved
+// for them. It only runs when the admission queue has a free slot so it never
+// delays actual requests, and at most maxConcurrentPrefetches directories
+// are warmed at once.
+func PrefetchOption() ServeOption {
+	return func(n *core.IpfsNode, _ net.Listener, parent *http.ServeMux) (*http.ServeMux, error) {
+		cfg, err := n.Repo.Config()
+		if err != nil {
+			return nil, err
+		}
+
+		depth := cfg.ConfigPinningService.PrefetchDepth
+		if depth <= 0 {
+			return parent, nil
+		}
+		maxBlocks := cfg.ConfigPinningService.PrefetchMaxBlocks
+		if maxBlocks <= 0 {
+			maxBlocks = defaultPrefetchMaxBlocks
+		}
+
+		slots := make(chan struct{}, maxConcurrentPrefetches)
+		mux := http.NewServeMux()
+		parent.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+			sw := &statusWriter{ResponseWriter: w}
+			mux.ServeHTTP(sw, r)
+
+			root, ok := prefetchRoot(r, sw.status, w.Header())
+			if !ok {
+				return
+			}
+			select {
+			case slots <- struct{}{}:
+			default:
+				return
+			}
+			queue := admissionQueueFrom(r.Context())
+			if queue != nil && !queue.tryAcquire() {
+				<-slots
+				return
+			}
+
+			go func() {
+				defer func() { <-slots }()
+				if queue != nil {
+					defer queue.release()
+				}
+				ctx, cancel := context.WithTimeout(n.Context(), prefetchTimeout)
+				defer cancel()
+
+				nd, err := n.DAG.Get(ctx, root)
+				if err != nil {
+					return
+				}
+				fetched := prefetchIndex(ctx, n.DAG, nd, depth, maxBlocks)
+				if fetched > 0 {
+					log.Debugf("prefetched %d blocks linked from %s", fetched, r.URL.Path)
+				}
+			}()
+		})
+
+		return mux, nil
+	}
+}
+
+// prefetchRoot returns the CID of the directory served in response to r,
+// when the response with status and header is worth prefetching for. The
+// gateway serves directories on paths ending with a slash, and lists the CID
+// it resolved the path to last in X-Ipfs-Roots.
+func prefetchRoot(r *http.Request, status int, header http.Header) (cid.Cid, bool) {
+	if r.Method != http.MethodGet || !isGatewayPath(r.URL.Path) || !strings.HasSuffix(r.URL.Path, "/") {
+		return cid.Undef, false
+	}
+	if status < http.StatusOK || status >= http.StatusMultipleChoices {
+		return cid.Undef, false
+	}
+	roots := header.Get("X-Ipfs-Roots")
+	if roots == "" {
+		return cid.Undef, false
+	}
+	c, err := cid.Decode(roots[strings.LastIndex(roots, ",")+1:])
+	if err != nil {
+		return cid.Undef, false
+	}
+	return c, true
+}
+
+// prefetchIndex fetches the blocks linked from nd through dserv, breadth
+// first, if nd is a directory with an index.html. It stops after depth levels
+// or maxBlocks blocks and returns the number of blocks fetched.
+func prefetchIndex(ctx context.Context, dserv ipld.DAGService, nd ipld.Node, depth, maxBlocks int) int {
+	dir, err := uio.NewDirectoryFromNode(dserv, nd)
+	if err != nil {
+		return 0
+	}
+	if _, err := dir.Find(ctx, "index.html"); err != nil {
+		return 0
+	}
+	links, err := dir.Links(ctx)
+	if err != nil {
+		return 0
+	}
+
+	seen := cid.NewSet()
+	fetched := 0
+	for level := 0; level < depth && len(links) > 0; level++ {
+		var batch []cid.Cid
+		for _, l := range links {
+			if fetched+len(batch) >= maxBlocks {
+				break
+			}
+			if seen.Visit(l.Cid) {
+				batch = append(batch, l.Cid)
+			}
+		}
+		if len(batch) == 0 {
+			break
+		}
+
+		links = nil
+		for opt := range dserv.GetMany(ctx, batch) {
+			if opt.Err != nil {
+				continue
+			}
+			fetched++
+			links = append(links, opt.Node.Links()...)
+		}
+	}
+	return fetched
+}
@@ -0,0 +1,186 @@
+package corehttp
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	dag "github.com/ipfs/boxo/ipld/merkledag"
+	ft "github.com/ipfs/boxo/ipld/unixfs"
+	"github.com/ipfs/go-cid"
+	ipld "github.com/ipfs/go-ipld-format"
+)
+
+// cachingDAG is an in-memory DAGService recording the nodes fetched through
+// it, standing in for the local cache being warmed.
+type cachingDAG struct {
+	mu     sync.Mutex
+	nodes  map[cid.Cid]ipld.Node
+	cached map[cid.Cid]bool
+}
+
+func newCachingDAG() *cachingDAG {
+	return &cachingDAG{nodes: make(map[cid.Cid]ipld.Node), cached: make(map[cid.Cid]bool)}
+}
+
+func (d *cachingDAG) Get(_ context.Context, c cid.Cid) (ipld.Node, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	nd, ok := d.nodes[c]
+	if !ok {
+		return nil, ipld.ErrNotFound{Cid: c}
+	}
+	d.cached[c] = true
+	return nd, nil
+}
+
+func (d *cachingDAG) GetMany(ctx context.Context, cids []cid.Cid) <-chan *ipld.NodeOption {
+	out := make(chan *ipld.NodeOption, len(cids))
+	for _, c := range cids {
+		nd, err := d.Get(ctx, c)
+		out <- &ipld.NodeOption{Node: nd, Err: err}
+	}
+	close(out)
+	return out
+}
+
+func (d *cachingDAG) Add(_ context.Context, nd ipld.Node) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.nodes[nd.Cid()] = nd
+	return nil
+}
+
+func (d *cachingDAG) AddMany(ctx context.Context, nds []ipld.Node) error {
+	for _, nd := range nds {
+		if err := d.Add(ctx, nd); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d *cachingDAG) Remove(_ context.Context, c cid.Cid) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delete(d.nodes, c)
+	return nil
+}
+
+func (d *cachingDAG) RemoveMany(ctx context.Context, cids []cid.Cid) error {
+	for _, c := range cids {
+		if err := d.Remove(ctx, c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d *cachingDAG) isCached(c cid.Cid) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.cached[c]
+}
+
+func fileNode(data string) *dag.ProtoNode {
+	return dag.NodeWithData(ft.FilePBData([]byte(data), uint64(len(data))))
+}
+
+func TestPrefetchIndex(t *testing.T) {
+	ctx := context.Background()
+	dserv := newCachingDAG()
+
+	index := fileNode("<html></html>")
+	style := fileNode("body {}")
+	script := fileNode("alert(1)")
+	sub := ft.EmptyDirNode()
+	if err := sub.AddNodeLink("app.js", script); err != nil {
+		t.Fatal(err)
+	}
+	root := ft.EmptyDirNode()
+	for name, nd := range map[string]ipld.Node{"index.html": index, "style.css": style, "js": sub} {
+		if err := root.AddNodeLink(name, nd); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := dserv.AddMany(ctx, []ipld.Node{index, style, script, sub, root}); err != nil {
+		t.Fatal(err)
+	}
+
+	if fetched := prefetchIndex(ctx, dserv, root, 1, 10); fetched != 3 {
+		t.Fatalf("expected 3 blocks prefetched at depth 1, got %d", fetched)
+	}
+	for _, nd := range []ipld.Node{index, style, sub} {
+		if !dserv.isCached(nd.Cid()) {
+			t.Errorf("expected %s to be prefetched", nd.Cid())
+		}
+	}
+	if dserv.isCached(script.Cid()) {
+		t.Error("prefetch went past the configured depth")
+	}
+
+	if fetched := prefetchIndex(ctx, dserv, root, 2, 10); fetched != 4 {
+		t.Fatalf("expected 4 blocks prefetched at depth 2, got %d", fetched)
+	}
+	if !dserv.isCached(script.Cid()) {
+		t.Errorf("expected %s to be prefetched", script.Cid())
+	}
+
+	if fetched := prefetchIndex(ctx, dserv, root, 2, 2); fetched != 2 {
+		t.Fatalf("expected prefetch to stop after 2 blocks, got %d", fetched)
+	}
+}
+
+func TestPrefetchIndexWithoutIndex(t *testing.T) {
+	ctx := context.Background()
+	dserv := newCachingDAG()
+
+	style := fileNode("body {}")
+	root := ft.EmptyDirNode()
+	if err := root.AddNodeLink("style.css", style); err != nil {
+		t.Fatal(err)
+	}
+	if err := dserv.AddMany(ctx, []ipld.Node{style, root}); err != nil {
+		t.Fatal(err)
+	}
+
+	if fetched := prefetchIndex(ctx, dserv, root, 1, 10); fetched != 0 {
+		t.Fatalf("expected no prefetch for a directory without index.html, got %d", fetched)
+	}
+	if fetched := prefetchIndex(ctx, dserv, style, 1, 10); fetched != 0 {
+		t.Fatalf("expected no prefetch for a file, got %d", fetched)
+	}
+}
+
+func TestPrefetchRoot(t *testing.T) {
+	dir := ft.EmptyDirNode().Cid()
+	roots := "bafkqaaa," + dir.String()
+	for _, tc := range []struct {
+		name, method, path, roots string
+		status                    int
+		prefetch                  bool
+	}{
+		{"directory", http.MethodGet, "/ipfs/bafkqaaa/dir/", roots, http.StatusOK, true},
+		{"file", http.MethodGet, "/ipfs/bafkqaaa/dir/index.html", roots, http.StatusOK, false},
+		{"HEAD", http.MethodHead, "/ipfs/bafkqaaa/dir/", roots, http.StatusOK, false},
+		{"not found", http.MethodGet, "/ipfs/bafkqaaa/dir/", roots, http.StatusNotFound, false},
+		{"redirect", http.MethodGet, "/ipfs/bafkqaaa/dir/", roots, http.StatusMovedPermanently, false},
+		{"no roots", http.MethodGet, "/ipfs/bafkqaaa/dir/", "", http.StatusOK, false},
+		{"not a gateway path", http.MethodGet, "/api/v0/", roots, http.StatusOK, false},
+	} {
+		r := httptest.NewRequest(tc.method, tc.path, nil)
+		header := http.Header{}
+		if tc.roots != "" {
+			header.Set("X-Ipfs-Roots", tc.roots)
+		}
+		c, ok := prefetchRoot(r, tc.status, header)
+		if ok != tc.prefetch {
+			t.Errorf("%s: expected prefetch %v, got %v", tc.name, tc.prefetch, ok)
+		}
+		if ok && c != dir {
+			t.Errorf("%s: expected to prefetch from %s, got %s", tc.name, dir, c)
+		}
+	}
+}
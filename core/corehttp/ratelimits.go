@@ -0,0 +1,123 @@
+package corehttp
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	core "github.com/ipfs/kubo/core"
+)
+
+// rateLimiterState describes a live rate limiter of the public gateway.
+type rateLimiterState struct {
+	Key       string    `json:"key"`
+	Tokens    float64   `json:"tokens"`
+	Throttled bool      `json:"throttled"`
+	LastSeen  time.Time `json:"last_seen"`
+}
+
+// rateLimitsState is the body listing the live rate limiters.
+type rateLimitsState struct {
+	IP  []rateLimiterState `json:"ip"`
+	CID []rateLimiterState `json:"cid"`
+}
+
+// RateLimitsOption lists the in-memory per-IP and per-CID rate limiters of
+// the public gateway on GET requests to path, and removes them on DELETE
+// requests. A DELETE removes the limiter of the 'key' parameter, or all of
+// them when it is not set, in the limiters of the 'scope' parameter, "ip" or
+// "cid", or in both when it is not set. CID keys can be given as CIDs.
+// Limiters kept in Redis are not listed. It is meant for the API server.
+func RateLimitsOption(path string) ServeOption {
+	return func(_ *core.IpfsNode, _ net.Listener, mux *http.ServeMux) (*http.ServeMux, error) {
+		mux.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
+			switch r.Method {
+			case http.MethodGet:
+				w.Header().Set("Content-Type", "application/json")
+				if err := json.NewEncoder(w).Encode(rateLimits()); err != nil {
+					log.Debugf("writing rate limits: %s", err)
+				}
+			case http.MethodDelete:
+				if err := r.ParseForm(); err != nil {
+					http.Error(w, err.Error(), http.StatusBadRequest)
+					return
+				}
+				removed, err := resetRateLimits(r.Form.Get("scope"), r.Form.Get("key"))
+				if err != nil {
+					http.Error(w, err.Error(), http.StatusBadRequest)
+					return
+				}
+				log.Infof("removed %d rate limiters", removed)
+				w.Header().Set("Content-Type", "application/json")
+				json.NewEncoder(w).Encode(struct {
+					Removed int `json:"removed"`
+				}{removed})
+			default:
+				http.Error(w, "only GET and DELETE allowed", http.StatusMethodNotAllowed)
+			}
+		})
+		return mux, nil
+	}
+}
+
+// rateLimits returns the state of the limiters, sorted by key.
+func rateLimits() rateLimitsState {
+	mtx.Lock()
+	defer mtx.Unlock()
+
+	t := now()
+	list := func(limitMap map[string]*limiterEntry) []rateLimiterState {
+		states := make([]rateLimiterState, 0, len(limitMap))
+		for key, entry := range limitMap {
+			tokens := entry.limiter.TokensAt(t)
+			states = append(states, rateLimiterState{
+				Key:       key,
+				Tokens:    tokens,
+				Throttled: tokens < 1,
+				LastSeen:  entry.lastAccess,
+			})
+		}
+		sort.Slice(states, func(i, j int) bool { return states[i].Key < states[j].Key })
+		return states
+	}
+	return rateLimitsState{IP: list(ipLimiters), CID: list(cidLimiters)}
+}
+
+// resetRateLimits removes the limiters of key, or all limiters if key is
+// empty, in scope, or in both scopes if scope is empty. It returns the
+// number of limiters removed.
+func resetRateLimits(scope, key string) (int, error) {
+	ip := scope == "" || scope == "ip"
+	cids := scope == "" || scope == "cid"
+	if !ip && !cids {
+		return 0, fmt.Errorf("unknown scope %q, expected \"ip\" or \"cid\"", scope)
+	}
+
+	mtx.Lock()
+	defer mtx.Unlock()
+
+	var removed int
+	remove := func(limitMap map[string]*limiterEntry, key string) {
+		if key == "" {
+			removed += len(limitMap)
+			clear(limitMap)
+		} else if _, ok := limitMap[key]; ok {
+			delete(limitMap, key)
+			removed++
+		}
+	}
+	if ip {
+		remove(ipLimiters, key)
+	}
+	if cids {
+		if c, err := cid.Decode(key); err == nil {
+			key = normalizeCIDKey(c)
+		}
+		remove(cidLimiters, key)
+	}
+	return removed, nil
+}
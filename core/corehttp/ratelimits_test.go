@@ -0,0 +1,88 @@
+package corehttp
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/ipfs/go-cid"
+)
+
+func TestRateLimitsOption(t *testing.T) {
+	t.Cleanup(func() {
+		mtx.Lock()
+		clear(ipLimiters)
+		clear(cidLimiters)
+		mtx.Unlock()
+	})
+	mux, err := RateLimitsOption("/debug/ratelimits")(nil, nil, http.NewServeMux())
+	if err != nil {
+		t.Fatal(err)
+	}
+	do := func(method, target string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest(method, target, nil))
+		return w
+	}
+	list := func() rateLimitsState {
+		t.Helper()
+		w := do(http.MethodGet, "/debug/ratelimits")
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected 200, got %d", w.Code)
+		}
+		var state rateLimitsState
+		if err := json.NewDecoder(w.Body).Decode(&state); err != nil {
+			t.Fatal(err)
+		}
+		return state
+	}
+
+	getLimiter("192.0.2.1:1234", ipLimiters, 1, time.Minute).Allow()
+	getLimiter("192.0.2.2:1234", ipLimiters, 10, time.Minute)
+	cidKey := normalizeCIDKey(cid.MustParse(testCid))
+	getLimiter(cidKey, cidLimiters, 10, time.Minute)
+
+	state := list()
+	if len(state.IP) != 2 || len(state.CID) != 1 {
+		t.Fatalf("expected 2 IP and 1 CID limiters, got %d and %d", len(state.IP), len(state.CID))
+	}
+	if ip := state.IP[0]; ip.Key != "192.0.2.1:1234" || !ip.Throttled || ip.LastSeen.IsZero() {
+		t.Fatalf("expected the first IP to be throttled, got %+v", ip)
+	}
+	if ip := state.IP[1]; ip.Throttled || ip.Tokens != 10 {
+		t.Fatalf("expected the second IP to have its 10 tokens, got %+v", ip)
+	}
+	if c := state.CID[0]; c.Key != cidKey {
+		t.Fatalf("expected the CID limiter of %s, got %+v", cidKey, c)
+	}
+
+	// CID limiters can be removed by CID
+	if w := do(http.MethodDelete, "/debug/ratelimits?scope=cid&key="+testCid); w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", w.Code)
+	}
+	if state := list(); len(state.CID) != 0 || len(state.IP) != 2 {
+		t.Fatalf("expected only the CID limiter to be removed, got %+v", state)
+	}
+
+	if w := do(http.MethodDelete, "/debug/ratelimits?scope=host"); w.Code != http.StatusBadRequest {
+		t.Fatalf("expected an unknown scope to be rejected, got %d", w.Code)
+	}
+
+	w := do(http.MethodDelete, "/debug/ratelimits")
+	var res struct{ Removed int }
+	if err := json.NewDecoder(w.Body).Decode(&res); err != nil {
+		t.Fatal(err)
+	}
+	if res.Removed != 2 {
+		t.Fatalf("expected 2 limiters to be removed, got %d", res.Removed)
+	}
+	if state := list(); len(state.IP) != 0 || len(state.CID) != 0 {
+		t.Fatalf("expected all limiters to be removed, got %+v", state)
+	}
+
+	if w := do(http.MethodPost, "/debug/ratelimits"); w.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("expected 405, got %d", w.Code)
+	}
+}
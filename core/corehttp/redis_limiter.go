@@ -0,0 +1,139 @@
+package corehttp
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"sync/atomic"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"golang.org/x/time/rate"
+)
+
+// redisLimiterTimeout bounds the Redis round trip of a rate limit check.
+const redisLimiterTimeout = 100 * time.Millisecond
+
+// limiter lets through the requests of a key up to a rate.
+type limiter interface {
+	Allow() bool
+	// Delay returns how long until Allow lets a request through again.
+	Delay() time.Duration
+}
+
+// memoryLimiter is a limiter local to this process.
+type memoryLimiter struct {
+	*rate.Limiter
+}
+
+func (l memoryLimiter) Delay() time.Duration {
+	// only peek at the delay, the request is rejected anyway
+	res := l.Reserve()
+	delay := res.Delay()
+	res.Cancel()
+	return delay
+}
+
+// slidingWindowScript records a request in the sorted set KEYS[1] unless it
+// already holds ARGV[3] requests in the window of ARGV[2] milliseconds
+// ending at ARGV[1]. It returns 0 when the request is allowed, the number of
+// milliseconds until the oldest request leaves the window otherwise.
+var slidingWindowScript = redis.NewScript(`
+local now = tonumber(ARGV[1])
+local window = tonumber(ARGV[2])
+local limit = tonumber(ARGV[3])
+redis.call('ZREMRANGEBYSCORE', KEYS[1], '-inf', now - window)
+if redis.call('ZCARD', KEYS[1]) < limit then
+	redis.call('ZADD', KEYS[1], now, ARGV[4])
+	redis.call('PEXPIRE', KEYS[1], window)
+	return 0
+end
+local oldest = redis.call('ZRANGE', KEYS[1], 0, 0, 'WITHSCORES')
+return tonumber(oldest[2]) + window - now
+`)
+
+// redisLimiter is a sliding window limiter whose counts are kept in Redis,
+// so all the gateways sharing a Redis server enforce the limit together.
+// When Redis can't be reached, the in-memory limiter returned by fallback is
+// used instead.
+type redisLimiter struct {
+	ctx    context.Context
+	client *redis.Client
+	// down is set while Redis is unavailable, so the outage is logged once
+	// rather than on each request
+	down     *atomic.Bool
+	key      string
+	requests int
+	window   time.Duration
+	fallback func() *rate.Limiter
+
+	delay time.Duration
+	local *rate.Limiter
+}
+
+func (l *redisLimiter) Allow() bool {
+	var member [8]byte
+	if _, err := rand.Read(member[:]); err != nil {
+		return l.useFallback(err)
+	}
+
+	ctx, cancel := context.WithTimeout(l.ctx, redisLimiterTimeout)
+	defer cancel()
+	ms, err := slidingWindowScript.Run(ctx, l.client, []string{l.key},
+		now().UnixMilli(), l.window.Milliseconds(), l.requests, hex.EncodeToString(member[:])).Int64()
+	if err != nil {
+		return l.useFallback(err)
+	}
+	if l.down.CompareAndSwap(true, false) {
+		log.Infof("Redis is available again, rate limiting through it")
+	}
+	l.delay = time.Duration(ms) * time.Millisecond
+	return ms == 0
+}
+
+func (l *redisLimiter) useFallback(err error) bool {
+	// a request going away tells nothing about Redis
+	if l.ctx.Err() == nil && l.down.CompareAndSwap(false, true) {
+		log.Warnf("Redis is unavailable, rate limiting in memory until it is back: %s", err)
+	}
+	log.Debugf("rate limiting %s in memory, Redis is unavailable: %s", l.key, err)
+	l.local = l.fallback()
+	return l.local.Allow()
+}
+
+func (l *redisLimiter) Delay() time.Duration {
+	if l.local != nil {
+		return memoryLimiter{l.local}.Delay()
+	}
+	return l.delay
+}
+
+// newRedisClient returns a client of the Redis server at addr, or nil when
+// addr is empty.
+func newRedisClient(addr string) *redis.Client {
+	if addr == "" {
+		return nil
+	}
+	return redis.NewClient(&redis.Options{Addr: addr})
+}
+
+// limiterFor returns the limiter of key in scope, allowing requests per
+// window: shared through Redis when RedisConn is set, kept in limitMap
+// otherwise.
+func (m *gatewayMiddleware) limiterFor(ctx context.Context, scope, key string, limitMap map[string]*limiterEntry, requests int, window time.Duration) limiter {
+	fallback := func() *rate.Limiter {
+		return getLimiter(key, limitMap, requests, window)
+	}
+	if m.redis == nil {
+		return memoryLimiter{fallback()}
+	}
+	return &redisLimiter{
+		ctx:      ctx,
+		client:   m.redis,
+		down:     &m.redisDown,
+		key:      "kubo:ratelimit:" + scope + ":" + key,
+		requests: requests,
+		window:   window,
+		fallback: fallback,
+	}
+}
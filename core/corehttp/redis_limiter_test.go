@@ -0,0 +1,173 @@
+package corehttp
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	cid "github.com/ipfs/go-cid"
+	"github.com/ipfs/kubo/config"
+	"github.com/ipfs/kubo/core/cidstats"
+)
+
+func TestRedisLimiterSharedAcrossInstances(t *testing.T) {
+	mr := miniredis.RunT(t)
+	ts := newTestPinningService(t)
+	t.Cleanup(func() {
+		mtx.Lock()
+		clear(ipLimiters)
+		clear(cidLimiters)
+		mtx.Unlock()
+	})
+
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	newGateway := func() http.Handler {
+		return DedicatedGatewayMiddleware(next, nil, &config.Config{
+			ConfigPinningService: config.ConfigPinningService{
+				PinningService: ts.URL,
+				RedisConn:      mr.Addr(),
+				CIDRateLimit:   3,
+			},
+		})
+	}
+	gateways := []http.Handler{newGateway(), newGateway()}
+
+	var allowed int
+	var last *httptest.ResponseRecorder
+	for i := 0; i < 6; i++ {
+		last = httptest.NewRecorder()
+		gateways[i%2].ServeHTTP(last, httptest.NewRequest(http.MethodGet, "/ipfs/"+testCid, nil))
+		if last.Code == http.StatusOK {
+			allowed++
+		}
+	}
+	if allowed != 3 {
+		t.Fatalf("expected 3 requests allowed across both gateways, got %d", allowed)
+	}
+	if last.Code != http.StatusTooManyRequests || last.Header().Get("Retry-After") == "" {
+		t.Fatalf("expected 429 with Retry-After, got %d", last.Code)
+	}
+
+	mtx.Lock()
+	n := len(cidLimiters)
+	mtx.Unlock()
+	if n != 0 {
+		t.Fatalf("expected no in-memory limiters, got %d", n)
+	}
+}
+
+func TestRedisLimiterWindow(t *testing.T) {
+	mr := miniredis.RunT(t)
+	clock := time.Unix(1700000000, 0)
+	now = func() time.Time { return clock }
+	t.Cleanup(func() { now = time.Now })
+
+	a := &gatewayMiddleware{redis: newRedisClient(mr.Addr())}
+	b := &gatewayMiddleware{redis: newRedisClient(mr.Addr())}
+	limiter := func(m *gatewayMiddleware) limiter {
+		return m.limiterFor(context.Background(), "ip", "192.0.2.1", nil, 2, time.Minute)
+	}
+
+	if !limiter(a).Allow() || !limiter(b).Allow() {
+		t.Fatal("expected the first 2 requests to be allowed")
+	}
+	clock = clock.Add(20 * time.Second)
+	l := limiter(a)
+	if l.Allow() {
+		t.Fatal("expected the third request to be rejected")
+	}
+	if d := l.Delay(); d != 40*time.Second {
+		t.Fatalf("expected a 40s delay, got %s", d)
+	}
+
+	// the first requests left the window
+	clock = clock.Add(40 * time.Second)
+	if !limiter(b).Allow() {
+		t.Fatal("expected a request to be allowed once the window slid")
+	}
+}
+
+func TestRedisLimiterFallback(t *testing.T) {
+	mr := miniredis.RunT(t)
+	t.Cleanup(func() {
+		mtx.Lock()
+		clear(ipLimiters)
+		mtx.Unlock()
+	})
+
+	m := &gatewayMiddleware{redis: newRedisClient(mr.Addr())}
+	mr.Close()
+
+	l := m.limiterFor(context.Background(), "ip", "192.0.2.1", ipLimiters, 1, time.Minute)
+	if !l.Allow() {
+		t.Fatal("expected the in-memory limiter to allow the first request")
+	}
+	l = m.limiterFor(context.Background(), "ip", "192.0.2.1", ipLimiters, 1, time.Minute)
+	if l.Allow() {
+		t.Fatal("expected the in-memory limiter to reject the second request")
+	}
+	if l.Delay() <= 0 {
+		t.Fatal("expected a delay from the in-memory limiter")
+	}
+	if !m.redisDown.Load() {
+		t.Fatal("expected the outage to be recorded")
+	}
+
+	if err := mr.Restart(); err != nil {
+		t.Fatal(err)
+	}
+	if !m.limiterFor(context.Background(), "ip", "192.0.2.2", ipLimiters, 1, time.Minute).Allow() {
+		t.Fatal("expected Redis to allow the first request once back")
+	}
+	if m.redisDown.Load() {
+		t.Fatal("expected the recovery to be recorded")
+	}
+}
+
+func TestCIDStatsCountedInRedis(t *testing.T) {
+	mr := miniredis.RunT(t)
+	ts, _ := newCountingDmcaService(t)
+	t.Cleanup(func() {
+		mtx.Lock()
+		clear(ipLimiters)
+		clear(cidLimiters)
+		mtx.Unlock()
+	})
+
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	handler := DedicatedGatewayMiddleware(next, nil, &config.Config{
+		ConfigPinningService: config.ConfigPinningService{
+			PinningService: ts.URL,
+			RedisConn:      mr.Addr(),
+			CIDStats:       true,
+		},
+	})
+	runningMiddlewares.Lock()
+	m := runningMiddlewares.list[len(runningMiddlewares.list)-1]
+	runningMiddlewares.Unlock()
+	t.Cleanup(func() { m.stats.Close() })
+
+	for _, c := range []string{testCid, testCid, testBlockedCid} {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/ipfs/"+c, nil))
+	}
+	ctx := context.Background()
+	if err := m.stats.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+	top, err := cidstats.Top(ctx, m.redis, time.Now(), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the blocked CID wasn't served, so it isn't counted
+	want := cidstats.Count{Key: normalizeCIDKey(cid.MustParse(testCid)), Requests: 2}
+	if len(top) != 1 || top[0] != want {
+		t.Fatalf("expected %v, got %v", want, top)
+	}
+}
@@ -0,0 +1,58 @@
+package corehttp
+
+import (
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+
+	config "github.com/ipfs/kubo/config"
+)
+
+const defaultRefererDenyStatus = http.StatusForbidden
+
+// refererAllowed reports whether the Referer of r is allowed to embed content
+// served by the gateway. Requests coming from the gateway itself are always
+// allowed, as are requests without a Referer unless BlockEmptyReferer is set.
+func refererAllowed(r *http.Request, cfg config.ConfigPinningService) bool {
+	if len(cfg.RefererAllowlist) == 0 {
+		return true
+	}
+
+	referer := r.Header.Get("Referer")
+	if referer == "" {
+		return !cfg.BlockEmptyReferer
+	}
+
+	u, err := url.Parse(referer)
+	if err != nil || u.Hostname() == "" {
+		return false
+	}
+	host := strings.ToLower(u.Hostname())
+
+	if self, _, err := net.SplitHostPort(r.Host); err == nil {
+		if strings.EqualFold(host, self) {
+			return true
+		}
+	} else if strings.EqualFold(host, r.Host) {
+		return true
+	}
+
+	for _, domain := range cfg.RefererAllowlist {
+		domain = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(domain), "."))
+		if domain == "" {
+			continue
+		}
+		if host == domain || strings.HasSuffix(host, "."+domain) {
+			return true
+		}
+	}
+	return false
+}
+
+func refererDenyStatus(cfg config.ConfigPinningService) int {
+	if cfg.RefererDenyStatus == 0 {
+		return defaultRefererDenyStatus
+	}
+	return cfg.RefererDenyStatus
+}
@@ -0,0 +1,78 @@
+package corehttp
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ipfs/kubo/config"
+)
+
+const testCid = "bafkqaaa"
+
+func newTestPinningService(t *testing.T) *httptest.Server {
+	t.Helper()
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+func TestRefererAllowlist(t *testing.T) {
+	ts := newTestPinningService(t)
+	cfg := &config.Config{
+		ConfigPinningService: config.ConfigPinningService{
+			PinningService:   ts.URL,
+			DedicatedGateway: true,
+			RefererAllowlist: []string{"example.com"},
+		},
+	}
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	handler := DedicatedGatewayMiddleware(next, nil, cfg)
+
+	for _, tc := range []struct {
+		name    string
+		referer string
+		status  int
+	}{
+		{"allowed", "https://example.com/page.html", http.StatusOK},
+		{"allowed subdomain", "https://www.example.com/", http.StatusOK},
+		{"disallowed", "https://hotlinker.net/", http.StatusForbidden},
+		{"suffix is not a subdomain", "https://badexample.com/", http.StatusForbidden},
+		{"empty", "", http.StatusOK},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/ipfs/"+testCid, nil)
+			if tc.referer != "" {
+				r.Header.Set("Referer", tc.referer)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+			if w.Code != tc.status {
+				t.Fatalf("expected status %d, got %d", tc.status, w.Code)
+			}
+		})
+	}
+}
+
+func TestRefererDenyStatus(t *testing.T) {
+	cfg := &config.Config{
+		ConfigPinningService: config.ConfigPinningService{
+			DedicatedGateway:  true,
+			RefererAllowlist:  []string{"example.com"},
+			RefererDenyStatus: http.StatusNotFound,
+			BlockEmptyReferer: true,
+		},
+	}
+	handler := DedicatedGatewayMiddleware(http.NotFoundHandler(), nil, cfg)
+
+	r := httptest.NewRequest(http.MethodGet, "/ipfs/"+testCid, nil)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("expected status %d, got %d", http.StatusNotFound, w.Code)
+	}
+}
@@ -0,0 +1,224 @@
+package corehttp
+
+import (
+	"html/template"
+	"reflect"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	config "github.com/ipfs/kubo/config"
+	"github.com/ipfs/kubo/core/cidstats"
+	"github.com/redis/go-redis/v9"
+)
+
+// gatewaySettings are the settings read by DedicatedGatewayMiddleware on each
+// request. They are replaced as a whole when the pinning service
+// configuration is reloaded.
+type gatewaySettings struct {
+	cfg             *config.Config
+	ipRateLimit     int
+	cidRateLimit    int
+	rateLimitWindow time.Duration
+	allowedCIDs     map[string]struct{}
+	ipAllowlist     ipPrefixes
+	ipDenylist      ipPrefixes
+	trustedProxies  ipPrefixes
+	dmcaPage        *template.Template
+}
+
+func newGatewaySettings(cfg *config.Config) *gatewaySettings {
+	s := &gatewaySettings{
+		cfg:             cfg,
+		ipRateLimit:     cfg.ConfigPinningService.IPRateLimit,
+		cidRateLimit:    cfg.ConfigPinningService.CIDRateLimit,
+		rateLimitWindow: cfg.ConfigPinningService.RateLimitWindow.WithDefault(defaultRateLimitWindow),
+		ipAllowlist:     parseIPPrefixes("IPAllowlist", cfg.ConfigPinningService.IPAllowlist),
+		ipDenylist:      parseIPPrefixes("IPDenylist", cfg.ConfigPinningService.IPDenylist),
+		trustedProxies:  parseIPPrefixes("TrustedProxies", cfg.ConfigPinningService.TrustedProxies),
+		dmcaPage:        loadDmcaPage(cfg.ConfigPinningService.DmcaBlockedPage),
+	}
+	if s.ipRateLimit == 0 {
+		s.ipRateLimit = defaultIPRateLimit
+	}
+	if s.cidRateLimit == 0 {
+		s.cidRateLimit = defaultCIDRateLimit
+	}
+	for _, allowed := range cfg.ConfigPinningService.AllowedCIDs {
+		c, err := cid.Decode(allowed)
+		if err != nil {
+			log.Warnf("ignoring invalid allowed CID %q: %s", allowed, err)
+			continue
+		}
+		if s.allowedCIDs == nil {
+			s.allowedCIDs = make(map[string]struct{})
+		}
+		s.allowedCIDs[normalizeCIDKey(c)] = struct{}{}
+	}
+	return s
+}
+
+// allowlisted reports whether the content of the normalized CID key is in
+// AllowedCIDs.
+func (s *gatewaySettings) allowlisted(key string) bool {
+	_, ok := s.allowedCIDs[key]
+	return ok
+}
+
+// gatewayMiddleware holds the reloadable state of a running
+// DedicatedGatewayMiddleware.
+type gatewayMiddleware struct {
+	settings atomic.Pointer[gatewaySettings]
+	dmca     *dmcaCache
+	cooldown *errorCooldown
+	access   *accessCache
+	inflight *cidInflight
+	redis    *redis.Client
+	stats    *cidstats.Counter
+
+	// redisDown is set while Redis is unreachable
+	redisDown atomic.Bool
+}
+
+var runningMiddlewares struct {
+	sync.Mutex
+	list []*gatewayMiddleware
+}
+
+func newGatewayMiddleware(cfg *config.Config) *gatewayMiddleware {
+	m := &gatewayMiddleware{
+		dmca:     newDmcaCache(cfg.ConfigPinningService),
+		cooldown: newErrorCooldown(cfg.ConfigPinningService.AccessErrorCooldownSize, cfg.ConfigPinningService.AccessErrorCooldown.WithDefault(defaultAccessErrorCooldown)),
+		access:   newAccessCache(cfg.ConfigPinningService.AccessCacheSize, cfg.ConfigPinningService.AccessCacheTTL.WithDefault(defaultAccessCacheTTL), cfg.ConfigPinningService.StaleIfError.WithDefault(0)),
+		inflight: newCidInflight(),
+		redis:    newRedisClient(cfg.ConfigPinningService.RedisConn),
+	}
+	if cfg.ConfigPinningService.CIDStats && m.redis != nil {
+		m.stats = cidstats.NewCounterConfig(m.redis, cfg.ConfigPinningService)
+	}
+	m.settings.Store(newGatewaySettings(cfg))
+	return m
+}
+
+// registerMiddleware adds m to runningMiddlewares, where ReloadPinningService
+// finds it, until the returned function is called.
+func registerMiddleware(m *gatewayMiddleware) func() {
+	runningMiddlewares.Lock()
+	defer runningMiddlewares.Unlock()
+	runningMiddlewares.list = append(runningMiddlewares.list, m)
+
+	return func() {
+		runningMiddlewares.Lock()
+		defer runningMiddlewares.Unlock()
+		for i, l := range runningMiddlewares.list {
+			if l == m {
+				runningMiddlewares.list = append(runningMiddlewares.list[:i], runningMiddlewares.list[i+1:]...)
+				break
+			}
+		}
+	}
+}
+
+// withReloadable returns cur with the settings of next that running
+// middlewares apply without a restart.
+func withReloadable(cur, next config.ConfigPinningService) config.ConfigPinningService {
+	cur.PinningService = next.PinningService
+	cur.PinningServiceEndpoints = next.PinningServiceEndpoints
+	cur.UpstreamTimeout = next.UpstreamTimeout
+	cur.BlockserviceApiKey = next.BlockserviceApiKey
+	cur.RefererAllowlist = next.RefererAllowlist
+	cur.RefererDenyStatus = next.RefererDenyStatus
+	cur.BlockEmptyReferer = next.BlockEmptyReferer
+	cur.CanonicalGatewayPaths = next.CanonicalGatewayPaths
+	cur.TrailingSlash = next.TrailingSlash
+	cur.DmcaAllowedTTL = next.DmcaAllowedTTL
+	cur.DmcaBlockedTTL = next.DmcaBlockedTTL
+	cur.DmcaFailMode = next.DmcaFailMode
+	cur.DmcaBlockedPage = next.DmcaBlockedPage
+	cur.AccessErrorCooldown = next.AccessErrorCooldown
+	cur.StaleIfError = next.StaleIfError
+	cur.IPRateLimit = next.IPRateLimit
+	cur.CIDRateLimit = next.CIDRateLimit
+	cur.RateLimitWindow = next.RateLimitWindow
+	cur.ClientKeyHeader = next.ClientKeyHeader
+	cur.ClientKeyParam = next.ClientKeyParam
+	cur.URLSigningKey = next.URLSigningKey
+	cur.RequireClientKey = next.RequireClientKey
+	cur.AllowedCIDs = next.AllowedCIDs
+	cur.IPAllowlist = next.IPAllowlist
+	cur.IPDenylist = next.IPDenylist
+	cur.TrustedProxies = next.TrustedProxies
+	cur.MaxConcurrentPerCID = next.MaxConcurrentPerCID
+	cur.CircuitBreakerThreshold = next.CircuitBreakerThreshold
+	cur.CircuitBreakerCooldown = next.CircuitBreakerCooldown
+	return cur
+}
+
+// reload swaps in the reloadable settings of next and returns the names of
+// the settings that changed, split between those applied and those that
+// require a restart.
+func (m *gatewayMiddleware) reload(next config.ConfigPinningService) (applied, restart []string) {
+	old := m.settings.Load()
+	cur := old.cfg.ConfigPinningService
+	merged := withReloadable(cur, next)
+
+	cv, nv, mv := reflect.ValueOf(cur), reflect.ValueOf(next), reflect.ValueOf(merged)
+	for i := 0; i < cv.NumField(); i++ {
+		if reflect.DeepEqual(cv.Field(i).Interface(), nv.Field(i).Interface()) {
+			continue
+		}
+		name := cv.Type().Field(i).Name
+		if reflect.DeepEqual(mv.Field(i).Interface(), nv.Field(i).Interface()) {
+			applied = append(applied, name)
+		} else {
+			restart = append(restart, name)
+		}
+	}
+	if len(applied) == 0 {
+		return applied, restart
+	}
+
+	cfg := *old.cfg
+	cfg.ConfigPinningService = merged
+	m.dmca.setTTLs(merged.DmcaAllowedTTL.WithDefault(defaultDmcaAllowedTTL), merged.DmcaBlockedTTL.WithDefault(0))
+	m.dmca.setStaleIfError(merged.StaleIfError.WithDefault(0))
+	m.access.setStaleIfError(merged.StaleIfError.WithDefault(0))
+	m.cooldown.setWindow(merged.AccessErrorCooldown.WithDefault(defaultAccessErrorCooldown))
+	m.settings.Store(newGatewaySettings(&cfg))
+	return applied, restart
+}
+
+// ReloadPinningService applies cfg to the gateway middlewares of the running
+// servers. Settings read on each request, such as the pinning service
+// endpoint and key, referer rules, cache TTLs and rate limits, take effect
+// immediately. The names of the changed settings that are only read at
+// startup are returned so they can be reported as requiring a restart.
+func ReloadPinningService(cfg config.ConfigPinningService) ([]string, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	runningMiddlewares.Lock()
+	defer runningMiddlewares.Unlock()
+
+	var applied, restart []string
+	for _, m := range runningMiddlewares.list {
+		a, r := m.reload(cfg)
+		for _, name := range a {
+			if !slices.Contains(applied, name) {
+				applied = append(applied, name)
+			}
+		}
+		for _, name := range r {
+			if !slices.Contains(restart, name) {
+				restart = append(restart, name)
+			}
+		}
+	}
+	for _, name := range applied {
+		log.Infof("reloaded ConfigPinningService.%s", name)
+	}
+	return restart, nil
+}
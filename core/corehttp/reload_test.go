@@ -0,0 +1,100 @@
+package corehttp
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+
+	"github.com/ipfs/kubo/config"
+)
+
+func TestReloadPinningServiceRateLimits(t *testing.T) {
+	const other = "QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n"
+	ts := newTestPinningService(t)
+	cfg := &config.Config{
+		ConfigPinningService: config.ConfigPinningService{
+			PinningService:     ts.URL,
+			BlockserviceApiKey: "test",
+			CIDRateLimit:       2,
+		},
+	}
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	runningMiddlewares.Lock()
+	runningMiddlewares.list = nil
+	runningMiddlewares.Unlock()
+	handler := DedicatedGatewayMiddleware(next, nil, cfg)
+	t.Cleanup(func() {
+		mtx.Lock()
+		clear(ipLimiters)
+		clear(cidLimiters)
+		mtx.Unlock()
+	})
+
+	// allowed returns how many of n requests for c go through.
+	allowed := func(c string, n int) int {
+		var ok int
+		for i := 0; i < n; i++ {
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/ipfs/"+c, nil))
+			if w.Code == http.StatusOK {
+				ok++
+			}
+		}
+		return ok
+	}
+
+	if got := allowed(testCid, 5); got != 2 {
+		t.Fatalf("expected 2 requests allowed before reload, got %d", got)
+	}
+
+	reloaded := cfg.ConfigPinningService
+	reloaded.CIDRateLimit = 4
+	reloaded.MaxConcurrentRequests = 10
+	restart, err := ReloadPinningService(reloaded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(restart, []string{"MaxConcurrentRequests"}) {
+		t.Fatalf("expected only MaxConcurrentRequests to require a restart, got %v", restart)
+	}
+
+	if got := allowed(other, 5); got != 4 {
+		t.Fatalf("expected 4 requests allowed after reload, got %d", got)
+	}
+
+	reloaded.CIDRateLimit = -1
+	if _, err := ReloadPinningService(reloaded); err == nil {
+		t.Fatal("expected an invalid config to be rejected")
+	}
+	if got := runningMiddlewares.list[0].settings.Load().cidRateLimit; got != 4 {
+		t.Fatalf("expected the rejected config to leave the limit at 4, got %d", got)
+	}
+}
+
+func TestServeDeregistersMiddleware(t *testing.T) {
+	running := func() int {
+		runningMiddlewares.Lock()
+		defer runningMiddlewares.Unlock()
+		return len(runningMiddlewares.list)
+	}
+	before := running()
+
+	t.Run("serving", func(t *testing.T) {
+		addr, _ := startServer(t, config.Config{})
+		resp, err := http.Get("http://" + addr + "/")
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if got := running(); got != before+1 {
+			t.Fatalf("expected the server's middleware to be registered, got %d middlewares for %d before", got, before)
+		}
+	})
+
+	if got := running(); got != before {
+		t.Fatalf("expected the middleware to be removed once the server stopped, got %d middlewares for %d before", got, before)
+	}
+}
@@ -0,0 +1,91 @@
+package corehttp
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"time"
+)
+
+// requestIDHeader carries the ID of a gateway request, taken from the client
+// when it sent one and echoed back in the response.
+const requestIDHeader = "X-Request-ID"
+
+// maxRequestIDLength bounds the size of the request IDs accepted from
+// clients.
+const maxRequestIDLength = 128
+
+// Decisions logged by requestLog besides the outcomes of
+// gatewayAccessRequests.
+const (
+	decisionRefererDenied    = "referer_denied"
+	decisionInvalidPath      = "invalid_path"
+	decisionCoolingDown      = "cooling_down"
+	decisionCheckFailed      = "check_failed"
+	decisionRedirected       = "redirected"
+	decisionMaintenance      = "maintenance"
+	decisionMissingClientKey = "missing_client_key"
+	decisionBadSignature     = "bad_signature"
+)
+
+// requestID returns the ID sent by the client in X-Request-ID, or a new
+// random one if it sent none or one that can't be logged as is.
+func requestID(r *http.Request) string {
+	if id := r.Header.Get(requestIDHeader); id != "" && len(id) <= maxRequestIDLength && printable(id) {
+		return id
+	}
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		log.Debugf("generating request ID: %s", err)
+	}
+	return hex.EncodeToString(b[:])
+}
+
+func printable(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] < 0x21 || s[i] > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+// requestLog ties the access decision made by DedicatedGatewayMiddleware to
+// the request it was made for.
+type requestLog struct {
+	id       string
+	remoteIP string
+	cid      string
+	upstream time.Duration
+}
+
+func newRequestLog(r *http.Request) *requestLog {
+	return &requestLog{id: requestID(r), remoteIP: remoteIP(r.RemoteAddr)}
+}
+
+// timeUpstream adds the time spent since start in pinning service checks.
+func (l *requestLog) timeUpstream(start time.Time) {
+	l.upstream += time.Since(start)
+}
+
+// decision logs the outcome of the access checks and the response status.
+// Allowed requests are logged at debug level, blocked ones at info level and
+// DMCA blocks and upstream failures at warn level.
+func (l *requestLog) decision(outcome string, status int) {
+	fields := []interface{}{
+		"request_id", l.id,
+		"remote_ip", l.remoteIP,
+		"cid", l.cid,
+		"decision", outcome,
+		"status", status,
+		"upstream_latency", l.upstream,
+	}
+	switch {
+	case status < http.StatusBadRequest:
+		log.Debugw("gateway access decision", fields...)
+	case outcome == accessDmcaBlocked || status >= http.StatusInternalServerError:
+		log.Warnw("gateway access decision", fields...)
+	default:
+		log.Infow("gateway access decision", fields...)
+	}
+}
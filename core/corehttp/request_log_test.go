@@ -0,0 +1,108 @@
+package corehttp
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	logging "github.com/ipfs/go-log"
+	"github.com/ipfs/kubo/config"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+// observeLogs captures the entries logged by the package until the test
+// ends.
+func observeLogs(t *testing.T) *observer.ObservedLogs {
+	core, logs := observer.New(zapcore.DebugLevel)
+	orig := log
+	log = &logging.ZapEventLogger{SugaredLogger: *zap.New(core).Sugar()}
+	t.Cleanup(func() { log = orig })
+	return logs
+}
+
+func TestRequestLogBlocked(t *testing.T) {
+	logs := observeLogs(t)
+	ts, _ := newCountingDmcaService(t)
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	handler := DedicatedGatewayMiddleware(next, nil, &config.Config{
+		ConfigPinningService: config.ConfigPinningService{
+			PinningService:   ts.URL,
+			DedicatedGateway: true,
+		},
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/ipfs/"+testBlockedCid, nil)
+	req.RemoteAddr = "203.0.113.7:4321"
+	req.Header.Set(requestIDHeader, "req-1")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if w.Code != http.StatusGone {
+		t.Fatalf("expected 410, got %d", w.Code)
+	}
+	if id := w.Header().Get(requestIDHeader); id != "req-1" {
+		t.Fatalf("expected the request ID to be echoed, got %q", id)
+	}
+
+	entries := logs.FilterMessage("gateway access decision").All()
+	if len(entries) != 1 {
+		t.Fatalf("expected 1 decision logged, got %d", len(entries))
+	}
+	e := entries[0]
+	if e.Level != zapcore.WarnLevel {
+		t.Fatalf("expected a DMCA block to be logged as a warning, got %s", e.Level)
+	}
+	fields := e.ContextMap()
+	for k, want := range map[string]interface{}{
+		"request_id": "req-1",
+		"remote_ip":  "203.0.113.7",
+		"cid":        testBlockedCid,
+		"decision":   accessDmcaBlocked,
+		"status":     int64(http.StatusGone),
+	} {
+		if fields[k] != want {
+			t.Fatalf("expected %s to be %v, got %v", k, want, fields[k])
+		}
+	}
+	if _, ok := fields["upstream_latency"]; !ok {
+		t.Fatal("expected the upstream latency to be logged")
+	}
+}
+
+func TestRequestLogAllowed(t *testing.T) {
+	logs := observeLogs(t)
+	ts := newTestPinningService(t)
+	t.Cleanup(func() {
+		mtx.Lock()
+		clear(ipLimiters)
+		clear(cidLimiters)
+		mtx.Unlock()
+	})
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	handler := DedicatedGatewayMiddleware(next, nil, &config.Config{
+		ConfigPinningService: config.ConfigPinningService{PinningService: ts.URL},
+	})
+
+	// an unusable ID is replaced with a generated one
+	req := httptest.NewRequest(http.MethodGet, "/ipfs/"+testCid, nil)
+	req.Header.Set(requestIDHeader, "bad id")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	id := w.Header().Get(requestIDHeader)
+	if id == "" || id == "bad id" {
+		t.Fatalf("expected a generated request ID, got %q", id)
+	}
+
+	entries := logs.FilterField(zap.String("request_id", id)).All()
+	if len(entries) != 1 {
+		t.Fatalf("expected 1 decision logged, got %d", len(entries))
+	}
+	if e := entries[0]; e.Level != zapcore.DebugLevel || e.ContextMap()["decision"] != accessAllowed {
+		t.Fatalf("expected an allowed decision logged at debug level, got %s %v", e.Level, e.ContextMap()["decision"])
+	}
+}
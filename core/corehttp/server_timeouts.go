@@ -0,0 +1,27 @@
+package corehttp
+
+import (
+	"net/http"
+	"time"
+
+	config "github.com/ipfs/kubo/config"
+)
+
+const (
+	// defaultReadHeaderTimeout bounds the time to receive request headers
+	// unless ConfigPinningService.ServerReadHeaderTimeout is set.
+	defaultReadHeaderTimeout = 10 * time.Second
+	// defaultIdleTimeout is how long idle connections are kept open unless
+	// ConfigPinningService.ServerIdleTimeout is set.
+	defaultIdleTimeout = 2 * time.Minute
+)
+
+// setServerTimeouts sets the timeouts of server from cfg. Only the request
+// headers and idle connections are bounded by default, responses of large
+// objects taking as long as they need.
+func setServerTimeouts(server *http.Server, cfg config.ConfigPinningService) {
+	server.ReadHeaderTimeout = cfg.ServerReadHeaderTimeout.WithDefault(defaultReadHeaderTimeout)
+	server.ReadTimeout = cfg.ServerReadTimeout.WithDefault(0)
+	server.WriteTimeout = cfg.ServerWriteTimeout.WithDefault(0)
+	server.IdleTimeout = cfg.ServerIdleTimeout.WithDefault(defaultIdleTimeout)
+}
@@ -0,0 +1,49 @@
+package corehttp
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	config "github.com/ipfs/kubo/config"
+)
+
+func TestServeReadHeaderTimeout(t *testing.T) {
+	const timeout = 200 * time.Millisecond
+	addr, _ := startServer(t, config.Config{
+		ConfigPinningService: config.ConfigPinningService{
+			ServerReadHeaderTimeout: config.NewOptionalDuration(timeout),
+		},
+	})
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	start := time.Now()
+	// headers are never completed
+	if _, err := conn.Write([]byte("GET / HTTP/1.1\r\nHost: localhost\r\n")); err != nil {
+		t.Fatal(err)
+	}
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := io.ReadAll(conn); err != nil {
+		t.Fatalf("expected the server to close the connection, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < timeout || elapsed > timeout+2*time.Second {
+		t.Fatalf("expected the connection to be closed after %s, took %s", timeout, elapsed)
+	}
+}
+
+func TestSetServerTimeoutsDefaults(t *testing.T) {
+	server := new(http.Server)
+	setServerTimeouts(server, config.ConfigPinningService{})
+	if server.ReadHeaderTimeout != defaultReadHeaderTimeout || server.IdleTimeout != defaultIdleTimeout {
+		t.Fatalf("unexpected default timeouts: read header %s, idle %s", server.ReadHeaderTimeout, server.IdleTimeout)
+	}
+	if server.ReadTimeout != 0 || server.WriteTimeout != 0 {
+		t.Fatalf("expected no read and write timeouts by default, got %s and %s", server.ReadTimeout, server.WriteTimeout)
+	}
+}
@@ -0,0 +1,142 @@
+package corehttp
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	cid "github.com/ipfs/go-cid"
+	config "github.com/ipfs/kubo/config"
+)
+
+// Query parameters of the signed dedicated gateway URLs.
+const (
+	signedURLToken  = "token"
+	signedURLExpiry = "exp"
+	signedURLSig    = "sig"
+)
+
+// Query parameters of the appeal tokens.
+const (
+	appealExpiry = "appeal_exp"
+	appealSig    = "appeal_sig"
+)
+
+// appealPrefix sets the messages signed for appeal tokens apart from those
+// of signed URLs, so neither can be used as the other.
+const appealPrefix = "appeal|"
+
+var (
+	errSignatureExpired = errors.New("signed URL expired")
+	errSignatureInvalid = errors.New("invalid URL signature")
+)
+
+// urlSigningKey returns the secret signed URLs are checked with:
+// URLSigningKey, or BlockserviceApiKey when it isn't set.
+func urlSigningKey(cfg config.ConfigPinningService) string {
+	if cfg.URLSigningKey != "" {
+		return cfg.URLSigningKey
+	}
+	return cfg.BlockserviceApiKey
+}
+
+// urlSignature returns the hex encoded HMAC-SHA256, keyed with secret, of
+// "<cid>|<exp>", followed by "|<token>" when a token is given.
+func urlSignature(secret, c string, exp int64, token string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(c + "|" + strconv.FormatInt(exp, 10)))
+	if token != "" {
+		mac.Write([]byte("|" + token))
+	}
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// SignGatewayURL adds to u the query parameters granting access to c on a
+// dedicated gateway until exp, without asking the pinning service. The
+// signature is keyed with secret, the URLSigningKey of the gateway or its
+// BlockserviceApiKey. token is an optional value covered by the signature,
+// such as the ID of the user the URL was issued to.
+func SignGatewayURL(u *url.URL, c cid.Cid, exp time.Time, token, secret string) {
+	q := u.Query()
+	if token != "" {
+		q.Set(signedURLToken, token)
+	}
+	q.Set(signedURLExpiry, strconv.FormatInt(exp.Unix(), 10))
+	q.Set(signedURLSig, urlSignature(secret, c.String(), exp.Unix(), token))
+	u.RawQuery = q.Encode()
+}
+
+// SignAppealURL adds to u the appeal token granting access to c until exp,
+// content whose takedown is being appealed. With the default
+// AccessDecisionOrder, c is served even though the pinning service blocks
+// it, unless the DMCA denylists do. The token is keyed with secret, as
+// signed URLs are.
+func SignAppealURL(u *url.URL, c cid.Cid, exp time.Time, secret string) {
+	q := u.Query()
+	q.Set(appealExpiry, strconv.FormatInt(exp.Unix(), 10))
+	q.Set(appealSig, urlSignature(secret, appealPrefix+c.String(), exp.Unix(), ""))
+	u.RawQuery = q.Encode()
+}
+
+// takeQuery returns the query parameters names of r, and r with them removed
+// so they aren't passed on with the rest of the URL.
+func takeQuery(r *http.Request, names ...string) (url.Values, *http.Request) {
+	q := r.URL.Query()
+	taken := make(url.Values, len(names))
+	for _, name := range names {
+		if q.Has(name) {
+			taken[name] = q[name]
+			q.Del(name)
+		}
+	}
+	u := *r.URL
+	u.RawQuery = q.Encode()
+	r = r.WithContext(r.Context())
+	r.URL = &u
+	return taken, r
+}
+
+// checkSignature checks sig is the signature of msg until expiry, keyed with
+// the URL signing key of cfg.
+func checkSignature(msg, expiry, token, sig string, cfg config.ConfigPinningService) error {
+	secret := urlSigningKey(cfg)
+	exp, err := strconv.ParseInt(expiry, 10, 64)
+	if err != nil || secret == "" {
+		return errSignatureInvalid
+	}
+	want := urlSignature(secret, msg, exp, token)
+	if !hmac.Equal([]byte(sig), []byte(want)) {
+		return errSignatureInvalid
+	}
+	if !now().Before(time.Unix(exp, 0)) {
+		return errSignatureExpired
+	}
+	return nil
+}
+
+// takeSignature checks the signature of a signed URL for c. It reports
+// whether r carried one, and returns an error when it is invalid or
+// expired. The returned request has the signature parameters removed so
+// they aren't passed on with the rest of the URL.
+func takeSignature(r *http.Request, c cid.Cid, cfg config.ConfigPinningService) (bool, *http.Request, error) {
+	if !r.URL.Query().Has(signedURLSig) {
+		return false, r, nil
+	}
+	q, r := takeQuery(r, signedURLToken, signedURLExpiry, signedURLSig)
+	return true, r, checkSignature(c.String(), q.Get(signedURLExpiry), q.Get(signedURLToken), q.Get(signedURLSig), cfg)
+}
+
+// takeAppealToken checks the appeal token for c, as takeSignature checks
+// signed URLs.
+func takeAppealToken(r *http.Request, c cid.Cid, cfg config.ConfigPinningService) (bool, *http.Request, error) {
+	if !r.URL.Query().Has(appealSig) {
+		return false, r, nil
+	}
+	q, r := takeQuery(r, appealExpiry, appealSig)
+	return true, r, checkSignature(appealPrefix+c.String(), q.Get(appealExpiry), "", q.Get(appealSig), cfg)
+}
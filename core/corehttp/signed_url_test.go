@@ -0,0 +1,96 @@
+package corehttp
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	cid "github.com/ipfs/go-cid"
+	"github.com/ipfs/kubo/config"
+)
+
+func TestSignedURLs(t *testing.T) {
+	clock := time.Unix(1700000000, 0)
+	now = func() time.Time { return clock }
+	t.Cleanup(func() { now = time.Now })
+
+	var accessCalls atomic.Int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/api/dedicatedGateways/") {
+			accessCalls.Add(1)
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(ts.Close)
+
+	cfg := &config.Config{
+		ConfigPinningService: config.ConfigPinningService{
+			PinningService:     ts.URL,
+			DedicatedGateway:   true,
+			BlockserviceApiKey: "api-key",
+			URLSigningKey:      "signing-key",
+		},
+	}
+	var forwardedQuery string
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		forwardedQuery = r.URL.RawQuery
+		w.WriteHeader(http.StatusOK)
+	})
+	handler := DedicatedGatewayMiddleware(next, nil, cfg)
+
+	c := cid.MustParse(testCid)
+	signed := func(exp time.Time, token, secret string) string {
+		u := &url.URL{Path: "/ipfs/" + testCid, RawQuery: "format=raw"}
+		SignGatewayURL(u, c, exp, token, secret)
+		return u.RequestURI()
+	}
+	valid := signed(clock.Add(time.Minute), "user-1", "signing-key")
+
+	for _, tc := range []struct {
+		name   string
+		target string
+		status int
+	}{
+		{"valid", valid, http.StatusOK},
+		{"valid without token", signed(clock.Add(time.Minute), "", "signing-key"), http.StatusOK},
+		{"expired", signed(clock.Add(-time.Second), "", "signing-key"), http.StatusForbidden},
+		{"other secret", signed(clock.Add(time.Minute), "", "api-key"), http.StatusForbidden},
+		{"tampered expiry", strings.Replace(valid, "exp=", "exp=1", 1), http.StatusForbidden},
+		{"tampered token", strings.Replace(valid, "token=user-1", "token=user-2", 1), http.StatusForbidden},
+		{"other cid", strings.Replace(valid, testCid, testBlockedCid, 1), http.StatusForbidden},
+		{"unsigned", "/ipfs/" + testCid, http.StatusForbidden},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			forwardedQuery = ""
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tc.target, nil))
+			if w.Code != tc.status {
+				t.Fatalf("expected status %d, got %d: %s", tc.status, w.Code, w.Body)
+			}
+			if tc.status == http.StatusOK && forwardedQuery != "format=raw" {
+				t.Fatalf("expected the signature to be removed from the query, got %q", forwardedQuery)
+			}
+		})
+	}
+
+	if n := accessCalls.Load(); n != 1 {
+		t.Fatalf("expected only the unsigned request to ask the pinning service, got %d calls", n)
+	}
+}
+
+func TestSignedURLsDefaultKey(t *testing.T) {
+	cfg := config.ConfigPinningService{BlockserviceApiKey: "api-key"}
+	u := &url.URL{Path: "/ipfs/" + testCid}
+	SignGatewayURL(u, cid.MustParse(testCid), time.Now().Add(time.Minute), "", "api-key")
+
+	ok, _, err := takeSignature(httptest.NewRequest(http.MethodGet, u.RequestURI(), nil), cid.MustParse(testCid), cfg)
+	if !ok || err != nil {
+		t.Fatalf("expected a URL signed with BlockserviceApiKey to be valid, got %v, %v", ok, err)
+	}
+}
@@ -0,0 +1,80 @@
+package corehttp
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	config "github.com/ipfs/kubo/config"
+	core "github.com/ipfs/kubo/core"
+)
+
+// ResponseTimeoutOption sets a write deadline on gateway responses picked from
+// ConfigPinningService.ResponseTimeouts by the size of the response, so small
+// objects fail fast on stalled clients while large transfers are given the
+// time they need.
+//
+// The size is the Content-Length set by the gateway, and the deadline is set
+// when the response header is written, so nothing is resolved up front.
+// Responses of unknown size, such as directory listings or CARs, get the
+// tier matching objects of any size.
+func ResponseTimeoutOption() ServeOption {
+	return func(n *core.IpfsNode, _ net.Listener, parent *http.ServeMux) (*http.ServeMux, error) {
+		cfg, err := n.Repo.Config()
+		if err != nil {
+			return nil, err
+		}
+
+		tiers := cfg.ConfigPinningService.ResponseTimeouts
+		if len(tiers) == 0 {
+			return parent, nil
+		}
+
+		mux := http.NewServeMux()
+		parent.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+			if !isGatewayPath(r.URL.Path) {
+				mux.ServeHTTP(w, r)
+				return
+			}
+			rc := http.NewResponseController(w)
+			mux.ServeHTTP(&headerWriter{ResponseWriter: w, setHeader: func(h http.Header, _ int) {
+				size, err := strconv.ParseUint(h.Get("Content-Length"), 10, 64)
+				if err != nil {
+					size = math.MaxUint64
+				}
+				if timeout := responseTimeout(tiers, size); timeout > 0 {
+					if err := rc.SetWriteDeadline(time.Now().Add(timeout)); err != nil {
+						log.Debugf("cannot set write deadline for %s: %s", r.URL.Path, err)
+					}
+				}
+			}}, r)
+		})
+
+		return mux, nil
+	}
+}
+
+// responseTimeout returns the timeout of the tier with the smallest MaxSize
+// fitting size, or zero when no tier matches.
+func responseTimeout(tiers []config.ResponseTimeoutTier, size uint64) time.Duration {
+	var (
+		timeout time.Duration
+		best    uint64
+		found   bool
+	)
+	for _, tier := range tiers {
+		limit := uint64(math.MaxUint64)
+		if tier.MaxSize > 0 {
+			limit = uint64(tier.MaxSize)
+		}
+		if size > limit || (found && limit >= best) {
+			continue
+		}
+		timeout = tier.Timeout.WithDefault(0)
+		best = limit
+		found = true
+	}
+	return timeout
+}
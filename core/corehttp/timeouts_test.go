@@ -0,0 +1,111 @@
+package corehttp
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/ipfs/kubo/config"
+	"github.com/ipfs/kubo/core"
+	"github.com/ipfs/kubo/repo"
+)
+
+func TestResponseTimeoutTiers(t *testing.T) {
+	tiers := []config.ResponseTimeoutTier{
+		{Timeout: config.NewOptionalDuration(time.Hour)},
+		{MaxSize: 1 << 20, Timeout: config.NewOptionalDuration(time.Minute)},
+		{MaxSize: 1 << 10, Timeout: config.NewOptionalDuration(time.Second)},
+	}
+	for _, tc := range []struct {
+		size    uint64
+		timeout time.Duration
+	}{
+		{10, time.Second},
+		{1 << 10, time.Second},
+		{1<<10 + 1, time.Minute},
+		{1 << 30, time.Hour},
+	} {
+		if got := responseTimeout(tiers, tc.size); got != tc.timeout {
+			t.Errorf("size %d: expected %s, got %s", tc.size, tc.timeout, got)
+		}
+	}
+
+	if got := responseTimeout(tiers[1:], 1<<30); got != 0 {
+		t.Errorf("expected no timeout past the last tier, got %s", got)
+	}
+}
+
+func TestResponseTimeoutStalledClient(t *testing.T) {
+	const (
+		smallTimeout = 100 * time.Millisecond
+		largeTimeout = time.Second
+		smallSize    = 1 << 30
+	)
+	c := config.Config{
+		ConfigPinningService: config.ConfigPinningService{
+			ResponseTimeouts: []config.ResponseTimeoutTier{
+				{MaxSize: smallSize, Timeout: config.NewOptionalDuration(smallTimeout)},
+				{Timeout: config.NewOptionalDuration(largeTimeout)},
+			},
+		},
+	}
+	n := &core.IpfsNode{Repo: &repo.Mock{C: c}}
+
+	// stand in for the gateway with a handler streaming a response of the
+	// requested size until the write deadline kicks in, and report how long
+	// that took
+	elapsed := make(chan time.Duration, 1)
+	streamOption := func(_ *core.IpfsNode, _ net.Listener, mux *http.ServeMux) (*http.ServeMux, error) {
+		mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Length", r.URL.Query().Get("size"))
+			start := time.Now()
+			chunk := make([]byte, 32<<10)
+			for time.Since(start) < 10*time.Second {
+				if _, err := w.Write(chunk); err != nil {
+					break
+				}
+			}
+			elapsed <- time.Since(start)
+		})
+		return mux, nil
+	}
+
+	dh := &delegatedHandler{}
+	ts := httptest.NewServer(dh)
+	t.Cleanup(func() { ts.Close() })
+
+	var err error
+	dh.Handler, err = MakeHandler(n, ts.Listener, ResponseTimeoutOption(), streamOption)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stalled := func(size int64) time.Duration {
+		conn, err := net.Dial("tcp", ts.Listener.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		// send the request and never read the response
+		if _, err := fmt.Fprintf(conn, "GET /ipfs/%s?size=%d HTTP/1.1\r\nHost: example.com\r\n\r\n", testCid, size); err != nil {
+			t.Fatal(err)
+		}
+		return <-elapsed
+	}
+
+	smallElapsed := stalled(smallSize)
+	largeElapsed := stalled(smallSize + 1)
+
+	if smallElapsed >= largeTimeout {
+		t.Errorf("small response took %s, expected it to time out after %s", smallElapsed, smallTimeout)
+	}
+	if largeElapsed < largeTimeout {
+		t.Errorf("large response gave up after %s, expected at least %s", largeElapsed, largeTimeout)
+	}
+	if smallElapsed >= largeElapsed {
+		t.Errorf("expected small response (%s) to time out before large one (%s)", smallElapsed, largeElapsed)
+	}
+}
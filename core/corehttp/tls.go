@@ -0,0 +1,197 @@
+package corehttp
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	core "github.com/ipfs/kubo/core"
+)
+
+// certCheckInterval is how often the certificate files are checked for
+// changes.
+const certCheckInterval = 30 * time.Second
+
+// certLoader serves the certificate and key of a pair of files, reloading
+// them when the files change so renewed certificates apply to new
+// connections without a restart.
+type certLoader struct {
+	certFile string
+	keyFile  string
+
+	mu      sync.Mutex
+	cert    *tls.Certificate
+	certMod time.Time
+	keyMod  time.Time
+	checked time.Time
+}
+
+func newCertLoader(certFile, keyFile string) (*certLoader, error) {
+	l := &certLoader{certFile: certFile, keyFile: keyFile}
+	if err := l.load(); err != nil {
+		return nil, err
+	}
+	l.checked = now()
+	return l, nil
+}
+
+// load reads the certificate and key, remembering the modification times
+// they were read at.
+func (l *certLoader) load() error {
+	certMod, keyMod, err := l.modTimes()
+	if err != nil {
+		return err
+	}
+	cert, err := tls.LoadX509KeyPair(l.certFile, l.keyFile)
+	if err != nil {
+		return err
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return err
+	}
+	if now().After(leaf.NotAfter) {
+		return fmt.Errorf("certificate %s expired on %s", l.certFile, leaf.NotAfter)
+	}
+	cert.Leaf = leaf
+	l.cert, l.certMod, l.keyMod = &cert, certMod, keyMod
+	return nil
+}
+
+// reload loads the certificate and key now, whether the files changed or
+// not. The previous certificate keeps being served when they fail to load,
+// don't make a pair or the certificate expired.
+func (l *certLoader) reload() (*x509.Certificate, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.checked = now()
+	if err := l.load(); err != nil {
+		return nil, err
+	}
+	return l.cert.Leaf, nil
+}
+
+func (l *certLoader) modTimes() (certMod, keyMod time.Time, err error) {
+	fi, err := os.Stat(l.certFile)
+	if err != nil {
+		return certMod, keyMod, err
+	}
+	certMod = fi.ModTime()
+	if fi, err = os.Stat(l.keyFile); err != nil {
+		return certMod, keyMod, err
+	}
+	return certMod, fi.ModTime(), nil
+}
+
+// GetCertificate returns the current certificate, reloading it first if
+// the files changed since they were last checked, at most every
+// certCheckInterval. A certificate that fails to load, for instance because
+// only one of the files was replaced yet, is retried on the next check while
+// the previous one keeps being served.
+func (l *certLoader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if t := now(); t.Sub(l.checked) >= certCheckInterval {
+		l.checked = t
+		certMod, keyMod, err := l.modTimes()
+		if err != nil {
+			log.Errorf("checking TLS certificate: %s", err)
+		} else if !certMod.Equal(l.certMod) || !keyMod.Equal(l.keyMod) {
+			if err := l.load(); err != nil {
+				log.Errorf("reloading TLS certificate, serving the previous one: %s", err)
+			} else {
+				log.Infof("reloaded TLS certificate from %s", l.certFile)
+			}
+		}
+	}
+	return l.cert, nil
+}
+
+// runningCertLoaders are the certificate loaders of the running servers.
+var runningCertLoaders struct {
+	sync.Mutex
+	list []*certLoader
+}
+
+// registerCertLoader adds l to runningCertLoaders until the returned function
+// is called.
+func registerCertLoader(l *certLoader) func() {
+	runningCertLoaders.Lock()
+	defer runningCertLoaders.Unlock()
+	runningCertLoaders.list = append(runningCertLoaders.list, l)
+
+	return func() {
+		runningCertLoaders.Lock()
+		defer runningCertLoaders.Unlock()
+		for i, c := range runningCertLoaders.list {
+			if c == l {
+				runningCertLoaders.list = append(runningCertLoaders.list[:i], runningCertLoaders.list[i+1:]...)
+				break
+			}
+		}
+	}
+}
+
+// reloadedCert describes a certificate reloaded by TLSReloadOption.
+type reloadedCert struct {
+	CertFile string    `json:"cert_file"`
+	Subject  string    `json:"subject"`
+	NotAfter time.Time `json:"not_after"`
+}
+
+// TLSReloadOption reloads the TLS certificates of the running servers from
+// their ConfigPinningService.SslCertPath and SslKeyPath on POST requests to
+// path, without waiting for the files to be checked for changes. A
+// certificate and key that fail to load, don't make a pair or an expired
+// certificate are rejected with 500, the previous certificate being kept.
+// The certificates reloaded are returned as JSON. Requests must carry
+// ConfigPinningService.AdminToken as a bearer token, the endpoint is
+// disabled when it is not set. It is meant for the API server.
+func TLSReloadOption(path string) ServeOption {
+	return func(n *core.IpfsNode, _ net.Listener, mux *http.ServeMux) (*http.ServeMux, error) {
+		mux.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
+			cfg, err := n.Repo.Config()
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			if status, err := checkAdminToken(r, cfg.ConfigPinningService.AdminToken); err != nil {
+				http.Error(w, err.Error(), status)
+				return
+			}
+			if r.Method != http.MethodPost {
+				http.Error(w, "only POST allowed", http.StatusMethodNotAllowed)
+				return
+			}
+
+			runningCertLoaders.Lock()
+			loaders := append([]*certLoader(nil), runningCertLoaders.list...)
+			runningCertLoaders.Unlock()
+
+			reloaded := []reloadedCert{}
+			for _, l := range loaders {
+				leaf, err := l.reload()
+				if err != nil {
+					log.Errorf("reloading TLS certificate, serving the previous one: %s", err)
+					http.Error(w, fmt.Sprintf("reloading TLS certificate: %s", err), http.StatusInternalServerError)
+					return
+				}
+				log.Infof("reloaded TLS certificate from %s", l.certFile)
+				reloaded = append(reloaded, reloadedCert{CertFile: l.certFile, Subject: leaf.Subject.String(), NotAfter: leaf.NotAfter})
+			}
+			w.Header().Set("Content-Type", "application/json")
+			if err := json.NewEncoder(w).Encode(reloaded); err != nil {
+				log.Debugf("writing reloaded certificates: %s", err)
+			}
+		})
+		return mux, nil
+	}
+}
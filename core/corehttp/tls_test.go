@@ -0,0 +1,275 @@
+package corehttp
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	config "github.com/ipfs/kubo/config"
+	core "github.com/ipfs/kubo/core"
+	"github.com/ipfs/kubo/repo"
+	"github.com/jbenet/goprocess"
+)
+
+// writeSelfSignedCert writes a certificate for 127.0.0.1 and its key to
+// dir, returning their paths and the certificate.
+func writeSelfSignedCert(t *testing.T, dir, name string) (certFile, keyFile string, cert *x509.Certificate) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cert, err = x509.ParseCertificate(der); err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certFile, keyFile = filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile, cert
+}
+
+// startServer runs Serve with cfg on a local listener until the test ends.
+func startServer(t *testing.T, cfg config.Config) (string, chan error) {
+	t.Helper()
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	node := &core.IpfsNode{
+		Repo:    &repo.Mock{C: cfg},
+		Process: goprocess.WithParent(goprocess.Background()),
+	}
+
+	hello := func(_ *core.IpfsNode, _ net.Listener, mux *http.ServeMux) (*http.ServeMux, error) {
+		mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("hello"))
+		})
+		return mux, nil
+	}
+	errc := make(chan error, 1)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		errc <- Serve(node, lis, hello)
+	}()
+	// the server is stopped before the next test starts
+	t.Cleanup(func() {
+		node.Process.Close()
+		<-done
+	})
+	return lis.Addr().String(), errc
+}
+
+func TestServeTLS(t *testing.T) {
+	certFile, keyFile, cert := writeSelfSignedCert(t, t.TempDir(), "gateway")
+	addr, _ := startServer(t, config.Config{
+		ConfigPinningService: config.ConfigPinningService{
+			SslCertPath: certFile,
+			SslKeyPath:  keyFile,
+		},
+	})
+
+	roots := x509.NewCertPool()
+	roots.AddCert(cert)
+	client := &http.Client{Transport: &http.Transport{
+		TLSClientConfig:   &tls.Config{RootCAs: roots},
+		ForceAttemptHTTP2: true,
+	}}
+	res, err := client.Get("https://" + addr + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, got %d", res.StatusCode)
+	}
+	if res.TLS == nil || res.TLS.Version < tls.VersionTLS12 {
+		t.Fatal("expected the response to be served over TLS 1.2 or later")
+	}
+	if res.ProtoMajor != 2 {
+		t.Fatalf("expected the response to be served over HTTP/2, got %s", res.Proto)
+	}
+
+	old := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{
+		RootCAs:    roots,
+		MaxVersion: tls.VersionTLS11,
+	}}}
+	if _, err := old.Get("https://" + addr + "/"); err == nil {
+		t.Fatal("expected TLS 1.1 to be rejected")
+	}
+}
+
+func TestServeTLSMisconfigured(t *testing.T) {
+	certFile, _, _ := writeSelfSignedCert(t, t.TempDir(), "gateway")
+	_, errc := startServer(t, config.Config{
+		ConfigPinningService: config.ConfigPinningService{
+			SslCertPath: certFile,
+		},
+	})
+	select {
+	case err := <-errc:
+		if err == nil {
+			t.Fatal("expected an error")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected Serve to fail without a key")
+	}
+}
+
+func TestServeTLSReloadsCertificate(t *testing.T) {
+	clock := time.Now()
+	now = func() time.Time { return clock }
+	t.Cleanup(func() { now = time.Now })
+
+	dir := t.TempDir()
+	certFile, keyFile, _ := writeSelfSignedCert(t, dir, "first")
+	addr, _ := startServer(t, config.Config{
+		ConfigPinningService: config.ConfigPinningService{
+			SslCertPath: certFile,
+			SslKeyPath:  keyFile,
+		},
+	})
+
+	// served returns the common name of the certificate served to a new
+	// connection.
+	served := func() string {
+		t.Helper()
+		conn, err := tls.Dial("tcp", addr, &tls.Config{InsecureSkipVerify: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		return conn.ConnectionState().PeerCertificates[0].Subject.CommonName
+	}
+	if got := served(); got != "first" {
+		t.Fatalf("expected the first certificate, got %q", got)
+	}
+
+	// renew the certificate in place, with a modification time distinct
+	// from the first one even on filesystems with a coarse resolution
+	writeSelfSignedCert(t, dir, "second")
+	later := time.Now().Add(time.Minute)
+	for _, f := range []string{certFile, keyFile} {
+		if err := os.Chtimes(f, later, later); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := served(); got != "first" {
+		t.Fatalf("expected the files to be checked at most every %s, got %q", certCheckInterval, got)
+	}
+
+	clock = clock.Add(certCheckInterval)
+	if got := served(); got != "second" {
+		t.Fatalf("expected the renewed certificate, got %q", got)
+	}
+}
+
+func TestTLSReloadOption(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile, _ := writeSelfSignedCert(t, dir, "first")
+	addr, _ := startServer(t, config.Config{
+		ConfigPinningService: config.ConfigPinningService{
+			SslCertPath: certFile,
+			SslKeyPath:  keyFile,
+		},
+	})
+	n := &core.IpfsNode{Repo: &repo.Mock{
+		C: config.Config{ConfigPinningService: config.ConfigPinningService{AdminToken: "secret"}},
+	}}
+	mux, err := TLSReloadOption("/debug/tls/reload")(n, nil, http.NewServeMux())
+	if err != nil {
+		t.Fatal(err)
+	}
+	reload := func() *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, "/debug/tls/reload", nil)
+		r.Header.Set("Authorization", "Bearer secret")
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, r)
+		return w
+	}
+	served := func() string {
+		t.Helper()
+		conn, err := tls.Dial("tcp", addr, &tls.Config{InsecureSkipVerify: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		return conn.ConnectionState().PeerCertificates[0].Subject.CommonName
+	}
+	if got := served(); got != "first" {
+		t.Fatalf("expected the first certificate, got %q", got)
+	}
+
+	// replaced in place, the files are only checked every certCheckInterval
+	writeSelfSignedCert(t, dir, "second")
+	w := reload()
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected the certificate to be reloaded, got %d: %s", w.Code, w.Body)
+	}
+	var reloaded []reloadedCert
+	if err := json.NewDecoder(w.Body).Decode(&reloaded); err != nil {
+		t.Fatal(err)
+	}
+	if len(reloaded) != 1 || reloaded[0].CertFile != certFile || reloaded[0].Subject != "CN=second" {
+		t.Fatalf("expected the second certificate to be reported, got %+v", reloaded)
+	}
+	if got := served(); got != "second" {
+		t.Fatalf("expected the replaced certificate, got %q", got)
+	}
+
+	// a key that doesn't match the certificate
+	_, otherKey, _ := writeSelfSignedCert(t, t.TempDir(), "other")
+	key, err := os.ReadFile(otherKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, key, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if w := reload(); w.Code != http.StatusInternalServerError {
+		t.Fatalf("expected the invalid pair to be rejected, got %d: %s", w.Code, w.Body)
+	}
+	if got := served(); got != "second" {
+		t.Fatalf("expected the previous certificate to be kept, got %q", got)
+	}
+
+	r := httptest.NewRequest(http.MethodPost, "/debug/tls/reload", nil)
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, r)
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("expected a request without the admin token to be rejected, got %d", w.Code)
+	}
+}
@@ -0,0 +1,215 @@
+package corerepo
+
+import (
+	"context"
+
+	bserv "github.com/ipfs/boxo/blockservice"
+	offline "github.com/ipfs/boxo/exchange/offline"
+	dag "github.com/ipfs/boxo/ipld/merkledag"
+	"github.com/ipfs/go-cid"
+	ipld "github.com/ipfs/go-ipld-format"
+
+	"github.com/ipfs/kubo/core"
+	"github.com/ipfs/kubo/gc"
+)
+
+// PinCheckResult describes an inconsistency between the pin set and the
+// blockstore found by CheckPins.
+type PinCheckResult struct {
+	Cid cid.Cid
+
+	// Missing is set for pinned blocks absent from the blockstore.
+	Missing bool
+
+	// Orphaned is set for blocks not reachable from any pin nor from the
+	// MFS root, that is blocks the next garbage collection would remove.
+	Orphaned bool
+
+	// Repaired is set when a missing block was fetched again or an orphaned
+	// block was removed.
+	Repaired bool
+
+	Err error
+}
+
+// CheckPins cross-references the pin set against the blockstore. Every pinned
+// DAG is walked using local blocks only and pinned blocks missing from the
+// blockstore are reported, followed by blocks not reachable from any pin.
+//
+// When repair is set, missing blocks are fetched again through the node's
+// block service and orphaned blocks are garbage collected.
+func CheckPins(ctx context.Context, n *core.IpfsNode, repair bool) <-chan PinCheckResult {
+	out := make(chan PinCheckResult)
+	go func() {
+		defer close(out)
+
+		emit := func(r PinCheckResult) bool {
+			select {
+			case out <- r:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		}
+
+		reachable, ok := walkPins(ctx, n, repair, emit)
+		if !ok {
+			return
+		}
+		if repair {
+			removeOrphanedBlocks(ctx, n, emit)
+		} else {
+			listOrphanedBlocks(ctx, n, reachable, emit)
+		}
+	}()
+	return out
+}
+
+// walkPins reports pinned blocks missing from the blockstore and returns the
+// multihashes of all blocks reachable from the pins and the MFS root. The
+// boolean is false when the walk was aborted.
+func walkPins(ctx context.Context, n *core.IpfsNode, repair bool, emit func(PinCheckResult) bool) (map[string]struct{}, bool) {
+	bs := n.Blockstore
+	ng := dag.NewDAGService(bserv.New(bs, offline.Exchange(bs)))
+
+	reachable := make(map[string]struct{})
+	walked := make(map[string]struct{})
+
+	var visit func(c cid.Cid, recursive, bestEffort bool) bool
+	visit = func(c cid.Cid, recursive, bestEffort bool) bool {
+		key := string(c.Hash())
+		if _, ok := walked[key]; ok {
+			return true
+		}
+		if _, ok := reachable[key]; ok && !recursive {
+			return true
+		}
+		reachable[key] = struct{}{}
+		if recursive {
+			walked[key] = struct{}{}
+		}
+
+		has, err := bs.Has(ctx, c)
+		if err != nil {
+			return emit(PinCheckResult{Cid: c, Err: err})
+		}
+		if !has {
+			// the MFS root is only kept on a best-effort basis
+			if bestEffort {
+				return true
+			}
+			res := PinCheckResult{Cid: c, Missing: true}
+			if repair {
+				if _, err := n.Blocks.GetBlock(ctx, c); err != nil {
+					res.Err = err
+				} else {
+					res.Repaired = true
+					has = true
+				}
+			}
+			if !emit(res) {
+				return false
+			}
+			if !has {
+				return true
+			}
+		}
+
+		if !recursive {
+			return true
+		}
+		links, err := ipld.GetLinks(ctx, ng, c)
+		if err != nil {
+			return emit(PinCheckResult{Cid: c, Err: err})
+		}
+		for _, l := range links {
+			if !visit(l.Cid, true, bestEffort) {
+				return false
+			}
+		}
+		return true
+	}
+
+	for p := range n.Pinning.RecursiveKeys(ctx) {
+		if p.Err != nil {
+			emit(PinCheckResult{Err: p.Err})
+			return nil, false
+		}
+		if !visit(p.C, true, false) {
+			return nil, false
+		}
+	}
+
+	if n.FilesRoot != nil {
+		roots, err := BestEffortRoots(n.FilesRoot)
+		if err != nil {
+			emit(PinCheckResult{Err: err})
+			return nil, false
+		}
+		for _, root := range roots {
+			if !visit(root, true, true) {
+				return nil, false
+			}
+		}
+	}
+
+	for p := range n.Pinning.DirectKeys(ctx) {
+		if p.Err != nil {
+			emit(PinCheckResult{Err: p.Err})
+			return nil, false
+		}
+		if !visit(p.C, false, false) {
+			return nil, false
+		}
+	}
+
+	for p := range n.Pinning.InternalPins(ctx) {
+		if p.Err != nil {
+			emit(PinCheckResult{Err: p.Err})
+			return nil, false
+		}
+		if !visit(p.C, true, false) {
+			return nil, false
+		}
+	}
+
+	return reachable, ctx.Err() == nil
+}
+
+func listOrphanedBlocks(ctx context.Context, n *core.IpfsNode, reachable map[string]struct{}, emit func(PinCheckResult) bool) {
+	keys, err := n.Blockstore.AllKeysChan(ctx)
+	if err != nil {
+		emit(PinCheckResult{Err: err})
+		return
+	}
+	for c := range keys {
+		if _, ok := reachable[string(c.Hash())]; ok {
+			continue
+		}
+		if !emit(PinCheckResult{Cid: c, Orphaned: true}) {
+			return
+		}
+	}
+}
+
+func removeOrphanedBlocks(ctx context.Context, n *core.IpfsNode, emit func(PinCheckResult) bool) {
+	var roots []cid.Cid
+	if n.FilesRoot != nil {
+		var err error
+		roots, err = BestEffortRoots(n.FilesRoot)
+		if err != nil {
+			emit(PinCheckResult{Err: err})
+			return
+		}
+	}
+
+	for res := range gc.GC(ctx, n.Blockstore, n.Repo.Datastore(), n.Pinning, roots) {
+		r := PinCheckResult{Cid: res.KeyRemoved, Orphaned: true, Repaired: true}
+		if res.Error != nil {
+			r = PinCheckResult{Err: res.Error}
+		}
+		if !emit(r) {
+			return
+		}
+	}
+}
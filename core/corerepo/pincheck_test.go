@@ -0,0 +1,85 @@
+package corerepo
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/ipfs/boxo/coreiface/options"
+	"github.com/ipfs/boxo/files"
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	syncds "github.com/ipfs/go-datastore/sync"
+	ipld "github.com/ipfs/go-ipld-format"
+
+	"github.com/ipfs/kubo/config"
+	"github.com/ipfs/kubo/core"
+	"github.com/ipfs/kubo/core/coreapi"
+	"github.com/ipfs/kubo/repo"
+)
+
+func TestCheckPins(t *testing.T) {
+	ctx := context.Background()
+	r := &repo.Mock{
+		C: config.Config{
+			Identity: config.Identity{
+				PeerID: "QmTFauExutTsy4XP6JbMFcw2Wa9645HJt2bTqL6qYDCKfe", // required by offline node
+			},
+		},
+		D: syncds.MutexWrap(datastore.NewMapDatastore()),
+	}
+	n, err := core.NewNode(ctx, &core.BuildCfg{Repo: r})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer n.Close()
+
+	api, err := coreapi.NewCoreAPI(n)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := bytes.Repeat([]byte("pinned data "), 1024)
+	p, err := api.Unixfs().Add(ctx, files.NewBytesFile(data), options.Unixfs.Chunker("size-1024"), options.Unixfs.Pin(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	links, err := ipld.GetLinks(ctx, n.DAG, p.RootCid())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(links) == 0 {
+		t.Fatal("expected a multi-block file")
+	}
+	missing := links[0].Cid
+	if err := n.Blockstore.DeleteBlock(ctx, missing); err != nil {
+		t.Fatal(err)
+	}
+
+	orphan := blocks.NewBlock([]byte("not pinned"))
+	if err := n.Blockstore.Put(ctx, orphan); err != nil {
+		t.Fatal(err)
+	}
+
+	var gotMissing, gotOrphan []cid.Cid
+	for res := range CheckPins(ctx, n, false) {
+		if res.Err != nil {
+			t.Fatal(res.Err)
+		}
+		switch {
+		case res.Missing:
+			gotMissing = append(gotMissing, res.Cid)
+		case res.Orphaned:
+			gotOrphan = append(gotOrphan, res.Cid)
+		}
+	}
+
+	if len(gotMissing) != 1 || !gotMissing[0].Equals(missing) {
+		t.Fatalf("expected %s to be reported missing, got %v", missing, gotMissing)
+	}
+	if len(gotOrphan) != 1 || !bytes.Equal(gotOrphan[0].Hash(), orphan.Cid().Hash()) {
+		t.Fatalf("expected %s to be reported orphaned, got %v", orphan.Cid(), gotOrphan)
+	}
+}
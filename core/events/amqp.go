@@ -0,0 +1,38 @@
+package events
+
+import (
+	"github.com/streadway/amqp"
+)
+
+type amqpChannel struct {
+	conn *amqp.Connection
+	ch   *amqp.Channel
+}
+
+// DialAMQP returns a DialFunc connecting to the AMQP broker at url.
+func DialAMQP(url string) DialFunc {
+	return func() (Channel, error) {
+		conn, err := amqp.Dial(url)
+		if err != nil {
+			return nil, err
+		}
+		ch, err := conn.Channel()
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+		return &amqpChannel{conn: conn, ch: ch}, nil
+	}
+}
+
+func (c *amqpChannel) Publish(exchange, key string, body []byte) error {
+	return c.ch.Publish(exchange, key, false, false, amqp.Publishing{
+		ContentType:  "application/json",
+		DeliveryMode: amqp.Persistent,
+		Body:         body,
+	})
+}
+
+func (c *amqpChannel) Close() error {
+	return c.conn.Close()
+}
@@ -0,0 +1,71 @@
+package events
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// Bus hands the events emitted to local subscribers, such as the clients of
+// the /events stream. Publishing never blocks: events are dropped for the
+// subscribers that don't keep up.
+type Bus struct {
+	mu   sync.Mutex
+	subs map[*Subscription]struct{}
+}
+
+// Subscription receives the events published on a Bus until it is closed.
+type Subscription struct {
+	bus     *Bus
+	ch      chan Event
+	dropped atomic.Uint64
+}
+
+// NewBus returns a Bus without subscribers.
+func NewBus() *Bus {
+	return &Bus{subs: make(map[*Subscription]struct{})}
+}
+
+// Subscribe returns a subscription buffering up to size events.
+func (b *Bus) Subscribe(size int) *Subscription {
+	s := &Subscription{bus: b, ch: make(chan Event, size)}
+	b.mu.Lock()
+	b.subs[s] = struct{}{}
+	b.mu.Unlock()
+	return s
+}
+
+// Publish hands e to the subscribers, dropping it for those whose buffer is
+// full.
+func (b *Bus) Publish(e Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for s := range b.subs {
+		select {
+		case s.ch <- e:
+		default:
+			s.dropped.Add(1)
+		}
+	}
+}
+
+// Events returns the channel the events are received on. It is closed when
+// the subscription is.
+func (s *Subscription) Events() <-chan Event {
+	return s.ch
+}
+
+// Dropped returns the number of events dropped because the buffer of the
+// subscription was full.
+func (s *Subscription) Dropped() uint64 {
+	return s.dropped.Load()
+}
+
+// Close stops the subscription.
+func (s *Subscription) Close() {
+	s.bus.mu.Lock()
+	defer s.bus.mu.Unlock()
+	if _, ok := s.bus.subs[s]; ok {
+		delete(s.bus.subs, s)
+		close(s.ch)
+	}
+}
@@ -0,0 +1,235 @@
+// Package events publishes the blocks written and the pins changed by the
+// node to a message broker and to local subscribers.
+package events
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/cenkalti/backoff/v4"
+	"github.com/ipfs/go-cid"
+	logging "github.com/ipfs/go-log"
+)
+
+var log = logging.Logger("events")
+
+// DefaultBufferSize is the number of events kept by an Emitter while the
+// broker is unreachable, unless configured otherwise.
+const DefaultBufferSize = 1000
+
+// newBackOff returns the delays between reconnections to the broker,
+// swapped in tests.
+var newBackOff = func() backoff.BackOff {
+	bo := backoff.NewExponentialBackOff()
+	bo.MaxElapsedTime = 0
+	return bo
+}
+
+// Operation is what happened to the CID of an Event.
+type Operation string
+
+const (
+	OpBlockPut Operation = "block_put"
+	OpPin      Operation = "pin"
+	OpUnpin    Operation = "unpin"
+)
+
+// Event is the JSON message published for each operation.
+type Event struct {
+	Cid       string    `json:"cid"`
+	Size      uint64    `json:"size,omitempty"`
+	Timestamp time.Time `json:"timestamp"`
+	Operation Operation `json:"operation"`
+}
+
+// Channel publishes messages to the broker.
+type Channel interface {
+	Publish(exchange, key string, body []byte) error
+	Close() error
+}
+
+// DialFunc opens a Channel to the broker. It is called again, with
+// exponential backoff, after the channel fails.
+type DialFunc func() (Channel, error)
+
+// Emitter publishes events in the background. Events are queued without
+// blocking the operations they describe; while the broker is unreachable,
+// the queue keeps the latest events up to its size. Events are also handed
+// to the subscribers of its Bus.
+type Emitter struct {
+	dial     DialFunc
+	exchange string
+	key      string
+	size     int
+	bus      *Bus
+
+	mu      sync.Mutex
+	queue   [][]byte
+	dropped int
+
+	wake    chan struct{}
+	closing chan struct{}
+	done    chan struct{}
+}
+
+// NewEmitter starts publishing events to exchange with the routing key key,
+// through channels opened by dial. At most size events are queued. When dial
+// is nil, events are only handed to the subscribers of the Bus.
+func NewEmitter(dial DialFunc, exchange, key string, size int) *Emitter {
+	if size <= 0 {
+		size = DefaultBufferSize
+	}
+	e := &Emitter{
+		dial:     dial,
+		exchange: exchange,
+		key:      key,
+		size:     size,
+		bus:      NewBus(),
+		wake:     make(chan struct{}, 1),
+		closing:  make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	if dial == nil {
+		close(e.done)
+		return e
+	}
+	go e.run()
+	return e
+}
+
+// Bus returns the bus the events are handed to locally.
+func (e *Emitter) Bus() *Bus {
+	return e.bus
+}
+
+// Emit queues the event of op on c, dropping the oldest queued event if the
+// queue is full.
+func (e *Emitter) Emit(op Operation, c cid.Cid, size uint64) {
+	ev := Event{
+		Cid:       c.String(),
+		Size:      size,
+		Timestamp: time.Now().UTC(),
+		Operation: op,
+	}
+	e.bus.Publish(ev)
+	if e.dial == nil {
+		return
+	}
+	msg, err := json.Marshal(ev)
+	if err != nil {
+		log.Errorf("encoding %s event of %s: %s", op, c, err)
+		return
+	}
+
+	e.mu.Lock()
+	if len(e.queue) == e.size {
+		e.queue = e.queue[1:]
+		e.dropped++
+	}
+	e.queue = append(e.queue, msg)
+	e.mu.Unlock()
+
+	select {
+	case e.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Close stops publishing. Events still queued are discarded.
+func (e *Emitter) Close() error {
+	close(e.closing)
+	<-e.done
+	return nil
+}
+
+// take removes the oldest queued message.
+func (e *Emitter) take() ([]byte, bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if len(e.queue) == 0 {
+		return nil, false
+	}
+	msg := e.queue[0]
+	e.queue = e.queue[1:]
+	return msg, true
+}
+
+// putBack queues msg again ahead of the others after it failed to be
+// published, unless newer events filled the queue meanwhile.
+func (e *Emitter) putBack(msg []byte) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if len(e.queue) == e.size {
+		e.dropped++
+		return
+	}
+	e.queue = append([][]byte{msg}, e.queue...)
+}
+
+// sleep waits for d, returning false if the emitter is closed meanwhile.
+func (e *Emitter) sleep(d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true
+	case <-e.closing:
+		return false
+	}
+}
+
+func (e *Emitter) run() {
+	defer close(e.done)
+
+	bo := newBackOff()
+	var ch Channel
+	defer func() {
+		if ch != nil {
+			ch.Close()
+		}
+	}()
+
+	for {
+		msg, ok := e.take()
+		if !ok {
+			select {
+			case <-e.wake:
+				continue
+			case <-e.closing:
+				return
+			}
+		}
+
+		if ch == nil {
+			var err error
+			if ch, err = e.dial(); err != nil {
+				log.Warnf("connecting to the event broker: %s", err)
+				e.putBack(msg)
+				if !e.sleep(bo.NextBackOff()) {
+					return
+				}
+				continue
+			}
+		}
+		if err := ch.Publish(e.exchange, e.key, msg); err != nil {
+			log.Warnf("publishing event: %s", err)
+			ch.Close()
+			ch = nil
+			e.putBack(msg)
+			if !e.sleep(bo.NextBackOff()) {
+				return
+			}
+			continue
+		}
+		bo.Reset()
+
+		e.mu.Lock()
+		dropped := e.dropped
+		e.dropped = 0
+		e.mu.Unlock()
+		if dropped > 0 {
+			log.Warnf("dropped %d events while the broker was unreachable", dropped)
+		}
+	}
+}
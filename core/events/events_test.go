@@ -0,0 +1,200 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cenkalti/backoff/v4"
+	bstore "github.com/ipfs/boxo/blockstore"
+	"github.com/ipfs/boxo/ipld/merkledag"
+	pin "github.com/ipfs/boxo/pinning/pinner"
+	blocks "github.com/ipfs/go-block-format"
+	cid "github.com/ipfs/go-cid"
+	ds "github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	ipld "github.com/ipfs/go-ipld-format"
+)
+
+// mockBroker records the messages published through its channels, failing
+// to connect while down is set.
+type mockBroker struct {
+	mu        sync.Mutex
+	down      bool
+	published chan Event
+}
+
+func newMockBroker() *mockBroker {
+	return &mockBroker{published: make(chan Event, 16)}
+}
+
+func (b *mockBroker) setDown(down bool) {
+	b.mu.Lock()
+	b.down = down
+	b.mu.Unlock()
+}
+
+func (b *mockBroker) dial() (Channel, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.down {
+		return nil, errors.New("connection refused")
+	}
+	return &mockChannel{b}, nil
+}
+
+type mockChannel struct {
+	b *mockBroker
+}
+
+func (c *mockChannel) Publish(exchange, key string, body []byte) error {
+	c.b.mu.Lock()
+	down := c.b.down
+	c.b.mu.Unlock()
+	if down {
+		return errors.New("connection closed")
+	}
+	if exchange != "ipfs" || key != "events" {
+		return errors.New("unexpected exchange or routing key")
+	}
+	var e Event
+	if err := json.Unmarshal(body, &e); err != nil {
+		return err
+	}
+	c.b.published <- e
+	return nil
+}
+
+func (c *mockChannel) Close() error {
+	return nil
+}
+
+func (b *mockBroker) expect(t *testing.T, op Operation, c cid.Cid) Event {
+	t.Helper()
+	select {
+	case e := <-b.published:
+		if e.Operation != op || e.Cid != c.String() {
+			t.Fatalf("expected %s event of %s, got %s event of %s", op, c, e.Operation, e.Cid)
+		}
+		if e.Timestamp.IsZero() {
+			t.Fatal("expected the event to be timestamped")
+		}
+		return e
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timed out waiting for %s event of %s", op, c)
+	}
+	return Event{}
+}
+
+func fastBackOff(t *testing.T) {
+	orig := newBackOff
+	newBackOff = func() backoff.BackOff {
+		return backoff.NewConstantBackOff(time.Millisecond)
+	}
+	t.Cleanup(func() { newBackOff = orig })
+}
+
+// acceptingPinner accepts all pins.
+type acceptingPinner struct {
+	pin.Pinner
+}
+
+func (acceptingPinner) Pin(context.Context, ipld.Node, bool) error           { return nil }
+func (acceptingPinner) Unpin(context.Context, cid.Cid, bool) error           { return nil }
+func (acceptingPinner) Update(context.Context, cid.Cid, cid.Cid, bool) error { return nil }
+
+func TestEventsPublished(t *testing.T) {
+	ctx := context.Background()
+	broker := newMockBroker()
+	e := NewEmitter(broker.dial, "ipfs", "events", 0)
+	defer e.Close()
+
+	bs := &Blockstore{Blockstore: bstore.NewBlockstore(dssync.MutexWrap(ds.NewMapDatastore())), Emitter: e}
+	a, b := blocks.NewBlock([]byte("a")), blocks.NewBlock([]byte("bb"))
+	if err := bs.Put(ctx, a); err != nil {
+		t.Fatal(err)
+	}
+	if ev := broker.expect(t, OpBlockPut, a.Cid()); ev.Size != 1 {
+		t.Fatalf("expected size 1, got %d", ev.Size)
+	}
+	if err := bs.PutMany(ctx, []blocks.Block{b}); err != nil {
+		t.Fatal(err)
+	}
+	if ev := broker.expect(t, OpBlockPut, b.Cid()); ev.Size != 2 {
+		t.Fatalf("expected size 2, got %d", ev.Size)
+	}
+
+	p := &Pinner{Pinner: acceptingPinner{}, Emitter: e}
+	nd := merkledag.NodeWithData([]byte("pinned"))
+	if err := p.Pin(ctx, nd, true); err != nil {
+		t.Fatal(err)
+	}
+	broker.expect(t, OpPin, nd.Cid())
+	if err := p.Unpin(ctx, nd.Cid(), true); err != nil {
+		t.Fatal(err)
+	}
+	broker.expect(t, OpUnpin, nd.Cid())
+	if err := p.Update(ctx, a.Cid(), b.Cid(), true); err != nil {
+		t.Fatal(err)
+	}
+	broker.expect(t, OpPin, b.Cid())
+	broker.expect(t, OpUnpin, a.Cid())
+}
+
+func TestEventsBrokerDown(t *testing.T) {
+	fastBackOff(t)
+	broker := newMockBroker()
+	broker.setDown(true)
+	e := NewEmitter(broker.dial, "ipfs", "events", 2)
+	defer e.Close()
+
+	cids := make([]cid.Cid, 3)
+	for i := range cids {
+		cids[i] = blocks.NewBlock([]byte{byte(i)}).Cid()
+		e.Emit(OpBlockPut, cids[i], 1)
+	}
+	select {
+	case ev := <-broker.published:
+		t.Fatalf("expected nothing to be published while the broker is down, got %v", ev)
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	// the oldest event was dropped to make room for the last one
+	broker.setDown(false)
+	broker.expect(t, OpBlockPut, cids[1])
+	broker.expect(t, OpBlockPut, cids[2])
+}
+
+func TestEventsBus(t *testing.T) {
+	e := NewEmitter(nil, "", "", 0)
+	defer e.Close()
+	slow := e.Bus().Subscribe(1)
+	defer slow.Close()
+	fast := e.Bus().Subscribe(2)
+
+	a, b := blocks.NewBlock([]byte("a")).Cid(), blocks.NewBlock([]byte("b")).Cid()
+	e.Emit(OpBlockPut, a, 1)
+	e.Emit(OpPin, b, 0)
+
+	for _, want := range []cid.Cid{a, b} {
+		if ev := <-fast.Events(); ev.Cid != want.String() {
+			t.Fatalf("expected event of %s, got %s", want, ev.Cid)
+		}
+	}
+	// the slow subscriber missed the event that didn't fit in its buffer
+	if ev := <-slow.Events(); ev.Cid != a.String() {
+		t.Fatalf("expected event of %s, got %s", a, ev.Cid)
+	}
+	if n := slow.Dropped(); n != 1 {
+		t.Fatalf("expected 1 dropped event, got %d", n)
+	}
+
+	fast.Close()
+	if _, ok := <-fast.Events(); ok {
+		t.Fatal("expected no event after the subscription is closed")
+	}
+	e.Emit(OpUnpin, b, 0)
+}
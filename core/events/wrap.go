@@ -0,0 +1,81 @@
+package events
+
+import (
+	"context"
+
+	bstore "github.com/ipfs/boxo/blockstore"
+	pin "github.com/ipfs/boxo/pinning/pinner"
+	blocks "github.com/ipfs/go-block-format"
+	cid "github.com/ipfs/go-cid"
+	ipld "github.com/ipfs/go-ipld-format"
+)
+
+// Blockstore emits an OpBlockPut event for each block written.
+type Blockstore struct {
+	bstore.Blockstore
+	Emitter *Emitter
+}
+
+func (bs *Blockstore) Put(ctx context.Context, b blocks.Block) error {
+	if err := bs.Blockstore.Put(ctx, b); err != nil {
+		return err
+	}
+	bs.Emitter.Emit(OpBlockPut, b.Cid(), uint64(len(b.RawData())))
+	return nil
+}
+
+func (bs *Blockstore) PutMany(ctx context.Context, blks []blocks.Block) error {
+	if err := bs.Blockstore.PutMany(ctx, blks); err != nil {
+		return err
+	}
+	for _, b := range blks {
+		bs.Emitter.Emit(OpBlockPut, b.Cid(), uint64(len(b.RawData())))
+	}
+	return nil
+}
+
+// Pinner emits OpPin and OpUnpin events for the pins added and removed.
+// Pin events carry the cumulative size of the pinned DAG as recorded in its
+// root.
+type Pinner struct {
+	pin.Pinner
+	Emitter *Emitter
+}
+
+func (p *Pinner) Pin(ctx context.Context, node ipld.Node, recursive bool) error {
+	if err := p.Pinner.Pin(ctx, node, recursive); err != nil {
+		return err
+	}
+	size, _ := node.Size()
+	p.Emitter.Emit(OpPin, node.Cid(), size)
+	return nil
+}
+
+func (p *Pinner) Unpin(ctx context.Context, c cid.Cid, recursive bool) error {
+	if err := p.Pinner.Unpin(ctx, c, recursive); err != nil {
+		return err
+	}
+	p.Emitter.Emit(OpUnpin, c, 0)
+	return nil
+}
+
+func (p *Pinner) Update(ctx context.Context, from, to cid.Cid, unpin bool) error {
+	if err := p.Pinner.Update(ctx, from, to, unpin); err != nil {
+		return err
+	}
+	p.Emitter.Emit(OpPin, to, 0)
+	if unpin {
+		p.Emitter.Emit(OpUnpin, from, 0)
+	}
+	return nil
+}
+
+func (p *Pinner) PinWithMode(ctx context.Context, c cid.Cid, mode pin.Mode) error {
+	if err := p.Pinner.PinWithMode(ctx, c, mode); err != nil {
+		return err
+	}
+	if mode != pin.NotPinned {
+		p.Emitter.Emit(OpPin, c, 0)
+	}
+	return nil
+}
@@ -26,6 +26,7 @@ import (
 	"github.com/ipld/go-ipld-prime/schema"
 	"go.uber.org/fx"
 
+	"github.com/ipfs/kubo/core/events"
 	"github.com/ipfs/kubo/core/node/helpers"
 	"github.com/ipfs/kubo/repo"
 )
@@ -44,7 +45,7 @@ func BlockService(lc fx.Lifecycle, bs blockstore.Blockstore, rem exchange.Interf
 }
 
 // Pinning creates new pinner which tells GC which blocks should be kept
-func Pinning(bstore blockstore.Blockstore, ds format.DAGService, repo repo.Repo) (pin.Pinner, error) {
+func Pinning(bstore blockstore.Blockstore, ds format.DAGService, repo repo.Repo, emitter *events.Emitter) (pin.Pinner, error) {
 	rootDS := repo.Datastore()
 
 	syncFn := func(ctx context.Context) error {
@@ -62,6 +63,9 @@ func Pinning(bstore blockstore.Blockstore, ds format.DAGService, repo repo.Repo)
 		return nil, err
 	}
 
+	if emitter != nil {
+		return &events.Pinner{Pinner: pinning, Emitter: emitter}, nil
+	}
 	return pinning, nil
 }
 
@@ -1,14 +1,22 @@
 package node
 
 import (
+	"context"
+	"fmt"
+
 	blockstore "github.com/ipfs/boxo/blockstore"
 	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/namespace"
 	config "github.com/ipfs/kubo/config"
+	"github.com/redis/go-redis/v9"
 	"go.uber.org/fx"
 
 	"github.com/ipfs/boxo/filestore"
+	"github.com/ipfs/kubo/core/events"
 	"github.com/ipfs/kubo/core/node/helpers"
 	"github.com/ipfs/kubo/repo"
+	"github.com/ipfs/kubo/repo/encryptds"
+	"github.com/ipfs/kubo/repo/redisds"
 	"github.com/ipfs/kubo/thirdparty/verifbs"
 )
 
@@ -23,16 +31,65 @@ func Datastore(repo repo.Repo) datastore.Datastore {
 	return repo.Datastore()
 }
 
+// Events starts publishing block and pin events to the AMQP broker when
+// configured in pinning, and to the local event stream when enabled. It
+// returns nil otherwise.
+func Events(pinning config.ConfigPinningService) func(lc fx.Lifecycle) *events.Emitter {
+	return func(lc fx.Lifecycle) *events.Emitter {
+		var dial events.DialFunc
+		if pinning.AmqpConnect != "" && pinning.AmqpEventRoutingKey != "" {
+			dial = events.DialAMQP(pinning.AmqpConnect)
+		} else if !pinning.EventStream {
+			return nil
+		}
+		e := events.NewEmitter(dial, pinning.AmqpEventExchange, pinning.AmqpEventRoutingKey, pinning.AmqpEventBufferSize)
+		lc.Append(fx.Hook{
+			OnStop: func(context.Context) error {
+				return e.Close()
+			},
+		})
+		return e
+	}
+}
+
 // BaseBlocks is the lower level blockstore without GC or Filestore layers
 type BaseBlocks blockstore.Blockstore
 
-// BaseBlockstoreCtor creates cached blockstore backed by the provided datastore
-func BaseBlockstoreCtor(cacheOpts blockstore.CacheOpts, nilRepo bool, hashOnRead bool) func(mctx helpers.MetricsCtx, repo repo.Repo, lc fx.Lifecycle) (bs BaseBlocks, err error) {
-	return func(mctx helpers.MetricsCtx, repo repo.Repo, lc fx.Lifecycle) (bs BaseBlocks, err error) {
+// BaseBlockstoreCtor creates cached blockstore backed by the provided datastore.
+// Blocks are cached in Redis and encrypted at rest when enabled in pinning,
+// and published to emitter when it isn't nil.
+func BaseBlockstoreCtor(cacheOpts blockstore.CacheOpts, nilRepo bool, hashOnRead bool, pinning config.ConfigPinningService) func(mctx helpers.MetricsCtx, repo repo.Repo, lc fx.Lifecycle, emitter *events.Emitter) (bs BaseBlocks, err error) {
+	return func(mctx helpers.MetricsCtx, repo repo.Repo, lc fx.Lifecycle, emitter *events.Emitter) (bs BaseBlocks, err error) {
+		d := datastore.Batching(namespace.Wrap(repo.Datastore(), blockstore.BlockPrefix))
+		if pinning.BlockCache {
+			cfg, err := repo.Config()
+			if err != nil {
+				return nil, err
+			}
+			client := redis.NewClient(&redis.Options{Addr: pinning.RedisConn})
+			lc.Append(fx.Hook{
+				OnStop: func(context.Context) error {
+					return client.Close()
+				},
+			})
+			// cache the stored blocks, encrypted if they are at rest, apart
+			// from those of the other nodes sharing the Redis server
+			d = redisds.WrapConfig(d, client, cfg.Identity.PeerID, pinning)
+		}
+		if pinning.EncryptBlocksAtRest {
+			d, err = encryptds.WrapConfig(d, pinning)
+			if err != nil {
+				return nil, fmt.Errorf("encrypting blocks at rest: %w", err)
+			}
+		}
+		bs = blockstore.NewBlockstoreNoPrefix(d)
 		// hash security
-		bs = blockstore.NewBlockstore(repo.Datastore())
 		bs = &verifbs.VerifBS{Blockstore: bs}
 
+		if emitter != nil {
+			bs = &events.Blockstore{Blockstore: bs, Emitter: emitter}
+		}
+
 		if !nilRepo {
 			bs, err = blockstore.CachedBlockstore(helpers.LifecycleCtx(mctx, lc), bs, cacheOpts)
 			if err != nil {
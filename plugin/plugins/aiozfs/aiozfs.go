@@ -3,7 +3,9 @@ package aiozfs
 import (
 	"fmt"
 	"path/filepath"
+	"time"
 
+	logging "github.com/ipfs/go-log"
 	"github.com/ipfs/kubo/plugin"
 	"github.com/ipfs/kubo/repo"
 	"github.com/ipfs/kubo/repo/fsrepo"
@@ -11,6 +13,8 @@ import (
 	aiozfs "github.com/phantue99/go-ds-aiozfs"
 )
 
+var log = logging.Logger("aiozfs")
+
 // Plugins is exported list of plugins that will be loaded
 var Plugins = []plugin.Plugin{
 	&aiozfsPlugin{},
@@ -36,42 +40,135 @@ func (*aiozfsPlugin) DatastoreTypeName() string {
 	return "aiozfs"
 }
 
+// Values of the syncMode param.
+const (
+	syncAlways   = "always"
+	syncNever    = "never"
+	syncInterval = "interval"
+)
+
 type datastoreConfig struct {
 	path      string
 	shardFun  *aiozfs.ShardIdV1
 	syncField bool
+
+	// syncInterval is how often writes are flushed to disk in the
+	// "interval" sync mode, zero otherwise.
+	syncInterval time.Duration
+
+	// fallbackPath is opened read-only when path is inaccessible, so the
+	// node can still start. Optional.
+	fallbackPath string
 }
 
-// BadgerdsDatastoreConfig returns a configuration stub for a badger datastore
-// from the given parameters
+// DatastoreConfigParser returns a configuration stub for an aiozfs datastore
+// from the given parameters. The sync field defaults to true.
 func (*aiozfsPlugin) DatastoreConfigParser() fsrepo.ConfigFromMap {
 	return func(params map[string]interface{}) (fsrepo.DatastoreConfig, error) {
 		var c datastoreConfig
-		var ok bool
-		var err error
 
-		c.path, ok = params["path"].(string)
+		p, ok, err := stringParam(params, "path")
+		if err != nil {
+			return nil, err
+		}
 		if !ok {
-			return nil, fmt.Errorf("'path' field is missing or not boolean")
+			return nil, fmt.Errorf("'path' field is missing")
+		}
+		if p == "" {
+			return nil, fmt.Errorf("'path' field is empty")
 		}
+		c.path = p
 
-		sshardFun, ok := params["shardFunc"].(string)
+		sshardFun, ok, err := stringParam(params, "shardFunc")
+		if err != nil {
+			return nil, err
+		}
 		if !ok {
-			return nil, fmt.Errorf("'shardFunc' field is missing or not a string")
+			return nil, fmt.Errorf("'shardFunc' field is missing")
 		}
 		c.shardFun, err = aiozfs.ParseShardFunc(sshardFun)
 		if err != nil {
 			return nil, err
 		}
 
-		c.syncField, ok = params["sync"].(bool)
-		if !ok {
-			return nil, fmt.Errorf("'sync' field is missing or not boolean")
+		// syncMode takes precedence over the older sync field
+		if _, ok := params["syncMode"]; ok {
+			if err := c.parseSyncMode(params); err != nil {
+				return nil, err
+			}
+		} else {
+			c.syncField = true
+			if v, ok := params["sync"]; ok {
+				if c.syncField, ok = v.(bool); !ok {
+					return nil, fmt.Errorf("'sync' field must be a boolean, got %T", v)
+				}
+			}
+			if _, ok := params["syncInterval"]; ok {
+				return nil, fmt.Errorf("'syncInterval' field requires 'syncMode' to be %q", syncInterval)
+			}
+		}
+
+		if c.fallbackPath, _, err = stringParam(params, "fallbackPath"); err != nil {
+			return nil, err
 		}
 		return &c, nil
 	}
 }
 
+// stringParam returns the string field name of params, reporting whether it
+// is set. A field of another type is an error.
+func stringParam(params map[string]interface{}, name string) (string, bool, error) {
+	v, ok := params[name]
+	if !ok {
+		return "", false, nil
+	}
+	s, ok := v.(string)
+	if !ok {
+		return "", false, fmt.Errorf("'%s' field must be a string, got %T", name, v)
+	}
+	return s, true, nil
+}
+
+// parseSyncMode sets how writes are synced from the syncMode field:
+// "always" syncs each write, "never" leaves flushing to the OS and
+// "interval" flushes the filesystem of the datastore every syncInterval, a
+// duration such as "1s".
+func (c *datastoreConfig) parseSyncMode(params map[string]interface{}) error {
+	m, _, err := stringParam(params, "syncMode")
+	if err != nil {
+		return err
+	}
+	interval, hasInterval, err := stringParam(params, "syncInterval")
+	if err != nil {
+		return err
+	}
+	if m != syncInterval && hasInterval {
+		return fmt.Errorf("'syncInterval' field requires 'syncMode' to be %q", syncInterval)
+	}
+
+	switch m {
+	case syncAlways:
+		c.syncField = true
+	case syncNever:
+		c.syncField = false
+	case syncInterval:
+		if !hasInterval {
+			return fmt.Errorf("'syncInterval' field is missing")
+		}
+		d, err := time.ParseDuration(interval)
+		if err != nil {
+			return fmt.Errorf("'syncInterval' field: %w", err)
+		}
+		if d <= 0 {
+			return fmt.Errorf("'syncInterval' field must be positive, got %s", interval)
+		}
+		c.syncField, c.syncInterval = false, d
+	default:
+		return fmt.Errorf("'syncMode' field must be %q, %q or %q, got %q", syncAlways, syncNever, syncInterval, m)
+	}
+	return nil
+}
+
 func (c *datastoreConfig) DiskSpec() fsrepo.DiskSpec {
 	return map[string]interface{}{
 		"type":      "aiozfs",
@@ -85,6 +182,49 @@ func (c *datastoreConfig) Create(path string) (repo.Datastore, error) {
 	if !filepath.IsAbs(p) {
 		p = filepath.Join(path, p)
 	}
+	if c.fallbackPath == "" {
+		return c.open(p)
+	}
 
-	return aiozfs.CreateOrOpen(p, c.shardFun, c.syncField)
+	fp := c.fallbackPath
+	if !filepath.IsAbs(fp) {
+		fp = filepath.Join(path, fp)
+	}
+	if filepath.Clean(fp) == filepath.Clean(p) {
+		return nil, fmt.Errorf("aiozfs fallbackPath %q is the same as path", c.fallbackPath)
+	}
+
+	err := accessible(p)
+	if err == nil {
+		return c.open(p)
+	}
+	if ferr := accessible(fp); ferr != nil {
+		return nil, fmt.Errorf("aiozfs path is inaccessible: %w, and so is fallbackPath: %w", err, ferr)
+	}
+
+	log.Errorf("aiozfs path %s is inaccessible (%s), opening fallback %s READ-ONLY: new blocks can't be stored until the daemon is restarted with the primary path available", p, err, fp)
+	d, err := c.open(fp)
+	if err != nil {
+		return nil, err
+	}
+	return &readOnlyDatastore{Batching: d}, nil
+}
+
+// open opens the datastore at p, reporting its health through Check. The
+// aiozfs datastore batches writes itself: the writes to a Batch are buffered
+// and stored together on Commit, which bulk imports should use rather than
+// one Put per block.
+func (c *datastoreConfig) open(p string) (repo.Datastore, error) {
+	d, err := aiozfs.CreateOrOpen(p, c.shardFun, c.syncField)
+	if err != nil {
+		return nil, err
+	}
+	cd := &checkedDatastore{Batching: d, path: p, usage: newDiskUsage(p, c.shardFun)}
+	if c.syncInterval > 0 {
+		if cd.flush, err = startPeriodicSync(p, c.syncInterval); err != nil {
+			d.Close()
+			return nil, err
+		}
+	}
+	return cd, nil
 }
@@ -0,0 +1,94 @@
+package aiozfs
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDatastoreConfigParserSync(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		params   map[string]interface{}
+		sync     bool
+		interval time.Duration
+		valid    bool
+	}{
+		{"sync", map[string]interface{}{"sync": true}, true, 0, true},
+		{"no sync", map[string]interface{}{"sync": false}, false, 0, true},
+		{"sync omitted", map[string]interface{}{}, true, 0, true},
+		{"sync not a boolean", map[string]interface{}{"sync": "yes"}, false, 0, false},
+		{"always", map[string]interface{}{"syncMode": "always"}, true, 0, true},
+		{"never", map[string]interface{}{"syncMode": "never"}, false, 0, true},
+		{"never overrides sync", map[string]interface{}{"sync": true, "syncMode": "never"}, false, 0, true},
+		{"interval", map[string]interface{}{"syncMode": "interval", "syncInterval": "2s"}, false, 2 * time.Second, true},
+		{"interval missing", map[string]interface{}{"syncMode": "interval"}, false, 0, false},
+		{"interval invalid", map[string]interface{}{"syncMode": "interval", "syncInterval": "soon"}, false, 0, false},
+		{"interval not positive", map[string]interface{}{"syncMode": "interval", "syncInterval": "0s"}, false, 0, false},
+		{"interval without mode", map[string]interface{}{"sync": true, "syncInterval": "2s"}, false, 0, false},
+		{"interval with other mode", map[string]interface{}{"syncMode": "always", "syncInterval": "2s"}, false, 0, false},
+		{"unknown mode", map[string]interface{}{"syncMode": "sometimes"}, false, 0, false},
+		{"mode not a string", map[string]interface{}{"syncMode": true}, false, 0, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			tc.params["path"] = "blocks"
+			tc.params["shardFunc"] = "/repo/flatfs/shard/v1/next-to-last/2"
+			c, err := (&aiozfsPlugin{}).DatastoreConfigParser()(tc.params)
+			if !tc.valid {
+				if err == nil {
+					t.Fatal("expected the params to be rejected")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			dc := c.(*datastoreConfig)
+			if dc.syncField != tc.sync || dc.syncInterval != tc.interval {
+				t.Fatalf("expected sync %t every %s, got %t every %s", tc.sync, tc.interval, dc.syncField, dc.syncInterval)
+			}
+		})
+	}
+}
+
+func TestPeriodicSync(t *testing.T) {
+	s, err := startPeriodicSync(t.TempDir(), time.Millisecond)
+	if err != nil {
+		t.Skipf("flushing a filesystem is not supported: %s", err)
+	}
+	time.Sleep(5 * time.Millisecond)
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDatastoreConfigParser(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		params map[string]interface{}
+		err    string
+	}{
+		{"valid", map[string]interface{}{"path": "blocks", "shardFunc": "/repo/flatfs/shard/v1/next-to-last/2"}, ""},
+		{"missing path", map[string]interface{}{"shardFunc": "/repo/flatfs/shard/v1/next-to-last/2"}, "'path' field is missing"},
+		{"non-string path", map[string]interface{}{"path": 42.0, "shardFunc": "/repo/flatfs/shard/v1/next-to-last/2"}, "'path' field must be a string, got float64"},
+		{"empty path", map[string]interface{}{"path": "", "shardFunc": "/repo/flatfs/shard/v1/next-to-last/2"}, "'path' field is empty"},
+		{"missing shardFunc", map[string]interface{}{"path": "blocks"}, "'shardFunc' field is missing"},
+		{"non-string shardFunc", map[string]interface{}{"path": "blocks", "shardFunc": true}, "'shardFunc' field must be a string, got bool"},
+		{"non-string fallbackPath", map[string]interface{}{"path": "blocks", "shardFunc": "/repo/flatfs/shard/v1/next-to-last/2", "fallbackPath": 1.0}, "'fallbackPath' field must be a string, got float64"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			c, err := (&aiozfsPlugin{}).DatastoreConfigParser()(tc.params)
+			if tc.err == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				if dc := c.(*datastoreConfig); !dc.syncField {
+					t.Fatal("expected sync to default to true")
+				}
+				return
+			}
+			if err == nil || err.Error() != tc.err {
+				t.Fatalf("expected error %q, got %v", tc.err, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,79 @@
+package aiozfs
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/kubo/repo"
+)
+
+const importBlocks = 10000
+
+func createDatastore(b *testing.B) repo.Datastore {
+	b.Helper()
+	c, err := (&aiozfsPlugin{}).DatastoreConfigParser()(map[string]interface{}{
+		"path":      "blocks",
+		"shardFunc": "/repo/flatfs/shard/v1/next-to-last/2",
+		"sync":      true,
+	})
+	if err != nil {
+		b.Fatal(err)
+	}
+	d, err := c.Create(b.TempDir())
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.Cleanup(func() { d.Close() })
+	return d
+}
+
+func importKeys() []datastore.Key {
+	keys := make([]datastore.Key, importBlocks)
+	for i := range keys {
+		keys[i] = datastore.NewKey(fmt.Sprintf("CIQBLOCK%08d", i))
+	}
+	return keys
+}
+
+// BenchmarkImport compares storing 10k blocks one Put at a time with storing
+// them in a single batch.
+func BenchmarkImport(b *testing.B) {
+	ctx := context.Background()
+	keys := importKeys()
+	value := make([]byte, 256)
+
+	b.Run("put", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			b.StopTimer()
+			d := createDatastore(b)
+			b.StartTimer()
+			for _, k := range keys {
+				if err := d.Put(ctx, k, value); err != nil {
+					b.Fatal(err)
+				}
+			}
+		}
+	})
+
+	b.Run("batch", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			b.StopTimer()
+			d := createDatastore(b)
+			b.StartTimer()
+			batch, err := d.Batch(ctx)
+			if err != nil {
+				b.Fatal(err)
+			}
+			for _, k := range keys {
+				if err := batch.Put(ctx, k, value); err != nil {
+					b.Fatal(err)
+				}
+			}
+			if err := batch.Commit(ctx); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
@@ -0,0 +1,97 @@
+package aiozfs
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	aiozfs "github.com/phantue99/go-ds-aiozfs"
+)
+
+// diskUsageRefresh is how long a computed disk usage is reported before the
+// datastore directory is walked again.
+const diskUsageRefresh = time.Minute
+
+// diskUsage computes the space taken by the blocks of an aiozfs datastore by
+// walking its shard directories, caching the result for refresh.
+type diskUsage struct {
+	dir     string
+	shardOf func(string) string
+	refresh time.Duration
+
+	mu       sync.Mutex
+	value    uint64
+	computed time.Time
+}
+
+func newDiskUsage(dir string, fun *aiozfs.ShardIdV1) *diskUsage {
+	return &diskUsage{dir: dir, shardOf: fun.Func(), refresh: diskUsageRefresh}
+}
+
+// get returns the disk usage, walking the directory again if the cached
+// value is older than refresh.
+func (u *diskUsage) get(ctx context.Context) (uint64, error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if !u.computed.IsZero() && time.Since(u.computed) < u.refresh {
+		return u.value, nil
+	}
+	value, err := u.walk(ctx)
+	if err != nil {
+		return 0, err
+	}
+	u.value, u.computed = value, time.Now()
+	return value, nil
+}
+
+// walk sums the sizes of the block files stored in the shard directory the
+// sharding function places them in. Metadata and temporary files are left
+// out, and a datastore directory that doesn't exist yet takes no space.
+func (u *diskUsage) walk(ctx context.Context) (uint64, error) {
+	shards, err := os.ReadDir(u.dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	var total uint64
+	for _, shard := range shards {
+		if !shard.IsDir() {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return 0, err
+		}
+		files, err := os.ReadDir(filepath.Join(u.dir, shard.Name()))
+		if err != nil {
+			return 0, err
+		}
+		for _, f := range files {
+			name := f.Name()
+			if f.IsDir() || strings.HasPrefix(name, ".") {
+				continue
+			}
+			if u.shardOf(strings.TrimSuffix(name, filepath.Ext(name))) != shard.Name() {
+				continue
+			}
+			info, err := f.Info()
+			if errors.Is(err, fs.ErrNotExist) {
+				// deleted since the directory was read
+				continue
+			}
+			if err != nil {
+				return 0, err
+			}
+			total += uint64(info.Size())
+		}
+	}
+	return total, nil
+}
@@ -0,0 +1,58 @@
+package aiozfs
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	aiozfs "github.com/phantue99/go-ds-aiozfs"
+)
+
+func TestDiskUsage(t *testing.T) {
+	ctx := context.Background()
+	dir := filepath.Join(t.TempDir(), "blocks")
+	fun := aiozfs.NextToLast(2)
+	u := newDiskUsage(dir, fun)
+
+	if du, err := u.get(ctx); err != nil || du != 0 {
+		t.Fatalf("expected a missing directory to take no space, got %d, %v", du, err)
+	}
+
+	write := func(shard, name string, size int) {
+		t.Helper()
+		if err := os.MkdirAll(filepath.Join(dir, shard), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, shard, name), make([]byte, size), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	const (
+		k1 = "CIQA4XCGRCRTCCHV7XSGAZPZJOAOHLPOI6IQR3H6YQ2OBYTKSF3T4IA"
+		k2 = "CIQBED3K6YA5I3QQWLJOCHWXDRK5EXZQILBCKAPEDUJENZ5B5HJ5R3A"
+	)
+	shardOf := fun.Func()
+	write(shardOf(k1), k1+".data", 100)
+	write(shardOf(k2), k2+".data", 20)
+	// not counted: metadata, temporary files and files outside their shard
+	write("", "SHARDING", 50)
+	write(shardOf(k1), ".temp123", 1000)
+	write("ZZ", k1+".data", 1000)
+
+	u = newDiskUsage(dir, fun)
+	if du, err := u.get(ctx); err != nil || du != 120 {
+		t.Fatalf("expected 120 bytes, got %d, %v", du, err)
+	}
+
+	// the usage is cached until refreshed
+	write(shardOf(k2), k2+".data", 30)
+	if du, _ := u.get(ctx); du != 120 {
+		t.Fatalf("expected the cached usage, got %d", du)
+	}
+	u.computed = time.Now().Add(-u.refresh)
+	if du, _ := u.get(ctx); du != 130 {
+		t.Fatalf("expected the refreshed usage, got %d", du)
+	}
+}
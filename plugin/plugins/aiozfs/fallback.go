@@ -0,0 +1,52 @@
+package aiozfs
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	ds "github.com/ipfs/go-datastore"
+)
+
+var errReadOnly = errors.New("aiozfs datastore is opened read-only from its fallback path")
+
+// accessible returns an error if the datastore directory p can't be used:
+// it must exist, or its parent must so that it can be created.
+func accessible(p string) error {
+	_, err := os.Stat(p)
+	if !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	_, err = os.Stat(filepath.Dir(p))
+	return err
+}
+
+// readOnlyDatastore rejects writes to the wrapped datastore.
+type readOnlyDatastore struct {
+	ds.Batching
+}
+
+func (*readOnlyDatastore) Put(context.Context, ds.Key, []byte) error {
+	return errReadOnly
+}
+
+func (*readOnlyDatastore) Delete(context.Context, ds.Key) error {
+	return errReadOnly
+}
+
+func (*readOnlyDatastore) Batch(context.Context) (ds.Batch, error) {
+	return nil, errReadOnly
+}
+
+func (d *readOnlyDatastore) DiskUsage(ctx context.Context) (uint64, error) {
+	return ds.DiskUsage(ctx, d.Batching)
+}
+
+func (d *readOnlyDatastore) Check(ctx context.Context) error {
+	if c, ok := d.Batching.(ds.CheckedDatastore); ok {
+		return c.Check(ctx)
+	}
+	return nil
+}
@@ -0,0 +1,85 @@
+package aiozfs
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/ipfs/go-datastore"
+)
+
+func TestCreateFallback(t *testing.T) {
+	ctx := context.Background()
+	root := t.TempDir()
+	key := datastore.NewKey("CIQA4XCGRCRTCCHV7XSGAZPZJOAOHLPOI6IQR3H6YQ2OBYTKSF3T4IA")
+
+	parse := func(params map[string]interface{}) *datastoreConfig {
+		t.Helper()
+		params["shardFunc"] = "/repo/flatfs/shard/v1/next-to-last/2"
+		params["sync"] = false
+		c, err := (&aiozfsPlugin{}).DatastoreConfigParser()(params)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c.(*datastoreConfig)
+	}
+
+	// seed the fallback with a block
+	d, err := parse(map[string]interface{}{"path": "fallback"}).Create(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Put(ctx, key, []byte("block")); err != nil {
+		t.Fatal(err)
+	}
+	d.Close()
+
+	t.Run("primary available", func(t *testing.T) {
+		d, err := parse(map[string]interface{}{"path": "blocks", "fallbackPath": "fallback"}).Create(root)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer d.Close()
+		if _, ok := d.(*readOnlyDatastore); ok {
+			t.Fatal("expected the primary path to be used")
+		}
+		if has, _ := d.Has(ctx, key); has {
+			t.Fatal("expected an empty primary datastore")
+		}
+	})
+
+	t.Run("primary unavailable", func(t *testing.T) {
+		// the mount the primary path lives on is missing
+		primary := filepath.Join(root, "unmounted", "blocks")
+		d, err := parse(map[string]interface{}{"path": primary, "fallbackPath": "fallback"}).Create(root)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer d.Close()
+		v, err := d.Get(ctx, key)
+		if err != nil || string(v) != "block" {
+			t.Fatalf("expected blocks to be read from the fallback, got %q, %v", v, err)
+		}
+		if err := d.Put(ctx, key, []byte("other")); !errors.Is(err, errReadOnly) {
+			t.Fatalf("expected the fallback to be read-only, got %v", err)
+		}
+	})
+
+	t.Run("both unavailable", func(t *testing.T) {
+		c := parse(map[string]interface{}{
+			"path":         filepath.Join(root, "unmounted", "blocks"),
+			"fallbackPath": filepath.Join(root, "unmounted", "fallback"),
+		})
+		if _, err := c.Create(root); err == nil {
+			t.Fatal("expected an error")
+		}
+	})
+
+	t.Run("same paths", func(t *testing.T) {
+		c := parse(map[string]interface{}{"path": "blocks", "fallbackPath": "./blocks"})
+		if _, err := c.Create(root); err == nil {
+			t.Fatal("expected an error")
+		}
+	})
+}
@@ -0,0 +1,48 @@
+package aiozfs
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	ds "github.com/ipfs/go-datastore"
+)
+
+// checkedDatastore reports the wrapped datastore unhealthy when its
+// directory becomes inaccessible, for instance when the disk holding it is
+// unmounted, for readiness checks. Its disk usage is computed from the
+// files stored in the directory, and its writes are flushed periodically
+// when flush is set.
+type checkedDatastore struct {
+	ds.Batching
+	path  string
+	usage *diskUsage
+	flush *periodicSync
+}
+
+var (
+	_ ds.Batching            = (*checkedDatastore)(nil)
+	_ ds.CheckedDatastore    = (*checkedDatastore)(nil)
+	_ ds.PersistentDatastore = (*checkedDatastore)(nil)
+)
+
+func (d *checkedDatastore) Check(ctx context.Context) error {
+	if _, err := os.Stat(d.path); err != nil {
+		return fmt.Errorf("aiozfs datastore directory is inaccessible: %w", err)
+	}
+	if c, ok := d.Batching.(ds.CheckedDatastore); ok {
+		return c.Check(ctx)
+	}
+	return nil
+}
+
+func (d *checkedDatastore) DiskUsage(ctx context.Context) (uint64, error) {
+	return d.usage.get(ctx)
+}
+
+func (d *checkedDatastore) Close() error {
+	if d.flush != nil {
+		d.flush.Close()
+	}
+	return d.Batching.Close()
+}
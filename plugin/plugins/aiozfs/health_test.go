@@ -0,0 +1,33 @@
+package aiozfs
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ipfs/go-datastore"
+)
+
+func TestCheckedDatastore(t *testing.T) {
+	ctx := context.Background()
+	p := filepath.Join(t.TempDir(), "blocks")
+	if err := os.Mkdir(p, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	d := &checkedDatastore{Batching: datastore.NewMapDatastore(), path: p}
+	if err := d.Check(ctx); err != nil {
+		t.Fatalf("expected the datastore to be healthy, got %s", err)
+	}
+
+	// the disk holding the datastore went away
+	if err := os.Remove(p); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Check(ctx); err == nil {
+		t.Fatal("expected an inaccessible directory to be reported")
+	}
+	if err := (&readOnlyDatastore{Batching: d}).Check(ctx); err == nil {
+		t.Fatal("expected the read-only datastore to report the check of its datastore")
+	}
+}
@@ -0,0 +1,173 @@
+package aiozfs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	aiozfs "github.com/phantue99/go-ds-aiozfs"
+)
+
+// shardSamples is the number of stored blocks checked per shard directory
+// when deriving the sharding function.
+const shardSamples = 16
+
+// FindDatastore returns the directory and configured sharding function of the
+// aiozfs datastore in the given datastore spec. Relative paths are resolved
+// against repoRoot.
+func FindDatastore(repoRoot string, spec map[string]interface{}) (string, *aiozfs.ShardIdV1, error) {
+	if spec["type"] == "aiozfs" {
+		p, ok := spec["path"].(string)
+		if !ok {
+			return "", nil, fmt.Errorf("'path' field is missing or not a string")
+		}
+		if !filepath.IsAbs(p) {
+			p = filepath.Join(repoRoot, p)
+		}
+		s, ok := spec["shardFunc"].(string)
+		if !ok {
+			return "", nil, fmt.Errorf("'shardFunc' field is missing or not a string")
+		}
+		fun, err := aiozfs.ParseShardFunc(s)
+		if err != nil {
+			return "", nil, err
+		}
+		return p, fun, nil
+	}
+
+	var children []interface{}
+	if child, ok := spec["child"]; ok {
+		children = append(children, child)
+	}
+	if mounts, ok := spec["mounts"].([]interface{}); ok {
+		children = append(children, mounts...)
+	}
+	for _, child := range children {
+		if m, ok := child.(map[string]interface{}); ok {
+			if p, fun, err := FindDatastore(repoRoot, m); err == nil {
+				return p, fun, nil
+			}
+		}
+	}
+	return "", nil, errors.New("no aiozfs datastore in the datastore spec")
+}
+
+// RebuildMetadata rewrites the SHARDING and disk usage files of the aiozfs
+// datastore in dir. The sharding function is derived from where blocks are
+// stored, falling back to fallback when the datastore is empty. It returns
+// the sharding function written and the recomputed disk usage.
+//
+// The datastore must not be open while its metadata is rebuilt.
+func RebuildMetadata(dir string, fallback *aiozfs.ShardIdV1) (*aiozfs.ShardIdV1, uint64, error) {
+	fun, err := deriveShardFunc(dir)
+	if err != nil {
+		return nil, 0, err
+	}
+	if fun == nil {
+		fun = fallback
+	}
+
+	if err := os.Remove(filepath.Join(dir, aiozfs.SHARDING_FN)); err != nil && !os.IsNotExist(err) {
+		return nil, 0, err
+	}
+	if err := aiozfs.WriteShardFunc(dir, fun); err != nil {
+		return nil, 0, err
+	}
+	if err := aiozfs.WriteReadme(dir, fun); err != nil {
+		return nil, 0, err
+	}
+
+	// the disk usage is recomputed and persisted when opening a datastore
+	// without a cached value
+	if err := os.Remove(filepath.Join(dir, aiozfs.DiskUsageFile)); err != nil && !os.IsNotExist(err) {
+		return nil, 0, err
+	}
+	ds, err := aiozfs.Open(dir, false)
+	if err != nil {
+		return nil, 0, err
+	}
+	du, err := ds.DiskUsage(context.Background())
+	if err != nil {
+		ds.Close()
+		return nil, 0, err
+	}
+	return fun, du, ds.Close()
+}
+
+// deriveShardFunc finds the sharding function placing the stored blocks in
+// the shard directories they are in. It returns nil when no block is stored.
+func deriveShardFunc(dir string) (*aiozfs.ShardIdV1, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var candidates []*aiozfs.ShardIdV1
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		shard := e.Name()
+		keys, err := sampleKeys(filepath.Join(dir, shard))
+		if err != nil {
+			return nil, err
+		}
+		if len(keys) == 0 {
+			continue
+		}
+
+		if candidates == nil {
+			n := len(shard)
+			candidates = []*aiozfs.ShardIdV1{aiozfs.NextToLast(n), aiozfs.Suffix(n), aiozfs.Prefix(n)}
+		}
+		matching := candidates[:0]
+		for _, fun := range candidates {
+			if placesAll(fun, keys, shard) {
+				matching = append(matching, fun)
+			}
+		}
+		candidates = matching
+		if len(candidates) == 0 {
+			return nil, fmt.Errorf("blocks in %s don't follow any known sharding function", dir)
+		}
+	}
+
+	if candidates == nil {
+		return nil, nil
+	}
+	return candidates[0], nil
+}
+
+// sampleKeys returns the keys of up to shardSamples blocks in a shard
+// directory.
+func sampleKeys(shardDir string) ([]string, error) {
+	entries, err := os.ReadDir(shardDir)
+	if err != nil {
+		return nil, err
+	}
+	var keys []string
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || strings.HasPrefix(name, ".") {
+			continue
+		}
+		keys = append(keys, strings.TrimSuffix(name, filepath.Ext(name)))
+		if len(keys) == shardSamples {
+			break
+		}
+	}
+	return keys, nil
+}
+
+func placesAll(fun *aiozfs.ShardIdV1, keys []string, shard string) bool {
+	shardOf := fun.Func()
+	for _, k := range keys {
+		if shardOf(k) != shard {
+			return false
+		}
+	}
+	return true
+}
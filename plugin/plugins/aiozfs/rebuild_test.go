@@ -0,0 +1,101 @@
+package aiozfs
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ipfs/go-datastore"
+	aiozfs "github.com/phantue99/go-ds-aiozfs"
+)
+
+func TestRebuildMetadata(t *testing.T) {
+	ctx := context.Background()
+	dir := filepath.Join(t.TempDir(), "blocks")
+	shard := aiozfs.NextToLast(2)
+
+	ds, err := aiozfs.CreateOrOpen(dir, shard, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, k := range []string{
+		"CIQA4XCGRCRTCCHV7XSGAZPZJOAOHLPOI6IQR3H6YQ2OBYTKSF3T4IA",
+		"CIQBED3K6YA5I3QQWLJOCHWXDRK5EXZQILBCKAPEDUJENZ5B5HJ5R3A",
+		"CIQFTFEEHEDF6KLBT32BFAGLXEZL4UWFNWM4LFTLMXQBCERZ6CMLX3Y",
+	} {
+		if err := ds.Put(ctx, datastore.NewKey(k), []byte(k)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want, err := ds.DiskUsage(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ds.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, fn := range []string{aiozfs.SHARDING_FN, aiozfs.DiskUsageFile} {
+		if err := os.Remove(filepath.Join(dir, fn)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the fallback must not be used when blocks are present
+	fun, du, err := RebuildMetadata(dir, aiozfs.Prefix(4))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fun.String() != shard.String() {
+		t.Fatalf("expected sharding %s, got %s", shard, fun)
+	}
+	if du != want {
+		t.Fatalf("expected disk usage %d, got %d", want, du)
+	}
+
+	read, err := aiozfs.ReadShardFunc(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if read.String() != shard.String() {
+		t.Fatalf("expected %s file to hold %s, got %s", aiozfs.SHARDING_FN, shard, read)
+	}
+	cached, err := os.ReadFile(filepath.Join(dir, aiozfs.DiskUsageFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(cached), "diskUsage") {
+		t.Fatalf("unexpected %s content: %s", aiozfs.DiskUsageFile, cached)
+	}
+}
+
+func TestFindDatastore(t *testing.T) {
+	spec := map[string]interface{}{
+		"type": "mount",
+		"mounts": []interface{}{
+			map[string]interface{}{
+				"mountpoint": "/blocks",
+				"type":       "measure",
+				"prefix":     "aiozfs.datastore",
+				"child": map[string]interface{}{
+					"type":      "aiozfs",
+					"path":      "blocks",
+					"sync":      true,
+					"shardFunc": "/repo/aiozfs/shard/v1/next-to-last/2",
+				},
+			},
+		},
+	}
+	p, fun, err := FindDatastore("/repo", spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p != filepath.Join("/repo", "blocks") {
+		t.Fatalf("unexpected datastore path %s", p)
+	}
+	if fun.String() != "/repo/aiozfs/shard/v1/next-to-last/2" {
+		t.Fatalf("unexpected sharding function %s", fun)
+	}
+}
@@ -0,0 +1,52 @@
+package aiozfs
+
+import (
+	"time"
+)
+
+// periodicSync flushes the filesystem holding a datastore directory at a
+// fixed interval, bounding how many writes not synced individually can be
+// lost on a crash.
+type periodicSync struct {
+	dir  string
+	stop chan struct{}
+	done chan struct{}
+}
+
+// startPeriodicSync flushes the filesystem of dir every interval until
+// closed. It fails if the filesystem can't be flushed on this platform.
+func startPeriodicSync(dir string, interval time.Duration) (*periodicSync, error) {
+	if err := syncFilesystem(dir); err != nil {
+		return nil, err
+	}
+	s := &periodicSync{
+		dir:  dir,
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+	go s.run(interval)
+	return s, nil
+}
+
+func (s *periodicSync) run(interval time.Duration) {
+	defer close(s.done)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := syncFilesystem(s.dir); err != nil {
+				log.Errorf("syncing aiozfs datastore %s: %s", s.dir, err)
+			}
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+// Close stops the periodic flushes, flushing one last time.
+func (s *periodicSync) Close() error {
+	close(s.stop)
+	<-s.done
+	return syncFilesystem(s.dir)
+}
@@ -0,0 +1,19 @@
+//go:build linux
+
+package aiozfs
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// syncFilesystem flushes the writes to the filesystem holding dir.
+func syncFilesystem(dir string) error {
+	f, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return unix.Syncfs(int(f.Fd()))
+}
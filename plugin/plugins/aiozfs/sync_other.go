@@ -0,0 +1,13 @@
+//go:build !unix
+
+package aiozfs
+
+import (
+	"errors"
+)
+
+// syncFilesystem fails: flushing a filesystem isn't supported on this
+// platform.
+func syncFilesystem(string) error {
+	return errors.New("aiozfs syncMode \"interval\" is not supported on this platform")
+}
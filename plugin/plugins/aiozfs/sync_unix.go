@@ -0,0 +1,14 @@
+//go:build unix && !linux
+
+package aiozfs
+
+import (
+	"syscall"
+)
+
+// syncFilesystem flushes the writes to all filesystems, this platform having
+// no way to flush a single one.
+func syncFilesystem(string) error {
+	syscall.Sync()
+	return nil
+}
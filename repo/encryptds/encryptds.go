@@ -0,0 +1,334 @@
+// Package encryptds provides a datastore wrapper encrypting values at rest
+// with AES-256-GCM.
+package encryptds
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"math"
+
+	ds "github.com/ipfs/go-datastore"
+	dsq "github.com/ipfs/go-datastore/query"
+	config "github.com/ipfs/kubo/config"
+)
+
+// KeySize is the size in bytes of the keys accepted by Wrap.
+const KeySize = 32
+
+// formatKeyed marks values recording the ID of the key they were sealed
+// with. Values sealed before key IDs were recorded have the nonce right
+// after the prefix and are opened with the key of ID "".
+const formatKeyed = 1
+
+// ErrDecrypt is returned when a stored value can't be decrypted, because it
+// was altered or written with another key.
+var ErrDecrypt = errors.New("encryptds: failed to decrypt value")
+
+// Key is an encryption key along with the ID recorded in the values it
+// sealed.
+type Key struct {
+	ID  string
+	Key []byte
+}
+
+// Datastore encrypts the values written to the wrapped datastore. Stored
+// values are the prefix, the ID of the key, a random nonce and the sealed
+// value, authenticated along with their datastore key so they can't be
+// swapped. Values without the prefix, written before encryption was enabled,
+// are read as is.
+type Datastore struct {
+	child  ds.Batching
+	keys   map[string]cipher.AEAD
+	active string
+	prefix []byte
+}
+
+var _ ds.Batching = (*Datastore)(nil)
+
+// Wrap returns child wrapped so values are encrypted with key and tagged
+// with prefix. The key must be KeySize bytes long and the prefix non-empty.
+func Wrap(child ds.Batching, key []byte, prefix string) (*Datastore, error) {
+	return WrapKeys(child, []Key{{Key: key}}, "", prefix)
+}
+
+// WrapConfig wraps child with the at-rest encryption settings of cfg:
+// BlockEncryptionKeys when listed, BlockEncryptionKey as the key of ID ""
+// otherwise. Keys are decoded with config.DecodeBlockEncryptionKey.
+func WrapConfig(child ds.Batching, cfg config.ConfigPinningService) (*Datastore, error) {
+	if len(cfg.BlockEncryptionKeys) == 0 {
+		key, err := config.DecodeBlockEncryptionKey(cfg.BlockEncryptionKey)
+		if err != nil {
+			return nil, fmt.Errorf("encryptds: %w", err)
+		}
+		return Wrap(child, key, cfg.EncryptedBlockPrefix)
+	}
+	keys := make([]Key, 0, len(cfg.BlockEncryptionKeys))
+	var active string
+	for _, k := range cfg.BlockEncryptionKeys {
+		key, err := config.DecodeBlockEncryptionKey(k.Key)
+		if err != nil {
+			return nil, fmt.Errorf("encryptds: key %q: %w", k.ID, err)
+		}
+		keys = append(keys, Key{ID: k.ID, Key: key})
+		if k.Active {
+			active = k.ID
+		}
+	}
+	return WrapKeys(child, keys, active, cfg.EncryptedBlockPrefix)
+}
+
+// WrapKeys is like Wrap with several keys, to rotate them. Values are
+// written with the key whose ID is active and read with the key they were
+// written with.
+func WrapKeys(child ds.Batching, keys []Key, active string, prefix string) (*Datastore, error) {
+	if prefix == "" {
+		return nil, errors.New("encryptds: prefix must not be empty")
+	}
+	d := &Datastore{
+		child:  child,
+		keys:   make(map[string]cipher.AEAD, len(keys)),
+		active: active,
+		prefix: []byte(prefix),
+	}
+	for _, k := range keys {
+		if len(k.Key) != KeySize {
+			return nil, fmt.Errorf("encryptds: key %q must be %d bytes long, got %d", k.ID, KeySize, len(k.Key))
+		}
+		if len(k.ID) > math.MaxUint8 {
+			return nil, fmt.Errorf("encryptds: key ID %q is longer than %d bytes", k.ID, math.MaxUint8)
+		}
+		if _, ok := d.keys[k.ID]; ok {
+			return nil, fmt.Errorf("encryptds: duplicate key ID %q", k.ID)
+		}
+		block, err := aes.NewCipher(k.Key)
+		if err != nil {
+			return nil, err
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+		d.keys[k.ID] = aead
+	}
+	if _, ok := d.keys[active]; !ok {
+		return nil, fmt.Errorf("encryptds: no key with the active ID %q", active)
+	}
+	return d, nil
+}
+
+func (d *Datastore) encrypt(key ds.Key, value []byte) ([]byte, error) {
+	aead := d.keys[d.active]
+	header := len(d.prefix) + 2 + len(d.active)
+	nonceSize := aead.NonceSize()
+	out := make([]byte, header+nonceSize, header+nonceSize+len(value)+aead.Overhead())
+	copy(out, d.prefix)
+	out[len(d.prefix)] = formatKeyed
+	out[len(d.prefix)+1] = byte(len(d.active))
+	copy(out[len(d.prefix)+2:], d.active)
+	nonce := out[header:]
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(out, nonce, value, key.Bytes()), nil
+}
+
+// sealedWith returns the ID of the key recorded in a stored value, and the
+// rest of the value.
+func (d *Datastore) sealedWith(stored []byte) (string, []byte, bool) {
+	if !bytes.HasPrefix(stored, d.prefix) {
+		return "", nil, false
+	}
+	rest := stored[len(d.prefix):]
+	if len(rest) < 2 || rest[0] != formatKeyed || len(rest) < 2+int(rest[1]) {
+		return "", nil, false
+	}
+	n := int(rest[1])
+	return string(rest[2 : 2+n]), rest[2+n:], true
+}
+
+func open(aead cipher.AEAD, key ds.Key, sealed []byte) ([]byte, error) {
+	nonceSize := aead.NonceSize()
+	if len(sealed) < nonceSize {
+		return nil, errors.New("too short")
+	}
+	return aead.Open(nil, sealed[:nonceSize], sealed[nonceSize:], key.Bytes())
+}
+
+func (d *Datastore) decrypt(key ds.Key, stored []byte) ([]byte, error) {
+	if !bytes.HasPrefix(stored, d.prefix) {
+		return stored, nil
+	}
+
+	err := errors.New("not sealed with a known key")
+	if id, sealed, ok := d.sealedWith(stored); ok {
+		if aead, ok := d.keys[id]; ok {
+			var value []byte
+			if value, err = open(aead, key, sealed); err == nil {
+				return value, nil
+			}
+		} else {
+			err = fmt.Errorf("unknown key %q", id)
+		}
+	}
+	if aead, ok := d.keys[""]; ok {
+		if value, lerr := open(aead, key, stored[len(d.prefix):]); lerr == nil {
+			return value, nil
+		}
+	}
+	return nil, fmt.Errorf("%w %s: %s", ErrDecrypt, key, err)
+}
+
+// Rotate re-encrypts the value stored under key with the active key, unless
+// it already is. It reports whether the value was rewritten. Values stored
+// in plain text are encrypted.
+func (d *Datastore) Rotate(ctx context.Context, key ds.Key) (bool, error) {
+	stored, err := d.child.Get(ctx, key)
+	if err != nil {
+		return false, err
+	}
+	if id, _, ok := d.sealedWith(stored); ok && id == d.active {
+		return false, nil
+	}
+	value, err := d.decrypt(key, stored)
+	if err != nil {
+		return false, err
+	}
+	if err := d.Put(ctx, key, value); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Verify checks that the value stored under key can be decrypted, without
+// changing it. It reports whether the value is encrypted: values stored in
+// plain text are not checked.
+func (d *Datastore) Verify(ctx context.Context, key ds.Key) (bool, error) {
+	stored, err := d.child.Get(ctx, key)
+	if err != nil {
+		return false, err
+	}
+	if !bytes.HasPrefix(stored, d.prefix) {
+		return false, nil
+	}
+	_, err = d.decrypt(key, stored)
+	return true, err
+}
+
+func (d *Datastore) Put(ctx context.Context, key ds.Key, value []byte) error {
+	sealed, err := d.encrypt(key, value)
+	if err != nil {
+		return err
+	}
+	return d.child.Put(ctx, key, sealed)
+}
+
+func (d *Datastore) Get(ctx context.Context, key ds.Key) ([]byte, error) {
+	stored, err := d.child.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	return d.decrypt(key, stored)
+}
+
+func (d *Datastore) Has(ctx context.Context, key ds.Key) (bool, error) {
+	return d.child.Has(ctx, key)
+}
+
+// GetSize returns the size of the decrypted value. The stored size can't
+// tell whether the value is encrypted, so the value is read.
+func (d *Datastore) GetSize(ctx context.Context, key ds.Key) (int, error) {
+	value, err := d.Get(ctx, key)
+	if err != nil {
+		return -1, err
+	}
+	return len(value), nil
+}
+
+func (d *Datastore) Delete(ctx context.Context, key ds.Key) error {
+	return d.child.Delete(ctx, key)
+}
+
+// Query decrypts the returned values. Queries for keys only are passed to
+// the wrapped datastore, others are run over all values of the prefix so
+// filters and orders apply to decrypted values.
+func (d *Datastore) Query(ctx context.Context, q dsq.Query) (dsq.Results, error) {
+	if q.KeysOnly && !q.ReturnsSizes {
+		return d.child.Query(ctx, q)
+	}
+
+	stored, err := d.child.Query(ctx, dsq.Query{Prefix: q.Prefix})
+	if err != nil {
+		return nil, err
+	}
+	decrypted := dsq.ResultsFromIterator(q, dsq.Iterator{
+		Next: func() (dsq.Result, bool) {
+			r, ok := stored.NextSync()
+			if !ok || r.Error != nil {
+				return r, ok
+			}
+			value, err := d.decrypt(ds.RawKey(r.Key), r.Value)
+			if err != nil {
+				return dsq.Result{Error: err}, true
+			}
+			r.Value, r.Size = value, len(value)
+			return r, true
+		},
+		Close: stored.Close,
+	})
+	qr := dsq.NaiveQueryApply(dsq.Query{
+		Filters: q.Filters,
+		Orders:  q.Orders,
+		Limit:   q.Limit,
+		Offset:  q.Offset,
+	}, decrypted)
+	if q.KeysOnly {
+		applied := qr
+		qr = dsq.ResultsFromIterator(q, dsq.Iterator{
+			Next: func() (dsq.Result, bool) {
+				r, ok := applied.NextSync()
+				r.Value = nil
+				return r, ok
+			},
+			Close: applied.Close,
+		})
+	}
+	return dsq.ResultsReplaceQuery(qr, q), nil
+}
+
+func (d *Datastore) Sync(ctx context.Context, prefix ds.Key) error {
+	return d.child.Sync(ctx, prefix)
+}
+
+func (d *Datastore) DiskUsage(ctx context.Context) (uint64, error) {
+	return ds.DiskUsage(ctx, d.child)
+}
+
+func (d *Datastore) Close() error {
+	return d.child.Close()
+}
+
+func (d *Datastore) Batch(ctx context.Context) (ds.Batch, error) {
+	b, err := d.child.Batch(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &batch{Batch: b, d: d}, nil
+}
+
+type batch struct {
+	ds.Batch
+	d *Datastore
+}
+
+func (b *batch) Put(ctx context.Context, key ds.Key, value []byte) error {
+	sealed, err := b.d.encrypt(key, value)
+	if err != nil {
+		return err
+	}
+	return b.Batch.Put(ctx, key, sealed)
+}
@@ -0,0 +1,406 @@
+package encryptds
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"testing"
+
+	ds "github.com/ipfs/go-datastore"
+	dsq "github.com/ipfs/go-datastore/query"
+	dssync "github.com/ipfs/go-datastore/sync"
+	config "github.com/ipfs/kubo/config"
+)
+
+const testPrefix = "enc:"
+
+var testKey = []byte("0123456789abcdef0123456789abcdef")
+
+func newTestDatastore(t *testing.T) (*Datastore, ds.Batching) {
+	t.Helper()
+	child := dssync.MutexWrap(ds.NewMapDatastore())
+	d, err := Wrap(child, testKey, testPrefix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return d, child
+}
+
+func TestRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	d, child := newTestDatastore(t)
+	key := ds.NewKey("/blocks/a")
+	value := []byte("block data")
+
+	if err := d.Put(ctx, key, value); err != nil {
+		t.Fatal(err)
+	}
+	stored, err := child.Get(ctx, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(stored, []byte(testPrefix)) {
+		t.Fatal("expected the stored value to be tagged with the prefix")
+	}
+	if bytes.Contains(stored, value) {
+		t.Fatal("expected the stored value to be encrypted")
+	}
+
+	got, err := d.Get(ctx, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, value) {
+		t.Fatalf("expected %q, got %q", value, got)
+	}
+	size, err := d.GetSize(ctx, key)
+	if err != nil || size != len(value) {
+		t.Fatalf("expected size %d, got %d, %v", len(value), size, err)
+	}
+}
+
+func TestTamper(t *testing.T) {
+	ctx := context.Background()
+	d, child := newTestDatastore(t)
+	key := ds.NewKey("/blocks/a")
+
+	if err := d.Put(ctx, key, []byte("block data")); err != nil {
+		t.Fatal(err)
+	}
+	stored, err := child.Get(ctx, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// flip the last ciphertext byte, right before the GCM tag
+	stored[len(stored)-17] ^= 0x01
+	if err := child.Put(ctx, key, stored); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.Get(ctx, key); !errors.Is(err, ErrDecrypt) {
+		t.Fatalf("expected a decryption failure, got %v", err)
+	}
+
+	// a value moved to another key is rejected as well
+	other := ds.NewKey("/blocks/b")
+	if err := d.Put(ctx, key, []byte("block data")); err != nil {
+		t.Fatal(err)
+	}
+	stored, _ = child.Get(ctx, key)
+	if err := child.Put(ctx, other, stored); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.Get(ctx, other); !errors.Is(err, ErrDecrypt) {
+		t.Fatalf("expected a decryption failure, got %v", err)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	ctx := context.Background()
+	d, child := newTestDatastore(t)
+	good, corrupted, plain := ds.NewKey("/blocks/a"), ds.NewKey("/blocks/b"), ds.NewKey("/blocks/c")
+
+	for _, key := range []ds.Key{good, corrupted} {
+		if err := d.Put(ctx, key, []byte("block data")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	stored, err := child.Get(ctx, corrupted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored[len(stored)-1] ^= 0x01
+	if err := child.Put(ctx, corrupted, stored); err != nil {
+		t.Fatal(err)
+	}
+	if err := child.Put(ctx, plain, []byte("block data")); err != nil {
+		t.Fatal(err)
+	}
+
+	if encrypted, err := d.Verify(ctx, good); err != nil || !encrypted {
+		t.Fatalf("expected the encrypted value to be verified, got %t, %v", encrypted, err)
+	}
+	if encrypted, err := d.Verify(ctx, corrupted); !errors.Is(err, ErrDecrypt) || !encrypted {
+		t.Fatalf("expected the corrupted value to fail, got %t, %v", encrypted, err)
+	}
+	if encrypted, err := d.Verify(ctx, plain); err != nil || encrypted {
+		t.Fatalf("expected the plain value to be skipped, got %t, %v", encrypted, err)
+	}
+	after, _ := child.Get(ctx, corrupted)
+	if !bytes.Equal(after, stored) {
+		t.Fatal("expected the corrupted value to be left as is")
+	}
+}
+
+func TestPlaintextValues(t *testing.T) {
+	ctx := context.Background()
+	d, child := newTestDatastore(t)
+	key := ds.NewKey("/blocks/a")
+
+	if err := child.Put(ctx, key, []byte("written before encryption")); err != nil {
+		t.Fatal(err)
+	}
+	got, err := d.Get(ctx, key)
+	if err != nil || string(got) != "written before encryption" {
+		t.Fatalf("expected plaintext values to be read as is, got %q, %v", got, err)
+	}
+}
+
+func TestQueryAndBatch(t *testing.T) {
+	ctx := context.Background()
+	d, _ := newTestDatastore(t)
+
+	b, err := d.Batch(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, k := range []string{"a", "b", "c"} {
+		if err := b.Put(ctx, ds.NewKey("/blocks/"+k), []byte("value "+k)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := b.Commit(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	qr, err := d.Query(ctx, dsq.Query{Prefix: "/blocks", Orders: []dsq.Order{dsq.OrderByKey{}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries, err := qr.Rest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 3 {
+		t.Fatalf("expected 3 entries, got %d", len(entries))
+	}
+	for i, k := range []string{"a", "b", "c"} {
+		if want := "value " + k; string(entries[i].Value) != want || entries[i].Size != len(want) {
+			t.Errorf("expected %q of size %d, got %q of size %d", want, len(want), entries[i].Value, entries[i].Size)
+		}
+	}
+}
+
+func TestWrapRejectsKeySize(t *testing.T) {
+	child := ds.NewMapDatastore()
+	for _, key := range [][]byte{testKey[:16], append(testKey, 'x')} {
+		if _, err := Wrap(child, key, testPrefix); err == nil {
+			t.Errorf("expected a %d byte key to be rejected", len(key))
+		}
+	}
+}
+
+func TestKeyRotation(t *testing.T) {
+	ctx := context.Background()
+	child := dssync.MutexWrap(ds.NewMapDatastore())
+	key := ds.NewKey("/blocks/a")
+	v1 := Key{ID: "v1", Key: testKey}
+	v2 := Key{ID: "v2", Key: []byte("fedcba9876543210fedcba9876543210")}
+
+	d, err := WrapKeys(child, []Key{v1}, "v1", testPrefix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Put(ctx, key, []byte("block data")); err != nil {
+		t.Fatal(err)
+	}
+
+	rotated, err := WrapKeys(child, []Key{v1, v2}, "v2", testPrefix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := rotated.Get(ctx, key)
+	if err != nil || string(got) != "block data" {
+		t.Fatalf("expected data written under v1 to be read after rotating to v2, got %q, %v", got, err)
+	}
+
+	ok, err := rotated.Rotate(ctx, key)
+	if err != nil || !ok {
+		t.Fatalf("expected the value to be re-encrypted, got %t, %v", ok, err)
+	}
+	if ok, err := rotated.Rotate(ctx, key); err != nil || ok {
+		t.Fatalf("expected a value under the active key to be left alone, got %t, %v", ok, err)
+	}
+
+	// v1 can be retired once everything is rotated
+	v2only, err := WrapKeys(child, []Key{v2}, "v2", testPrefix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err = v2only.Get(ctx, key)
+	if err != nil || string(got) != "block data" {
+		t.Fatalf("expected the rotated value to be read with v2 alone, got %q, %v", got, err)
+	}
+	if _, err := d.Get(ctx, key); !errors.Is(err, ErrDecrypt) {
+		t.Fatalf("expected v1 alone to fail on the rotated value, got %v", err)
+	}
+}
+
+func TestUnkeyedValues(t *testing.T) {
+	ctx := context.Background()
+	d, child := newTestDatastore(t)
+	key := ds.NewKey("/blocks/a")
+
+	// values sealed before key IDs were recorded: prefix, nonce, ciphertext
+	block, _ := aes.NewCipher(testKey)
+	aead, _ := cipher.NewGCM(block)
+	nonce := make([]byte, aead.NonceSize())
+	stored := aead.Seal(append([]byte(testPrefix), nonce...), nonce, []byte("block data"), key.Bytes())
+	if err := child.Put(ctx, key, stored); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := d.Get(ctx, key)
+	if err != nil || string(got) != "block data" {
+		t.Fatalf("expected the unkeyed value to be read with the key of ID \"\", got %q, %v", got, err)
+	}
+}
+
+func TestWrapKeysRejectsConfig(t *testing.T) {
+	child := ds.NewMapDatastore()
+	v1 := Key{ID: "v1", Key: testKey}
+	for name, keys := range map[string][]Key{
+		"no active key": {{ID: "v0", Key: testKey}},
+		"duplicate id":  {v1, v1},
+	} {
+		if _, err := WrapKeys(child, keys, "v1", testPrefix); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestWrapConfigDecodesKeys(t *testing.T) {
+	ctx := context.Background()
+	d, child := newTestDatastore(t)
+	key := ds.NewKey("/blocks/a")
+	if err := d.Put(ctx, key, []byte("block data")); err != nil {
+		t.Fatal(err)
+	}
+
+	// the same key, raw and hex encoded
+	for _, cfg := range []config.ConfigPinningService{
+		{BlockEncryptionKey: string(testKey), EncryptedBlockPrefix: testPrefix},
+		{BlockEncryptionKey: config.BlockKeyHexPrefix + hex.EncodeToString(testKey), EncryptedBlockPrefix: testPrefix},
+		{BlockEncryptionKeys: []config.BlockEncryptionKeyEntry{{Key: config.BlockKeyHexPrefix + hex.EncodeToString(testKey), Active: true}}, EncryptedBlockPrefix: testPrefix},
+	} {
+		wrapped, err := WrapConfig(child, cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, err := wrapped.Get(ctx, key); err != nil || string(got) != "block data" {
+			t.Fatalf("expected the value to be read, got %q, %v", got, err)
+		}
+	}
+
+	if _, err := WrapConfig(child, config.ConfigPinningService{BlockEncryptionKey: "hex:zz", EncryptedBlockPrefix: testPrefix}); err == nil {
+		t.Fatal("expected a malformed key to be rejected")
+	}
+}
+
+func TestRotateAllResumes(t *testing.T) {
+	child := dssync.MutexWrap(ds.NewMapDatastore())
+	v1 := Key{ID: "v1", Key: testKey}
+	v2 := Key{ID: "v2", Key: []byte("fedcba9876543210fedcba9876543210")}
+	d, err := WrapKeys(child, []Key{v1}, "v1", testPrefix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const count = 10
+	for i := 0; i < count; i++ {
+		if err := d.Put(context.Background(), ds.NewKey(fmt.Sprintf("/block%d", i)), []byte{byte(i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cp := Checkpoint{Store: dssync.MutexWrap(ds.NewMapDatastore()), Key: ds.NewKey("/local/rotate")}
+	noFailure := func(key ds.Key, err error) error {
+		t.Fatalf("unexpected failure for %s: %s", key, err)
+		return nil
+	}
+	sealedWith := func(id string) int {
+		t.Helper()
+		var n int
+		for i := 0; i < count; i++ {
+			stored, err := child.Get(context.Background(), ds.NewKey(fmt.Sprintf("/block%d", i)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if sealed, _, ok := d.sealedWith(stored); ok && sealed == id {
+				n++
+			}
+		}
+		return n
+	}
+
+	// the first run is cancelled after 4 values
+	rotated, err := WrapKeys(child, []Key{v1, v2}, "v2", testPrefix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	var reports []RotateProgress
+	err = rotated.RotateAll(ctx, cp, 2, func(p RotateProgress) error {
+		reports = append(reports, p)
+		if p.Done == 4 {
+			cancel()
+		}
+		return nil
+	}, noFailure)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected the rotation to be cancelled, got %v", err)
+	}
+	if len(reports) != 2 || reports[1].Done != 4 || reports[1].Rotated != 4 || reports[1].Remaining != count-4 {
+		t.Fatalf("expected progress every 2 values up to 4, got %+v", reports)
+	}
+	if n := sealedWith("v2"); n != 4 {
+		t.Fatalf("expected 4 values rotated before cancelling, got %d", n)
+	}
+
+	// a new datastore, as after a restart, resumes from the checkpoint
+	resumed, err := WrapKeys(child, []Key{v1, v2}, "v2", testPrefix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reports = nil
+	if err := resumed.RotateAll(context.Background(), cp, 2, func(p RotateProgress) error {
+		reports = append(reports, p)
+		return nil
+	}, noFailure); err != nil {
+		t.Fatal(err)
+	}
+	last := reports[len(reports)-1]
+	if last.Done != count || last.Rotated != count || last.Remaining != 0 {
+		t.Fatalf("expected the rotation to complete, got %+v", last)
+	}
+	if first := reports[0]; first.Done != 6 || first.Remaining != count-6 {
+		t.Fatalf("expected the rotation to resume after the first 4 values, got %+v", first)
+	}
+	if n := sealedWith("v2"); n != count {
+		t.Fatalf("expected every value to be rotated, got %d", n)
+	}
+	if has, err := cp.Store.Has(context.Background(), cp.Key); err != nil || has {
+		t.Fatalf("expected the checkpoint to be removed once done, got %t, %v", has, err)
+	}
+
+	// a rotation to another key starts over
+	v3 := Key{ID: "v3", Key: []byte("abcdef0123456789abcdef0123456789")}
+	next, err := WrapKeys(child, []Key{v2, v3}, "v3", testPrefix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := saveRotateState(context.Background(), cp, rotateState{Active: "v2", Last: "/block9", Done: count}); err != nil {
+		t.Fatal(err)
+	}
+	reports = nil
+	if err := next.RotateAll(context.Background(), cp, count, func(p RotateProgress) error {
+		reports = append(reports, p)
+		return nil
+	}, noFailure); err != nil {
+		t.Fatal(err)
+	}
+	if last := reports[len(reports)-1]; last.Done != count || last.Rotated != count {
+		t.Fatalf("expected a rotation to another key to start over, got %+v", last)
+	}
+}
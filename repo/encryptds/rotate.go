@@ -0,0 +1,169 @@
+package encryptds
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	ds "github.com/ipfs/go-datastore"
+	dsq "github.com/ipfs/go-datastore/query"
+)
+
+// Checkpoint is where RotateAll records how far it went, so an interrupted
+// rotation resumes where it stopped.
+type Checkpoint struct {
+	Store ds.Datastore
+	Key   ds.Key
+}
+
+// RotateProgress is the progress of RotateAll. The counts include the
+// values handled by the runs resumed from.
+type RotateProgress struct {
+	Done      int
+	Rotated   int
+	Failed    int
+	Remaining int
+	// ETA is the time left, estimated from the pace of the current run.
+	ETA time.Duration
+}
+
+// rotateState is the content of a Checkpoint.
+type rotateState struct {
+	// Active is the ID of the key the values were rotated to, a rotation to
+	// another key starting over.
+	Active  string
+	Last    string
+	Done    int
+	Rotated int
+	Failed  int
+}
+
+// RotateAll re-encrypts every value with the active key, as Rotate does,
+// going through the keys in order so it can resume from cp. The keys are
+// listed by the wrapped datastore, which sorts them in memory when it can't
+// list them in order, while values are read one at a time. Values that
+// can't be re-encrypted are passed to failed and skipped.
+//
+// Progress is recorded in cp and passed to report every n values, and once
+// done, when the checkpoint is removed. When ctx is cancelled, the progress
+// is recorded before returning.
+func (d *Datastore) RotateAll(ctx context.Context, cp Checkpoint, n int, report func(RotateProgress) error, failed func(ds.Key, error) error) error {
+	state, err := loadRotateState(ctx, cp)
+	if err != nil {
+		return err
+	}
+	if state.Active != d.active {
+		state = rotateState{Active: d.active}
+	}
+
+	var filters []dsq.Filter
+	if state.Last != "" {
+		filters = []dsq.Filter{dsq.FilterKeyCompare{Op: dsq.GreaterThan, Key: state.Last}}
+	}
+	remaining, err := d.count(ctx, filters)
+	if err != nil {
+		return err
+	}
+	qr, err := d.child.Query(ctx, dsq.Query{KeysOnly: true, Filters: filters, Orders: []dsq.Order{dsq.OrderByKey{}}})
+	if err != nil {
+		return err
+	}
+	defer qr.Close()
+
+	start := time.Now()
+	var checked int
+	progress := func() RotateProgress {
+		p := RotateProgress{Done: state.Done, Rotated: state.Rotated, Failed: state.Failed, Remaining: remaining}
+		if checked > 0 {
+			p.ETA = time.Since(start) / time.Duration(checked) * time.Duration(remaining)
+		}
+		return p
+	}
+	// the checkpoint is saved even once ctx is cancelled
+	save := func() error {
+		return saveRotateState(context.WithoutCancel(ctx), cp, state)
+	}
+
+	for r := range qr.Next() {
+		if r.Error != nil {
+			return errors.Join(r.Error, save())
+		}
+		if ctx.Err() != nil {
+			return errors.Join(ctx.Err(), save())
+		}
+		key := ds.RawKey(r.Key)
+		rotated, err := d.Rotate(ctx, key)
+		switch {
+		case err != nil && ctx.Err() != nil:
+			// the value is left for the next run
+			return errors.Join(ctx.Err(), save())
+		case err != nil:
+			state.Failed++
+			if err := failed(key, err); err != nil {
+				return errors.Join(err, save())
+			}
+		case rotated:
+			state.Rotated++
+		}
+		state.Last = r.Key
+		state.Done++
+		checked++
+		remaining = max(remaining-1, 0)
+
+		if checked%n == 0 {
+			if err := save(); err != nil {
+				return err
+			}
+			if err := report(progress()); err != nil {
+				return err
+			}
+		}
+	}
+	if ctx.Err() != nil {
+		return errors.Join(ctx.Err(), save())
+	}
+	if err := cp.Store.Delete(ctx, cp.Key); err != nil {
+		return err
+	}
+	return report(progress())
+}
+
+// count returns the number of keys of the wrapped datastore passing filters.
+func (d *Datastore) count(ctx context.Context, filters []dsq.Filter) (int, error) {
+	qr, err := d.child.Query(ctx, dsq.Query{KeysOnly: true, Filters: filters})
+	if err != nil {
+		return 0, err
+	}
+	defer qr.Close()
+	var n int
+	for r := range qr.Next() {
+		if r.Error != nil {
+			return 0, r.Error
+		}
+		n++
+	}
+	return n, nil
+}
+
+func loadRotateState(ctx context.Context, cp Checkpoint) (rotateState, error) {
+	var state rotateState
+	b, err := cp.Store.Get(ctx, cp.Key)
+	if errors.Is(err, ds.ErrNotFound) {
+		return state, nil
+	} else if err != nil {
+		return state, err
+	}
+	if err := json.Unmarshal(b, &state); err != nil {
+		return state, err
+	}
+	return state, nil
+}
+
+func saveRotateState(ctx context.Context, cp Checkpoint, state rotateState) error {
+	b, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	return cp.Store.Put(ctx, cp.Key, b)
+}
@@ -7,6 +7,7 @@ import (
 	"sort"
 
 	"github.com/ipfs/kubo/repo"
+	"github.com/ipfs/kubo/repo/metricsds"
 
 	ds "github.com/ipfs/go-datastore"
 	"github.com/ipfs/go-datastore/mount"
@@ -93,8 +94,9 @@ type mountDatastoreConfig struct {
 }
 
 type premount struct {
-	ds     DatastoreConfig
-	prefix ds.Key
+	ds      DatastoreConfig
+	prefix  ds.Key
+	backend string
 }
 
 // MountDatastoreConfig returns a mount DatastoreConfig from a spec.
@@ -121,8 +123,9 @@ func MountDatastoreConfig(params map[string]interface{}) (DatastoreConfig, error
 		}
 
 		res.mounts = append(res.mounts, premount{
-			ds:     child,
-			prefix: ds.NewKey(prefix.(string)),
+			ds:      child,
+			prefix:  ds.NewKey(prefix.(string)),
+			backend: datastoreBackend(child, cfg),
 		})
 	}
 	sort.Slice(res.mounts,
@@ -155,12 +158,22 @@ func (c *mountDatastoreConfig) Create(path string) (repo.Datastore, error) {
 		if err != nil {
 			return nil, err
 		}
-		mounts[i].Datastore = ds
+		mounts[i].Datastore = metricsds.Wrap(ds, m.backend)
 		mounts[i].Prefix = m.prefix
 	}
 	return mount.New(mounts), nil
 }
 
+// datastoreBackend returns the type of the datastore storing the data of
+// dsc, the datastores it may be wrapped in aside, to label its metrics.
+func datastoreBackend(dsc DatastoreConfig, params map[string]interface{}) string {
+	if typ, ok := dsc.DiskSpec()["type"].(string); ok {
+		return typ
+	}
+	typ, _ := params["type"].(string)
+	return typ
+}
+
 type memDatastoreConfig struct {
 	cfg map[string]interface{}
 }
@@ -15,6 +15,8 @@ import (
 	keystore "github.com/ipfs/boxo/keystore"
 	repo "github.com/ipfs/kubo/repo"
 	"github.com/ipfs/kubo/repo/common"
+	"github.com/ipfs/kubo/repo/metricsds"
+	"github.com/ipfs/kubo/repo/readonlyds"
 	dir "github.com/ipfs/kubo/thirdparty/dir"
 	rcmgr "github.com/libp2p/go-libp2p/p2p/host/resource-manager"
 
@@ -72,6 +74,15 @@ const (
 
 const specFn = "datastore_spec"
 
+// readOnlyPrefixes are the namespaces of the stored content, whose writes are
+// rejected when Datastore.ReadOnly is set: blocks, filestore references and
+// pins. The state the node keeps elsewhere in the datastore stays writable.
+var readOnlyPrefixes = []ds.Key{
+	ds.NewKey("/blocks"),
+	ds.NewKey("/filestore"),
+	ds.NewKey("/pins"),
+}
+
 var (
 
 	// packageLock must be held to while performing any operation that modifies an
@@ -497,6 +508,13 @@ func (r *FSRepo) openDatastore() error {
 	if err != nil {
 		return err
 	}
+	// mounts record the latency of each of their datastores
+	if _, ok := dsc.(*mountDatastoreConfig); !ok {
+		d = metricsds.Wrap(d, datastoreBackend(dsc, r.config.Datastore.Spec))
+	}
+	if r.config.Datastore.ReadOnly {
+		d = readonlyds.Wrap(d, readOnlyPrefixes...)
+	}
 	r.ds = d
 
 	// Wrap it with metrics gathering
@@ -638,7 +656,15 @@ func (r *FSRepo) SetConfig(updated *config.Config) error {
 	if err := serialize.ReadConfigFile(r.configFilePath, &mapconf); err != nil {
 		return err
 	}
-	m, err := config.ToMap(updated)
+	// keep the environment references of the file in place of the secrets
+	// they were expanded to
+	raw, err := config.FromMap(mapconf)
+	if err != nil {
+		return err
+	}
+	unexpanded := *updated
+	unexpanded.ConfigPinningService = updated.ConfigPinningService.WithEnvReferences(raw.ConfigPinningService)
+	m, err := config.ToMap(&unexpanded)
 	if err != nil {
 		return err
 	}
@@ -707,6 +733,9 @@ func (r *FSRepo) SetConfigKey(key string, value interface{}) error {
 	if err != nil {
 		return err
 	}
+	if conf.ConfigPinningService, err = conf.ConfigPinningService.ExpandEnv(); err != nil {
+		return err
+	}
 	r.config = conf
 
 	if err := serialize.WriteConfigFile(r.configFilePath, mapconf); err != nil {
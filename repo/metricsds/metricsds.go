@@ -0,0 +1,189 @@
+// Package metricsds provides a datastore wrapper recording the latency and
+// errors of the operations of the wrapped datastore in Prometheus.
+package metricsds
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	ds "github.com/ipfs/go-datastore"
+	dsq "github.com/ipfs/go-datastore/query"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Operations recorded, used as the operation label of the metrics.
+const (
+	opGet            = "get"
+	opHas            = "has"
+	opGetSize        = "get_size"
+	opPut            = "put"
+	opDelete         = "delete"
+	opQuery          = "query"
+	opSync           = "sync"
+	opBatchCommit    = "batch_commit"
+	opCheck          = "check"
+	opScrub          = "scrub"
+	opCollectGarbage = "collect_garbage"
+)
+
+var (
+	operationDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "ipfs",
+		Subsystem: "datastore",
+		Name:      "operation_duration_seconds",
+		Help:      "Latency of the datastore operations by backend.",
+		Buckets:   []float64{.0001, .00025, .0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5},
+	}, []string{"operation", "backend"})
+
+	operationErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "ipfs",
+		Subsystem: "datastore",
+		Name:      "operation_errors_total",
+		Help:      "Failed datastore operations by backend. Keys not found are not counted.",
+	}, []string{"operation", "backend"})
+)
+
+// Datastore records the latency of each operation of the wrapped datastore,
+// and the operations that failed, labeled with its backend name.
+type Datastore struct {
+	child   ds.Batching
+	backend string
+}
+
+var (
+	_ ds.Batching            = (*Datastore)(nil)
+	_ ds.PersistentDatastore = (*Datastore)(nil)
+	_ ds.CheckedDatastore    = (*Datastore)(nil)
+	_ ds.ScrubbedDatastore   = (*Datastore)(nil)
+	_ ds.GCDatastore         = (*Datastore)(nil)
+)
+
+// Wrap returns child recording its metrics under backend, such as "flatfs"
+// or "aiozfs".
+func Wrap(child ds.Batching, backend string) *Datastore {
+	return &Datastore{child: child, backend: backend}
+}
+
+// observe records an operation started at start that returned err.
+func (d *Datastore) observe(op string, start time.Time, err error) {
+	operationDuration.WithLabelValues(op, d.backend).Observe(time.Since(start).Seconds())
+	if err != nil && !errors.Is(err, ds.ErrNotFound) {
+		operationErrors.WithLabelValues(op, d.backend).Inc()
+	}
+}
+
+func (d *Datastore) Get(ctx context.Context, key ds.Key) ([]byte, error) {
+	start := time.Now()
+	value, err := d.child.Get(ctx, key)
+	d.observe(opGet, start, err)
+	return value, err
+}
+
+func (d *Datastore) Has(ctx context.Context, key ds.Key) (bool, error) {
+	start := time.Now()
+	exists, err := d.child.Has(ctx, key)
+	d.observe(opHas, start, err)
+	return exists, err
+}
+
+func (d *Datastore) GetSize(ctx context.Context, key ds.Key) (int, error) {
+	start := time.Now()
+	size, err := d.child.GetSize(ctx, key)
+	d.observe(opGetSize, start, err)
+	return size, err
+}
+
+func (d *Datastore) Put(ctx context.Context, key ds.Key, value []byte) error {
+	start := time.Now()
+	err := d.child.Put(ctx, key, value)
+	d.observe(opPut, start, err)
+	return err
+}
+
+func (d *Datastore) Delete(ctx context.Context, key ds.Key) error {
+	start := time.Now()
+	err := d.child.Delete(ctx, key)
+	d.observe(opDelete, start, err)
+	return err
+}
+
+// Query records the time taken to start the query, the results being read
+// afterwards.
+func (d *Datastore) Query(ctx context.Context, q dsq.Query) (dsq.Results, error) {
+	start := time.Now()
+	res, err := d.child.Query(ctx, q)
+	d.observe(opQuery, start, err)
+	return res, err
+}
+
+func (d *Datastore) Sync(ctx context.Context, prefix ds.Key) error {
+	start := time.Now()
+	err := d.child.Sync(ctx, prefix)
+	d.observe(opSync, start, err)
+	return err
+}
+
+func (d *Datastore) Check(ctx context.Context) error {
+	c, ok := d.child.(ds.CheckedDatastore)
+	if !ok {
+		return nil
+	}
+	start := time.Now()
+	err := c.Check(ctx)
+	d.observe(opCheck, start, err)
+	return err
+}
+
+func (d *Datastore) Scrub(ctx context.Context) error {
+	s, ok := d.child.(ds.ScrubbedDatastore)
+	if !ok {
+		return nil
+	}
+	start := time.Now()
+	err := s.Scrub(ctx)
+	d.observe(opScrub, start, err)
+	return err
+}
+
+func (d *Datastore) CollectGarbage(ctx context.Context) error {
+	gc, ok := d.child.(ds.GCDatastore)
+	if !ok {
+		return nil
+	}
+	start := time.Now()
+	err := gc.CollectGarbage(ctx)
+	d.observe(opCollectGarbage, start, err)
+	return err
+}
+
+func (d *Datastore) DiskUsage(ctx context.Context) (uint64, error) {
+	return ds.DiskUsage(ctx, d.child)
+}
+
+func (d *Datastore) Close() error {
+	return d.child.Close()
+}
+
+func (d *Datastore) Batch(ctx context.Context) (ds.Batch, error) {
+	b, err := d.child.Batch(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &batch{Batch: b, d: d}, nil
+}
+
+// batch records the latency of its commit, the writes being buffered until
+// then.
+type batch struct {
+	ds.Batch
+	d *Datastore
+}
+
+func (b *batch) Commit(ctx context.Context) error {
+	start := time.Now()
+	err := b.Batch.Commit(ctx)
+	b.d.observe(opBatchCommit, start, err)
+	return err
+}
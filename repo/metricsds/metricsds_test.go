@@ -0,0 +1,110 @@
+package metricsds
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	ds "github.com/ipfs/go-datastore"
+	dsq "github.com/ipfs/go-datastore/query"
+	dssync "github.com/ipfs/go-datastore/sync"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// failingDatastore fails its writes.
+type failingDatastore struct {
+	ds.Batching
+}
+
+func (failingDatastore) Put(context.Context, ds.Key, []byte) error {
+	return errors.New("disk full")
+}
+
+func samples(t *testing.T, op, backend string) uint64 {
+	t.Helper()
+	var m dto.Metric
+	if err := operationDuration.WithLabelValues(op, backend).(prometheus.Metric).Write(&m); err != nil {
+		t.Fatal(err)
+	}
+	return m.GetHistogram().GetSampleCount()
+}
+
+func errorCount(t *testing.T, op, backend string) float64 {
+	t.Helper()
+	var m dto.Metric
+	if err := operationErrors.WithLabelValues(op, backend).Write(&m); err != nil {
+		t.Fatal(err)
+	}
+	return m.GetCounter().GetValue()
+}
+
+func TestMetrics(t *testing.T) {
+	ctx := context.Background()
+	d := Wrap(dssync.MutexWrap(ds.NewMapDatastore()), "test")
+	key := ds.NewKey("/a")
+
+	if err := d.Put(ctx, key, []byte("value")); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := d.Get(ctx, key); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := d.Get(ctx, ds.NewKey("/missing")); !errors.Is(err, ds.ErrNotFound) {
+		t.Fatalf("expected not found, got %v", err)
+	}
+	if has, err := d.Has(ctx, key); err != nil || !has {
+		t.Fatalf("expected the key to be found, got %v, %v", has, err)
+	}
+	res, err := d.Query(ctx, dsq.Query{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Close()
+	b, err := d.Batch(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Delete(ctx, key); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Commit(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Delete(ctx, key); err != nil {
+		t.Fatal(err)
+	}
+
+	for op, want := range map[string]uint64{
+		opPut:         1,
+		opGet:         3,
+		opHas:         1,
+		opQuery:       1,
+		opBatchCommit: 1,
+		opDelete:      1,
+	} {
+		if got := samples(t, op, "test"); got != want {
+			t.Errorf("%s: expected %d samples, got %d", op, want, got)
+		}
+	}
+	if n := errorCount(t, opGet, "test"); n != 0 {
+		t.Fatalf("expected keys not found not to count as errors, got %v", n)
+	}
+}
+
+func TestMetricsErrors(t *testing.T) {
+	ctx := context.Background()
+	d := Wrap(failingDatastore{dssync.MutexWrap(ds.NewMapDatastore())}, "failing")
+
+	if err := d.Put(ctx, ds.NewKey("/a"), []byte("value")); err == nil {
+		t.Fatal("expected the write to fail")
+	}
+	if n := samples(t, opPut, "failing"); n != 1 {
+		t.Fatalf("expected the failed write to be timed, got %d samples", n)
+	}
+	if n := errorCount(t, opPut, "failing"); n != 1 {
+		t.Fatalf("expected 1 error, got %v", n)
+	}
+}
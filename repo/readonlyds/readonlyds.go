@@ -0,0 +1,123 @@
+// Package readonlyds provides a datastore wrapper rejecting the writes under
+// some namespaces, for nodes that must never change the content they store.
+package readonlyds
+
+import (
+	"context"
+	"errors"
+
+	ds "github.com/ipfs/go-datastore"
+	dsq "github.com/ipfs/go-datastore/query"
+)
+
+// ErrReadOnly is returned by the writes to a read-only namespace.
+var ErrReadOnly = errors.New("datastore is read-only")
+
+// Datastore serves the reads of the wrapped datastore and fails its writes
+// under the read-only namespaces with ErrReadOnly. Datastore maintenance
+// rewriting the stored data, such as scrubbing or garbage collection, is not
+// exposed.
+type Datastore struct {
+	child    ds.Batching
+	prefixes []ds.Key
+}
+
+var (
+	_ ds.Batching            = (*Datastore)(nil)
+	_ ds.PersistentDatastore = (*Datastore)(nil)
+	_ ds.CheckedDatastore    = (*Datastore)(nil)
+)
+
+// Wrap returns child with its writes under any of prefixes rejected. The
+// writes to other keys go through, so that the state a node keeps next to
+// its content, such as its MFS root or IPNS records, can still be updated.
+func Wrap(child ds.Batching, prefixes ...ds.Key) *Datastore {
+	return &Datastore{child: child, prefixes: prefixes}
+}
+
+func (d *Datastore) readOnly(key ds.Key) bool {
+	for _, prefix := range d.prefixes {
+		if key.Equal(prefix) || key.IsDescendantOf(prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func (d *Datastore) Get(ctx context.Context, key ds.Key) ([]byte, error) {
+	return d.child.Get(ctx, key)
+}
+
+func (d *Datastore) Has(ctx context.Context, key ds.Key) (bool, error) {
+	return d.child.Has(ctx, key)
+}
+
+func (d *Datastore) GetSize(ctx context.Context, key ds.Key) (int, error) {
+	return d.child.GetSize(ctx, key)
+}
+
+func (d *Datastore) Query(ctx context.Context, q dsq.Query) (dsq.Results, error) {
+	return d.child.Query(ctx, q)
+}
+
+func (d *Datastore) Put(ctx context.Context, key ds.Key, value []byte) error {
+	if d.readOnly(key) {
+		return ErrReadOnly
+	}
+	return d.child.Put(ctx, key, value)
+}
+
+func (d *Datastore) Delete(ctx context.Context, key ds.Key) error {
+	if d.readOnly(key) {
+		return ErrReadOnly
+	}
+	return d.child.Delete(ctx, key)
+}
+
+func (d *Datastore) Sync(ctx context.Context, prefix ds.Key) error {
+	return d.child.Sync(ctx, prefix)
+}
+
+func (d *Datastore) Check(ctx context.Context) error {
+	if c, ok := d.child.(ds.CheckedDatastore); ok {
+		return c.Check(ctx)
+	}
+	return nil
+}
+
+func (d *Datastore) DiskUsage(ctx context.Context) (uint64, error) {
+	return ds.DiskUsage(ctx, d.child)
+}
+
+func (d *Datastore) Close() error {
+	return d.child.Close()
+}
+
+func (d *Datastore) Batch(ctx context.Context) (ds.Batch, error) {
+	b, err := d.child.Batch(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &batch{b, d}, nil
+}
+
+// batch rejects the writes batched under the read-only namespaces, and
+// forwards the others to the batch of the wrapped datastore.
+type batch struct {
+	ds.Batch
+	d *Datastore
+}
+
+func (b *batch) Put(ctx context.Context, key ds.Key, value []byte) error {
+	if b.d.readOnly(key) {
+		return ErrReadOnly
+	}
+	return b.Batch.Put(ctx, key, value)
+}
+
+func (b *batch) Delete(ctx context.Context, key ds.Key) error {
+	if b.d.readOnly(key) {
+		return ErrReadOnly
+	}
+	return b.Batch.Delete(ctx, key)
+}
@@ -0,0 +1,109 @@
+package readonlyds
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+
+	ds "github.com/ipfs/go-datastore"
+	dsq "github.com/ipfs/go-datastore/query"
+	dssync "github.com/ipfs/go-datastore/sync"
+)
+
+func TestReadOnly(t *testing.T) {
+	ctx := context.Background()
+	child := dssync.MutexWrap(ds.NewMapDatastore())
+	key := ds.NewKey("/a")
+	if err := child.Put(ctx, key, []byte("value")); err != nil {
+		t.Fatal(err)
+	}
+	d := Wrap(child, ds.NewKey("/a"), ds.NewKey("/b"))
+
+	if v, err := d.Get(ctx, key); err != nil || !bytes.Equal(v, []byte("value")) {
+		t.Fatalf("expected the stored value, got %q, %v", v, err)
+	}
+	if has, err := d.Has(ctx, key); err != nil || !has {
+		t.Fatalf("expected the key to be found, got %v, %v", has, err)
+	}
+	if size, err := d.GetSize(ctx, key); err != nil || size != len("value") {
+		t.Fatalf("expected size %d, got %d, %v", len("value"), size, err)
+	}
+	res, err := d.Query(ctx, dsq.Query{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries, err := res.Rest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Key != key.String() {
+		t.Fatalf("expected the stored entry, got %v", entries)
+	}
+
+	if err := d.Put(ctx, ds.NewKey("/b"), []byte("value")); !errors.Is(err, ErrReadOnly) {
+		t.Fatalf("expected Put to fail with ErrReadOnly, got %v", err)
+	}
+	if err := d.Delete(ctx, key); !errors.Is(err, ErrReadOnly) {
+		t.Fatalf("expected Delete to fail with ErrReadOnly, got %v", err)
+	}
+	b, err := d.Batch(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Put(ctx, ds.NewKey("/b"), []byte("value")); !errors.Is(err, ErrReadOnly) {
+		t.Fatalf("expected a batched Put to fail with ErrReadOnly, got %v", err)
+	}
+	if err := b.Delete(ctx, key); !errors.Is(err, ErrReadOnly) {
+		t.Fatalf("expected a batched Delete to fail with ErrReadOnly, got %v", err)
+	}
+	if err := b.Commit(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if has, err := child.Has(ctx, ds.NewKey("/b")); err != nil || has {
+		t.Fatalf("expected nothing written, got %v, %v", has, err)
+	}
+	if has, err := child.Has(ctx, key); err != nil || !has {
+		t.Fatalf("expected nothing deleted, got %v, %v", has, err)
+	}
+}
+
+func TestReadOnlyScope(t *testing.T) {
+	ctx := context.Background()
+	child := dssync.MutexWrap(ds.NewMapDatastore())
+	d := Wrap(child, ds.NewKey("/blocks"))
+
+	if err := d.Put(ctx, ds.NewKey("/blocks/a"), []byte("value")); !errors.Is(err, ErrReadOnly) {
+		t.Fatalf("expected a Put under the read-only namespace to fail with ErrReadOnly, got %v", err)
+	}
+	if err := d.Put(ctx, ds.NewKey("/local/filesroot"), []byte("value")); err != nil {
+		t.Fatalf("expected a Put outside the read-only namespace to succeed, got %v", err)
+	}
+	if err := d.Put(ctx, ds.NewKey("/blocksx"), []byte("value")); err != nil {
+		t.Fatalf("expected a Put to a sibling of the read-only namespace to succeed, got %v", err)
+	}
+	if err := d.Delete(ctx, ds.NewKey("/blocksx")); err != nil {
+		t.Fatalf("expected a Delete outside the read-only namespace to succeed, got %v", err)
+	}
+
+	b, err := d.Batch(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Put(ctx, ds.NewKey("/blocks/b"), []byte("value")); !errors.Is(err, ErrReadOnly) {
+		t.Fatalf("expected a batched Put under the read-only namespace to fail with ErrReadOnly, got %v", err)
+	}
+	if err := b.Put(ctx, ds.NewKey("/provq/a"), []byte("value")); err != nil {
+		t.Fatalf("expected a batched Put outside the read-only namespace to succeed, got %v", err)
+	}
+	if err := b.Commit(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	for key, want := range map[string]bool{"/blocks/a": false, "/blocks/b": false, "/blocksx": false, "/local/filesroot": true, "/provq/a": true} {
+		if has, err := child.Has(ctx, ds.NewKey(key)); err != nil || has != want {
+			t.Fatalf("expected %s stored to be %v, got %v, %v", key, want, has, err)
+		}
+	}
+}
@@ -0,0 +1,288 @@
+// Package redisds provides a datastore wrapper caching the small values read
+// from the wrapped datastore in Redis.
+package redisds
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	ds "github.com/ipfs/go-datastore"
+	dsq "github.com/ipfs/go-datastore/query"
+	logging "github.com/ipfs/go-log"
+	config "github.com/ipfs/kubo/config"
+	"github.com/redis/go-redis/v9"
+)
+
+var log = logging.Logger("redisds")
+
+const (
+	// DefaultTTL is how long a value stays cached after it was read,
+	// unless configured otherwise.
+	DefaultTTL = 10 * time.Minute
+
+	// DefaultMaxSize is the size in bytes of the largest value cached,
+	// unless configured otherwise.
+	DefaultMaxSize = 64 << 10
+)
+
+// redisTimeout bounds each Redis round trip, so a slow Redis server costs
+// little more than a cache miss.
+const redisTimeout = 100 * time.Millisecond
+
+// keyPrefix is prepended to the scope and the datastore keys to name the
+// Redis keys.
+const keyPrefix = "kubo:block:"
+
+// Datastore caches the values read from the wrapped datastore in Redis, up
+// to a size, for a TTL. Values are removed from the cache when written or
+// deleted through the Datastore. When Redis can't be reached, values are
+// read from the wrapped datastore.
+type Datastore struct {
+	child   ds.Batching
+	client  *redis.Client
+	prefix  string
+	ttl     time.Duration
+	maxSize int
+
+	mu    sync.Mutex
+	reads map[ds.Key]*pendingRead
+}
+
+// pendingRead tracks the Gets of a key reading the wrapped datastore, and
+// whether the key was written or deleted meanwhile, so that a value read
+// before it changed isn't cached.
+type pendingRead struct {
+	n     int
+	stale bool
+}
+
+var _ ds.Batching = (*Datastore)(nil)
+
+// Wrap returns child cached in the Redis server of client, under keys
+// named after scope. Values of up to maxSize bytes are cached for ttl. The
+// client is not closed with the Datastore.
+func Wrap(child ds.Batching, client *redis.Client, scope string, ttl time.Duration, maxSize int) *Datastore {
+	return &Datastore{
+		child:   child,
+		client:  client,
+		prefix:  keyPrefix + scope + ":",
+		ttl:     ttl,
+		maxSize: maxSize,
+		reads:   make(map[ds.Key]*pendingRead),
+	}
+}
+
+// WrapConfig wraps child with the block cache settings of cfg, for the
+// repo of the peer peerID.
+func WrapConfig(child ds.Batching, client *redis.Client, peerID string, cfg config.ConfigPinningService) *Datastore {
+	maxSize := cfg.BlockCacheMaxSize
+	if maxSize == 0 {
+		maxSize = DefaultMaxSize
+	}
+	return Wrap(child, client, Scope(peerID, cfg), cfg.BlockCacheTTL.WithDefault(DefaultTTL), maxSize)
+}
+
+// Scope names the cache entries of the repo of the peer peerID. Nodes
+// sharing a Redis server never read each other's entries, which hold the
+// values as stored, encrypted at rest or not. The ID of the active key is
+// part of the scope, so entries of another key or of plain values aren't
+// read either.
+func Scope(peerID string, cfg config.ConfigPinningService) string {
+	if !cfg.EncryptBlocksAtRest {
+		return peerID + "/plain"
+	}
+	var active string
+	for _, k := range cfg.BlockEncryptionKeys {
+		if k.Active {
+			active = k.ID
+		}
+	}
+	return peerID + "/enc/" + active
+}
+
+func (d *Datastore) redisKey(key ds.Key) string {
+	return d.prefix + key.String()
+}
+
+// startRead registers a Get reading key from the wrapped datastore.
+func (d *Datastore) startRead(key ds.Key) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	r, ok := d.reads[key]
+	if !ok {
+		r = &pendingRead{}
+		d.reads[key] = r
+	}
+	r.n++
+}
+
+// endRead unregisters a Get registered with startRead.
+func (d *Datastore) endRead(key ds.Key) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if r := d.reads[key]; r != nil {
+		if r.n--; r.n == 0 {
+			delete(d.reads, key)
+		}
+	}
+}
+
+// stale reports whether key was written or deleted since the Gets reading
+// it started.
+func (d *Datastore) stale(key ds.Key) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	r := d.reads[key]
+	return r != nil && r.stale
+}
+
+// Get reads the value of key from Redis, or from the wrapped datastore on a
+// miss, caching it if it is small enough and wasn't written or deleted
+// meanwhile.
+func (d *Datastore) Get(ctx context.Context, key ds.Key) ([]byte, error) {
+	rctx, cancel := context.WithTimeout(ctx, redisTimeout)
+	value, err := d.client.Get(rctx, d.redisKey(key)).Bytes()
+	cancel()
+	if err == nil {
+		return value, nil
+	}
+	if !errors.Is(err, redis.Nil) {
+		log.Debugf("reading %s from the cache: %s", key, err)
+	}
+
+	d.startRead(key)
+	defer d.endRead(key)
+	value, err = d.child.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	if len(value) <= d.maxSize && !d.stale(key) {
+		rctx, cancel := context.WithTimeout(ctx, redisTimeout)
+		if err := d.client.Set(rctx, d.redisKey(key), value, d.ttl).Err(); err != nil {
+			log.Debugf("caching %s: %s", key, err)
+		}
+		cancel()
+		// a write or delete may have removed the entry right before it
+		// was set
+		if d.stale(key) {
+			d.invalidate(ctx, key)
+		}
+	}
+	return value, nil
+}
+
+// Has reports a cached key without asking the wrapped datastore.
+func (d *Datastore) Has(ctx context.Context, key ds.Key) (bool, error) {
+	rctx, cancel := context.WithTimeout(ctx, redisTimeout)
+	n, err := d.client.Exists(rctx, d.redisKey(key)).Result()
+	cancel()
+	if err == nil && n > 0 {
+		return true, nil
+	}
+	return d.child.Has(ctx, key)
+}
+
+func (d *Datastore) GetSize(ctx context.Context, key ds.Key) (int, error) {
+	return d.child.GetSize(ctx, key)
+}
+
+func (d *Datastore) Put(ctx context.Context, key ds.Key, value []byte) error {
+	if err := d.child.Put(ctx, key, value); err != nil {
+		return err
+	}
+	d.invalidate(ctx, key)
+	return nil
+}
+
+func (d *Datastore) Delete(ctx context.Context, key ds.Key) error {
+	if err := d.child.Delete(ctx, key); err != nil {
+		return err
+	}
+	d.invalidate(ctx, key)
+	return nil
+}
+
+// invalidate removes keys from the cache, and keeps the Gets reading them
+// from caching what they read. A failure is only logged: the cached values
+// expire with their TTL.
+func (d *Datastore) invalidate(ctx context.Context, keys ...ds.Key) {
+	if len(keys) == 0 {
+		return
+	}
+	d.mu.Lock()
+	for _, key := range keys {
+		if r := d.reads[key]; r != nil {
+			r.stale = true
+		}
+	}
+	d.mu.Unlock()
+
+	rkeys := make([]string, len(keys))
+	for i, key := range keys {
+		rkeys[i] = d.redisKey(key)
+	}
+	rctx, cancel := context.WithTimeout(ctx, redisTimeout)
+	defer cancel()
+	if err := d.client.Del(rctx, rkeys...).Err(); err != nil {
+		log.Warnf("removing %d values from the cache, they may be served until they expire: %s", len(keys), err)
+	}
+}
+
+func (d *Datastore) Query(ctx context.Context, q dsq.Query) (dsq.Results, error) {
+	return d.child.Query(ctx, q)
+}
+
+func (d *Datastore) Sync(ctx context.Context, prefix ds.Key) error {
+	return d.child.Sync(ctx, prefix)
+}
+
+func (d *Datastore) DiskUsage(ctx context.Context) (uint64, error) {
+	return ds.DiskUsage(ctx, d.child)
+}
+
+func (d *Datastore) Close() error {
+	return d.child.Close()
+}
+
+func (d *Datastore) Batch(ctx context.Context) (ds.Batch, error) {
+	b, err := d.child.Batch(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &batch{Batch: b, d: d}, nil
+}
+
+// batch removes the keys it writes or deletes from the cache once
+// committed.
+type batch struct {
+	ds.Batch
+	d    *Datastore
+	keys []ds.Key
+}
+
+func (b *batch) Put(ctx context.Context, key ds.Key, value []byte) error {
+	if err := b.Batch.Put(ctx, key, value); err != nil {
+		return err
+	}
+	b.keys = append(b.keys, key)
+	return nil
+}
+
+func (b *batch) Delete(ctx context.Context, key ds.Key) error {
+	if err := b.Batch.Delete(ctx, key); err != nil {
+		return err
+	}
+	b.keys = append(b.keys, key)
+	return nil
+}
+
+func (b *batch) Commit(ctx context.Context) error {
+	if err := b.Batch.Commit(ctx); err != nil {
+		return err
+	}
+	b.d.invalidate(ctx, b.keys...)
+	b.keys = nil
+	return nil
+}
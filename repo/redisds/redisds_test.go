@@ -0,0 +1,229 @@
+package redisds
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	ds "github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	config "github.com/ipfs/kubo/config"
+	"github.com/redis/go-redis/v9"
+)
+
+// countingDatastore counts the reads reaching the wrapped datastore.
+type countingDatastore struct {
+	ds.Batching
+	gets int
+}
+
+func (c *countingDatastore) Get(ctx context.Context, key ds.Key) ([]byte, error) {
+	c.gets++
+	return c.Batching.Get(ctx, key)
+}
+
+func newTestDatastore(t *testing.T, maxSize int) (*Datastore, *countingDatastore, *miniredis.Miniredis) {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { client.Close() })
+	child := &countingDatastore{Batching: dssync.MutexWrap(ds.NewMapDatastore())}
+	return Wrap(child, client, "peer/plain", time.Minute, maxSize), child, mr
+}
+
+func TestCacheHit(t *testing.T) {
+	ctx := context.Background()
+	d, child, mr := newTestDatastore(t, 1024)
+	key := ds.NewKey("/a")
+	value := []byte("block data")
+
+	if err := d.Put(ctx, key, value); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		got, err := d.Get(ctx, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, value) {
+			t.Fatalf("expected %q, got %q", value, got)
+		}
+	}
+	if child.gets != 1 {
+		t.Fatalf("expected only the first read to reach the datastore, got %d reads", child.gets)
+	}
+	if ttl := mr.TTL(d.redisKey(key)); ttl != time.Minute {
+		t.Fatalf("expected the value to be cached for a minute, got %s", ttl)
+	}
+	if has, err := d.Has(ctx, key); err != nil || !has {
+		t.Fatalf("expected the key to be found, got %t, %v", has, err)
+	}
+}
+
+func TestCacheMaxSize(t *testing.T) {
+	ctx := context.Background()
+	d, child, mr := newTestDatastore(t, 4)
+	key := ds.NewKey("/a")
+
+	if err := d.Put(ctx, key, []byte("too large")); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := d.Get(ctx, key); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if child.gets != 2 {
+		t.Fatalf("expected every read to reach the datastore, got %d reads", child.gets)
+	}
+	if mr.Exists(d.redisKey(key)) {
+		t.Fatal("expected the value not to be cached")
+	}
+}
+
+func TestCacheInvalidation(t *testing.T) {
+	ctx := context.Background()
+	d, _, mr := newTestDatastore(t, 1024)
+	key := ds.NewKey("/a")
+
+	if err := d.Put(ctx, key, []byte("v1")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.Get(ctx, key); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Put(ctx, key, []byte("v2")); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := d.Get(ctx, key); err != nil || string(got) != "v2" {
+		t.Fatalf("expected the new value, got %q, %v", got, err)
+	}
+
+	if err := d.Delete(ctx, key); err != nil {
+		t.Fatal(err)
+	}
+	if mr.Exists(d.redisKey(key)) {
+		t.Fatal("expected the deleted value to leave the cache")
+	}
+	if _, err := d.Get(ctx, key); !errors.Is(err, ds.ErrNotFound) {
+		t.Fatalf("expected the deleted value not to be found, got %v", err)
+	}
+
+	if err := d.Put(ctx, key, []byte("v3")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.Get(ctx, key); err != nil {
+		t.Fatal(err)
+	}
+	b, err := d.Batch(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Put(ctx, key, []byte("v4")); err != nil {
+		t.Fatal(err)
+	}
+	if !mr.Exists(d.redisKey(key)) {
+		t.Fatal("expected the value to stay cached until the batch is committed")
+	}
+	if err := b.Commit(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := d.Get(ctx, key); err != nil || string(got) != "v4" {
+		t.Fatalf("expected the batched value, got %q, %v", got, err)
+	}
+}
+
+func TestRedisUnavailable(t *testing.T) {
+	ctx := context.Background()
+	d, child, mr := newTestDatastore(t, 1024)
+	key := ds.NewKey("/a")
+	mr.Close()
+
+	if err := d.Put(ctx, key, []byte("block data")); err != nil {
+		t.Fatal(err)
+	}
+	got, err := d.Get(ctx, key)
+	if err != nil || string(got) != "block data" {
+		t.Fatalf("expected the value from the datastore, got %q, %v", got, err)
+	}
+	if child.gets != 1 {
+		t.Fatalf("expected the read to reach the datastore, got %d reads", child.gets)
+	}
+}
+
+// hookDatastore calls afterGet once a read of the wrapped datastore
+// returned.
+type hookDatastore struct {
+	ds.Batching
+	afterGet func()
+}
+
+func (h *hookDatastore) Get(ctx context.Context, key ds.Key) ([]byte, error) {
+	value, err := h.Batching.Get(ctx, key)
+	if h.afterGet != nil {
+		h.afterGet()
+	}
+	return value, err
+}
+
+func TestDeleteDuringMiss(t *testing.T) {
+	ctx := context.Background()
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { client.Close() })
+	child := &hookDatastore{Batching: dssync.MutexWrap(ds.NewMapDatastore())}
+	d := Wrap(child, client, "peer/plain", time.Minute, 1024)
+	key := ds.NewKey("/a")
+
+	if err := d.Put(ctx, key, []byte("block data")); err != nil {
+		t.Fatal(err)
+	}
+	// the block is garbage collected while a read of it is in flight
+	child.afterGet = func() {
+		child.afterGet = nil
+		if err := d.Delete(ctx, key); err != nil {
+			t.Error(err)
+		}
+	}
+	if _, err := d.Get(ctx, key); err != nil {
+		t.Fatal(err)
+	}
+	if mr.Exists(d.redisKey(key)) {
+		t.Fatal("expected the value read before the delete not to be cached")
+	}
+	if _, err := d.Get(ctx, key); !errors.Is(err, ds.ErrNotFound) {
+		t.Fatalf("expected the deleted value not to be found, got %v", err)
+	}
+}
+
+func TestCacheScope(t *testing.T) {
+	ctx := context.Background()
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { client.Close() })
+	key := ds.NewKey("/a")
+
+	encrypted := config.ConfigPinningService{EncryptBlocksAtRest: true, BlockEncryptionKeys: []config.BlockEncryptionKeyEntry{{ID: "v2", Active: true}}}
+	if got := Scope("peerA", encrypted); got != "peerA/enc/v2" {
+		t.Fatalf("expected the scope to name the peer and the active key, got %q", got)
+	}
+
+	a := WrapConfig(dssync.MutexWrap(ds.NewMapDatastore()), client, "peerA", encrypted)
+	b := WrapConfig(dssync.MutexWrap(ds.NewMapDatastore()), client, "peerB", encrypted)
+	if err := a.Put(ctx, key, []byte("ciphertext of A")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.Get(ctx, key); err != nil {
+		t.Fatal(err)
+	}
+	// B doesn't store the block, and must not read A's cached value
+	if _, err := b.Get(ctx, key); !errors.Is(err, ds.ErrNotFound) {
+		t.Fatalf("expected another repo not to read the cached value, got %v", err)
+	}
+	if has, err := b.Has(ctx, key); err != nil || has {
+		t.Fatalf("expected another repo not to find the cached key, got %t, %v", has, err)
+	}
+}
@@ -0,0 +1,54 @@
+package cli
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/ipfs/kubo/config"
+	"github.com/ipfs/kubo/test/cli/harness"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDatastoreReadOnly(t *testing.T) {
+	t.Parallel()
+
+	node := harness.NewT(t).NewNode().Init("--profile=test")
+	unpinned := node.IPFSAddStr("unpinned content", "--pin=false")
+	pinned := node.IPFSAddStr("pinned content")
+	node.UpdateConfig(func(cfg *config.Config) {
+		cfg.Datastore.ReadOnly = true
+	})
+
+	node.StartDaemon("--offline")
+
+	t.Run("stored content is served", func(t *testing.T) {
+		assert.Equal(t, "unpinned content", node.IPFS("cat", unpinned).Stdout.String())
+		assert.Equal(t, "pinned content", node.IPFS("cat", pinned).Stdout.String())
+	})
+
+	t.Run("content can't be added", func(t *testing.T) {
+		res := node.RunPipeToIPFS(strings.NewReader("new content"), "add", "-q")
+		assert.NotEqual(t, 0, res.ExitCode())
+		assert.Contains(t, res.Stderr.String(), "datastore is read-only")
+	})
+
+	t.Run("content can't be pinned", func(t *testing.T) {
+		res := node.RunIPFS("pin", "add", unpinned)
+		assert.NotEqual(t, 0, res.ExitCode())
+		assert.Contains(t, res.Stderr.String(), "datastore is read-only")
+	})
+
+	t.Run("IPNS records are still written", func(t *testing.T) {
+		node.IPFS("name", "publish", "--allow-offline", "/ipfs/"+pinned)
+		res := node.IPFS("name", "resolve")
+		assert.Equal(t, "/ipfs/"+pinned, res.Stdout.Trimmed())
+	})
+
+	t.Run("the daemon shuts down cleanly", func(t *testing.T) {
+		node.StopDaemon()
+		// the MFS root is published on shutdown, and the repo is still usable
+		res := node.RunIPFS("files", "stat", "/")
+		require.Equal(t, 0, res.ExitCode(), res.Stderr.String())
+	})
+}